@@ -3,18 +3,21 @@ package api
 import (
 	"net/http"
 
+	"github.com/rakutentech/shibuya/shibuya/config"
 	httproute "github.com/rakutentech/shibuya/shibuya/http/route"
 	"github.com/rakutentech/shibuya/shibuya/model"
 	smodel "github.com/rakutentech/shibuya/shibuya/scheduler/model"
 )
 
 type AdminAPI struct {
-	ctx string
+	contexts   []string
+	authConfig *config.AuthConfig
 }
 
-func NewAdminAPI(ctx string) *AdminAPI {
+func NewAdminAPI(contexts []string, authConfig *config.AuthConfig) *AdminAPI {
 	aa := &AdminAPI{
-		ctx: ctx,
+		contexts:   contexts,
+		authConfig: authConfig,
 	}
 	return aa
 }
@@ -33,16 +36,32 @@ func (aa *AdminAPI) Router() *httproute.Router {
 			Path:        "collections",
 			HandlerFunc: aa.collectionAdminGetHandler,
 		},
+		{
+			Name:        "Get the queued deployments by admin",
+			Method:      "GET",
+			Path:        "queue",
+			HandlerFunc: aa.queueAdminGetHandler,
+		},
+		{
+			Name:        "Change the priority of a queued deployment by admin",
+			Method:      "PUT",
+			Path:        "queue/{collection_id}",
+			HandlerFunc: aa.queuePriorityHandler,
+		},
 	}
 	router.AddRoutes(routes)
 	return router
 }
 
 func (aa *AdminAPI) collectionAdminGetHandler(w http.ResponseWriter, r *http.Request) {
-	collections, err := model.GetRunningCollections(aa.ctx)
-	if err != nil {
-		handleErrors(w, err)
-		return
+	collections := []*model.RunningPlan{}
+	for _, ctx := range aa.contexts {
+		running, err := model.GetRunningCollections(ctx)
+		if err != nil {
+			handleErrors(w, err)
+			return
+		}
+		collections = append(collections, running...)
 	}
 	acr := new(AdminCollectionResponse)
 	acr.RunningCollections = collections
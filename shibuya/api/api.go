@@ -40,13 +40,15 @@ func MakeRouter(sc config.ShibuyaConfig, objStorage object_storage.StorageInterf
 	projectAPI := NewProjectAPI(sc)
 	planAPI := NewPlanAPI(sc, objStorage)
 	collectionAPI := NewCollectionAPI(sc, objStorage, ctr)
+	applyAPI := NewApplyAPI(sc, objStorage, ctr)
 	usageAPI := NewUsageAPI()
-	adminAPI := NewAdminAPI(sc.Context)
+	adminAPI := NewAdminAPI(sc.Contexts(), sc.AuthConfig)
 	metricsGateway := NewMetricsGateway(sc.MetricStorage)
 	apiComponents := []ShibuyaAPIComponent{
 		projectAPI,
 		planAPI,
 		collectionAPI,
+		applyAPI,
 		usageAPI,
 		adminAPI,
 		metricsGateway,
@@ -0,0 +1,540 @@
+package api
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rakutentech/shibuya/shibuya/config"
+	"github.com/rakutentech/shibuya/shibuya/controller"
+	httproute "github.com/rakutentech/shibuya/shibuya/http/route"
+	"github.com/rakutentech/shibuya/shibuya/model"
+	"github.com/rakutentech/shibuya/shibuya/object_storage"
+)
+
+// ApplyAPI reconciles a project with its plans and collections to a manifest
+type ApplyAPI struct {
+	objStorage object_storage.StorageInterface
+	ctr        *controller.Controller
+	sc         config.ShibuyaConfig
+}
+
+func NewApplyAPI(sc config.ShibuyaConfig, objStorage object_storage.StorageInterface, ctr *controller.Controller) *ApplyAPI {
+	return &ApplyAPI{
+		objStorage: objStorage,
+		ctr:        ctr,
+		sc:         sc,
+	}
+}
+
+func (aa *ApplyAPI) Router() *httproute.Router {
+	router := httproute.NewRouter("apply api", "/apply")
+	router.AddRoutes(httproute.Routes{
+		{
+			Name:        "Apply a manifest",
+			Method:      "POST",
+			HandlerFunc: aa.applyHandler,
+		},
+	})
+	return router
+}
+
+// fileChanges are the files to upload and to delete to make the stored files match the manifest
+type fileChanges struct {
+	upload  map[string][]byte
+	replace map[string]struct{}
+	remove  []string
+}
+
+func (fc *fileChanges) empty() bool {
+	return len(fc.upload) == 0 && len(fc.remove) == 0
+}
+
+func (fc *fileChanges) String() string {
+	var upload, replace []string
+	for name := range fc.upload {
+		if _, ok := fc.replace[name]; ok {
+			replace = append(replace, name)
+		} else {
+			upload = append(upload, name)
+		}
+	}
+	parts := []string{}
+	for _, p := range []struct {
+		verb  string
+		names []string
+	}{{"upload", upload}, {"replace", replace}, {"delete", fc.remove}} {
+		if len(p.names) > 0 {
+			parts = append(parts, fmt.Sprintf("%s %s", p.verb, strings.Join(sortedStrings(p.names), ", ")))
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+func sortedStrings(s []string) []string {
+	sort.Strings(s)
+	return s
+}
+
+// sortedByName returns the values of the map ordered by their names
+func sortedByName[T any](m map[string]T) []T {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	r := make([]T, len(names))
+	for i, name := range names {
+		r[i] = m[name]
+	}
+	return r
+}
+
+type planState struct {
+	desired *model.ManifestPlan
+	current *model.Plan
+	files   *fileChanges
+}
+
+type collectionState struct {
+	desired *model.ManifestCollection
+	current *model.Collection
+	files   *fileChanges
+	config  bool
+}
+
+// applier works out the changes needed by a manifest and applies them. All the changes are
+// validated before anything is changed.
+type applier struct {
+	*ApplyAPI
+	account     *model.Account
+	manifest    *model.Manifest
+	files       map[string][]byte
+	prune       bool
+	project     *model.Project
+	plans       []*planState
+	collections []*collectionState
+	// plans and collections which are not in the manifest
+	prunedPlans       []*model.Plan
+	prunedCollections []*model.Collection
+	changes           []*model.ManifestChange
+}
+
+func (a *applier) addChange(action, kind, name, detail string) {
+	a.changes = append(a.changes, &model.ManifestChange{Action: action, Kind: kind, Name: name, Detail: detail})
+}
+
+// diffFiles compares the stored files with the files in the manifest by their contents
+func (a *applier) diffFiles(current []*model.ShibuyaFile, desired []string) (*fileChanges, error) {
+	fc := &fileChanges{upload: make(map[string][]byte), replace: make(map[string]struct{})}
+	stored := make(map[string]*model.ShibuyaFile, len(current))
+	for _, f := range current {
+		stored[f.Filename] = f
+	}
+	for _, p := range desired {
+		name := path.Base(p)
+		content := a.files[p]
+		f, ok := stored[name]
+		if !ok {
+			fc.upload[name] = content
+			continue
+		}
+		delete(stored, name)
+		old, err := a.objStorage.Download(f.Filepath)
+		if err != nil {
+			var notFound object_storage.FileNotFound
+			if !errors.As(err, &notFound) {
+				return nil, err
+			}
+		}
+		if err != nil || !bytes.Equal(old, content) {
+			fc.upload[name] = content
+			fc.replace[name] = struct{}{}
+		}
+	}
+	for name := range stored {
+		fc.remove = append(fc.remove, name)
+	}
+	return fc, nil
+}
+
+func (a *applier) loadProject() error {
+	mp := a.manifest.Project
+	_, member := a.account.MLMap[mp.Owner]
+	if !member && !a.account.IsAdmin(a.sc.AuthConfig) {
+		return makeNoPermissionErr(fmt.Sprintf("You are not part of %s", mp.Owner))
+	}
+	project, err := model.GetProjectByName(mp.Owner, mp.Name)
+	var dbe *model.DBError
+	switch {
+	case err == nil:
+		a.project = project
+		return nil
+	case !errors.As(err, &dbe) || !errors.Is(dbe.Err, sql.ErrNoRows):
+		return err
+	}
+	// same as the project creation, only members of the owner can create the project
+	if !member {
+		return makeNoPermissionErr(fmt.Sprintf("You are not part of %s", mp.Owner))
+	}
+	if a.sc.EnableSid {
+		if mp.SID == "" {
+			return makeInvalidRequestError("SID cannot be empty")
+		}
+		if _, err := strconv.Atoi(mp.SID); err != nil {
+			return makeInvalidRequestError("SID is invalid")
+		}
+	}
+	a.addChange(model.ChangeCreate, "project", mp.Name, "")
+	return nil
+}
+
+func (a *applier) diffPlans() error {
+	existing := make(map[string]*model.Plan)
+	if a.project != nil {
+		plans, err := a.project.GetPlans()
+		if err != nil {
+			return err
+		}
+		for _, p := range plans {
+			if _, ok := existing[p.Name]; ok {
+				return makeInvalidRequestError(fmt.Sprintf("There are more than one plans named %s in the project", p.Name))
+			}
+			existing[p.Name] = p
+		}
+	}
+	for _, mp := range a.manifest.Plans {
+		ps := &planState{desired: mp}
+		var current []*model.ShibuyaFile
+		if p, ok := existing[mp.Name]; ok {
+			delete(existing, mp.Name)
+			plan, err := model.GetPlan(p.ID)
+			if err != nil {
+				return err
+			}
+			if plan.Kind != mp.Kind {
+				return makeInvalidRequestError(fmt.Sprintf("The kind of plan %s cannot be changed from %s to %s", mp.Name, plan.Kind, mp.Kind))
+			}
+			ps.current = plan
+			current = plan.Data
+			if plan.TestFile != nil {
+				current = append(current, plan.TestFile)
+			}
+		}
+		files, err := a.diffFiles(current, append([]string{mp.TestFile}, mp.DataFiles...))
+		if err != nil {
+			return err
+		}
+		ps.files = files
+		a.plans = append(a.plans, ps)
+		switch {
+		case ps.current == nil:
+			a.addChange(model.ChangeCreate, "plan", mp.Name, files.String())
+		case !files.empty():
+			a.addChange(model.ChangeUpdate, "plan", mp.Name, files.String())
+		}
+	}
+	if a.prune {
+		for _, p := range sortedByName(existing) {
+			a.prunedPlans = append(a.prunedPlans, p)
+			a.addChange(model.ChangeDelete, "plan", p.Name, "")
+		}
+	}
+	return nil
+}
+
+// planIDs returns the ids of the plans in the manifest. Plans which don't exist yet get negative
+// ids, so the configs can be validated before the plans are created.
+func (a *applier) planIDs() (map[string]int64, map[int64]string) {
+	ids := make(map[string]int64, len(a.plans))
+	names := make(map[int64]string, len(a.plans))
+	for i, ps := range a.plans {
+		id := int64(-i - 1)
+		if ps.current != nil {
+			id = ps.current.ID
+		}
+		ids[ps.desired.Name] = id
+		names[id] = ps.desired.Name
+	}
+	return ids, names
+}
+
+func (a *applier) diffCollections() error {
+	existing := make(map[string]*model.Collection)
+	if a.project != nil {
+		collections, err := a.project.GetCollections()
+		if err != nil {
+			return err
+		}
+		for _, c := range collections {
+			if _, ok := existing[c.Name]; ok {
+				return makeInvalidRequestError(fmt.Sprintf("There are more than one collections named %s in the project", c.Name))
+			}
+			existing[c.Name] = c
+		}
+	}
+	planIDs, planNames := a.planIDs()
+	for _, mc := range a.manifest.Collections {
+		cs := &collectionState{desired: mc}
+		ec := mc.Resolve(planIDs)
+		if err := validateExecutionCollection(ec, a.sc); err != nil {
+			return fmt.Errorf("%w in collection %s", err, mc.Name)
+		}
+		var current []*model.ShibuyaFile
+		if c, ok := existing[mc.Name]; ok {
+			delete(existing, mc.Name)
+			collection, err := model.GetCollection(c.ID)
+			if err != nil {
+				return err
+			}
+			cs.current = collection
+			current = collection.Data
+			currentConfig, err := collection.GetConfig()
+			if err != nil {
+				return err
+			}
+			cs.config = !model.ConfigEqual(currentConfig, ec, planNames)
+			if cs.config {
+				if err := checkCollectionChangeable(a.ctr, collection, ec); err != nil {
+					return fmt.Errorf("%w in collection %s", err, mc.Name)
+				}
+			}
+		} else {
+			cs.config = len(ec.Tests) > 0 || len(ec.Criteria) > 0 || len(ec.AbortOn) > 0 || ec.CSVSplit
+		}
+		files, err := a.diffFiles(current, mc.DataFiles)
+		if err != nil {
+			return err
+		}
+		cs.files = files
+		a.collections = append(a.collections, cs)
+
+		detail := []string{}
+		if cs.config {
+			detail = append(detail, "configure")
+		}
+		if !files.empty() {
+			detail = append(detail, files.String())
+		}
+		switch {
+		case cs.current == nil:
+			a.addChange(model.ChangeCreate, "collection", mc.Name, strings.Join(detail, "; "))
+		case len(detail) > 0:
+			a.addChange(model.ChangeUpdate, "collection", mc.Name, strings.Join(detail, "; "))
+		}
+	}
+	if a.prune {
+		for _, c := range sortedByName(existing) {
+			if err := checkCollectionDeletable(a.ctr, c); err != nil {
+				return fmt.Errorf("%w, collection %s cannot be pruned", err, c.Name)
+			}
+			a.prunedCollections = append(a.prunedCollections, c)
+			a.addChange(model.ChangeDelete, "collection", c.Name, "")
+		}
+	}
+	return nil
+}
+
+func (a *applier) applyProject() error {
+	if a.project != nil {
+		return nil
+	}
+	mp := a.manifest.Project
+	projectID, err := model.CreateProject(mp.Name, mp.Owner, mp.SID)
+	if err != nil {
+		return err
+	}
+	a.project, err = model.GetProject(projectID)
+	return err
+}
+
+func (a *applier) applyPlans() (map[string]int64, error) {
+	planIDs := make(map[string]int64, len(a.plans))
+	for _, ps := range a.plans {
+		if ps.current == nil {
+			planID, err := model.CreatePlan(ps.desired.Name, a.project.ID, ps.desired.Kind)
+			if err != nil {
+				return nil, err
+			}
+			if ps.current, err = model.GetPlan(planID); err != nil {
+				return nil, err
+			}
+		}
+		plan := ps.current
+		planIDs[plan.Name] = plan.ID
+		for _, name := range ps.files.remove {
+			if err := plan.DeleteFile(a.objStorage, name); err != nil {
+				return nil, err
+			}
+		}
+		// uploads replace the files of the same names and keep their previous versions
+		for name, content := range ps.files.upload {
+			if err := plan.StoreFile(a.objStorage, io.NopCloser(bytes.NewReader(content)), name, a.account.Name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return planIDs, nil
+}
+
+func (a *applier) applyCollections(planIDs map[string]int64) error {
+	for _, cs := range a.collections {
+		if cs.current == nil {
+			collectionID, err := model.CreateCollection(cs.desired.Name, a.project.ID)
+			if err != nil {
+				return err
+			}
+			if cs.current, err = model.GetCollection(collectionID); err != nil {
+				return err
+			}
+		}
+		collection := cs.current
+		for _, name := range cs.files.remove {
+			if err := collection.DeleteFile(a.objStorage, name); err != nil {
+				return err
+			}
+		}
+		for name, content := range cs.files.upload {
+			if _, ok := cs.files.replace[name]; ok {
+				if err := collection.DeleteFile(a.objStorage, name); err != nil {
+					return err
+				}
+			}
+			if err := collection.StoreFile(a.objStorage, name, io.NopCloser(bytes.NewReader(content))); err != nil {
+				return err
+			}
+		}
+		if !cs.config {
+			continue
+		}
+		ec := cs.desired.Resolve(planIDs)
+		ec.ProjectID, ec.CollectionID = a.project.ID, collection.ID
+		// derives the flat config from the stages again as the config is resolved with the real ids
+		if err := validateExecutionCollection(ec, a.sc); err != nil {
+			return err
+		}
+		if err := collection.Store(ec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *applier) applyPrune() error {
+	for _, c := range a.prunedCollections {
+		if err := c.Delete(a.objStorage); err != nil {
+			return err
+		}
+	}
+	for _, p := range a.prunedPlans {
+		using, err := p.IsBeingUsed()
+		if err != nil {
+			return err
+		}
+		if using {
+			return makeInvalidRequestError(fmt.Sprintf("plan %s is being used", p.Name))
+		}
+		plan, err := model.GetPlan(p.ID)
+		if err != nil {
+			return err
+		}
+		if err := plan.Delete(a.objStorage); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *applier) apply() error {
+	if err := a.applyProject(); err != nil {
+		return err
+	}
+	planIDs, err := a.applyPlans()
+	if err != nil {
+		return err
+	}
+	if err := a.applyCollections(planIDs); err != nil {
+		return err
+	}
+	return a.applyPrune()
+}
+
+// applyHandler takes a multipart form with the manifest in the manifest field and every file of
+// the manifest in the field named by its path. With dry_run, it only reports the changes.
+// With prune, the plans and collections of the project which are not in the manifest are deleted.
+func (aa *ApplyAPI) applyHandler(w http.ResponseWriter, r *http.Request) {
+	account := r.Context().Value(accountKey).(*model.Account)
+	if err := r.ParseMultipartForm(100 << 20); err != nil { //parse 100 MB of data
+		handleErrors(w, makeInvalidRequestError(err.Error()))
+		return
+	}
+	file, _, err := r.FormFile("manifest")
+	if err != nil {
+		handleErrors(w, makeInvalidResourceError("manifest"))
+		return
+	}
+	raw, err := io.ReadAll(file)
+	if err != nil {
+		handleErrors(w, makeInvalidRequestError("invalid manifest"))
+		return
+	}
+	manifest, err := model.ParseManifest(raw)
+	if err != nil {
+		handleErrors(w, makeInvalidRequestError(err.Error()))
+		return
+	}
+	if err := manifest.Validate(); err != nil {
+		handleErrors(w, makeInvalidRequestError(err.Error()))
+		return
+	}
+	var dryRun, prune bool
+	for name, v := range map[string]*bool{"dry_run": &dryRun, "prune": &prune} {
+		if value := r.Form.Get(name); value != "" {
+			if *v, err = strconv.ParseBool(value); err != nil {
+				handleErrors(w, makeInvalidResourceError(name))
+				return
+			}
+		}
+	}
+	files := make(map[string][]byte)
+	for _, p := range manifest.Files() {
+		f, _, err := r.FormFile(p)
+		if err != nil {
+			handleErrors(w, makeInvalidRequestError(fmt.Sprintf("file %s is missing", p)))
+			return
+		}
+		content, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			handleErrors(w, makeInvalidRequestError(fmt.Sprintf("invalid file %s", p)))
+			return
+		}
+		files[p] = content
+	}
+	a := &applier{ApplyAPI: aa, account: account, manifest: manifest, files: files, prune: prune,
+		changes: []*model.ManifestChange{}}
+	for _, step := range []func() error{a.loadProject, a.diffPlans, a.diffCollections} {
+		if err := step(); err != nil {
+			handleErrors(w, err)
+			return
+		}
+	}
+	if !dryRun {
+		if err := a.apply(); err != nil {
+			handleErrors(w, err)
+			return
+		}
+	}
+	result := &model.ApplyResult{DryRun: dryRun, Changes: a.changes}
+	if a.project != nil {
+		result.ProjectID = a.project.ID
+	}
+	renderJSON(w, http.StatusOK, result)
+}
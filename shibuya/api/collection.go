@@ -2,6 +2,7 @@ package api
 
 import (
 	"bytes"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,6 +13,7 @@ import (
 
 	"github.com/rakutentech/shibuya/shibuya/config"
 	"github.com/rakutentech/shibuya/shibuya/controller"
+	"github.com/rakutentech/shibuya/shibuya/engines/registry"
 	authtoken "github.com/rakutentech/shibuya/shibuya/http/auth/token"
 	httproute "github.com/rakutentech/shibuya/shibuya/http/route"
 	"github.com/rakutentech/shibuya/shibuya/model"
@@ -39,6 +41,11 @@ func NewCollectionAPI(sc config.ShibuyaConfig, objStorage object_storage.Storage
 func (ca *CollectionAPI) Router() *httproute.Router {
 	router := httproute.NewRouter("collection api", "/collections")
 	router.AddRoutes(httproute.Routes{
+		{
+			Name:        "Get collections",
+			Method:      "GET",
+			HandlerFunc: ca.collectionsGetHandler,
+		},
 		{
 			Name:        "Create a collection",
 			Method:      "POST",
@@ -88,6 +95,12 @@ func (ca *CollectionAPI) Router() *httproute.Router {
 			Path:        "{collection_id}/config",
 			HandlerFunc: ca.collectionUploadHandler,
 		},
+		{
+			Name:        "Clone a collection",
+			Method:      "POST",
+			Path:        "{collection_id}/clone",
+			HandlerFunc: ca.collectionCloneHandler,
+		},
 		{
 			Name:        "GET a collection config as a file",
 			Method:      "GET",
@@ -109,6 +122,18 @@ func (ca *CollectionAPI) Router() *httproute.Router {
 			Path:        "{collection_id}/deploy",
 			HandlerFunc: ca.collectionDeploymentHandler,
 		},
+		{
+			Name:        "Get the queued deployment of a collection",
+			Method:      "GET",
+			Path:        "{collection_id}/queue",
+			HandlerFunc: ca.queueGetHandler,
+		},
+		{
+			Name:        "Cancel the queued deployment of a collection",
+			Method:      "DELETE",
+			Path:        "{collection_id}/queue",
+			HandlerFunc: ca.queueDeleteHandler,
+		},
 		{
 			Name:        "Trigger a collection",
 			Method:      "POST",
@@ -128,6 +153,25 @@ func (ca *CollectionAPI) Router() *httproute.Router {
 			HandlerFunc: ca.collectionPurgeHandler,
 		},
 
+		{
+			Name:        "Run a collection from the deployment to the purge",
+			Method:      "POST",
+			Path:        "{collection_id}/run",
+			HandlerFunc: ca.collectionRunHandler,
+		},
+		{
+			Name:        "Get a collection run handle",
+			Method:      "GET",
+			Path:        "{collection_id}/run/{handle_id}",
+			HandlerFunc: ca.oneShotRunGetHandler,
+		},
+		{
+			Name:        "Stream the phases of a collection run handle",
+			Method:      "GET",
+			Path:        "{collection_id}/run/{handle_id}/stream",
+			HandlerFunc: ca.oneShotRunStreamHandler,
+		},
+
 		{
 			Name:        "Get collection runs",
 			Method:      "GET",
@@ -135,7 +179,13 @@ func (ca *CollectionAPI) Router() *httproute.Router {
 			HandlerFunc: ca.runGetHandler,
 		},
 		{
-			Name:        "Get collection runs",
+			Name:        "Compare two collection runs",
+			Method:      "GET",
+			Path:        "{collection_id}/runs/compare",
+			HandlerFunc: ca.runCompareHandler,
+		},
+		{
+			Name:        "Get a collection run",
 			Method:      "GET",
 			Path:        "{collection_id}/runs/{run_id}",
 			HandlerFunc: ca.runGetHandler,
@@ -153,6 +203,43 @@ func (ca *CollectionAPI) Router() *httproute.Router {
 			HandlerFunc: ca.runDeleteHandler,
 		},
 
+		{
+			Name:        "Get collection schedules",
+			Method:      "GET",
+			Path:        "{collection_id}/schedules",
+			HandlerFunc: ca.scheduleGetHandler,
+		},
+		{
+			Name:        "Create a collection schedule",
+			Method:      "POST",
+			Path:        "{collection_id}/schedules",
+			HandlerFunc: ca.scheduleCreateHandler,
+		},
+		{
+			Name:        "Get a collection schedule",
+			Method:      "GET",
+			Path:        "{collection_id}/schedules/{schedule_id}",
+			HandlerFunc: ca.scheduleGetHandler,
+		},
+		{
+			Name:        "Update a collection schedule",
+			Method:      "PUT",
+			Path:        "{collection_id}/schedules/{schedule_id}",
+			HandlerFunc: ca.scheduleUpdateHandler,
+		},
+		{
+			Name:        "Delete a collection schedule",
+			Method:      "DELETE",
+			Path:        "{collection_id}/schedules/{schedule_id}",
+			HandlerFunc: ca.scheduleDeleteHandler,
+		},
+		{
+			Name:        "Get the runs of a collection schedule",
+			Method:      "GET",
+			Path:        "{collection_id}/schedules/{schedule_id}/runs",
+			HandlerFunc: ca.scheduleRunsHandler,
+		},
+
 		{
 			Name:        "Get collection status",
 			Method:      "GET",
@@ -166,6 +253,12 @@ func (ca *CollectionAPI) Router() *httproute.Router {
 			Path:        "{collection_id}/stream",
 			HandlerFunc: ca.streamCollectionMetrics,
 		},
+		{
+			Name:        "Change the load of a running plan",
+			Method:      "PATCH",
+			Path:        "{collection_id}/plans/{plan_id}/load",
+			HandlerFunc: ca.planLoadHandler,
+		},
 		{
 			Name:        "Handle a collection plan log",
 			Method:      "GET",
@@ -215,6 +308,12 @@ func hasInvalidDiff(curr, updated []*model.ExecutionPlan) (bool, string) {
 		if currPlan.Concurrency != item.Concurrency {
 			return true, "You cannot change concurrency while having engines deployed"
 		}
+		if !currPlan.ExecutorOverrides.Equal(item.ExecutorOverrides) {
+			return true, "You cannot change the engine containers while having engines deployed"
+		}
+		if currPlan.Context != item.Context {
+			return true, "You cannot change the context of a plan while having engines deployed"
+		}
 	}
 	return false, ""
 }
@@ -256,32 +355,176 @@ func (ca *CollectionAPI) collectionGetHandler(w http.ResponseWriter, r *http.Req
 	renderJSON(w, http.StatusOK, collection)
 }
 
-func (ca *CollectionAPI) collectionUpdateHandler(w http.ResponseWriter, _ *http.Request) {
-	renderJSON(w, http.StatusNotImplemented, nil)
+// collectionsGetHandler lists the collections of a project, or of all the projects of the account,
+// having the tags of the selector
+func (ca *CollectionAPI) collectionsGetHandler(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	selector, err := getTagSelector(r)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	projects, err := getListedProjects(r, ca.sc.AuthConfig)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	collections := []*model.Collection{}
+	for _, p := range projects {
+		pcs, err := p.GetCollections()
+		if err != nil {
+			handleErrors(w, err)
+			return
+		}
+		for _, c := range pcs {
+			if c.Tags.Match(selector) {
+				collections = append(collections, c)
+			}
+		}
+	}
+	renderJSON(w, http.StatusOK, collections)
 }
 
-func (ca *CollectionAPI) collectionDeleteHandler(w http.ResponseWriter, r *http.Request) {
+func (ca *CollectionAPI) collectionUpdateHandler(w http.ResponseWriter, r *http.Request) {
 	collection, err := getCollection(r, ca.sc.AuthConfig)
 	if err != nil {
 		handleErrors(w, err)
 		return
 	}
-	if ca.ctr.Scheduler.PodReadyCount(collection.ID) > 0 {
-		handleErrors(w, makeInvalidRequestError("You cannot launch engines when there are engines already deployed"))
+	r.ParseForm()
+	mu, err := parseMetadataUpdate(r, collection.Name, collection.Description)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	if err := collection.Update(mu.name, mu.description); err != nil {
+		if errors.Is(err, model.ErrNameTaken) {
+			err = makeInvalidRequestError(err.Error())
+		}
+		handleErrors(w, err)
 		return
 	}
-	runningPlans, err := model.GetRunningPlansByCollection(collection.ID)
+	if mu.tags != nil {
+		if err := collection.StoreTags(mu.tags); err != nil {
+			handleErrors(w, err)
+			return
+		}
+	}
+	renderJSON(w, http.StatusOK, collection)
+}
+
+func (ca *CollectionAPI) collectionDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	collection, err := getCollection(r, ca.sc.AuthConfig)
 	if err != nil {
 		handleErrors(w, err)
 		return
 	}
-	if len(runningPlans) > 0 {
-		handleErrors(w, makeInvalidRequestError("You cannot delete the collection during testing period"))
+	if err := checkCollectionDeletable(ca.ctr, collection); err != nil {
+		handleErrors(w, err)
 		return
 	}
 	collection.Delete(ca.objStorage)
 }
 
+func checkCollectionDeletable(ctr *controller.Controller, collection *model.Collection) error {
+	if ctr.PodReadyCount(collection.ID) > 0 {
+		return makeInvalidRequestError("You cannot launch engines when there are engines already deployed")
+	}
+	runningPlans, err := model.GetRunningPlansByCollection(collection.ID)
+	if err != nil {
+		return err
+	}
+	if len(runningPlans) > 0 {
+		return makeInvalidRequestError("You cannot delete the collection during testing period")
+	}
+	return nil
+}
+
+func validateRules(ec *model.ExecutionCollection) error {
+	plans := make(map[int64]struct{}, len(ec.Tests))
+	for _, ep := range ec.Tests {
+		plans[ep.PlanID] = struct{}{}
+	}
+	for _, c := range ec.Criteria {
+		if err := c.Validate(); err != nil {
+			return makeInvalidRequestError(err.Error())
+		}
+		if _, ok := plans[c.PlanID]; c.PlanID != 0 && !ok {
+			return makeInvalidRequestError(fmt.Sprintf("testid %d in rule %q is not in the collection", c.PlanID, c.Rule))
+		}
+	}
+	for _, g := range ec.AbortOn {
+		if err := g.Validate(); err != nil {
+			return makeInvalidRequestError(err.Error())
+		}
+		if _, ok := plans[g.PlanID]; g.PlanID != 0 && !ok {
+			return makeInvalidRequestError(fmt.Sprintf("testid %d in rule %q is not in the collection", g.PlanID, g.Rule))
+		}
+	}
+	return nil
+}
+
+// validateExecutionCollection checks the config of a collection before it's stored. It also derives
+// the flat config of the plans from their stages. The engines are limited per cluster as the plans
+// can run in different clusters.
+func validateExecutionCollection(ec *model.ExecutionCollection, sc config.ShibuyaConfig) error {
+	enginesRequired := make(map[string]int)
+	for _, ep := range ec.Tests {
+		enginesRequired[ep.Context] += ep.Engines
+	}
+	for context, required := range enginesRequired {
+		csc, err := sc.ForContext(context)
+		if err != nil {
+			return makeInvalidRequestError(err.Error())
+		}
+		limit := csc.ExecutorConfig.MaxEnginesInCollection
+		if required > limit {
+			return makeInvalidRequestError(fmt.Sprintf("You are reaching the resource limit of the cluster %s. Requesting engines: %d, limit: %d.",
+				csc.Context, required, limit))
+		}
+	}
+	for _, ep := range ec.Tests {
+		if ep.Engines <= 0 {
+			return makeInvalidRequestError("You cannot configure a plan with zero engine")
+		}
+		if err := ep.ApplyStages(); err != nil {
+			return makeInvalidRequestError(err.Error())
+		}
+		if ep.TargetRPS < 0 {
+			return makeInvalidRequestError("target_rps cannot be negative")
+		}
+		csc, err := sc.ForContext(ep.Context)
+		if err != nil {
+			return makeInvalidRequestError(err.Error())
+		}
+		if err := ep.ExecutorOverrides.Validate(csc.ExecutorConfig); err != nil {
+			return makeInvalidRequestError(fmt.Sprintf("testid %d: %s", ep.PlanID, err))
+		}
+	}
+	return validateRules(ec)
+}
+
+// checkCollectionChangeable checks whether the collection can take the config at the moment
+func checkCollectionChangeable(ctr *controller.Controller, collection *model.Collection, ec *model.ExecutionCollection) error {
+	runningPlans, err := model.GetRunningPlansByCollection(collection.ID)
+	if err != nil {
+		return err
+	}
+	if len(runningPlans) > 0 {
+		return makeInvalidRequestError("You cannot change the collection during testing period")
+	}
+	if ctr.PodReadyCount(collection.ID) > 0 {
+		currentPlans, err := collection.GetExecutionPlans()
+		if err != nil {
+			return err
+		}
+		if ok, message := hasInvalidDiff(currentPlans, ec.Tests); ok {
+			return makeInvalidRequestError(message)
+		}
+	}
+	return nil
+}
+
 func (ca *CollectionAPI) collectionUploadHandler(w http.ResponseWriter, r *http.Request) {
 	collection, err := getCollection(r, ca.sc.AuthConfig)
 	if err != nil {
@@ -315,7 +558,6 @@ func (ca *CollectionAPI) collectionUploadHandler(w http.ResponseWriter, r *http.
 		handleErrors(w, err)
 		return
 	}
-	totalEnginesRequired := 0
 	for _, ep := range e.Content.Tests {
 		plan, err := model.GetPlan(ep.PlanID)
 		if err != nil {
@@ -331,46 +573,52 @@ func (ca *CollectionAPI) collectionUploadHandler(w http.ResponseWriter, r *http.
 			handleErrors(w, makeInvalidRequestError("You can only add plan within the same project"))
 			return
 		}
-		totalEnginesRequired += ep.Engines
-	}
-	sc := ca.sc
-	if totalEnginesRequired > sc.ExecutorConfig.MaxEnginesInCollection {
-		errMsg := fmt.Sprintf("You are reaching the resource limit of the cluster. Requesting engines: %d, limit: %d.",
-			totalEnginesRequired, sc.ExecutorConfig.MaxEnginesInCollection)
-		handleErrors(w, makeInvalidRequestError(errMsg))
-		return
+		if ep.TestFileVersion != 0 {
+			if _, err := plan.GetTestFileVersion(ep.TestFileVersion); err != nil {
+				handleErrors(w, makeInvalidRequestError(err.Error()))
+				return
+			}
+		}
 	}
-	runningPlans, err := model.GetRunningPlansByCollection(collection.ID)
-	if err != nil {
+	if err := validateExecutionCollection(e.Content, ca.sc); err != nil {
 		handleErrors(w, err)
 		return
 	}
-	if len(runningPlans) > 0 {
-		handleErrors(w, makeInvalidRequestError("You cannot change the collection during testing period"))
+	if err := checkCollectionChangeable(ca.ctr, collection, e.Content); err != nil {
+		handleErrors(w, err)
 		return
 	}
-	for _, ep := range e.Content.Tests {
-		if ep.Engines <= 0 {
-			handleErrors(w, makeInvalidRequestError("You cannot configure a plan with zero engine"))
-			return
-		}
+	err = collection.Store(e.Content)
+	if err != nil {
+		handleErrors(w, err)
 	}
-	if ca.ctr.Scheduler.PodReadyCount(collection.ID) > 0 {
-		currentPlans, err := collection.GetExecutionPlans()
-		if err != nil {
-			handleErrors(w, err)
-			return
-		}
-		if ok, message := hasInvalidDiff(currentPlans, e.Content.Tests); ok {
-			handleErrors(w, makeInvalidRequestError(message))
-			return
-		}
+}
 
+// collectionCloneHandler copies the collection with its config and data files, optionally into
+// another project of the caller. The plans are cloned too when the project is different.
+func (ca *CollectionAPI) collectionCloneHandler(w http.ResponseWriter, r *http.Request) {
+	account := r.Context().Value(accountKey).(*model.Account)
+	collection, err := getCollection(r, ca.sc.AuthConfig)
+	if err != nil {
+		handleErrors(w, err)
+		return
 	}
-	err = collection.Store(e.Content)
+	r.ParseForm()
+	projectID, err := getCloneTarget(r, collection.ProjectID, ca.sc.AuthConfig)
 	if err != nil {
 		handleErrors(w, err)
+		return
 	}
+	clone, err := collection.Clone(ca.objStorage, projectID, r.Form.Get("name"), account.Name)
+	if errors.Is(err, model.ErrNameTaken) {
+		handleErrors(w, makeInvalidRequestError(err.Error()))
+		return
+	}
+	if err != nil {
+		handleErrors(w, makeInternalServerError(err.Error()))
+		return
+	}
+	renderJSON(w, http.StatusOK, clone)
 }
 
 func (ca *CollectionAPI) collectionConfigGetHandler(w http.ResponseWriter, req *http.Request) {
@@ -379,12 +627,12 @@ func (ca *CollectionAPI) collectionConfigGetHandler(w http.ResponseWriter, req *
 		handleErrors(w, err)
 		return
 	}
-	eps, err := collection.GetExecutionPlans()
+	ec, err := collection.GetConfig()
 	if err != nil {
 		handleErrors(w, err)
 		return
 	}
-	for _, ep := range eps {
+	for _, ep := range ec.Tests {
 		plan, err := model.GetPlan(ep.PlanID)
 		if err != nil {
 			handleErrors(w, err)
@@ -392,15 +640,7 @@ func (ca *CollectionAPI) collectionConfigGetHandler(w http.ResponseWriter, req *
 		}
 		ep.Name = plan.Name
 	}
-	e := &model.ExecutionWrapper{
-		Content: &model.ExecutionCollection{
-			Name:         collection.Name,
-			ProjectID:    collection.ProjectID,
-			CollectionID: collection.ID,
-			Tests:        eps,
-			CSVSplit:     collection.CSVSplit,
-		},
-	}
+	e := &model.ExecutionWrapper{Content: ec}
 	content, err := yaml.Marshal(e)
 	if err != nil {
 		handleErrors(w, err)
@@ -471,7 +711,7 @@ func (ca *CollectionAPI) collectionEnginesDetailHandler(w http.ResponseWriter, r
 		handleErrors(w, err)
 		return
 	}
-	collectionDetails, err := ca.ctr.Scheduler.GetCollectionEnginesDetail(collection.ProjectID, collection.ID)
+	collectionDetails, err := ca.ctr.CollectionEnginesDetail(collection)
 	if err != nil {
 		handleErrors(w, err)
 		return
@@ -492,15 +732,20 @@ func (ca *CollectionAPI) collectionDeploymentHandler(w http.ResponseWriter, r *h
 		handleErrors(w, err)
 		return
 	}
-	if err := ca.ctr.DeployCollection(collection, token); err != nil {
+	qd, err := ca.ctr.RequestDeployment(collection, token, account.Name)
+	if err != nil {
 		var dbe *model.DBError
-		if errors.As(err, &dbe) {
+		if errors.As(err, &dbe) || errors.Is(err, controller.QuotaError) || errors.Is(err, controller.CapacityError) {
 			handleErrors(w, makeInvalidRequestError(err.Error()))
 			return
 		}
 		handleErrors(w, makeInternalServerError(err.Error()))
 		return
 	}
+	// the collection is deployed once it's admitted by the deploy queue
+	if qd != nil {
+		renderJSON(w, http.StatusAccepted, qd)
+	}
 }
 
 func (ca *CollectionAPI) collectionTriggerHandler(w http.ResponseWriter, r *http.Request) {
@@ -539,12 +784,158 @@ func (ca *CollectionAPI) collectionPurgeHandler(w http.ResponseWriter, r *http.R
 	}
 }
 
-func (ca *CollectionAPI) runGetHandler(w http.ResponseWriter, _ *http.Request) {
-	renderJSON(w, http.StatusNotImplemented, nil)
+func getRunID(r *http.Request, collection *model.Collection) (int64, error) {
+	return parseRunID(r.PathValue("run_id"), collection, "run_id")
+}
+
+func parseRunID(value string, collection *model.Collection, name string) (int64, error) {
+	runID, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, makeInvalidResourceError(name)
+	}
+	run, err := model.GetRun(runID)
+	if err != nil || run.CollectionID != collection.ID {
+		return 0, makeInvalidResourceError(name)
+	}
+	return runID, nil
+}
+
+func parseThreshold(r *http.Request, name string, defaultValue float64) (float64, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return defaultValue, nil
+	}
+	t, err := strconv.ParseFloat(v, 64)
+	if err != nil || t < 0 {
+		return 0, makeInvalidRequestError(fmt.Sprintf("%s should be a non-negative number", name))
+	}
+	return t, nil
 }
 
-func (ca *CollectionAPI) runDeleteHandler(w http.ResponseWriter, _ *http.Request) {
-	renderJSON(w, http.StatusNotImplemented, nil)
+func getCompareThresholds(r *http.Request) (model.CompareThresholds, error) {
+	th := model.DefaultCompareThresholds
+	var err error
+	if th.Latency, err = parseThreshold(r, "latency_threshold", th.Latency); err != nil {
+		return th, err
+	}
+	if th.Throughput, err = parseThreshold(r, "throughput_threshold", th.Throughput); err != nil {
+		return th, err
+	}
+	if th.ErrorRate, err = parseThreshold(r, "error_rate_threshold", th.ErrorRate); err != nil {
+		return th, err
+	}
+	return th, nil
+}
+
+func getRunSummaries(runID int64) ([]*model.RunSummary, error) {
+	summaries, err := model.GetRunSummaries(runID)
+	if err != nil {
+		return nil, err
+	}
+	if len(summaries) == 0 {
+		return nil, makeInvalidRequestError(fmt.Sprintf("Run %d does not have any results", runID))
+	}
+	return summaries, nil
+}
+
+// Thresholds can be overridden by latency_threshold(percent), throughput_threshold(percent) and
+// error_rate_threshold(percentage points) query params.
+func (ca *CollectionAPI) runCompareHandler(w http.ResponseWriter, r *http.Request) {
+	collection, err := getCollection(r, ca.sc.AuthConfig)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	query := r.URL.Query()
+	baseRunID, err := parseRunID(query.Get("base"), collection, "base")
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	targetRunID, err := parseRunID(query.Get("target"), collection, "target")
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	th, err := getCompareThresholds(r)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	base, err := getRunSummaries(baseRunID)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	target, err := getRunSummaries(targetRunID)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	renderJSON(w, http.StatusOK, model.CompareRuns(baseRunID, targetRunID, base, target, th))
+}
+
+func (ca *CollectionAPI) runGetHandler(w http.ResponseWriter, r *http.Request) {
+	collection, err := getCollection(r, ca.sc.AuthConfig)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	if r.PathValue("run_id") == "" {
+		runs, err := collection.GetRuns()
+		if err != nil {
+			handleErrors(w, err)
+			return
+		}
+		renderJSON(w, http.StatusOK, runs)
+		return
+	}
+	runID, err := getRunID(r, collection)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	result, err := model.GetRunResult(runID)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	renderJSON(w, http.StatusOK, result)
+}
+
+func (ca *CollectionAPI) runDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	collection, err := getCollection(r, ca.sc.AuthConfig)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	currentRun, err := collection.GetCurrentRun()
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	if r.PathValue("run_id") == "" {
+		if currentRun != 0 {
+			handleErrors(w, makeInvalidRequestError("You cannot delete the runs while the collection is running"))
+			return
+		}
+		if err := collection.DeleteRunHistory(); err != nil {
+			handleErrors(w, err)
+		}
+		return
+	}
+	runID, err := getRunID(r, collection)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	if runID == currentRun {
+		handleErrors(w, makeInvalidRequestError("You cannot delete a run in progress"))
+		return
+	}
+	if err := collection.DeleteRun(runID); err != nil {
+		handleErrors(w, err)
+	}
 }
 
 func (ca *CollectionAPI) collectionStatusHandler(w http.ResponseWriter, r *http.Request) {
@@ -620,7 +1011,7 @@ func (ca *CollectionAPI) planLogHandler(w http.ResponseWriter, r *http.Request)
 		handleErrors(w, err)
 		return
 	}
-	content, err := ca.ctr.Scheduler.DownloadPodLog(collection.ID, plan.ID)
+	content, err := ca.ctr.DownloadPodLog(collection.ID, plan.ID)
 	if err != nil {
 		handleErrors(w, makeInvalidRequestError(err.Error()))
 		return
@@ -629,3 +1020,63 @@ func (ca *CollectionAPI) planLogHandler(w http.ResponseWriter, r *http.Request)
 	m["c"] = content
 	renderJSON(w, http.StatusOK, m)
 }
+
+// planLoadHandler changes the concurrency of every engine of a running plan without restarting it
+func (ca *CollectionAPI) planLoadHandler(w http.ResponseWriter, r *http.Request) {
+	collection, err := getCollection(r, ca.sc.AuthConfig)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	plan, err := getPlan(r, ca.sc.AuthConfig)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	r.ParseForm()
+	concurrency, err := strconv.Atoi(r.Form.Get("concurrency"))
+	if err != nil || concurrency <= 0 {
+		handleErrors(w, makeInvalidRequestError("concurrency should be a positive number"))
+		return
+	}
+	ep, err := model.GetExecutionPlan(collection.ID, plan.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			handleErrors(w, makeInvalidRequestError("The plan is not in the collection"))
+			return
+		}
+		handleErrors(w, err)
+		return
+	}
+	if len(ep.Stages) > 0 {
+		handleErrors(w, makeInvalidRequestError("The load of a plan with stages cannot be changed"))
+		return
+	}
+	if !registry.SupportsLiveScaling(plan.Kind) {
+		handleErrors(w, makeInvalidRequestError(fmt.Sprintf("%s plans cannot be scaled during a run", plan.Kind)))
+		return
+	}
+	if !ep.Scalable {
+		handleErrors(w, makeInvalidRequestError("The plan needs to be scalable in the collection config to change its load"))
+		return
+	}
+	if _, err := model.GetRunningPlan(collection.ID, plan.ID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			handleErrors(w, makeInvalidRequestError("The plan is not running"))
+			return
+		}
+		handleErrors(w, err)
+		return
+	}
+	if err := ca.ctr.ScalePlan(collection, ep, concurrency); err != nil {
+		if errors.Is(err, controller.QuotaError) {
+			handleErrors(w, makeInvalidRequestError(err.Error()))
+			return
+		}
+		handleErrors(w, makeInternalServerError(err.Error()))
+		return
+	}
+	// only the running engines use the new concurrency, the collection config is unchanged
+	ep.Concurrency = concurrency
+	renderJSON(w, http.StatusOK, ep)
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/rakutentech/shibuya/shibuya/config"
+	"github.com/rakutentech/shibuya/shibuya/model"
+)
+
+// same as the size of the description columns
+const maxDescriptionLength = 1024
+
+// metadataUpdate is an update of the name, description and tags shared by projects, collections
+// and plans. Fields missing from the form keep their current values.
+type metadataUpdate struct {
+	name        string
+	description string
+	// tags is nil when the tags are not changed
+	tags model.Tags
+}
+
+func parseMetadataUpdate(r *http.Request, name, description string) (*metadataUpdate, error) {
+	mu := &metadataUpdate{name: name, description: description}
+	if _, ok := r.Form["name"]; ok {
+		mu.name = r.Form.Get("name")
+		if mu.name == "" {
+			return nil, makeInvalidRequestError("name cannot be empty")
+		}
+	}
+	if _, ok := r.Form["description"]; ok {
+		mu.description = r.Form.Get("description")
+		if len(mu.description) > maxDescriptionLength {
+			return nil, makeInvalidRequestError(fmt.Sprintf("description cannot be longer than %d", maxDescriptionLength))
+		}
+	}
+	if _, ok := r.Form["tags"]; ok {
+		tags, err := model.ParseTags(r.Form.Get("tags"))
+		if err != nil {
+			return nil, makeInvalidRequestError(err.Error())
+		}
+		mu.tags = tags
+	}
+	return mu, nil
+}
+
+// getTagSelector returns the tags the listed resources need to have
+func getTagSelector(r *http.Request) (model.Tags, error) {
+	selector, err := model.ParseTags(r.URL.Query().Get("tags"))
+	if err != nil {
+		return nil, makeInvalidRequestError(err.Error())
+	}
+	return selector, nil
+}
+
+// getListedProjects returns the projects of the form, or all the projects of the account when the
+// form doesn't have a project
+func getListedProjects(r *http.Request, authConfig *config.AuthConfig) ([]*model.Project, error) {
+	if r.Form.Get("project_id") != "" {
+		project, err := getProjectFromForm(r, authConfig)
+		if err != nil {
+			return nil, err
+		}
+		return []*model.Project{project}, nil
+	}
+	account := r.Context().Value(accountKey).(*model.Account)
+	return model.GetProjectsByOwners(account.ML)
+}
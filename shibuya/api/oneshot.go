@@ -0,0 +1,142 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rakutentech/shibuya/shibuya/controller"
+	authtoken "github.com/rakutentech/shibuya/shibuya/http/auth/token"
+	"github.com/rakutentech/shibuya/shibuya/model"
+)
+
+const (
+	oneShotStreamInterval = 2 * time.Second
+)
+
+func getOneShotRun(r *http.Request, collection *model.Collection) (*model.OneShotRun, error) {
+	id, err := strconv.ParseInt(r.PathValue("handle_id"), 10, 64)
+	if err != nil {
+		return nil, makeInvalidResourceError("handle_id")
+	}
+	o, err := model.GetOneShotRun(id)
+	if err != nil || o.CollectionID != collection.ID {
+		return nil, makeInvalidResourceError("handle_id")
+	}
+	return o, nil
+}
+
+// collectionRunHandler deploys, triggers and purges the collection in one request. The engines are
+// purged after the run unless purge_after is false. timeout is a duration such as 30m and covers
+// the whole run including the deployment.
+func (ca *CollectionAPI) collectionRunHandler(w http.ResponseWriter, r *http.Request) {
+	collection, err := getCollection(r, ca.sc.AuthConfig)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	r.ParseForm()
+	purgeAfter := true
+	if v := r.Form.Get("purge_after"); v != "" {
+		if purgeAfter, err = strconv.ParseBool(v); err != nil {
+			handleErrors(w, makeInvalidRequestError("purge_after should be a boolean"))
+			return
+		}
+	}
+	timeout := controller.MaxRunTimeout
+	if v := r.Form.Get("timeout"); v != "" {
+		if timeout, err = time.ParseDuration(v); err != nil || timeout <= 0 || timeout > controller.MaxRunTimeout {
+			handleErrors(w, makeInvalidRequestError(fmt.Sprintf("timeout should be a duration up to %v", controller.MaxRunTimeout)))
+			return
+		}
+	}
+	if err := ca.ctr.CheckRunnable(collection); err != nil {
+		handleErrors(w, makeInvalidRequestError(err.Error()))
+		return
+	}
+	account := r.Context().Value(accountKey).(*model.Account)
+	token, err := authtoken.GenToken(account.Name, account.ML, controller.MaxRunTimeout)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	o, err := ca.ctr.RunOneShot(collection, token, account.Name, purgeAfter, timeout)
+	if errors.Is(err, model.ErrOneShotRunActive) {
+		handleErrors(w, makeInvalidRequestError(err.Error()))
+		return
+	}
+	if err != nil {
+		handleErrors(w, makeInternalServerError(err.Error()))
+		return
+	}
+	renderJSON(w, http.StatusOK, o)
+}
+
+func (ca *CollectionAPI) oneShotRunGetHandler(w http.ResponseWriter, r *http.Request) {
+	collection, err := getCollection(r, ca.sc.AuthConfig)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	o, err := getOneShotRun(r, collection)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	renderJSON(w, http.StatusOK, o)
+}
+
+// oneShotRunStreamHandler sends the handle every time its phase changes until the run is finished
+func (ca *CollectionAPI) oneShotRunStreamHandler(w http.ResponseWriter, r *http.Request) {
+	collection, err := getCollection(r, ca.sc.AuthConfig)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	o, err := getOneShotRun(r, collection)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported!", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	ticker := time.NewTicker(oneShotStreamInterval)
+	defer ticker.Stop()
+	lastPhase := ""
+	for {
+		if o.Phase != lastPhase {
+			s, err := json.Marshal(o)
+			if err != nil {
+				fmt.Fprintf(w, "data:%v\n\n", err)
+			} else {
+				fmt.Fprintf(w, "data:%s\n\n", s)
+			}
+			flusher.Flush()
+			lastPhase = o.Phase
+		}
+		if model.IsTerminalPhase(o.Phase) {
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+		if o, err = model.GetOneShotRun(o.ID); err != nil {
+			fmt.Fprintf(w, "data:%v\n\n", err)
+			flusher.Flush()
+			return
+		}
+	}
+}
@@ -1,10 +1,10 @@
 package api
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
-	"github.com/go-sql-driver/mysql"
 	"github.com/rakutentech/shibuya/shibuya/config"
 	httproute "github.com/rakutentech/shibuya/shibuya/http/route"
 	"github.com/rakutentech/shibuya/shibuya/model"
@@ -27,6 +27,11 @@ func NewPlanAPI(sc config.ShibuyaConfig, objStorage object_storage.StorageInterf
 func (pa *PlanAPI) Router() *httproute.Router {
 	router := httproute.NewRouter("plan api", "/plans")
 	router.AddRoutes(httproute.Routes{
+		{
+			Name:        "Get plans",
+			Method:      "GET",
+			HandlerFunc: pa.plansGetHandler,
+		},
 		{
 			Name:        "Create a plan",
 			Method:      "POST",
@@ -50,6 +55,24 @@ func (pa *PlanAPI) Router() *httproute.Router {
 			Path:        "{plan_id}",
 			HandlerFunc: pa.planDeleteHandler,
 		},
+		{
+			Name:        "Clone a plan",
+			Method:      "POST",
+			Path:        "{plan_id}/clone",
+			HandlerFunc: pa.planCloneHandler,
+		},
+		{
+			Name:        "Get the versions of the plan files",
+			Method:      "GET",
+			Path:        "{plan_id}/versions",
+			HandlerFunc: pa.planVersionsHandler,
+		},
+		{
+			Name:        "Download a version of the plan files",
+			Method:      "GET",
+			Path:        "{plan_id}/versions/{version}",
+			HandlerFunc: pa.planVersionDownloadHandler,
+		},
 		{
 			Name:        "Get a plan files",
 			Method:      "GET",
@@ -132,8 +155,62 @@ func (pa *PlanAPI) planGetHandler(w http.ResponseWriter, r *http.Request) {
 	renderJSON(w, http.StatusOK, plan)
 }
 
-func (pa *PlanAPI) planUpdateHandler(w http.ResponseWriter, _ *http.Request) {
-	renderJSON(w, http.StatusNotImplemented, nil)
+// plansGetHandler lists the plans of a project, or of all the projects of the account, having the
+// tags of the selector
+func (pa *PlanAPI) plansGetHandler(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	selector, err := getTagSelector(r)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	projects, err := getListedProjects(r, pa.sc.AuthConfig)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	plans := []*model.Plan{}
+	for _, p := range projects {
+		pps, err := p.GetPlans()
+		if err != nil {
+			handleErrors(w, err)
+			return
+		}
+		for _, plan := range pps {
+			if plan.Tags.Match(selector) {
+				plans = append(plans, plan)
+			}
+		}
+	}
+	renderJSON(w, http.StatusOK, plans)
+}
+
+func (pa *PlanAPI) planUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	plan, err := getPlan(r, pa.sc.AuthConfig)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	r.ParseForm()
+	mu, err := parseMetadataUpdate(r, plan.Name, plan.Description)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	if err := plan.Update(mu.name, mu.description); err != nil {
+		if errors.Is(err, model.ErrNameTaken) {
+			err = makeInvalidRequestError(err.Error())
+		}
+		handleErrors(w, err)
+		return
+	}
+	if mu.tags != nil {
+		if err := plan.StoreTags(mu.tags); err != nil {
+			handleErrors(w, err)
+			return
+		}
+	}
+	renderJSON(w, http.StatusOK, plan)
 }
 
 func (pa *PlanAPI) planDeleteHandler(w http.ResponseWriter, r *http.Request) {
@@ -154,6 +231,32 @@ func (pa *PlanAPI) planDeleteHandler(w http.ResponseWriter, r *http.Request) {
 	plan.Delete(pa.objStorage)
 }
 
+// planCloneHandler copies the plan with its files, optionally into another project of the caller
+func (pa *PlanAPI) planCloneHandler(w http.ResponseWriter, r *http.Request) {
+	account := r.Context().Value(accountKey).(*model.Account)
+	plan, err := getPlan(r, pa.sc.AuthConfig)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	r.ParseForm()
+	projectID, err := getCloneTarget(r, plan.ProjectID, pa.sc.AuthConfig)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	clone, err := plan.Clone(pa.objStorage, projectID, r.Form.Get("name"), account.Name)
+	if errors.Is(err, model.ErrNameTaken) {
+		handleErrors(w, makeInvalidRequestError(err.Error()))
+		return
+	}
+	if err != nil {
+		handleErrors(w, makeInternalServerError(err.Error()))
+		return
+	}
+	renderJSON(w, http.StatusOK, clone)
+}
+
 func (pa *PlanAPI) planFilesUploadHandler(w http.ResponseWriter, r *http.Request) {
 	plan, err := getPlan(r, pa.sc.AuthConfig)
 	if err != nil {
@@ -166,19 +269,10 @@ func (pa *PlanAPI) planFilesUploadHandler(w http.ResponseWriter, r *http.Request
 		handleErrors(w, makeInvalidRequestError("Something wrong with file you uploaded"))
 		return
 	}
-	if plan.IsTestFile(handler.Filename) && !plan.IsThePlanFileValid(handler.Filename) {
-		handleErrors(w, makeInvalidRequestError("Wrong file for the plan"))
-		return
-	}
-	err = plan.StoreFile(pa.objStorage, file, handler.Filename)
+	account := r.Context().Value(accountKey).(*model.Account)
+	// uploading a file with the same name replaces it and keeps the previous one as a version
+	err = plan.StoreFile(pa.objStorage, file, handler.Filename, account.Name)
 	if err != nil {
-		// TODO need to handle the upload error here
-		if driverErr, ok := err.(*mysql.MySQLError); ok {
-			if driverErr.Number == 1062 {
-				handleErrors(w, makeInvalidRequestError("File already exists. If you wish to update it then delete existing one and upload again."))
-				return
-			}
-		}
 		handleErrors(w, makeInternalServerError(err.Error()))
 		return
 	}
@@ -196,6 +290,39 @@ func (pa *PlanAPI) planFilesDownloadHandler(w http.ResponseWriter, r *http.Reque
 	serveFile(pa.objStorage, w, r, filename)
 }
 
+func (pa *PlanAPI) planVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	plan, err := getPlan(r, pa.sc.AuthConfig)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	versions, err := plan.GetVersions()
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	renderJSON(w, http.StatusOK, versions)
+}
+
+func (pa *PlanAPI) planVersionDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	plan, err := getPlan(r, pa.sc.AuthConfig)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	version, err := strconv.ParseInt(r.PathValue("version"), 10, 64)
+	if err != nil {
+		handleErrors(w, makeInvalidResourceError("version"))
+		return
+	}
+	v, err := plan.GetVersion(version)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	serveFile(pa.objStorage, w, r, plan.MakeVersionFileName(v.Version, v.Filename))
+}
+
 func (pa *PlanAPI) planFilesDeleteHandler(w http.ResponseWriter, r *http.Request) {
 	plan, err := getPlan(r, pa.sc.AuthConfig)
 	if err != nil {
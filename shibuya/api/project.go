@@ -1,6 +1,7 @@
 package api
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -87,6 +88,19 @@ func getProjectFromForm(r *http.Request, authConfig *config.AuthConfig) (*model.
 	return getProject(int64(pid), account, authConfig)
 }
 
+// getCloneTarget returns the project to clone into, which is the project of the form or the
+// project of the source by default
+func getCloneTarget(r *http.Request, sourceProjectID int64, authConfig *config.AuthConfig) (int64, error) {
+	if r.Form.Get("project_id") == "" {
+		return sourceProjectID, nil
+	}
+	project, err := getProjectFromForm(r, authConfig)
+	if err != nil {
+		return 0, err
+	}
+	return project.ID, nil
+}
+
 func (pa *ProjectAPI) projectCreateHandler(w http.ResponseWriter, r *http.Request) {
 	account := r.Context().Value(accountKey).(*model.Account)
 	r.ParseForm()
@@ -139,8 +153,47 @@ func (pa *ProjectAPI) projectGetHandler(w http.ResponseWriter, r *http.Request)
 	renderJSON(w, http.StatusOK, project)
 }
 
-func (pa *ProjectAPI) projectUpdateHandler(w http.ResponseWriter, _ *http.Request) {
-	renderJSON(w, http.StatusNotImplemented, nil)
+// projectUpdateHandler changes the name, owner, description and tags of the project. The new owner
+// needs to be one of the groups of the account unless the account is an admin.
+func (pa *ProjectAPI) projectUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	project, err := getProjectFromPath(r, pa.sc.AuthConfig)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	r.ParseForm()
+	mu, err := parseMetadataUpdate(r, project.Name, project.Description)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	owner := project.Owner
+	if _, ok := r.Form["owner"]; ok {
+		owner = r.Form.Get("owner")
+		if owner == "" {
+			handleErrors(w, makeInvalidRequestError("Owner name cannot be empty"))
+			return
+		}
+		account := r.Context().Value(accountKey).(*model.Account)
+		if _, ok := account.MLMap[owner]; !ok && !account.IsAdmin(pa.sc.AuthConfig) {
+			handleErrors(w, makeNoPermissionErr(fmt.Sprintf("You are not part of %s", owner)))
+			return
+		}
+	}
+	if err := project.Update(mu.name, owner, mu.description); err != nil {
+		if errors.Is(err, model.ErrNameTaken) {
+			err = makeInvalidRequestError(err.Error())
+		}
+		handleErrors(w, err)
+		return
+	}
+	if mu.tags != nil {
+		if err := project.StoreTags(mu.tags); err != nil {
+			handleErrors(w, err)
+			return
+		}
+	}
+	renderJSON(w, http.StatusOK, project)
 }
 
 func (pa *ProjectAPI) projectDeleteHandler(w http.ResponseWriter, r *http.Request) {
@@ -193,7 +246,21 @@ func (pa *ProjectAPI) projectsGetHandler(w http.ResponseWriter, r *http.Request)
 	} else {
 		includePlans = false
 	}
+	selector, err := getTagSelector(r)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
 	projects, _ := model.GetProjectsByOwners(account.ML)
+	if len(selector) > 0 {
+		matched := []*model.Project{}
+		for _, p := range projects {
+			if p.Tags.Match(selector) {
+				matched = append(matched, p)
+			}
+		}
+		projects = matched
+	}
 	if !includeCollections && !includePlans {
 		renderJSON(w, http.StatusOK, projects)
 		return
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/rakutentech/shibuya/shibuya/model"
+)
+
+func (ca *CollectionAPI) queueGetHandler(w http.ResponseWriter, r *http.Request) {
+	collection, err := getCollection(r, ca.sc.AuthConfig)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	qd, err := collection.GetQueuedDeployment()
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	renderJSON(w, http.StatusOK, qd)
+}
+
+func (ca *CollectionAPI) queueDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	collection, err := getCollection(r, ca.sc.AuthConfig)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	if _, err := collection.GetQueuedDeployment(); err != nil {
+		handleErrors(w, err)
+		return
+	}
+	if err := collection.DequeueDeployment(); err != nil {
+		handleErrors(w, makeInternalServerError(err.Error()))
+		return
+	}
+}
+
+func (aa *AdminAPI) queueAdminGetHandler(w http.ResponseWriter, r *http.Request) {
+	account := r.Context().Value(accountKey).(*model.Account)
+	if !account.IsAdmin(aa.authConfig) {
+		handleErrors(w, makeNoPermissionErr("Only the admins can list the deploy queue"))
+		return
+	}
+	queue, err := model.GetQueuedDeployments()
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	renderJSON(w, http.StatusOK, queue)
+}
+
+// queuePriorityHandler overrides the priority the deployment got from its project
+func (aa *AdminAPI) queuePriorityHandler(w http.ResponseWriter, r *http.Request) {
+	account := r.Context().Value(accountKey).(*model.Account)
+	if !account.IsAdmin(aa.authConfig) {
+		handleErrors(w, makeNoPermissionErr("Only the admins can change the priority of a deployment"))
+		return
+	}
+	collectionID, err := strconv.ParseInt(r.PathValue("collection_id"), 10, 64)
+	if err != nil {
+		handleErrors(w, makeInvalidResourceError("collection_id"))
+		return
+	}
+	r.ParseForm()
+	priority, err := strconv.Atoi(r.Form.Get("priority"))
+	if err != nil {
+		handleErrors(w, makeInvalidRequestError("priority should be an integer"))
+		return
+	}
+	collection, err := model.GetCollection(collectionID)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	qd, err := collection.GetQueuedDeployment()
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	if qd.Failed {
+		handleErrors(w, makeInvalidRequestError("The deployment of the collection failed and is not queued anymore"))
+		return
+	}
+	if err := qd.SetPriority(priority); err != nil {
+		handleErrors(w, makeInternalServerError(err.Error()))
+		return
+	}
+	// the position changes with the priority
+	if qd, err = collection.GetQueuedDeployment(); err != nil {
+		handleErrors(w, err)
+		return
+	}
+	renderJSON(w, http.StatusOK, qd)
+}
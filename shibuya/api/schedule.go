@@ -0,0 +1,149 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/rakutentech/shibuya/shibuya/model"
+)
+
+func getSchedule(r *http.Request, collection *model.Collection) (*model.Schedule, error) {
+	scheduleID, err := strconv.ParseInt(r.PathValue("schedule_id"), 10, 64)
+	if err != nil {
+		return nil, makeInvalidResourceError("schedule_id")
+	}
+	schedule, err := model.GetSchedule(scheduleID)
+	if err != nil || schedule.CollectionID != collection.ID {
+		return nil, makeInvalidResourceError("schedule_id")
+	}
+	return schedule, nil
+}
+
+// parseScheduleForm overrides the fields of the schedule which are present in the form
+func parseScheduleForm(r *http.Request, schedule *model.Schedule) error {
+	r.ParseForm()
+	if _, ok := r.Form["cron"]; ok {
+		schedule.Cron = r.Form.Get("cron")
+	}
+	if _, ok := r.Form["timezone"]; ok {
+		schedule.Timezone = r.Form.Get("timezone")
+	}
+	if _, ok := r.Form["enabled"]; ok {
+		enabled, err := strconv.ParseBool(r.Form.Get("enabled"))
+		if err != nil {
+			return makeInvalidRequestError("enabled should be a boolean")
+		}
+		schedule.Enabled = enabled
+	}
+	if schedule.Cron == "" {
+		return makeInvalidRequestError("cron cannot be empty")
+	}
+	if err := schedule.Validate(); err != nil {
+		return makeInvalidRequestError(err.Error())
+	}
+	return nil
+}
+
+func (ca *CollectionAPI) scheduleGetHandler(w http.ResponseWriter, r *http.Request) {
+	collection, err := getCollection(r, ca.sc.AuthConfig)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	if r.PathValue("schedule_id") == "" {
+		schedules, err := collection.GetSchedules()
+		if err != nil {
+			handleErrors(w, err)
+			return
+		}
+		renderJSON(w, http.StatusOK, schedules)
+		return
+	}
+	schedule, err := getSchedule(r, collection)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	renderJSON(w, http.StatusOK, schedule)
+}
+
+func (ca *CollectionAPI) scheduleCreateHandler(w http.ResponseWriter, r *http.Request) {
+	collection, err := getCollection(r, ca.sc.AuthConfig)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	account := r.Context().Value(accountKey).(*model.Account)
+	schedule := &model.Schedule{
+		Timezone:  "UTC",
+		Enabled:   true,
+		CreatedBy: account.Name,
+	}
+	if err := parseScheduleForm(r, schedule); err != nil {
+		handleErrors(w, err)
+		return
+	}
+	if err := collection.CreateSchedule(schedule); err != nil {
+		handleErrors(w, makeInternalServerError(err.Error()))
+		return
+	}
+	renderJSON(w, http.StatusOK, schedule)
+}
+
+func (ca *CollectionAPI) scheduleUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	collection, err := getCollection(r, ca.sc.AuthConfig)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	schedule, err := getSchedule(r, collection)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	if err := parseScheduleForm(r, schedule); err != nil {
+		handleErrors(w, err)
+		return
+	}
+	if err := schedule.Update(); err != nil {
+		handleErrors(w, makeInternalServerError(err.Error()))
+		return
+	}
+	renderJSON(w, http.StatusOK, schedule)
+}
+
+func (ca *CollectionAPI) scheduleDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	collection, err := getCollection(r, ca.sc.AuthConfig)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	schedule, err := getSchedule(r, collection)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	if err := schedule.Delete(); err != nil {
+		handleErrors(w, makeInternalServerError(err.Error()))
+		return
+	}
+}
+
+func (ca *CollectionAPI) scheduleRunsHandler(w http.ResponseWriter, r *http.Request) {
+	collection, err := getCollection(r, ca.sc.AuthConfig)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	schedule, err := getSchedule(r, collection)
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	runs, err := schedule.GetRuns()
+	if err != nil {
+		handleErrors(w, err)
+		return
+	}
+	renderJSON(w, http.StatusOK, runs)
+}
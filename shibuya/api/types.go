@@ -6,5 +6,8 @@ import (
 )
 
 type ShibuyaObject interface {
-	*model.Project | *model.Collection | *model.Plan | *smodel.CollectionStatus
+	*model.Project | *model.Collection | *model.Plan | *smodel.CollectionStatus | *model.RunResult |
+		*model.RunComparison | *model.Schedule | *[]*model.Schedule | *[]*model.ScheduleRun |
+		*model.OneShotRun | *[]*model.RunHistory | *model.ApplyResult |
+		*[]*model.PlanFileVersion | *[]*model.Project | *[]*model.Collection | *[]*model.Plan
 }
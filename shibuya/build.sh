@@ -10,10 +10,17 @@ case "$target" in
     ;;
     "locust") GOOS=linux GOARCH=amd64 go build -ldflags="-w -s" -o build/locust-agent $(pwd)/engines/locust/cmd
     ;;
+    "k6") GOOS=linux GOARCH=amd64 go build -ldflags="-w -s" -o build/k6-agent $(pwd)/engines/k6/cmd
+    ;;
+    "gatling") GOOS=linux GOARCH=amd64 go build -ldflags="-w -s" -o build/gatling-agent $(pwd)/engines/gatling/cmd
+    ;;
     "controller") GOOS=linux GOARCH=amd64 go build -ldflags="-w -s" -o build/shibuya-controller $(pwd)/controller/cmd
     ;;
     "coordinator") GOOS=linux GOARCH=amd64 go build -ldflags="-w -s" -o build/shibuya-coordinator $(pwd)/coordinator
     ;;
+    # the cli is built for the current platform
+    "cli") go build -ldflags="-w -s" -o build/shibuya-cli $(pwd)/cmd/shibuya
+    ;;
     *)
     GOOS=linux GOARCH=amd64 go build -ldflags="-w -s" -o build/shibuya
 esac
@@ -0,0 +1,106 @@
+package client
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/rakutentech/shibuya/shibuya/model"
+)
+
+type ApplyClient struct {
+	Meta
+	*ClientOpts
+}
+
+func NewApplyClient(clientOpts *ClientOpts) *ApplyClient {
+	return &ApplyClient{
+		Meta: Meta{
+			Kind: "apply",
+		},
+		ClientOpts: clientOpts,
+	}
+}
+
+// makeManifestRequest puts the manifest and all the files it refers to into a multipart form. The
+// paths of the files are relative to the manifest.
+func makeManifestRequest(resourceUrl, manifestPath string) (*http.Request, error) {
+	raw, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := model.ParseManifest(raw)
+	if err != nil {
+		return nil, err
+	}
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("manifest", filepath.Base(manifestPath))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := part.Write(raw); err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(manifestPath)
+	for _, p := range manifest.Files() {
+		filename := p
+		if !filepath.IsAbs(filename) {
+			filename = filepath.Join(dir, filename)
+		}
+		if err := addFormFile(writer, p, filename); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", resourceUrl, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req, nil
+}
+
+func addFormFile(writer *multipart.Writer, formName, filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	part, err := writer.CreateFormFile(formName, filepath.Base(filename))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(part, file)
+	return err
+}
+
+// Apply reconciles the project in the manifest with its plans and collections to the manifest.
+// With dryRun, nothing is changed and only the changes are returned.
+func (ac *ApplyClient) Apply(manifestPath string, dryRun, prune bool) (*model.ApplyResult, error) {
+	query := url.Values{}
+	query.Set("dry_run", strconv.FormatBool(dryRun))
+	query.Set("prune", strconv.FormatBool(prune))
+	resourceUrl := ac.ResourceUrl(ac.Endpoint, "") + "?" + query.Encode()
+	req, err := makeManifestRequest(resourceUrl, manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := ac.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	result := new(model.ApplyResult)
+	if err := handleResponse(resp, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 
 	es "github.com/iandyh/eventsource"
 	"github.com/rakutentech/shibuya/shibuya/model"
@@ -97,6 +98,23 @@ func (cc *CollectionClient) sendCollectionVerbReq(collectionID int64, verb strin
 	return handleResponse(resp, nil)
 }
 
+// List returns the collections of the project, or of all the projects when projectID is 0, having
+// the tags of the selector
+func (cc *CollectionClient) List(projectID int64, tags string) ([]*model.Collection, error) {
+	resourceUrl := makeListUrl(cc.ResourceUrl(cc.Endpoint, ""), projectID, tags)
+	collections, err := sendGetRequest(cc.Client, resourceUrl, &[]*model.Collection{})
+	if err != nil {
+		return nil, err
+	}
+	return *collections, nil
+}
+
+// Update only changes the fields in params, which are name, description and tags
+func (cc *CollectionClient) Update(collectionID int64, params map[string]string) (*model.Collection, error) {
+	resourceUrl := cc.ResourceUrl(cc.Endpoint, strconv.Itoa(int(collectionID)))
+	return sendUpdateRequest(cc.Client, resourceUrl, params, &model.Collection{})
+}
+
 func (cc *CollectionClient) Delete(collectionID int64) error {
 	resourceUrl := cc.ResourceUrl(cc.Endpoint, strconv.Itoa(int(collectionID)))
 	return sendDeleteRequest(cc.Client, resourceUrl)
@@ -107,6 +125,12 @@ func (cc *CollectionClient) Get(collectionID int64) (*model.Collection, error) {
 	return sendGetRequest(cc.Client, resourceUrl, &model.Collection{})
 }
 
+// Clone copies the collection into the project, or into its own project when projectID is 0
+func (cc *CollectionClient) Clone(collectionID, projectID int64, name string) (*model.Collection, error) {
+	resourceUrl := cc.ResourceUrl(cc.Endpoint, fmt.Sprintf("%d/clone", collectionID))
+	return sendCreateRequest(cc.Client, resourceUrl, makeCloneParams(projectID, name), &model.Collection{})
+}
+
 func (cc *CollectionClient) Launch(collectionID int64) error {
 	return cc.sendCollectionVerbReq(collectionID, "deploy")
 }
@@ -130,3 +154,105 @@ func (cc *CollectionClient) Status(collectionID int64) (*smodel.CollectionStatus
 	return sendGetRequest(cc.Client, resourceUrl, &smodel.CollectionStatus{})
 
 }
+
+// ScalePlan changes the concurrency of every engine of a running plan
+func (cc *CollectionClient) ScalePlan(collectionID, planID int64, concurrency int) error {
+	subResource := fmt.Sprintf("%d/plans/%d/load", collectionID, planID)
+	resourceUrl := cc.ResourceUrl(cc.Endpoint, subResource)
+	values := makeFormValues(map[string]string{"concurrency": strconv.Itoa(concurrency)})
+	req, err := http.NewRequest("PATCH", resourceUrl, strings.NewReader(values.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := cc.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return handleResponse(resp, nil)
+}
+
+// Run deploys, triggers and purges the collection on the server side. The phase of the returned
+// handle can be polled with GetRunHandle.
+func (cc *CollectionClient) Run(collectionID int64, purgeAfter bool, timeout string) (*model.OneShotRun, error) {
+	subResource := fmt.Sprintf("%d/run", collectionID)
+	resourceUrl := cc.ResourceUrl(cc.Endpoint, subResource)
+	params := map[string]string{
+		"purge_after": strconv.FormatBool(purgeAfter),
+		"timeout":     timeout,
+	}
+	return sendCreateRequest(cc.Client, resourceUrl, params, &model.OneShotRun{})
+}
+
+func (cc *CollectionClient) GetRunHandle(collectionID, handleID int64) (*model.OneShotRun, error) {
+	subResource := fmt.Sprintf("%d/run/%d", collectionID, handleID)
+	resourceUrl := cc.ResourceUrl(cc.Endpoint, subResource)
+	return sendGetRequest(cc.Client, resourceUrl, &model.OneShotRun{})
+}
+
+func (cc *CollectionClient) CreateSchedule(collectionID int64, cron, timezone string) (*model.Schedule, error) {
+	subResource := fmt.Sprintf("%d/schedules", collectionID)
+	resourceUrl := cc.ResourceUrl(cc.Endpoint, subResource)
+	params := map[string]string{
+		"cron":     cron,
+		"timezone": timezone,
+	}
+	return sendCreateRequest(cc.Client, resourceUrl, params, &model.Schedule{})
+}
+
+func (cc *CollectionClient) GetSchedules(collectionID int64) ([]*model.Schedule, error) {
+	subResource := fmt.Sprintf("%d/schedules", collectionID)
+	resourceUrl := cc.ResourceUrl(cc.Endpoint, subResource)
+	schedules, err := sendGetRequest(cc.Client, resourceUrl, &[]*model.Schedule{})
+	if err != nil {
+		return nil, err
+	}
+	return *schedules, nil
+}
+
+// UpdateSchedule only changes the fields in params, which are cron, timezone and enabled
+func (cc *CollectionClient) UpdateSchedule(collectionID, scheduleID int64, params map[string]string) (*model.Schedule, error) {
+	subResource := fmt.Sprintf("%d/schedules/%d", collectionID, scheduleID)
+	resourceUrl := cc.ResourceUrl(cc.Endpoint, subResource)
+	return sendUpdateRequest(cc.Client, resourceUrl, params, &model.Schedule{})
+}
+
+func (cc *CollectionClient) DeleteSchedule(collectionID, scheduleID int64) error {
+	subResource := fmt.Sprintf("%d/schedules/%d", collectionID, scheduleID)
+	resourceUrl := cc.ResourceUrl(cc.Endpoint, subResource)
+	return sendDeleteRequest(cc.Client, resourceUrl)
+}
+
+func (cc *CollectionClient) GetScheduleRuns(collectionID, scheduleID int64) ([]*model.ScheduleRun, error) {
+	subResource := fmt.Sprintf("%d/schedules/%d/runs", collectionID, scheduleID)
+	resourceUrl := cc.ResourceUrl(cc.Endpoint, subResource)
+	runs, err := sendGetRequest(cc.Client, resourceUrl, &[]*model.ScheduleRun{})
+	if err != nil {
+		return nil, err
+	}
+	return *runs, nil
+}
+
+// GetRuns returns the runs of the collection, the latest first
+func (cc *CollectionClient) GetRuns(collectionID int64) ([]*model.RunHistory, error) {
+	subResource := fmt.Sprintf("%d/runs", collectionID)
+	resourceUrl := cc.ResourceUrl(cc.Endpoint, subResource)
+	runs, err := sendGetRequest(cc.Client, resourceUrl, &[]*model.RunHistory{})
+	if err != nil {
+		return nil, err
+	}
+	return *runs, nil
+}
+
+func (cc *CollectionClient) GetRun(collectionID, runID int64) (*model.RunResult, error) {
+	subResource := fmt.Sprintf("%d/runs/%d", collectionID, runID)
+	resourceUrl := cc.ResourceUrl(cc.Endpoint, subResource)
+	return sendGetRequest(cc.Client, resourceUrl, &model.RunResult{})
+}
+
+func (cc *CollectionClient) CompareRuns(collectionID, baseRunID, targetRunID int64) (*model.RunComparison, error) {
+	subResource := fmt.Sprintf("%d/runs/compare?base=%d&target=%d", collectionID, baseRunID, targetRunID)
+	resourceUrl := cc.ResourceUrl(cc.Endpoint, subResource)
+	return sendGetRequest(cc.Client, resourceUrl, &model.RunComparison{})
+}
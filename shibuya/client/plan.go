@@ -2,6 +2,8 @@ package client
 
 import (
 	"fmt"
+	"io"
+	"net/http"
 	"os"
 
 	"github.com/rakutentech/shibuya/shibuya/model"
@@ -47,6 +49,23 @@ func (pc *PlanClient) UploadFile(planID int64, file *os.File) error {
 	return handleResponse(resp, nil)
 }
 
+// List returns the plans of the project, or of all the projects when projectID is 0, having the
+// tags of the selector
+func (pc *PlanClient) List(projectID int64, tags string) ([]*model.Plan, error) {
+	resourceUrl := makeListUrl(pc.ResourceUrl(pc.Endpoint, ""), projectID, tags)
+	plans, err := sendGetRequest(pc.Client, resourceUrl, &[]*model.Plan{})
+	if err != nil {
+		return nil, err
+	}
+	return *plans, nil
+}
+
+// Update only changes the fields in params, which are name, description and tags
+func (pc *PlanClient) Update(planID int64, params map[string]string) (*model.Plan, error) {
+	resourceUrl := pc.ResourceUrl(pc.Endpoint, fmt.Sprintf("%d", planID))
+	return sendUpdateRequest(pc.Client, resourceUrl, params, &model.Plan{})
+}
+
 func (pc *PlanClient) Delete(planID int64) error {
 	resourceUrl := pc.ResourceUrl(pc.Endpoint, fmt.Sprintf("%d", planID))
 	return sendDeleteRequest(pc.Client, resourceUrl)
@@ -56,3 +75,32 @@ func (pc *PlanClient) Get(planID int64) (*model.Plan, error) {
 	resourceUrl := pc.ResourceUrl(pc.Endpoint, fmt.Sprintf("%d", planID))
 	return sendGetRequest(pc.Client, resourceUrl, &model.Plan{})
 }
+
+// Clone copies the plan into the project, or into its own project when projectID is 0
+func (pc *PlanClient) Clone(planID, projectID int64, name string) (*model.Plan, error) {
+	resourceUrl := pc.ResourceUrl(pc.Endpoint, fmt.Sprintf("%d/clone", planID))
+	return sendCreateRequest(pc.Client, resourceUrl, makeCloneParams(projectID, name), &model.Plan{})
+}
+
+func (pc *PlanClient) GetVersions(planID int64) ([]*model.PlanFileVersion, error) {
+	resourceUrl := pc.ResourceUrl(pc.Endpoint, fmt.Sprintf("%d/versions", planID))
+	versions, err := sendGetRequest(pc.Client, resourceUrl, &[]*model.PlanFileVersion{})
+	if err != nil {
+		return nil, err
+	}
+	return *versions, nil
+}
+
+// DownloadVersion returns the content of a version of the plan files
+func (pc *PlanClient) DownloadVersion(planID, version int64) ([]byte, error) {
+	resourceUrl := pc.ResourceUrl(pc.Endpoint, fmt.Sprintf("%d/versions/%d", planID, version))
+	resp, err := pc.Client.Get(resourceUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, handleResponse(resp, nil)
+	}
+	return io.ReadAll(resp.Body)
+}
@@ -35,6 +35,22 @@ func (pc *ProjectClient) Get(ID int64) (*model.Project, error) {
 	return sendGetRequest(pc.Client, resourceUrl, &model.Project{})
 }
 
+// List returns the projects having the tags of the selector
+func (pc *ProjectClient) List(tags string) ([]*model.Project, error) {
+	resourceUrl := makeListUrl(pc.ResourceUrl(pc.Endpoint, ""), 0, tags)
+	projects, err := sendGetRequest(pc.Client, resourceUrl, &[]*model.Project{})
+	if err != nil {
+		return nil, err
+	}
+	return *projects, nil
+}
+
+// Update only changes the fields in params, which are name, owner, description and tags
+func (pc *ProjectClient) Update(ID int64, params map[string]string) (*model.Project, error) {
+	resourceUrl := pc.ResourceUrl(pc.Endpoint, strconv.Itoa(int(ID)))
+	return sendUpdateRequest(pc.Client, resourceUrl, params, &model.Project{})
+}
+
 func (pc *ProjectClient) Delete(ID int64) error {
 	resourceUrl := pc.ResourceUrl(pc.Endpoint, strconv.Itoa(int(ID)))
 	return sendDeleteRequest(pc.Client, resourceUrl)
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/rakutentech/shibuya/shibuya/api"
@@ -20,9 +21,37 @@ func makeFormValues(params map[string]string) url.Values {
 	return values
 }
 
-func makeFormRequest(resourceUrl string, params map[string]string) (*http.Request, error) {
+// makeCloneParams leaves the project and the name out when they are not set, so the server uses
+// the ones of the source
+func makeCloneParams(projectID int64, name string) map[string]string {
+	params := map[string]string{}
+	if projectID != 0 {
+		params["project_id"] = strconv.FormatInt(projectID, 10)
+	}
+	if name != "" {
+		params["name"] = name
+	}
+	return params
+}
+
+// makeListUrl adds the project and the tag selector to the url when they are set
+func makeListUrl(resourceUrl string, projectID int64, tags string) string {
+	query := url.Values{}
+	if projectID != 0 {
+		query.Set("project_id", strconv.FormatInt(projectID, 10))
+	}
+	if tags != "" {
+		query.Set("tags", tags)
+	}
+	if len(query) == 0 {
+		return resourceUrl
+	}
+	return resourceUrl + "?" + query.Encode()
+}
+
+func makeFormRequest(method, resourceUrl string, params map[string]string) (*http.Request, error) {
 	values := makeFormValues(params)
-	req, err := http.NewRequest("POST", resourceUrl, strings.NewReader(values.Encode()))
+	req, err := http.NewRequest(method, resourceUrl, strings.NewReader(values.Encode()))
 	if err != nil {
 		return nil, err
 	}
@@ -52,7 +81,16 @@ func makeFileUploadRequest(resourceUrl, method string, formName string, file *os
 }
 
 func sendCreateRequest[T api.ShibuyaObject](client *http.Client, resourceUrl string, params map[string]string, obj T) (T, error) {
-	req, err := makeFormRequest(resourceUrl, params)
+	return sendFormRequest(client, "POST", resourceUrl, params, obj)
+}
+
+// sendUpdateRequest only sends the fields to change in params
+func sendUpdateRequest[T api.ShibuyaObject](client *http.Client, resourceUrl string, params map[string]string, obj T) (T, error) {
+	return sendFormRequest(client, "PUT", resourceUrl, params, obj)
+}
+
+func sendFormRequest[T api.ShibuyaObject](client *http.Client, method, resourceUrl string, params map[string]string, obj T) (T, error) {
+	req, err := makeFormRequest(method, resourceUrl, params)
 	if err != nil {
 		return nil, err
 	}
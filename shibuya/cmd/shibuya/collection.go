@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"time"
+
+	"github.com/rakutentech/shibuya/shibuya/model"
+)
+
+const (
+	pollInterval = 5 * time.Second
+	// the summary of a run is stored shortly after the run is marked as finished
+	summaryTimeout = time.Minute
+	// same as the maximum on the server side
+	maxRunTimeout = 24 * time.Hour
+)
+
+// metricEvent is an event of the metric stream of a collection
+type metricEvent struct {
+	PlanID string `json:"plan_id"`
+	Raw    string `json:"metrics"`
+}
+
+var collectionCommands = []*command{
+	{verb: "create", usage: "-project <project_id> <name>", run: collectionCreate},
+	{verb: "get", usage: "<collection_id>", run: collectionGet},
+	{verb: "list", usage: "[-project <project_id>] [-tags <k=v,...>]", run: collectionList},
+	{verb: "update", usage: "[-name <name>] [-description <text>] [-tags <k=v,...>] <collection_id>", run: collectionUpdate},
+	{verb: "delete", usage: "<collection_id>", run: collectionDelete},
+	{verb: "clone", usage: "[-project <project_id>] [-name <name>] <collection_id>", run: collectionClone},
+	{verb: "upload", usage: "<collection_id> <data_file>", run: collectionUpload},
+	{verb: "configure", usage: "<collection_id> <yaml_file>", run: collectionConfigure},
+	{verb: "launch", usage: "<collection_id>", run: collectionVerb("launch")},
+	{verb: "trigger", usage: "[-wait] <collection_id>", run: collectionTrigger},
+	{verb: "stop", usage: "<collection_id>", run: collectionVerb("stop")},
+	{verb: "purge", usage: "<collection_id>", run: collectionVerb("purge")},
+	{verb: "status", usage: "<collection_id>", run: collectionStatus},
+	{verb: "watch", usage: "<collection_id>", run: collectionWatch},
+	{verb: "run", usage: "[-keep] [-timeout 1h] <collection_id>", run: collectionRun},
+	{verb: "result", usage: "<collection_id> <run_id>", run: collectionResult},
+}
+
+func collectionCreate(c *cli, args []string) error {
+	fs := flag.NewFlagSet("create", flag.ContinueOnError)
+	projectID := fs.Int64("project", 0, "id of the project")
+	if err := parseFlags(fs, args, 1); err != nil {
+		return err
+	}
+	if *projectID == 0 {
+		return errUsage
+	}
+	collection, err := c.collections.Create(strconv.FormatInt(*projectID, 10), fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return c.print(collection)
+}
+
+func collectionGet(c *cli, args []string) error {
+	id, err := idArg(args)
+	if err != nil {
+		return err
+	}
+	collection, err := c.collections.Get(id)
+	if err != nil {
+		return err
+	}
+	return c.print(collection)
+}
+
+func collectionList(c *cli, args []string) error {
+	projectID, tags, err := listArgs(args, true)
+	if err != nil {
+		return err
+	}
+	collections, err := c.collections.List(projectID, tags)
+	if err != nil {
+		return err
+	}
+	return c.print(collections)
+}
+
+func collectionUpdate(c *cli, args []string) error {
+	id, params, err := updateArgs(args, "name", "description", "tags")
+	if err != nil {
+		return err
+	}
+	collection, err := c.collections.Update(id, params)
+	if err != nil {
+		return err
+	}
+	return c.print(collection)
+}
+
+func collectionDelete(c *cli, args []string) error {
+	id, err := idArg(args)
+	if err != nil {
+		return err
+	}
+	return c.collections.Delete(id)
+}
+
+func collectionClone(c *cli, args []string) error {
+	id, projectID, name, err := cloneArgs(args)
+	if err != nil {
+		return err
+	}
+	collection, err := c.collections.Clone(id, projectID, name)
+	if err != nil {
+		return err
+	}
+	return c.print(collection)
+}
+
+// withFile parses the collection id and opens the file of the commands taking both
+func withFile(args []string, f func(id int64, file *os.File) error) error {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	if err := parseFlags(fs, args, 2); err != nil {
+		return err
+	}
+	id, err := parseID(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return f(id, file)
+}
+
+func collectionUpload(c *cli, args []string) error {
+	return withFile(args, c.collections.UploadFile)
+}
+
+func collectionConfigure(c *cli, args []string) error {
+	return withFile(args, c.collections.Configure)
+}
+
+func collectionVerb(verb string) func(c *cli, args []string) error {
+	return func(c *cli, args []string) error {
+		id, err := idArg(args)
+		if err != nil {
+			return err
+		}
+		switch verb {
+		case "launch":
+			return c.collections.Launch(id)
+		case "stop":
+			return c.collections.Stop(id)
+		case "purge":
+			return c.collections.Purge(id)
+		}
+		return fmt.Errorf("unknown verb %s", verb)
+	}
+}
+
+func collectionStatus(c *cli, args []string) error {
+	id, err := idArg(args)
+	if err != nil {
+		return err
+	}
+	status, err := c.collections.Status(id)
+	if err != nil {
+		return err
+	}
+	return c.print(status)
+}
+
+// currentRun returns the run in progress, which is always the latest one
+func (c *cli) currentRun(collectionID int64) (int64, error) {
+	runs, err := c.collections.GetRuns(collectionID)
+	if err != nil {
+		return 0, err
+	}
+	if len(runs) == 0 || !runs[0].EndTime.IsZero() {
+		return 0, fmt.Errorf("collection %d does not have any run in progress", collectionID)
+	}
+	return runs[0].ID, nil
+}
+
+func collectionTrigger(c *cli, args []string) error {
+	fs := flag.NewFlagSet("trigger", flag.ContinueOnError)
+	wait := fs.Bool("wait", false, "wait for the run to finish and exit with its result")
+	if err := parseFlags(fs, args, 1); err != nil {
+		return err
+	}
+	id, err := parseID(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if err := c.collections.Trigger(id); err != nil {
+		return err
+	}
+	if !*wait {
+		return nil
+	}
+	runID, err := c.currentRun(id)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.errOut, "Waiting for run %d to finish\n", runID)
+	return c.waitRun(id, runID)
+}
+
+// waitRun waits until the run is finished and its summary and verdict are stored, then reports the result
+func (c *cli) waitRun(collectionID, runID int64) error {
+	var summaryDeadline time.Time
+	for {
+		result, err := c.collections.GetRun(collectionID, runID)
+		if err != nil {
+			return err
+		}
+		if !result.EndTime.IsZero() {
+			if summaryDeadline.IsZero() {
+				summaryDeadline = time.Now().Add(summaryTimeout)
+			}
+			judged := !result.HasCriteria || result.Verdict != ""
+			if (result.Summary != nil && judged) || time.Now().After(summaryDeadline) {
+				break
+			}
+		}
+		time.Sleep(pollInterval)
+	}
+	return c.reportRun(collectionID, runID)
+}
+
+// reportRun prints the result of the run and fails when the run fails its criteria or is aborted.
+// A finished run without a summary, or without a verdict while the collection has criteria, fails
+// too as it cannot be told whether the run passed.
+func (c *cli) reportRun(collectionID, runID int64) error {
+	result, err := c.collections.GetRun(collectionID, runID)
+	if err != nil {
+		return err
+	}
+	if err := c.print(result); err != nil {
+		return err
+	}
+	if result.AbortReason != "" {
+		return fmt.Errorf("%w: run %d is aborted, %s", errRunFailed, runID, result.AbortReason)
+	}
+	if result.Verdict == model.RunFailed {
+		return fmt.Errorf("%w: run %d does not meet the criteria", errRunFailed, runID)
+	}
+	if result.EndTime.IsZero() {
+		return nil
+	}
+	if result.Summary == nil {
+		return fmt.Errorf("%w: run %d does not have a summary", errRunFailed, runID)
+	}
+	if result.HasCriteria && result.Verdict == "" {
+		return fmt.Errorf("%w: run %d is not judged against the criteria", errRunFailed, runID)
+	}
+	return nil
+}
+
+func collectionResult(c *cli, args []string) error {
+	fs := flag.NewFlagSet("result", flag.ContinueOnError)
+	if err := parseFlags(fs, args, 2); err != nil {
+		return err
+	}
+	id, err := parseID(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	runID, err := parseID(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	return c.reportRun(id, runID)
+}
+
+// collectionRun deploys, triggers and purges the collection on the server side and waits for it
+func collectionRun(c *cli, args []string) error {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	keep := fs.Bool("keep", false, "keep the engines after the run")
+	timeout := fs.Duration("timeout", maxRunTimeout, "timeout of the whole run including the deployment")
+	if err := parseFlags(fs, args, 1); err != nil {
+		return err
+	}
+	id, err := parseID(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	handle, err := c.collections.Run(id, !*keep, timeout.String())
+	if err != nil {
+		return err
+	}
+	phase := ""
+	for {
+		if handle.Phase != phase {
+			phase = handle.Phase
+			fmt.Fprintf(c.errOut, "%s %s\n", time.Now().Format(time.TimeOnly), phase)
+		}
+		if model.IsTerminalPhase(phase) {
+			break
+		}
+		time.Sleep(pollInterval)
+		if handle, err = c.collections.GetRunHandle(id, handle.ID); err != nil {
+			return err
+		}
+	}
+	if handle.Phase == model.PhaseFailed {
+		return fmt.Errorf("run of collection %d failed: %s", id, handle.Error)
+	}
+	return c.waitRun(id, handle.RunID)
+}
+
+// collectionWatch prints the metrics of the run in progress until it's finished or interrupted
+func collectionWatch(c *cli, args []string) error {
+	id, err := idArg(args)
+	if err != nil {
+		return err
+	}
+	runID, err := c.currentRun(id)
+	if err != nil {
+		return err
+	}
+	stream, cancel, err := c.collections.Subscribe(id)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event := <-stream.Events:
+			var e metricEvent
+			if err := json.Unmarshal([]byte(event.Data()), &e); err != nil {
+				continue
+			}
+			fmt.Fprintf(c.out, "plan %s: %s\n", e.PlanID, e.Raw)
+		case err := <-stream.Errors:
+			fmt.Fprintln(c.errOut, err)
+		case <-ticker.C:
+			result, err := c.collections.GetRun(id, runID)
+			if err != nil {
+				return err
+			}
+			if !result.EndTime.IsZero() {
+				return nil
+			}
+		}
+	}
+}
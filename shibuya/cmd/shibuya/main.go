@@ -0,0 +1,180 @@
+// shibuya is the command line interface of the shibuya api. Commands look like
+//
+//	shibuya [-profile name] [-endpoint url] [-token token] <resource> <verb> [flags] [args]
+//
+// The exit code is 0 on success, 1 on errors, 2 on wrong usages and 3 when a run fails its criteria,
+// is aborted by its guards, or finishes without a summary or a verdict.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rakutentech/shibuya/shibuya/client"
+)
+
+const (
+	exitOK        = 0
+	exitError     = 1
+	exitUsage     = 2
+	exitRunFailed = 3
+)
+
+var (
+	errUsage     = errors.New("wrong usage")
+	errRunFailed = errors.New("run failed")
+)
+
+type cli struct {
+	out         io.Writer
+	errOut      io.Writer
+	configPath  string
+	profile     *Profile
+	projects    *client.ProjectClient
+	plans       *client.PlanClient
+	collections *client.CollectionClient
+	applier     *client.ApplyClient
+}
+
+type command struct {
+	verb  string
+	usage string
+	// the profile commands work without an endpoint
+	local bool
+	run   func(c *cli, args []string) error
+}
+
+var resources = map[string][]*command{
+	"profile":    profileCommands,
+	"project":    projectCommands,
+	"plan":       planCommands,
+	"collection": collectionCommands,
+	"manifest":   manifestCommands,
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: shibuya [-profile name] [-endpoint url] [-token token] <resource> <verb> [flags] [args]")
+	names := make([]string, 0, len(resources))
+	for name := range resources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "\n%s:\n", name)
+		for _, cmd := range resources[name] {
+			fmt.Fprintf(w, "  %s %s %s\n", name, cmd.verb, cmd.usage)
+		}
+	}
+}
+
+func findCommand(resource, verb string) (*command, bool) {
+	for _, cmd := range resources[resource] {
+		if cmd.verb == verb {
+			return cmd, true
+		}
+	}
+	return nil, false
+}
+
+func (c *cli) connect(name, endpoint, token string) error {
+	config, err := loadConfig(c.configPath)
+	if err != nil {
+		return err
+	}
+	c.profile, err = config.resolve(name, endpoint, token)
+	if err != nil {
+		return err
+	}
+	opts := client.NewClientOpts(strings.TrimSuffix(c.profile.Endpoint, "/"), c.profile.Token, nil)
+	c.projects = client.NewProjectClient(opts)
+	c.plans = client.NewPlanClient(opts)
+	c.collections = client.NewCollectionClient(*opts)
+	c.applier = client.NewApplyClient(opts)
+	return nil
+}
+
+func run(args []string, out, errOut io.Writer) int {
+	fs := flag.NewFlagSet("shibuya", flag.ContinueOnError)
+	fs.SetOutput(errOut)
+	fs.Usage = func() { usage(errOut) }
+	profile := fs.String("profile", "", "name of the profile in the config")
+	endpoint := fs.String("endpoint", "", "endpoint of the shibuya api, overrides the profile")
+	token := fs.String("token", "", "api token, overrides the profile")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() < 2 {
+		usage(errOut)
+		return exitUsage
+	}
+	cmd, ok := findCommand(fs.Arg(0), fs.Arg(1))
+	if !ok {
+		usage(errOut)
+		return exitUsage
+	}
+	path, err := configPath()
+	if err != nil {
+		fmt.Fprintln(errOut, err)
+		return exitError
+	}
+	c := &cli{out: out, errOut: errOut, configPath: path}
+	if !cmd.local {
+		if err := c.connect(*profile, *endpoint, *token); err != nil {
+			fmt.Fprintln(errOut, err)
+			return exitError
+		}
+	}
+	err = cmd.run(c, fs.Args()[2:])
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, errUsage):
+		fmt.Fprintf(errOut, "Usage: shibuya %s %s %s\n", fs.Arg(0), cmd.verb, cmd.usage)
+		return exitUsage
+	case errors.Is(err, errRunFailed):
+		fmt.Fprintln(errOut, err)
+		return exitRunFailed
+	}
+	fmt.Fprintln(errOut, err)
+	return exitError
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// parseFlags parses the flags of a command and checks the number of the positional arguments
+func parseFlags(fs *flag.FlagSet, args []string, nargs int) error {
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	if fs.NArg() != nargs {
+		return errUsage
+	}
+	return nil
+}
+
+func parseID(s string) (int64, error) {
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid id %s", errUsage, s)
+	}
+	return id, nil
+}
+
+func (c *cli) print(obj interface{}) error {
+	content, err := json.MarshalIndent(obj, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(c.out, string(content))
+	return nil
+}
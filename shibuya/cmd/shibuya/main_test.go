@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rakutentech/shibuya/shibuya/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func runCLI(args ...string) (int, string, string) {
+	out, errOut := &bytes.Buffer{}, &bytes.Buffer{}
+	code := run(args, out, errOut)
+	return code, out.String(), errOut.String()
+}
+
+func TestProfiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	t.Setenv("SHIBUYA_CONFIG", path)
+	t.Setenv("SHIBUYA_PROFILE", "")
+	t.Setenv("SHIBUYA_ENDPOINT", "")
+	t.Setenv("SHIBUYA_TOKEN", "")
+
+	code, _, _ := runCLI("profile", "set", "-endpoint", "http://dev", "-token", "t1", "dev")
+	assert.Equal(t, exitOK, code)
+	code, _, _ = runCLI("profile", "set", "-endpoint", "http://prod", "prod")
+	assert.Equal(t, exitOK, code)
+	code, out, _ := runCLI("profile", "list")
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "* dev\thttp://dev\n  prod\thttp://prod\n", out)
+
+	code, _, _ = runCLI("profile", "use", "staging")
+	assert.Equal(t, exitError, code)
+	code, _, _ = runCLI("profile", "use", "prod")
+	assert.Equal(t, exitOK, code)
+
+	config, err := loadConfig(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "prod", config.Current)
+
+	p, err := config.resolve("", "", "")
+	assert.Nil(t, err)
+	assert.Equal(t, &Profile{Endpoint: "http://prod"}, p)
+	p, err = config.resolve("dev", "", "")
+	assert.Nil(t, err)
+	assert.Equal(t, &Profile{Endpoint: "http://dev", Token: "t1"}, p)
+	t.Setenv("SHIBUYA_TOKEN", "t2")
+	p, err = config.resolve("dev", "http://local", "")
+	assert.Nil(t, err)
+	assert.Equal(t, &Profile{Endpoint: "http://local", Token: "t2"}, p)
+	_, err = config.resolve("staging", "", "")
+	assert.NotNil(t, err)
+}
+
+func TestUsage(t *testing.T) {
+	t.Setenv("SHIBUYA_CONFIG", filepath.Join(t.TempDir(), "config.yaml"))
+	code, _, _ := runCLI("collection")
+	assert.Equal(t, exitUsage, code)
+	code, _, _ = runCLI("collection", "explode", "1")
+	assert.Equal(t, exitUsage, code)
+	code, _, _ = runCLI("-endpoint", "http://localhost", "collection", "get", "abc")
+	assert.Equal(t, exitUsage, code)
+	code, _, _ = runCLI("-endpoint", "http://localhost", "collection", "result", "1")
+	assert.Equal(t, exitUsage, code)
+	// no endpoint is configured
+	code, _, _ = runCLI("collection", "get", "1")
+	assert.Equal(t, exitError, code)
+}
+
+func TestResultExitCode(t *testing.T) {
+	t.Setenv("SHIBUYA_CONFIG", filepath.Join(t.TempDir(), "config.yaml"))
+	ended := time.Now()
+	summary := &model.RunSummary{RequestCount: 10}
+	runs := map[string]*model.RunResult{
+		"/api/collections/1/runs/1": {RunHistory: &model.RunHistory{ID: 1, Verdict: model.RunPassed, EndTime: ended,
+			HasCriteria: true}, Summary: summary},
+		"/api/collections/1/runs/2": {RunHistory: &model.RunHistory{ID: 2, Verdict: model.RunFailed}},
+		"/api/collections/1/runs/3": {RunHistory: &model.RunHistory{ID: 3, AbortReason: "p99 > 2s"}},
+		"/api/collections/1/runs/5": {RunHistory: &model.RunHistory{ID: 5, EndTime: ended}},
+		"/api/collections/1/runs/6": {RunHistory: &model.RunHistory{ID: 6, EndTime: ended, HasCriteria: true},
+			Summary: summary},
+		"/api/collections/1/runs/7": {RunHistory: &model.RunHistory{ID: 7, EndTime: ended}, Summary: summary},
+	}
+	var auth string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		result, ok := runs[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(result)
+	}))
+	defer ts.Close()
+
+	code, out, _ := runCLI("-endpoint", ts.URL, "-token", "secret", "collection", "result", "1", "1")
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, out, `"verdict": "passed"`)
+	assert.Equal(t, "Bearer secret", auth)
+
+	code, _, errOut := runCLI("-endpoint", ts.URL, "collection", "result", "1", "2")
+	assert.Equal(t, exitRunFailed, code)
+	assert.Contains(t, errOut, "does not meet the criteria")
+
+	code, _, errOut = runCLI("-endpoint", ts.URL, "collection", "result", "1", "3")
+	assert.Equal(t, exitRunFailed, code)
+	assert.Contains(t, errOut, "p99 > 2s")
+
+	code, _, _ = runCLI("-endpoint", ts.URL, "collection", "result", "1", "4")
+	assert.Equal(t, exitError, code)
+
+	// the runs which cannot be told to pass are failed
+	code, _, errOut = runCLI("-endpoint", ts.URL, "collection", "result", "1", "5")
+	assert.Equal(t, exitRunFailed, code)
+	assert.Contains(t, errOut, "does not have a summary")
+
+	code, _, errOut = runCLI("-endpoint", ts.URL, "collection", "result", "1", "6")
+	assert.Equal(t, exitRunFailed, code)
+	assert.Contains(t, errOut, "is not judged")
+
+	code, _, _ = runCLI("-endpoint", ts.URL, "collection", "result", "1", "7")
+	assert.Equal(t, exitOK, code)
+}
+
+func TestManifestApply(t *testing.T) {
+	t.Setenv("SHIBUYA_CONFIG", filepath.Join(t.TempDir(), "config.yaml"))
+	dir := t.TempDir()
+	manifest := "project: {name: checkout, owner: team-a}\nplans:\n  - {name: browse, kind: jmeter, test_file: plans/browse.jmx}\n"
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "shibuya.yaml"), []byte(manifest), 0644))
+	assert.Nil(t, os.Mkdir(filepath.Join(dir, "plans"), 0755))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "plans", "browse.jmx"), []byte("<jmx/>"), 0644))
+
+	var dryRuns []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/apply", r.URL.Path)
+		assert.Nil(t, r.ParseMultipartForm(1<<20))
+		f, _, err := r.FormFile("plans/browse.jmx")
+		assert.Nil(t, err)
+		f.Close()
+		dryRuns = append(dryRuns, r.Form.Get("dry_run"))
+		json.NewEncoder(w).Encode(&model.ApplyResult{
+			DryRun:  r.Form.Get("dry_run") == "true",
+			Changes: []*model.ManifestChange{{Action: model.ChangeCreate, Kind: "plan", Name: "browse", Detail: "upload browse.jmx"}},
+		})
+	}))
+	defer ts.Close()
+
+	code, out, _ := runCLI("-endpoint", ts.URL, "manifest", "diff", filepath.Join(dir, "shibuya.yaml"))
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "+ plan browse (upload browse.jmx)\n", out)
+	assert.Equal(t, []string{"true"}, dryRuns)
+
+	code, _, _ = runCLI("-endpoint", ts.URL, "manifest", "apply", filepath.Join(dir, "shibuya.yaml"))
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, []string{"true", "true", "false"}, dryRuns)
+
+	code, _, _ = runCLI("-endpoint", ts.URL, "manifest", "apply", filepath.Join(dir, "missing.yaml"))
+	assert.Equal(t, exitError, code)
+}
+
+func TestClone(t *testing.T) {
+	t.Setenv("SHIBUYA_CONFIG", filepath.Join(t.TempDir(), "config.yaml"))
+	var form map[string][]string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/collections/1/clone", r.URL.Path)
+		r.ParseForm()
+		form = r.PostForm
+		json.NewEncoder(w).Encode(&model.Collection{ID: 2, ProjectID: 3, Name: "production"})
+	}))
+	defer ts.Close()
+
+	code, out, _ := runCLI("-endpoint", ts.URL, "collection", "clone", "-project", "3", "-name", "production", "1")
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, out, `"project_id": 3`)
+	assert.Equal(t, map[string][]string{"project_id": {"3"}, "name": {"production"}}, form)
+
+	code, _, _ = runCLI("-endpoint", ts.URL, "collection", "clone", "1")
+	assert.Equal(t, exitOK, code)
+	assert.Empty(t, form)
+}
+
+func TestPlanVersions(t *testing.T) {
+	t.Setenv("SHIBUYA_CONFIG", filepath.Join(t.TempDir(), "config.yaml"))
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/plans/1/versions":
+			json.NewEncoder(w).Encode([]*model.PlanFileVersion{{PlanID: 1, Version: 2, Filename: "a.jmx", TestFile: true}})
+		case "/api/plans/1/versions/2":
+			w.Write([]byte("<jmx/>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	code, out, _ := runCLI("-endpoint", ts.URL, "plan", "versions", "1")
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, out, `"filename": "a.jmx"`)
+
+	code, out, _ = runCLI("-endpoint", ts.URL, "plan", "download", "1", "2")
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "<jmx/>", out)
+
+	code, _, _ = runCLI("-endpoint", ts.URL, "plan", "download", "1", "3")
+	assert.Equal(t, exitError, code)
+}
+
+func TestUpdateAndList(t *testing.T) {
+	t.Setenv("SHIBUYA_CONFIG", filepath.Join(t.TempDir(), "config.yaml"))
+	var form map[string][]string
+	var query string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "PUT" && r.URL.Path == "/api/projects/1":
+			r.ParseForm()
+			form = r.PostForm
+			json.NewEncoder(w).Encode(&model.Project{ID: 1, Name: "checkout", Owner: "team-b"})
+		case r.Method == "GET" && r.URL.Path == "/api/collections":
+			query = r.URL.RawQuery
+			json.NewEncoder(w).Encode([]*model.Collection{{ID: 2, Name: "nightly", Tags: model.Tags{"env": "staging"}}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	code, out, _ := runCLI("-endpoint", ts.URL, "project", "update", "-owner", "team-b", "-tags", "", "1")
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, out, `"owner": "team-b"`)
+	assert.Equal(t, map[string][]string{"owner": {"team-b"}, "tags": {""}}, form)
+
+	code, _, _ = runCLI("-endpoint", ts.URL, "project", "update", "1")
+	assert.Equal(t, exitUsage, code)
+
+	code, out, _ = runCLI("-endpoint", ts.URL, "collection", "list", "-project", "3", "-tags", "env=staging")
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, out, `"env": "staging"`)
+	assert.Equal(t, "project_id=3&tags=env%3Dstaging", query)
+}
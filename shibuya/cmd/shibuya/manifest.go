@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/rakutentech/shibuya/shibuya/model"
+)
+
+var manifestCommands = []*command{
+	{verb: "diff", usage: "[-prune] <manifest>", run: manifestDiff},
+	{verb: "apply", usage: "[-prune] <manifest>", run: manifestApply},
+}
+
+var changeSigns = map[string]string{
+	model.ChangeCreate: "+",
+	model.ChangeUpdate: "~",
+	model.ChangeDelete: "-",
+}
+
+func (c *cli) printChanges(result *model.ApplyResult) {
+	if len(result.Changes) == 0 {
+		fmt.Fprintln(c.out, "No changes")
+		return
+	}
+	for _, change := range result.Changes {
+		fmt.Fprintf(c.out, "%s %s %s", changeSigns[change.Action], change.Kind, change.Name)
+		if change.Detail != "" {
+			fmt.Fprintf(c.out, " (%s)", change.Detail)
+		}
+		fmt.Fprintln(c.out)
+	}
+}
+
+func manifestArgs(name string, args []string) (string, bool, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	prune := fs.Bool("prune", false, "delete the plans and collections of the project which are not in the manifest")
+	if err := parseFlags(fs, args, 1); err != nil {
+		return "", false, err
+	}
+	return fs.Arg(0), *prune, nil
+}
+
+func manifestDiff(c *cli, args []string) error {
+	path, prune, err := manifestArgs("diff", args)
+	if err != nil {
+		return err
+	}
+	result, err := c.applier.Apply(path, true, prune)
+	if err != nil {
+		return err
+	}
+	c.printChanges(result)
+	return nil
+}
+
+// manifestApply shows the changes before applying them
+func manifestApply(c *cli, args []string) error {
+	path, prune, err := manifestArgs("apply", args)
+	if err != nil {
+		return err
+	}
+	result, err := c.applier.Apply(path, true, prune)
+	if err != nil {
+		return err
+	}
+	c.printChanges(result)
+	if len(result.Changes) == 0 {
+		return nil
+	}
+	result, err = c.applier.Apply(path, false, prune)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.errOut, "Applied %d changes to project %d\n", len(result.Changes), result.ProjectID)
+	return nil
+}
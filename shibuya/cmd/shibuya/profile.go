@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	defaultProfile = "default"
+)
+
+// Profile is the endpoint of a shibuya installation and the token used to talk to it
+type Profile struct {
+	Endpoint string `yaml:"endpoint"`
+	Token    string `yaml:"token,omitempty"`
+}
+
+// Config is stored in ~/.shibuya/config.yaml unless SHIBUYA_CONFIG is set
+type Config struct {
+	Current  string              `yaml:"current"`
+	Profiles map[string]*Profile `yaml:"profiles"`
+}
+
+func configPath() (string, error) {
+	if p := os.Getenv("SHIBUYA_CONFIG"); p != "" {
+		return p, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".shibuya", "config.yaml"), nil
+}
+
+// loadConfig returns an empty config when the file does not exist yet
+func loadConfig(path string) (*Config, error) {
+	c := &Config{Profiles: map[string]*Profile{}}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(content, c); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	if c.Profiles == nil {
+		c.Profiles = map[string]*Profile{}
+	}
+	return c, nil
+}
+
+// save writes the config with 0600 because it contains the tokens
+func (c *Config) save(path string) error {
+	content, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, content, 0600)
+}
+
+func (c *Config) profileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resolve picks the profile by the flag, SHIBUYA_PROFILE and the current profile in that order.
+// The endpoint and token of the profile can be overridden by the flags and SHIBUYA_ENDPOINT and
+// SHIBUYA_TOKEN.
+func (c *Config) resolve(name, endpoint, token string) (*Profile, error) {
+	if name == "" {
+		name = os.Getenv("SHIBUYA_PROFILE")
+	}
+	if name == "" {
+		name = c.Current
+	}
+	if name == "" {
+		name = defaultProfile
+	}
+	p := &Profile{}
+	if stored, ok := c.Profiles[name]; ok {
+		*p = *stored
+	}
+	if v := os.Getenv("SHIBUYA_ENDPOINT"); v != "" {
+		p.Endpoint = v
+	}
+	if v := os.Getenv("SHIBUYA_TOKEN"); v != "" {
+		p.Token = v
+	}
+	if endpoint != "" {
+		p.Endpoint = endpoint
+	}
+	if token != "" {
+		p.Token = token
+	}
+	if p.Endpoint == "" {
+		return nil, fmt.Errorf("no endpoint is configured for profile %s, use `shibuya profile set %s -endpoint <url>`",
+			name, name)
+	}
+	return p, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+)
+
+var profileCommands = []*command{
+	{verb: "set", usage: "[-endpoint url] [-token token] <name>", local: true, run: profileSet},
+	{verb: "use", usage: "<name>", local: true, run: profileUse},
+	{verb: "list", usage: "", local: true, run: profileList},
+	{verb: "delete", usage: "<name>", local: true, run: profileDelete},
+}
+
+// profileSet creates the profile or updates the given fields of it. The first profile becomes the
+// current one.
+func profileSet(c *cli, args []string) error {
+	fs := flag.NewFlagSet("set", flag.ContinueOnError)
+	endpoint := fs.String("endpoint", "", "endpoint of the shibuya api")
+	token := fs.String("token", "", "api token")
+	if err := parseFlags(fs, args, 1); err != nil {
+		return err
+	}
+	config, err := loadConfig(c.configPath)
+	if err != nil {
+		return err
+	}
+	name := fs.Arg(0)
+	p, ok := config.Profiles[name]
+	if !ok {
+		p = &Profile{}
+		config.Profiles[name] = p
+	}
+	if *endpoint != "" {
+		p.Endpoint = *endpoint
+	}
+	if *token != "" {
+		p.Token = *token
+	}
+	if config.Current == "" {
+		config.Current = name
+	}
+	return config.save(c.configPath)
+}
+
+func profileUse(c *cli, args []string) error {
+	fs := flag.NewFlagSet("use", flag.ContinueOnError)
+	if err := parseFlags(fs, args, 1); err != nil {
+		return err
+	}
+	config, err := loadConfig(c.configPath)
+	if err != nil {
+		return err
+	}
+	name := fs.Arg(0)
+	if _, ok := config.Profiles[name]; !ok {
+		return fmt.Errorf("profile %s does not exist", name)
+	}
+	config.Current = name
+	return config.save(c.configPath)
+}
+
+func profileList(c *cli, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+	config, err := loadConfig(c.configPath)
+	if err != nil {
+		return err
+	}
+	for _, name := range config.profileNames() {
+		marker := " "
+		if name == config.Current {
+			marker = "*"
+		}
+		fmt.Fprintf(c.out, "%s %s\t%s\n", marker, name, config.Profiles[name].Endpoint)
+	}
+	return nil
+}
+
+func profileDelete(c *cli, args []string) error {
+	fs := flag.NewFlagSet("delete", flag.ContinueOnError)
+	if err := parseFlags(fs, args, 1); err != nil {
+		return err
+	}
+	config, err := loadConfig(c.configPath)
+	if err != nil {
+		return err
+	}
+	name := fs.Arg(0)
+	if _, ok := config.Profiles[name]; !ok {
+		return fmt.Errorf("profile %s does not exist", name)
+	}
+	delete(config.Profiles, name)
+	if config.Current == name {
+		config.Current = ""
+	}
+	return config.save(c.configPath)
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"strconv"
+
+	"github.com/rakutentech/shibuya/shibuya/model"
+)
+
+var projectCommands = []*command{
+	{verb: "create", usage: "-owner <group> <name>", run: projectCreate},
+	{verb: "get", usage: "<project_id>", run: projectGet},
+	{verb: "list", usage: "[-tags <k=v,...>]", run: projectList},
+	{verb: "update", usage: "[-name <name>] [-owner <group>] [-description <text>] [-tags <k=v,...>] <project_id>", run: projectUpdate},
+	{verb: "delete", usage: "<project_id>", run: projectDelete},
+}
+
+var planCommands = []*command{
+	{verb: "create", usage: "-project <project_id> -kind <kind> <name>", run: planCreate},
+	{verb: "get", usage: "<plan_id>", run: planGet},
+	{verb: "list", usage: "[-project <project_id>] [-tags <k=v,...>]", run: planList},
+	{verb: "update", usage: "[-name <name>] [-description <text>] [-tags <k=v,...>] <plan_id>", run: planUpdate},
+	{verb: "upload", usage: "<plan_id> <file>", run: planUpload},
+	{verb: "clone", usage: "[-project <project_id>] [-name <name>] <plan_id>", run: planClone},
+	{verb: "versions", usage: "<plan_id>", run: planVersions},
+	{verb: "download", usage: "<plan_id> <version>", run: planDownload},
+	{verb: "delete", usage: "<plan_id>", run: planDelete},
+}
+
+// idArg parses the flags of the commands which take an id as the only argument
+func idArg(args []string) (int64, error) {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	if err := parseFlags(fs, args, 1); err != nil {
+		return 0, err
+	}
+	return parseID(fs.Arg(0))
+}
+
+// updateArgs parses the flags of the update commands. Only the flags given are sent, so the other
+// fields keep their values. An empty -tags removes all the tags.
+func updateArgs(args []string, fields ...string) (int64, map[string]string, error) {
+	fs := flag.NewFlagSet("update", flag.ContinueOnError)
+	for _, f := range fields {
+		fs.String(f, "", f)
+	}
+	if err := parseFlags(fs, args, 1); err != nil {
+		return 0, nil, err
+	}
+	id, err := parseID(fs.Arg(0))
+	if err != nil {
+		return 0, nil, err
+	}
+	params := map[string]string{}
+	fs.Visit(func(f *flag.Flag) {
+		params[f.Name] = f.Value.String()
+	})
+	if len(params) == 0 {
+		return 0, nil, errUsage
+	}
+	return id, params, nil
+}
+
+// listArgs parses the flags of the list commands. projectID is 0 when all the projects are listed.
+func listArgs(args []string, withProject bool) (projectID int64, tags string, err error) {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	if withProject {
+		fs.Int64Var(&projectID, "project", 0, "id of the project, all the projects by default")
+	}
+	fs.StringVar(&tags, "tags", "", "only list the ones having the tags, a tag without a value matches any value")
+	err = parseFlags(fs, args, 0)
+	return
+}
+
+func projectCreate(c *cli, args []string) error {
+	fs := flag.NewFlagSet("create", flag.ContinueOnError)
+	owner := fs.String("owner", "", "group owning the project")
+	if err := parseFlags(fs, args, 1); err != nil {
+		return err
+	}
+	if *owner == "" {
+		return errUsage
+	}
+	project, err := c.projects.Create(fs.Arg(0), *owner)
+	if err != nil {
+		return err
+	}
+	return c.print(project)
+}
+
+func projectGet(c *cli, args []string) error {
+	id, err := idArg(args)
+	if err != nil {
+		return err
+	}
+	project, err := c.projects.Get(id)
+	if err != nil {
+		return err
+	}
+	return c.print(project)
+}
+
+func projectList(c *cli, args []string) error {
+	_, tags, err := listArgs(args, false)
+	if err != nil {
+		return err
+	}
+	projects, err := c.projects.List(tags)
+	if err != nil {
+		return err
+	}
+	return c.print(projects)
+}
+
+func projectUpdate(c *cli, args []string) error {
+	id, params, err := updateArgs(args, "name", "owner", "description", "tags")
+	if err != nil {
+		return err
+	}
+	project, err := c.projects.Update(id, params)
+	if err != nil {
+		return err
+	}
+	return c.print(project)
+}
+
+func projectDelete(c *cli, args []string) error {
+	id, err := idArg(args)
+	if err != nil {
+		return err
+	}
+	return c.projects.Delete(id)
+}
+
+func planCreate(c *cli, args []string) error {
+	fs := flag.NewFlagSet("create", flag.ContinueOnError)
+	projectID := fs.Int64("project", 0, "id of the project")
+	kind := fs.String("kind", string(model.JmeterPlan), "kind of the plan")
+	if err := parseFlags(fs, args, 1); err != nil {
+		return err
+	}
+	if *projectID == 0 {
+		return errUsage
+	}
+	plan, err := c.plans.Create(strconv.FormatInt(*projectID, 10), fs.Arg(0), model.PlanKind(*kind))
+	if err != nil {
+		return err
+	}
+	return c.print(plan)
+}
+
+func planGet(c *cli, args []string) error {
+	id, err := idArg(args)
+	if err != nil {
+		return err
+	}
+	plan, err := c.plans.Get(id)
+	if err != nil {
+		return err
+	}
+	return c.print(plan)
+}
+
+func planList(c *cli, args []string) error {
+	projectID, tags, err := listArgs(args, true)
+	if err != nil {
+		return err
+	}
+	plans, err := c.plans.List(projectID, tags)
+	if err != nil {
+		return err
+	}
+	return c.print(plans)
+}
+
+func planUpdate(c *cli, args []string) error {
+	id, params, err := updateArgs(args, "name", "description", "tags")
+	if err != nil {
+		return err
+	}
+	plan, err := c.plans.Update(id, params)
+	if err != nil {
+		return err
+	}
+	return c.print(plan)
+}
+
+func planUpload(c *cli, args []string) error {
+	fs := flag.NewFlagSet("upload", flag.ContinueOnError)
+	if err := parseFlags(fs, args, 2); err != nil {
+		return err
+	}
+	id, err := parseID(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return c.plans.UploadFile(id, f)
+}
+
+// cloneArgs parses the flags of the clone commands
+func cloneArgs(args []string) (id, projectID int64, name string, err error) {
+	fs := flag.NewFlagSet("clone", flag.ContinueOnError)
+	project := fs.Int64("project", 0, "id of the project to clone into, the same project by default")
+	fs.StringVar(&name, "name", "", "name of the clone, the same name by default, suffixed with (copy) in the same project")
+	if err = parseFlags(fs, args, 1); err != nil {
+		return
+	}
+	id, err = parseID(fs.Arg(0))
+	return id, *project, name, err
+}
+
+func planClone(c *cli, args []string) error {
+	id, projectID, name, err := cloneArgs(args)
+	if err != nil {
+		return err
+	}
+	plan, err := c.plans.Clone(id, projectID, name)
+	if err != nil {
+		return err
+	}
+	return c.print(plan)
+}
+
+func planVersions(c *cli, args []string) error {
+	id, err := idArg(args)
+	if err != nil {
+		return err
+	}
+	versions, err := c.plans.GetVersions(id)
+	if err != nil {
+		return err
+	}
+	return c.print(versions)
+}
+
+// planDownload writes a version of the plan files to the output
+func planDownload(c *cli, args []string) error {
+	fs := flag.NewFlagSet("download", flag.ContinueOnError)
+	if err := parseFlags(fs, args, 2); err != nil {
+		return err
+	}
+	id, err := parseID(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	version, err := parseID(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	content, err := c.plans.DownloadVersion(id, version)
+	if err != nil {
+		return err
+	}
+	_, err = c.out.Write(content)
+	return err
+}
+
+func planDelete(c *cli, args []string) error {
+	id, err := idArg(args)
+	if err != nil {
+		return err
+	}
+	return c.plans.Delete(id)
+}
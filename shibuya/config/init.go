@@ -5,11 +5,13 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
+	"sort"
 
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/oauth2"
@@ -92,7 +94,10 @@ type Toleration struct {
 }
 
 type ExecutorConfig struct {
-	InCluster              bool                          `json:"in_cluster"`
+	InCluster bool `json:"in_cluster"`
+	// KubeContext is the context in the kubeconfig used when the controller is not in the cluster.
+	// The current context is used when it's empty.
+	KubeContext            string                        `json:"kube_context"`
 	Namespace              string                        `json:"namespace"`
 	Cluster                *ClusterConfig                `json:"cluster"`
 	ImagePullSecret        string                        `json:"pull_secret"`
@@ -102,12 +107,80 @@ type ExecutorConfig struct {
 	NodeAffinity           []map[string]string           `json:"node_affinity"`
 	Tolerations            []Toleration                  `json:"tolerations"`
 	MaxEnginesInCollection int                           `json:"max_engines_in_collection"`
+	Local                  *LocalConfig                  `json:"local,omitempty"`
+	Docker                 *DockerConfig                 `json:"docker,omitempty"`
+	CloudRun               *CloudRunConfig               `json:"cloudrun,omitempty"`
+	NodePool               *NodePoolConfig               `json:"node_pool,omitempty"`
+	// AllowedImages are the images the plans can run their engines with instead of the ones of the
+	// engines_container
+	AllowedImages []string `json:"allowed_images"`
+}
+
+// LocalConfig is used by the local scheduler, which runs the engines and the coordinators on the
+// controller host instead of a cluster
+type LocalConfig struct {
+	// Runtime is either process or docker
+	Runtime string `json:"runtime"`
+	// WorkDir keeps the files and the logs of the engines and the coordinators
+	WorkDir string `json:"work_dir"`
+	// Network is the docker network the containers are attached to
+	Network string `json:"network"`
+}
+
+// DockerConfig is used by the docker scheduler, which runs the engines and the coordinators on a list
+// of docker hosts through the Docker Engine API
+type DockerConfig struct {
+	Hosts []*DockerHost `json:"hosts"`
+	// APIVersion is the version of the Docker Engine API
+	APIVersion string `json:"api_version"`
+}
+
+type DockerHost struct {
+	// Endpoint is the url of the Docker Engine API, like https://10.0.0.1:2376
+	Endpoint string `json:"endpoint"`
+	// Address is the IP the published ports of the host are reached at. It defaults to the host of
+	// the endpoint.
+	Address string `json:"address"`
+	// TLSCA verifies the certificate of the host. The system CAs are used when it's empty.
+	TLSCA string `json:"tls_ca"`
+	// TLSCert and TLSKey are the client certificate the host authenticates the scheduler with
+	TLSCert string `json:"tls_cert"`
+	TLSKey  string `json:"tls_key"`
+	// Insecure allows an http endpoint. Anyone reaching the endpoint gets the control of the host.
+	Insecure bool `json:"insecure"`
+}
+
+// CloudRunConfig is used by the Cloud Run scheduler
+type CloudRunConfig struct {
+	// ServiceAccount runs the services of the engines, the coordinators and the scrapers. It needs to
+	// access the secrets of shibuya in Secret Manager.
+	ServiceAccount string `json:"service_account"`
+}
+
+// NodePoolConfig makes the k8s scheduler run the engines of every collection in a dedicated node pool,
+// which is sized for the engines before they are deployed
+type NodePoolConfig struct {
+	// Provider manages the node pools of the cluster. Only gke is supported.
+	Provider    string `json:"provider"`
+	MachineType string `json:"machine_type"`
+	DiskSizeGb  int64  `json:"disk_size_gb"`
+	// NodeCPU and NodeMem are the allocatable resources of a node, which decide how many engines fit in it
+	NodeCPU string `json:"node_cpu"`
+	NodeMem string `json:"node_mem"`
+	// MaxNodes is the max size of a pool. There is no limit when it's 0.
+	MaxNodes int `json:"max_nodes"`
 }
 
 type ExecutorContainer struct {
 	Image string `json:"image"`
 	CPU   string `json:"cpu"`
 	Mem   string `json:"mem"`
+	// Command starts the engine when the local scheduler runs the engines as processes
+	Command []string `json:"command,omitempty"`
+	// Env is added to the engines. It cannot replace the env set by shibuya.
+	Env map[string]string `json:"env,omitempty"`
+	// NodeSelector is only used by the k8s scheduler
+	NodeSelector map[string]string `json:"node_selector,omitempty"`
 }
 
 type ScraperContainer struct {
@@ -150,6 +223,8 @@ type IngressConfig struct {
 	Replicas int32  `json:"replicas"`
 	CPU      string `json:"cpu"`
 	Mem      string `json:"mem"`
+	// Command starts the coordinator when the local scheduler runs the coordinators as processes
+	Command []string `json:"command,omitempty"`
 
 	//Ingress controllers should be kept longer than then engines
 	Lifespan   string `json:"lifespan"`
@@ -167,6 +242,37 @@ type CAPair struct {
 	PrivateKey *rsa.PrivateKey
 }
 
+// Quota limits what can be deployed at the same time and the usage of a month. The zero values are
+// no limit.
+type Quota struct {
+	Engines int `json:"engines"`
+	VU      int `json:"vu"`
+	// VUHPerMonth is checked against the launches finished in the current month
+	VUHPerMonth float64 `json:"vuh_per_month"`
+	// Priority orders the deployments waiting for capacity, the highest first
+	Priority int `json:"priority"`
+}
+
+// QuotasConfig has the quotas by project id and by owner. A project with its own quota is only
+// limited by it. The other projects share the quota of their owner, or the default one.
+type QuotasConfig struct {
+	Default  *Quota            `json:"default"`
+	Owners   map[string]*Quota `json:"owners"`
+	Projects map[int64]*Quota  `json:"projects"`
+}
+
+// ForProject returns the quota of the project and whether it is its own quota rather than the one
+// of its owner
+func (qc *QuotasConfig) ForProject(projectID int64, owner string) (*Quota, bool) {
+	if q, ok := qc.Projects[projectID]; ok {
+		return q, true
+	}
+	if q, ok := qc.Owners[owner]; ok {
+		return q, false
+	}
+	return qc.Default, false
+}
+
 type ShibuyaConfig struct {
 	ProjectHome      string           `json:"project_home"`
 	UploadFileHelp   string           `json:"upload_file_help"`
@@ -183,6 +289,11 @@ type ShibuyaConfig struct {
 	MetricStorage    []MetricStorage  `json:"metric_storage"`
 	ScraperContainer ScraperContainer `json:"scraper_container"`
 	EnableSid        bool             `json:"enable_sid"`
+	// Clusters are the executor clusters managed in addition to the one of ExecutorConfig, by their
+	// contexts. The kube context of a cluster is its context unless kube_context is set.
+	Clusters map[string]*ExecutorConfig `json:"clusters"`
+	// Quotas limit what the projects deploy across all the clusters. There is no limit when it's not set.
+	Quotas *QuotasConfig `json:"quotas"`
 
 	// below are configs generated from above values
 	DevMode         bool
@@ -197,6 +308,77 @@ func loadContext() string {
 	return os.Getenv("env")
 }
 
+func (ec *ExecutorConfig) setDefaults() {
+	if ec.Cluster.GCDuration == 0 {
+		ec.Cluster.GCDuration = 15
+	}
+	if ec.Cluster.Kind == "" {
+		// if not specified, use k8s as default
+		ec.Cluster.Kind = "k8s"
+	}
+	if ec.MaxEnginesInCollection == 0 {
+		ec.MaxEnginesInCollection = 500
+	}
+	if ec.Cluster.Kind == "local" {
+		if ec.Local == nil {
+			ec.Local = &LocalConfig{}
+		}
+		if ec.Local.Runtime == "" {
+			ec.Local.Runtime = "docker"
+		}
+		if ec.Local.WorkDir == "" {
+			ec.Local.WorkDir = path.Join(os.TempDir(), "shibuya-local")
+		}
+		if ec.Local.Network == "" {
+			ec.Local.Network = "shibuya"
+		}
+	}
+	if ec.Cluster.Kind == "docker" {
+		if ec.Docker == nil || len(ec.Docker.Hosts) == 0 {
+			log.Fatal("Docker scheduler needs at least one host")
+		}
+		if ec.Docker.APIVersion == "" {
+			ec.Docker.APIVersion = "v1.41"
+		}
+		for _, h := range ec.Docker.Hosts {
+			if h.Address != "" {
+				continue
+			}
+			u, err := url.Parse(h.Endpoint)
+			if err != nil {
+				log.Fatalf("Invalid docker host %s: %v", h.Endpoint, err)
+			}
+			h.Address = u.Hostname()
+		}
+	}
+}
+
+// Contexts returns the contexts of all the executor clusters, starting with the default one
+func (sc ShibuyaConfig) Contexts() []string {
+	contexts := []string{sc.Context}
+	names := make([]string, 0, len(sc.Clusters))
+	for name := range sc.Clusters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return append(contexts, names...)
+}
+
+// ForContext returns the config with the executor config of the cluster of the context. An empty
+// context is the default cluster.
+func (sc ShibuyaConfig) ForContext(context string) (ShibuyaConfig, error) {
+	if context == "" || context == sc.Context {
+		return sc, nil
+	}
+	ec, ok := sc.Clusters[context]
+	if !ok {
+		return sc, fmt.Errorf("unknown context %s", context)
+	}
+	sc.Context = context
+	sc.ExecutorConfig = ec
+	return sc, nil
+}
+
 func LoadCaCert(certPath, keyPath string) *CAPair {
 	tlscert, err := tls.LoadX509KeyPair(certPath, keyPath)
 	if err != nil {
@@ -273,15 +455,18 @@ func LoadConfig() ShibuyaConfig {
 	// configuration might provide an empty struct here
 	// TODO: we should not let jmeter code rely on this part
 	if sc.ExecutorConfig != nil {
-		if sc.ExecutorConfig.Cluster.GCDuration == 0 {
-			sc.ExecutorConfig.Cluster.GCDuration = 15
+		sc.ExecutorConfig.setDefaults()
+	}
+	for name, ec := range sc.Clusters {
+		if name == sc.Context {
+			log.Fatalf("Cluster %s has the same context as the default cluster", name)
 		}
-		if sc.ExecutorConfig.Cluster.Kind == "" {
-			// if not specified, use k8s as default
-			sc.ExecutorConfig.Cluster.Kind = "k8s"
+		if ec.Cluster == nil {
+			log.Fatalf("Cluster %s does not have the cluster config", name)
 		}
-		if sc.ExecutorConfig.MaxEnginesInCollection == 0 {
-			sc.ExecutorConfig.MaxEnginesInCollection = 500
+		ec.setDefaults()
+		if ec.KubeContext == "" {
+			ec.KubeContext = name
 		}
 	}
 	if sc.IngressConfig.Lifespan == "" {
@@ -15,23 +15,23 @@ func newRateLimiter() flowcontrol.RateLimiter {
 }
 
 // getConfig returns a Kubernetes client config for a given context.
-func getConfig() clientcmd.ClientConfig {
+func getConfig(kubeContext string) clientcmd.ClientConfig {
 	rules := clientcmd.NewDefaultClientConfigLoadingRules()
 	rules.DefaultClientConfig = &clientcmd.DefaultClientConfig
-	overrides := &clientcmd.ConfigOverrides{ClusterDefaults: clientcmd.ClusterDefaults}
+	overrides := &clientcmd.ConfigOverrides{ClusterDefaults: clientcmd.ClusterDefaults, CurrentContext: kubeContext}
 	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides)
 }
 
 // configForContext creates a Kubernetes REST client configuration for a given kubeconfig context.
-func configForContext(inCluster bool) (*rest.Config, error) {
+func configForContext(inCluster bool, kubeContext string) (*rest.Config, error) {
 	var config *rest.Config
 	var err error
 	if inCluster {
 		log.Print("Using in cluster config")
 		config, err = rest.InClusterConfig()
 	} else {
-		log.Print("Using out of cluster config")
-		config, err = getConfig().ClientConfig()
+		log.Printf("Using out of cluster config with context %q", kubeContext)
+		config, err = getConfig(kubeContext).ClientConfig()
 	}
 	if err != nil {
 		return nil, fmt.Errorf("could not get Kubernetes config- %s", err)
@@ -42,7 +42,7 @@ func configForContext(inCluster bool) (*rest.Config, error) {
 
 // GetKubeClient creates a Kubernetes config and client for a given kubeconfig context.
 func GetKubeClient(cfg *ExecutorConfig) (*kubernetes.Clientset, error) {
-	config, err := configForContext(cfg.InCluster)
+	config, err := configForContext(cfg.InCluster, cfg.KubeContext)
 	if err != nil {
 		return nil, err
 	}
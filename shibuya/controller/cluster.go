@@ -0,0 +1,213 @@
+package controller
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/rakutentech/shibuya/shibuya/config"
+	cdrclient "github.com/rakutentech/shibuya/shibuya/coordinator/client"
+	"github.com/rakutentech/shibuya/shibuya/model"
+	"github.com/rakutentech/shibuya/shibuya/scheduler"
+	serrors "github.com/rakutentech/shibuya/shibuya/scheduler/errors"
+	smodel "github.com/rakutentech/shibuya/shibuya/scheduler/model"
+	log "github.com/sirupsen/logrus"
+)
+
+// cluster is an executor cluster managed by the controller. Every cluster has its own coordinator
+// and scraper per project, so the engines only talk to the components in their own cluster.
+type cluster struct {
+	context string
+	// sc has the executor config of the cluster
+	sc        config.ShibuyaConfig
+	scheduler scheduler.EngineScheduler
+	// httpClient reaches the coordinators and the engines of the cluster
+	httpClient *http.Client
+	cdrclient  *cdrclient.Client
+}
+
+// clusterPlans are the execution plans of a collection running in the same cluster
+type clusterPlans struct {
+	*cluster
+	eps []*model.ExecutionPlan
+}
+
+func newClusters(sc config.ShibuyaConfig) []*cluster {
+	clusters := []*cluster{}
+	for _, context := range sc.Contexts() {
+		csc, err := sc.ForContext(context)
+		if err != nil {
+			log.Fatal(err)
+		}
+		httpClient := newClusterHTTPClient(csc)
+		clusters = append(clusters, &cluster{
+			context:    context,
+			sc:         csc,
+			scheduler:  scheduler.NewEngineScheduler(csc),
+			httpClient: httpClient,
+			cdrclient:  cdrclient.NewClient(httpClient),
+		})
+	}
+	return clusters
+}
+
+// newClusterHTTPClient trusts our CA only, as the coordinators are served with the certs issued by
+// it. The coordinators on Cloud Run are served with the certs of google instead, so the clusters on
+// Cloud Run trust the system CAs as well.
+func newClusterHTTPClient(sc config.ShibuyaConfig) *http.Client {
+	pool := x509.NewCertPool()
+	if sc.ExecutorConfig.Cluster.Kind == "cloudrun" {
+		systemPool, err := x509.SystemCertPool()
+		if err != nil {
+			log.Fatal(err)
+		}
+		pool = systemPool
+	}
+	pool.AddCert(sc.CAPair.Cert)
+	return &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs: pool,
+			},
+		},
+	}
+}
+
+// coordinatorOpts returns the options of the requests to the coordinator of the project
+func (cl *cluster) coordinatorOpts(projectID int64) (cdrclient.ReqOpts, error) {
+	ingressIP, err := cl.scheduler.GetIngressUrl(projectID)
+	if err != nil {
+		return cdrclient.ReqOpts{}, err
+	}
+	apiKey, err := cl.scheduler.GetProjectAPIKey(projectID)
+	if err != nil {
+		return cdrclient.ReqOpts{}, err
+	}
+	return cdrclient.ReqOpts{
+		Endpoint: ingressIP,
+		APIKey:   apiKey,
+	}, nil
+}
+
+// Contexts returns the contexts of all the clusters, starting with the default one
+func (c *Controller) Contexts() []string {
+	contexts := make([]string, len(c.clusters))
+	for i, cl := range c.clusters {
+		contexts[i] = cl.context
+	}
+	return contexts
+}
+
+func (c *Controller) getCluster(context string) (*cluster, error) {
+	if context == "" {
+		return c.clusters[0], nil
+	}
+	for _, cl := range c.clusters {
+		if cl.context == context {
+			return cl, nil
+		}
+	}
+	return nil, makeUnknownContextError(context)
+}
+
+// clusterOf returns the cluster the engines of the plan run in
+func (c *Controller) clusterOf(ep *model.ExecutionPlan) (*cluster, error) {
+	return c.getCluster(ep.Context)
+}
+
+// groupByCluster groups the plans by the clusters they run in, in the order of the clusters. A
+// collection without plans belongs to the default cluster.
+func (c *Controller) groupByCluster(eps []*model.ExecutionPlan) ([]*clusterPlans, error) {
+	byContext := make(map[string]*clusterPlans)
+	for _, ep := range eps {
+		cl, err := c.clusterOf(ep)
+		if err != nil {
+			return nil, err
+		}
+		g, ok := byContext[cl.context]
+		if !ok {
+			g = &clusterPlans{cluster: cl}
+			byContext[cl.context] = g
+		}
+		g.eps = append(g.eps, ep)
+	}
+	if len(eps) == 0 {
+		return []*clusterPlans{{cluster: c.clusters[0]}}, nil
+	}
+	groups := []*clusterPlans{}
+	for _, cl := range c.clusters {
+		if g, ok := byContext[cl.context]; ok {
+			groups = append(groups, g)
+		}
+	}
+	return groups, nil
+}
+
+func (c *Controller) newPlanController(ep *model.ExecutionPlan, collection *model.Collection) (*PlanController, error) {
+	cl, err := c.clusterOf(ep)
+	if err != nil {
+		return nil, err
+	}
+	return NewPlanController(ep, collection, cl.scheduler, cl.httpClient, cl.sc), nil
+}
+
+// PodReadyCount returns the number of the ready pods of the collection in all the clusters
+func (c *Controller) PodReadyCount(collectionID int64) int {
+	count := 0
+	for _, cl := range c.clusters {
+		count += cl.scheduler.PodReadyCount(collectionID)
+	}
+	return count
+}
+
+// DownloadPodLog returns the log of an engine of the plan from the cluster the plan runs in
+func (c *Controller) DownloadPodLog(collectionID, planID int64) (string, error) {
+	ep, err := model.GetExecutionPlan(collectionID, planID)
+	if err != nil {
+		return "", err
+	}
+	cl, err := c.clusterOf(ep)
+	if err != nil {
+		return "", err
+	}
+	return cl.scheduler.DownloadPodLog(collectionID, planID)
+}
+
+// CollectionEnginesDetail returns the engines of the collection in all the clusters it runs in.
+// The ingress is the one of the first cluster having engines.
+func (c *Controller) CollectionEnginesDetail(collection *model.Collection) (*smodel.CollectionDetails, error) {
+	eps, err := collection.GetExecutionPlans()
+	if err != nil {
+		return nil, err
+	}
+	groups, err := c.groupByCluster(eps)
+	if err != nil {
+		return nil, err
+	}
+	var details *smodel.CollectionDetails
+	for _, g := range groups {
+		d, err := g.scheduler.GetCollectionEnginesDetail(collection.ProjectID, collection.ID)
+		var notFound *serrors.NoResourcesFoundErr
+		if errors.As(err, &notFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range d.Engines {
+			e.Context = g.context
+		}
+		if details == nil {
+			details = d
+			continue
+		}
+		details.Engines = append(details.Engines, d.Engines...)
+	}
+	if details == nil {
+		return nil, &serrors.NoResourcesFoundErr{Message: "Cannot find the engines"}
+	}
+	return details, nil
+}
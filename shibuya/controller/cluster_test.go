@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/rakutentech/shibuya/shibuya/config"
+	"github.com/rakutentech/shibuya/shibuya/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupByCluster(t *testing.T) {
+	c := &Controller{clusters: []*cluster{{context: "default"}, {context: "east"}, {context: "west"}}}
+	assert.Equal(t, []string{"default", "east", "west"}, c.Contexts())
+
+	eps := []*model.ExecutionPlan{
+		{PlanID: 1, Context: "west"},
+		{PlanID: 2},
+		{PlanID: 3, Context: "default"},
+		{PlanID: 4, Context: "west"},
+	}
+	groups, err := c.groupByCluster(eps)
+	assert.Nil(t, err)
+	assert.Len(t, groups, 2)
+	assert.Equal(t, "default", groups[0].context)
+	assert.Equal(t, []*model.ExecutionPlan{eps[1], eps[2]}, groups[0].eps)
+	assert.Equal(t, "west", groups[1].context)
+	assert.Equal(t, []*model.ExecutionPlan{eps[0], eps[3]}, groups[1].eps)
+
+	groups, err = c.groupByCluster(nil)
+	assert.Nil(t, err)
+	assert.Len(t, groups, 1)
+	assert.Equal(t, "default", groups[0].context)
+
+	_, err = c.groupByCluster([]*model.ExecutionPlan{{PlanID: 1, Context: "north"}})
+	assert.True(t, errors.Is(err, ContextError))
+}
+
+func TestClusterHTTPClient(t *testing.T) {
+	ca := &x509.Certificate{Raw: []byte("ca"), RawSubject: []byte("shibuya")}
+	ourCA := x509.NewCertPool()
+	ourCA.AddCert(ca)
+	rootCAs := func(kind string) *x509.CertPool {
+		sc := config.ShibuyaConfig{
+			CAPair:         &config.CAPair{Cert: ca},
+			ExecutorConfig: &config.ExecutorConfig{Cluster: &config.ClusterConfig{Kind: kind}},
+		}
+		return newClusterHTTPClient(sc).Transport.(*http.Transport).TLSClientConfig.RootCAs
+	}
+	// only the coordinators on Cloud Run are served with the certs of public CAs
+	assert.True(t, ourCA.Equal(rootCAs("k8s")))
+	assert.True(t, ourCA.Equal(rootCAs("docker")))
+	systemPool, err := x509.SystemCertPool()
+	assert.Nil(t, err)
+	systemPool.AddCert(ca)
+	assert.True(t, systemPool.Equal(rootCAs("cloudrun")))
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"github.com/rakutentech/shibuya/shibuya/config"
 	"github.com/rakutentech/shibuya/shibuya/controller"
+	_ "github.com/rakutentech/shibuya/shibuya/engines/builtin"
 	"github.com/rakutentech/shibuya/shibuya/model"
 	log "github.com/sirupsen/logrus"
 )
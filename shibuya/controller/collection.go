@@ -38,53 +38,74 @@ func prepareCollection(collection *model.Collection) []*enginesModel.EngineDataC
 	return engineDataConfigs
 }
 
-func (c *Controller) DeployCollection(collection *model.Collection, apiToken string) error {
+func (c *Controller) DeployCollection(collection *model.Collection, apiToken string) (err error) {
 	eps, err := collection.GetExecutionPlans()
 	if err != nil {
 		return err
 	}
-	nodesCount := int64(0)
-	enginesCount := 0
-	vu := 0
-	for _, e := range eps {
-		enginesCount += e.Engines
-		vu += e.Engines * e.Concurrency
+	groups, err := c.groupByCluster(eps)
+	if err != nil {
+		return err
+	}
+	if err := c.admitCollection(collection, eps); err != nil {
+		return err
+	}
+	// a failed deployment does not hold the quota until the grace period ends
+	defer func() {
+		if err != nil {
+			c.forgetAdmission(collection.ID)
+		}
+	}()
+	usages := make([]*model.LaunchUsage, len(groups))
+	for i, g := range groups {
+		usage := &model.LaunchUsage{Context: g.context}
+		for _, e := range g.eps {
+			usage.EnginesCount += int64(e.Engines)
+			usage.VU += int64(e.Engines * e.Concurrency)
+		}
+		usages[i] = usage
 	}
 	sid := ""
 	if project, err := model.GetProject(collection.ProjectID); err == nil {
 		sid = project.SID
 	}
-	if err := collection.NewLaunchEntry(sid, c.sc.Context, int64(enginesCount), nodesCount, int64(vu)); err != nil {
+	if err := collection.NewLaunchEntry(sid, usages); err != nil {
 		return err
 	}
-	service, err := c.Scheduler.ExposeProject(collection.ProjectID)
-	if err != nil {
-		return err
-	}
-	token, err := c.Scheduler.GetProjectAPIKey(collection.ProjectID)
-	if err != nil {
-		return err
-	}
-	if err = c.Scheduler.CreateCollectionScraper(apiToken, token, collection.ID); err != nil {
-		log.Error(err)
-		return err
+	// every cluster has its own coordinator and scraper, and the engines talk to the ones in their cluster
+	serviceIPs := make(map[string]string, len(groups))
+	for _, g := range groups {
+		service, err := g.scheduler.ExposeProject(collection.ProjectID)
+		if err != nil {
+			return err
+		}
+		token, err := g.scheduler.GetProjectAPIKey(collection.ProjectID)
+		if err != nil {
+			return err
+		}
+		if err = g.scheduler.CreateCollectionScraper(apiToken, token, collection.ID); err != nil {
+			log.Error(err)
+			return err
+		}
+		serviceIPs[g.context] = service.Spec.ClusterIP
 	}
-	serviceIP := service.Spec.ClusterIP
 	// we will assume collection deployment will always be successful
 	// For some large deployments, it might take more than 1 min to finish, which could result 504 at gateway side
 	// So we do not wait for the deployment to be finished.
 	go func() {
 		var wg sync.WaitGroup
 		now_ := time.Now()
-		for _, e := range eps {
-			wg.Add(1)
-			go func(ep *model.ExecutionPlan) {
-				defer wg.Done()
-				pc := NewPlanController(ep, collection, c.Scheduler, c.httpClient, c.sc)
-				utils.Retry(func() error {
-					return pc.deploy(serviceIP)
-				}, nil)
-			}(e)
+		for _, g := range groups {
+			for _, e := range g.eps {
+				wg.Add(1)
+				go func(g *clusterPlans, ep *model.ExecutionPlan) {
+					defer wg.Done()
+					pc := NewPlanController(ep, collection, g.scheduler, g.httpClient, g.sc)
+					utils.Retry(func() error {
+						return pc.deploy(serviceIPs[g.context])
+					}, nil)
+				}(g, e)
+			}
 		}
 		wg.Wait()
 		duration := time.Now().Sub(now_)
@@ -113,6 +134,15 @@ func (c *Controller) TriggerCollection(collection *model.Collection) error {
 		if plan.TestFile == nil {
 			return fmt.Errorf("Triggering plan aborted. There is no Test file in this plan %d", plan.ID)
 		}
+		if ep.TestFileVersion != 0 {
+			if _, err := plan.GetTestFileVersion(ep.TestFileVersion); err != nil {
+				return fmt.Errorf("Triggering plan aborted. %v", err)
+			}
+		}
+	}
+	groups, err := c.groupByCluster(collection.ExecutionPlans)
+	if err != nil {
+		return err
 	}
 	runID, err := collection.StartRun()
 	if err != nil {
@@ -120,12 +150,21 @@ func (c *Controller) TriggerCollection(collection *model.Collection) error {
 	}
 	planEngineDataConfigs := make(map[int64]enginesModel.PlanEnginesConfig, len(collection.ExecutionPlans))
 	plans := make([]*model.Plan, len(collection.ExecutionPlans))
+	runFiles := []*model.RunPlanFile{}
 	for i, ep := range collection.ExecutionPlans {
-		pc := NewPlanController(ep, collection, c.Scheduler, c.httpClient, c.sc)
+		pc, err := c.newPlanController(ep, collection)
+		if err != nil {
+			return err
+		}
 		plan, err := model.GetPlan(ep.PlanID)
 		if err != nil {
 			return err
 		}
+		if ep.TestFileVersion != 0 {
+			if err := plan.PinTestFile(ep.TestFileVersion); err != nil {
+				return err
+			}
+		}
 		plan.TestFile.Content, err = c.storageClient.Download(plan.TestFile.Filepath)
 		if err != nil {
 			return err
@@ -135,19 +174,27 @@ func (c *Controller) TriggerCollection(collection *model.Collection) error {
 			return err
 		}
 		plans[i] = plan
+		files, err := plan.RunFiles()
+		if err != nil {
+			return err
+		}
+		runFiles = append(runFiles, files...)
 		pec := enginesModel.PlanEnginesConfig{
 			Kind:          plan.Kind,
 			Name:          plan.Name,
 			Duration:      strconv.Itoa(ep.Duration),
 			Concurrency:   strconv.Itoa(ep.Concurrency),
 			Rampup:        strconv.Itoa(ep.Rampup),
+			Stages:        ep.Stages,
+			TargetRPS:     ep.EngineRPS(),
+			Scalable:      ep.Scalable,
 			EnginesConfig: planEngineDataConfig,
 		}
 		planEngineDataConfigs[ep.PlanID] = pec
 	}
-	ingressIP, err := c.Scheduler.GetIngressUrl(collection.ProjectID)
-	if err != nil {
-		return err
+	// the run records the versions so a regression can be traced back to a change of the files
+	if err := collection.StoreRunPlanFiles(runID, runFiles); err != nil {
+		log.Error(err)
 	}
 	for _, d := range collection.Data {
 		log.Infof("Downloading file %s", d.Filename)
@@ -157,16 +204,29 @@ func (c *Controller) TriggerCollection(collection *model.Collection) error {
 		}
 		d.Content = content
 	}
-	apiKey, err := c.Scheduler.GetProjectAPIKey(collection.ProjectID)
-	if err != nil {
-		return err
-	}
-	ro := cdrclient.ReqOpts{
-		Endpoint: ingressIP,
-		APIKey:   apiKey,
-	}
-	if err := c.cdrclient.TriggerCollection(ro, collection, planEngineDataConfigs, plans); err != nil {
-		return err
+	// every cluster is triggered through its own coordinator with the plans running in it
+	planOpts := make(map[int64]cdrclient.ReqOpts, len(collection.ExecutionPlans))
+	planClusters := make(map[int64]*cluster, len(collection.ExecutionPlans))
+	for _, g := range groups {
+		ro, err := g.coordinatorOpts(collection.ProjectID)
+		if err != nil {
+			return err
+		}
+		configs := make(map[int64]enginesModel.PlanEnginesConfig, len(g.eps))
+		clusterPlans := []*model.Plan{}
+		for _, ep := range g.eps {
+			configs[ep.PlanID] = planEngineDataConfigs[ep.PlanID]
+			planOpts[ep.PlanID] = ro
+			planClusters[ep.PlanID] = g.cluster
+			for _, plan := range plans {
+				if plan.ID == ep.PlanID {
+					clusterPlans = append(clusterPlans, plan)
+				}
+			}
+		}
+		if err := g.cdrclient.TriggerCollection(ro, collection, configs, clusterPlans); err != nil {
+			return err
+		}
 	}
 	allRunning := true
 	timeoutDuration := time.Duration(30 * time.Second)
@@ -186,7 +246,7 @@ waitLoop:
 				wg.Add(1)
 				go func(planID int64) {
 					defer wg.Done()
-					if err := c.cdrclient.ProgressCheck(ro, collection.ID, planID); err != nil {
+					if err := planClusters[planID].cdrclient.ProgressCheck(planOpts[planID], collection.ID, planID); err != nil {
 						results <- false
 						return
 					}
@@ -207,12 +267,15 @@ waitLoop:
 	if !allRunning {
 		return fmt.Errorf("Trigger failed after %v. Please purge and try again.", timeoutDuration)
 	}
-	for _, ep := range collection.ExecutionPlans {
-		if err := model.AddRunningPlan(c.sc.Context, collection.ID, ep.PlanID); err != nil {
-			return err
+	for _, g := range groups {
+		for _, ep := range g.eps {
+			if err := model.AddRunningPlan(g.context, collection.ID, ep.PlanID); err != nil {
+				return err
+			}
 		}
 	}
 	collection.NewRun(runID)
+	go c.recordRun(collection, plans, runID)
 	return nil
 }
 
@@ -230,24 +293,49 @@ func (c *Controller) TermCollection(collection *model.Collection, force bool) (e
 			model.DeleteRunningPlan(collection.ID, ep.PlanID)
 		}
 		collection.StopRun()
-		collection.RunFinish(currRunID)
+		c.finishRun(collection, currRunID)
 	}()
-	externalIP, err := c.Scheduler.GetIngressUrl(collection.ProjectID)
+	groups, err := c.groupByCluster(eps)
+	if err != nil {
+		return err
+	}
+	// a failure in one cluster should not keep the plans in the other clusters running
+	for _, g := range groups {
+		ro, err := g.coordinatorOpts(collection.ProjectID)
+		if err == nil {
+			err = g.cdrclient.TermCollection(ro, collection.ID, g.eps)
+		}
+		if err != nil && e == nil {
+			e = err
+		}
+	}
+	return e
+}
+
+// ScalePlan changes the concurrency of every engine of a running plan within the quota of its
+// project. The change only lasts for the run, the next runs start again with the concurrency of the
+// collection config.
+func (c *Controller) ScalePlan(collection *model.Collection, ep *model.ExecutionPlan, concurrency int) error {
+	cl, err := c.clusterOf(ep)
 	if err != nil {
 		return err
 	}
-	apiKey, err := c.Scheduler.GetProjectAPIKey(collection.ProjectID)
+	ro, err := cl.coordinatorOpts(collection.ProjectID)
 	if err != nil {
 		return err
 	}
-	ro := cdrclient.ReqOpts{
-		Endpoint: externalIP,
-		APIKey:   apiKey,
+	previous, err := c.admitScale(collection, ep.PlanID, concurrency)
+	if err != nil {
+		return err
 	}
-	if err := c.cdrclient.TermCollection(ro, collection.ID, eps); err != nil {
+	pc := NewPlanController(ep, collection, cl.scheduler, cl.httpClient, cl.sc)
+	if err := pc.scale(cl.cdrclient, ro, concurrency); err != nil {
+		if e := model.SetRunningPlanConcurrency(collection.ID, ep.PlanID, previous); e != nil {
+			log.Error(e)
+		}
 		return err
 	}
-	return e
+	return nil
 }
 
 func (c *Controller) TermAndPurgeCollection(collection *model.Collection) (err error) {
@@ -260,10 +348,17 @@ func (c *Controller) TermAndPurgeCollection(collection *model.Collection) (err e
 		}
 	}()
 	c.TermCollection(collection, true)
-	if err = c.Scheduler.PurgeCollection(collection.ID); err != nil {
-		return err
+	c.forgetAdmission(collection.ID)
+	if e := collection.DequeueDeployment(); e != nil {
+		log.Error(e)
+	}
+	// the engines are purged from all the clusters as the contexts of the plans could have been
+	// changed since they were deployed
+	for _, cl := range c.clusters {
+		if e := cl.scheduler.PurgeCollection(collection.ID); e != nil && err == nil {
+			err = e
+		}
 	}
-
 	return err
 }
 
@@ -274,28 +369,42 @@ func (c *Controller) CollectionStatus(collection *model.Collection) (*smodel.Col
 	if err != nil {
 		return nil, err
 	}
-	numberOfEngines := 0
-	for _, ep := range eps {
-		numberOfEngines += ep.Engines
-	}
-	cs, err := c.Scheduler.CollectionStatus(collection.ProjectID, collection.ID, eps)
+	groups, err := c.groupByCluster(eps)
 	if err != nil {
 		return nil, err
 	}
-	ingressIP, err := c.Scheduler.GetIngressUrl(collection.ProjectID)
-	if err != nil || ingressIP == "" {
-		return cs, nil
+	cs := &smodel.CollectionStatus{ScraperDeployed: true}
+	for _, g := range groups {
+		gs, err := g.scheduler.CollectionStatus(collection.ProjectID, collection.ID, g.eps)
+		if err != nil {
+			return nil, err
+		}
+		c.checkEnginesReachable(g, collection, gs)
+		cs.ScraperDeployed = cs.ScraperDeployed && gs.ScraperDeployed
+		cs.Plans = append(cs.Plans, gs.Plans...)
+		cs.PoolSize += gs.PoolSize
+		cs.PoolStatus = gs.PoolStatus
 	}
-	apiKey, err := c.Scheduler.GetProjectAPIKey(collection.ProjectID)
-	if err != nil {
-		return cs, nil
+	if c.sc.DevMode {
+		cs.PoolSize = 100
+		cs.PoolStatus = "running"
+	}
+	return cs, nil
+}
+
+// checkEnginesReachable marks the plans of the cluster as reachable when the coordinator of the
+// cluster can reach all their engines
+func (c *Controller) checkEnginesReachable(g *clusterPlans, collection *model.Collection, cs *smodel.CollectionStatus) {
+	numberOfEngines := 0
+	for _, ep := range g.eps {
+		numberOfEngines += ep.Engines
 	}
-	ro := cdrclient.ReqOpts{
-		Endpoint: ingressIP,
-		APIKey:   apiKey,
+	ro, err := g.coordinatorOpts(collection.ProjectID)
+	if err != nil || ro.Endpoint == "" {
+		return
 	}
-	if err := c.cdrclient.Healthcheck(ro, collection, numberOfEngines); err != nil {
-		return cs, nil
+	if err := g.cdrclient.Healthcheck(ro, collection, numberOfEngines); err != nil {
+		return
 	}
 	for _, ps := range cs.Plans {
 		// TODO! now, for simplicity, we combine the logic together.
@@ -307,11 +416,6 @@ func (c *Controller) CollectionStatus(collection *model.Collection) (*smodel.Col
 		ps.StartedTime = rp.StartedTime
 		ps.InProgress = true
 	}
-	if c.sc.DevMode {
-		cs.PoolSize = 100
-		cs.PoolStatus = "running"
-	}
-	return cs, nil
 }
 
 func (c *Controller) SubscribeCollection(collection *model.Collection) ([]*Engine, error) {
@@ -325,7 +429,10 @@ func (c *Controller) SubscribeCollection(collection *model.Collection) ([]*Engin
 		wg.Add(1)
 		go func(ep *model.ExecutionPlan) {
 			defer wg.Done()
-			pc := NewPlanController(ep, collection, c.Scheduler, c.httpClient, c.sc)
+			pc, err := c.newPlanController(ep, collection)
+			if err != nil {
+				return
+			}
 			engines, err := pc.subscribe()
 			if err != nil {
 				return
@@ -342,9 +449,13 @@ func (c *Controller) calculateUsage(collection *model.Collection) error {
 	if err != nil {
 		return err
 	}
-	vu := 0
+	vus := make(map[string]int64)
 	for _, ep := range eps {
-		vu += ep.Engines * ep.Concurrency
+		cl, err := c.clusterOf(ep)
+		if err != nil {
+			return err
+		}
+		vus[cl.context] += int64(ep.Engines * ep.Concurrency)
 	}
-	return collection.MarkUsageFinished(c.sc.Context, int64(vu))
+	return collection.MarkUsageFinished(vus)
 }
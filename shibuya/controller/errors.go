@@ -6,9 +6,25 @@ import (
 )
 
 var (
-	EngineError = errors.New("Error with Engine-")
+	EngineError   = errors.New("Error with Engine-")
+	ContextError  = errors.New("Error with Context-")
+	QuotaError    = errors.New("Error with Quota-")
+	CapacityError = errors.New("Error with Capacity-")
 )
 
 func makeWrongEngineTypeError() error {
 	return fmt.Errorf("%w%s", EngineError, "Wrong Engine type requested")
 }
+
+func makeUnknownContextError(context string) error {
+	return fmt.Errorf("%wUnknown context %s", ContextError, context)
+}
+
+func makeQuotaExceededError(scope, message string) error {
+	return fmt.Errorf("%wQuota of %s exceeded: %s", QuotaError, scope, message)
+}
+
+func makeCapacityExceededError(context string) error {
+	return fmt.Errorf("%wThe engines of the collection do not fit in the nodes of cluster %s even when they are free",
+		CapacityError, context)
+}
@@ -5,7 +5,6 @@ import (
 	"strconv"
 	"time"
 
-	cdrclient "github.com/rakutentech/shibuya/shibuya/coordinator/client"
 	"github.com/rakutentech/shibuya/shibuya/model"
 	log "github.com/sirupsen/logrus"
 )
@@ -15,6 +14,8 @@ import (
 type RunningPlan struct {
 	ep         *model.ExecutionPlan
 	collection *model.Collection
+	// cluster is the cluster the plan was triggered in
+	cluster *cluster
 }
 
 func (c *Controller) CheckRunningThenTerminate() {
@@ -23,24 +24,16 @@ func (c *Controller) CheckRunningThenTerminate() {
 		go func(jobs <-chan *RunningPlan) {
 		jobLoop:
 			for j := range jobs {
-				pc := NewPlanController(j.ep, j.collection, c.Scheduler, c.httpClient, c.sc)
-				externalIP, err := c.Scheduler.GetIngressUrl(j.collection.ProjectID)
+				pc := NewPlanController(j.ep, j.collection, j.cluster.scheduler, j.cluster.httpClient, j.cluster.sc)
+				ro, err := j.cluster.coordinatorOpts(j.collection.ProjectID)
 				if err != nil {
 					continue jobLoop
 				}
-				apiKey, err := c.Scheduler.GetProjectAPIKey(j.collection.ProjectID)
-				if err != nil {
-					continue jobLoop
-				}
-				ro := cdrclient.ReqOpts{
-					Endpoint: externalIP,
-					APIKey:   apiKey,
-				}
-				if running := pc.progress(c.cdrclient, ro); !running {
+				if running := pc.progress(j.cluster.cdrclient, ro); !running {
 					collection := j.collection
 					currRunID, err := collection.GetCurrentRun()
 					if currRunID != int64(0) {
-						if err := pc.term(c.cdrclient, ro); err != nil {
+						if err := pc.term(j.cluster.cdrclient, ro); err != nil {
 							log.Error(err)
 							continue jobLoop
 						}
@@ -53,41 +46,48 @@ func (c *Controller) CheckRunningThenTerminate() {
 						continue jobLoop
 					}
 					collection.StopRun()
-					collection.RunFinish(currRunID)
+					c.finishRun(collection, currRunID)
 				}
 			}
 		}(jobs)
 	}
-	log.Printf("Getting all the running plans for %s", c.sc.Context)
+	log.Printf("Getting all the running plans for %v", c.Contexts())
 	for {
-		runningPlans, err := model.GetRunningPlans(c.sc.Context)
-		if err != nil {
-			log.Error(err)
-			continue
+		for _, cl := range c.clusters {
+			c.checkRunningPlans(cl, jobs)
 		}
-		localCache := make(map[int64]*model.Collection)
-		for _, rp := range runningPlans {
-			var collection *model.Collection
-			var ok bool
-			collection, ok = localCache[rp.CollectionID]
-			if !ok {
-				collection, err = model.GetCollection(rp.CollectionID)
-				if err != nil {
-					continue
-				}
-				localCache[rp.CollectionID] = collection
-			}
-			ep, err := model.GetExecutionPlan(collection.ID, rp.PlanID)
+		time.Sleep(2 * time.Second)
+	}
+}
+
+func (c *Controller) checkRunningPlans(cl *cluster, jobs chan<- *RunningPlan) {
+	runningPlans, err := model.GetRunningPlans(cl.context)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	localCache := make(map[int64]*model.Collection)
+	for _, rp := range runningPlans {
+		var collection *model.Collection
+		var ok bool
+		collection, ok = localCache[rp.CollectionID]
+		if !ok {
+			collection, err = model.GetCollection(rp.CollectionID)
 			if err != nil {
 				continue
 			}
-			item := &RunningPlan{
-				ep:         ep,
-				collection: collection,
-			}
-			jobs <- item
+			localCache[rp.CollectionID] = collection
 		}
-		time.Sleep(2 * time.Second)
+		ep, err := model.GetExecutionPlan(collection.ID, rp.PlanID)
+		if err != nil {
+			continue
+		}
+		item := &RunningPlan{
+			ep:         ep,
+			collection: collection,
+			cluster:    cl,
+		}
+		jobs <- item
 	}
 }
 
@@ -111,38 +111,44 @@ func isCollectionStale(rh *model.RunHistory, launchTime time.Time, gcDuration fl
 func (c *Controller) AutoPurgeDeployments() {
 	log.Info("Start the loop for purging idle engines")
 	for {
-		deployedCollections, err := c.Scheduler.GetDeployedCollections()
+		for _, cl := range c.clusters {
+			c.purgeIdleDeployments(cl)
+		}
+		time.Sleep(60 * time.Second)
+	}
+}
+
+func (c *Controller) purgeIdleDeployments(cl *cluster) {
+	deployedCollections, err := cl.scheduler.GetDeployedCollections()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	for collectionID, launchTime := range deployedCollections {
+		collection, err := model.GetCollection(collectionID)
 		if err != nil {
 			log.Error(err)
 			continue
 		}
-		for collectionID, launchTime := range deployedCollections {
-			collection, err := model.GetCollection(collectionID)
-			if err != nil {
-				log.Error(err)
-				continue
-			}
 
-			lr, err := collection.GetLastRun()
-			if err != nil {
-				log.Error(err)
-				continue
-			}
-			status, err := isCollectionStale(lr, launchTime, c.sc.ExecutorConfig.Cluster.GCDuration)
-			if err != nil {
-				log.Error(err)
-				continue
-			}
-			if !status {
-				continue
-			}
-			err = c.TermAndPurgeCollection(collection)
-			if err != nil {
-				log.Error(err)
-				continue
-			}
+		lr, err := collection.GetLastRun()
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		status, err := isCollectionStale(lr, launchTime, cl.sc.ExecutorConfig.Cluster.GCDuration)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		if !status {
+			continue
+		}
+		err = c.TermAndPurgeCollection(collection)
+		if err != nil {
+			log.Error(err)
+			continue
 		}
-		time.Sleep(60 * time.Second)
 	}
 }
 
@@ -152,7 +158,8 @@ func (c *Controller) AutoPurgeDeployments() {
 // 2. If any of the collection has a run, it will be the end time of that run
 func (c *Controller) AutoPurgeProjectIngressController() {
 	log.Info("Start the loop for purging idle ingress controllers")
-	projectLastUsedTime := make(map[int64]time.Time)
+	// the last used time of the projects by context, as every cluster has its own ingress
+	projectLastUsedTime := make(map[string]map[int64]time.Time)
 	ingressLifespan, err := time.ParseDuration(c.sc.IngressConfig.Lifespan)
 	if err != nil {
 		log.Fatal(err)
@@ -163,73 +170,82 @@ func (c *Controller) AutoPurgeProjectIngressController() {
 	}
 	log.Println(fmt.Sprintf("Project ingress lifespan is %v. And the GC Interval is %v", ingressLifespan, gcInterval))
 	for {
-		deployedServices, err := c.Scheduler.GetDeployedServices()
+		for _, cl := range c.clusters {
+			if _, ok := projectLastUsedTime[cl.context]; !ok {
+				projectLastUsedTime[cl.context] = make(map[int64]time.Time)
+			}
+			c.purgeIdleIngress(cl, projectLastUsedTime[cl.context], ingressLifespan)
+		}
+		// The interval should not be very long. For example, a collection has been launched for 30 minutes,
+		// If there is a run being executed for a minute, there is a chance the GC misses that run and the project
+		// ip will be deleted.
+		time.Sleep(gcInterval)
+	}
+}
+
+func (c *Controller) purgeIdleIngress(cl *cluster, projectLastUsedTime map[int64]time.Time, ingressLifespan time.Duration) {
+	deployedServices, err := cl.scheduler.GetDeployedServices()
+	if err != nil {
+		return
+	}
+svcLoop:
+	for projectID := range deployedServices {
+		// Because of this if, the GC could not know any operation happening in the span.
+		// When the time is up and there is no engines deployment, the ingress ip will be deleted right away.
+		// if time.Since(createdTime) < ingressLifespan {
+		// 	continue
+		// }
+		pods, err := cl.scheduler.GetEnginesByProject(projectID)
 		if err != nil {
 			continue
 		}
-	svcLoop:
-		for projectID := range deployedServices {
-			// Because of this if, the GC could not know any operation happening in the span.
-			// When the time is up and there is no engines deployment, the ingress ip will be deleted right away.
-			// if time.Since(createdTime) < ingressLifespan {
-			// 	continue
-			// }
-			pods, err := c.Scheduler.GetEnginesByProject(projectID)
+		t, err := time.Parse("2006-01-03", "2000-01-01")
+		if err != nil {
+			log.Fatal(err)
+		}
+		latestRun := &model.RunHistory{EndTime: t}
+		for _, p := range pods {
+			collectionID, err := strconv.ParseInt(p.Labels["collection"], 10, 64)
 			if err != nil {
-				continue
+				log.Error(err)
+				continue svcLoop
 			}
-			t, err := time.Parse("2006-01-03", "2000-01-01")
+			collection, err := model.GetCollection(collectionID)
 			if err != nil {
-				log.Fatal(err)
+				continue svcLoop
 			}
-			latestRun := &model.RunHistory{EndTime: t}
-			for _, p := range pods {
-				collectionID, err := strconv.ParseInt(p.Labels["collection"], 10, 64)
-				if err != nil {
-					log.Error(err)
-					continue svcLoop
-				}
-				collection, err := model.GetCollection(collectionID)
-				if err != nil {
-					continue svcLoop
-				}
-				lr, err := collection.GetLastRun()
-				if err != nil {
-					continue svcLoop
-				}
-				if lr != nil {
-					// We need to track the ongoing run because if run stops before the loop and engines are purged,
-					// the lastUsedTime will be the engine launch time.
-					if lr.EndTime.IsZero() {
-						lr.EndTime = time.Now()
-					}
-					if lr.EndTime.After(latestRun.EndTime) {
-						latestRun = lr
-					}
-				}
+			lr, err := collection.GetLastRun()
+			if err != nil {
+				continue svcLoop
 			}
-			plu := projectLastUsedTime[projectID]
-			if len(pods) > 0 {
-				// the pods are ordered by created time in asc order. So the first pod in the list
-				// is the most reccently being created
-				podLastCreatedTime := pods[0].CreationTimestamp.Time
-				if podLastCreatedTime.After(plu) {
-					plu = podLastCreatedTime
+			if lr != nil {
+				// We need to track the ongoing run because if run stops before the loop and engines are purged,
+				// the lastUsedTime will be the engine launch time.
+				if lr.EndTime.IsZero() {
+					lr.EndTime = time.Now()
+				}
+				if lr.EndTime.After(latestRun.EndTime) {
+					latestRun = lr
 				}
 			}
-			// we also need this line because if there is no engines deployed, we could not find the latest run
-			if latestRun.EndTime.After(plu) {
-				plu = latestRun.EndTime
-			}
-			projectLastUsedTime[projectID] = plu
-			if time.Since(plu) > ingressLifespan {
-				log.Println(fmt.Sprintf("Going to delete ingress for project %d. Last used time was %v", projectID, plu))
-				c.Scheduler.PurgeProjectIngress(projectID)
+		}
+		plu := projectLastUsedTime[projectID]
+		if len(pods) > 0 {
+			// the pods are ordered by created time in asc order. So the first pod in the list
+			// is the most reccently being created
+			podLastCreatedTime := pods[0].CreationTimestamp.Time
+			if podLastCreatedTime.After(plu) {
+				plu = podLastCreatedTime
 			}
 		}
-		// The interval should not be very long. For example, a collection has been launched for 30 minutes,
-		// If there is a run being executed for a minute, there is a chance the GC misses that run and the project
-		// ip will be deleted.
-		time.Sleep(gcInterval)
+		// we also need this line because if there is no engines deployed, we could not find the latest run
+		if latestRun.EndTime.After(plu) {
+			plu = latestRun.EndTime
+		}
+		projectLastUsedTime[projectID] = plu
+		if time.Since(plu) > ingressLifespan {
+			log.Println(fmt.Sprintf("Going to delete ingress for project %d in %s. Last used time was %v", projectID, cl.context, plu))
+			cl.scheduler.PurgeProjectIngress(projectID)
+		}
 	}
 }
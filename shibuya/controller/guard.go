@@ -0,0 +1,177 @@
+package controller
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	enginesModel "github.com/rakutentech/shibuya/shibuya/engines/model"
+	"github.com/rakutentech/shibuya/shibuya/model"
+)
+
+const (
+	guardCheckInterval = time.Second
+)
+
+func (ls *latencyStats) merge(other *latencyStats) {
+	if other.count == 0 {
+		return
+	}
+	if ls.count == 0 || other.min < ls.min {
+		ls.min = other.min
+	}
+	if other.max > ls.max {
+		ls.max = other.max
+	}
+	if ls.first.IsZero() || other.first.Before(ls.first) {
+		ls.first = other.first
+	}
+	if other.last.After(ls.last) {
+		ls.last = other.last
+	}
+	ls.count += other.count
+	ls.errors += other.errors
+	ls.sum += other.sum
+	for status, count := range other.statuses {
+		ls.statuses[status] += count
+	}
+	for latency, count := range other.buckets {
+		ls.buckets[latency] += count
+	}
+}
+
+// guardState keeps one slot per second of the sliding window of a guard
+type guardState struct {
+	guard         *model.Guard
+	rule          *model.Rule
+	window        int64
+	slots         []*latencyStats
+	slotSeconds   []int64
+	breachedSince time.Time
+}
+
+func newGuardState(g *model.Guard) (*guardState, error) {
+	rule, err := model.ParseRule(g.Rule)
+	if err != nil {
+		return nil, err
+	}
+	window := g.WindowSeconds()
+	if window > model.MaxGuardSeconds {
+		return nil, fmt.Errorf("window of rule %q cannot be more than %d seconds", g.Rule, model.MaxGuardSeconds)
+	}
+	return &guardState{
+		guard:       g,
+		rule:        rule,
+		window:      int64(window),
+		slots:       make([]*latencyStats, window),
+		slotSeconds: make([]int64, window),
+	}, nil
+}
+
+func (gs *guardState) matches(planID int64, label string) bool {
+	if gs.guard.PlanID == 0 {
+		return true
+	}
+	if gs.guard.PlanID != planID {
+		return false
+	}
+	return gs.guard.Label == "" || gs.guard.Label == label
+}
+
+func (gs *guardState) add(metric enginesModel.ShibuyaMetric, t time.Time) {
+	sec := t.Unix()
+	i := sec % gs.window
+	if gs.slots[i] == nil || gs.slotSeconds[i] != sec {
+		gs.slots[i] = newLatencyStats()
+		gs.slotSeconds[i] = sec
+	}
+	gs.slots[i].add(metric, t)
+}
+
+// windowSummary merges the slots within the window ending at now
+func (gs *guardState) windowSummary(now time.Time) *model.RunSummary {
+	ls := newLatencyStats()
+	sec := now.Unix()
+	for i, slot := range gs.slots {
+		if slot != nil && gs.slotSeconds[i] > sec-gs.window && gs.slotSeconds[i] <= sec {
+			ls.merge(slot)
+		}
+	}
+	s := ls.toSummary(gs.guard.PlanID, gs.guard.Label)
+	s.Throughput = float64(ls.count) / float64(gs.window)
+	return s
+}
+
+// check returns the reason of the abort once the rule has been breached for long enough
+func (gs *guardState) check(now time.Time) (string, bool) {
+	s := gs.windowSummary(now)
+	minRequests := int64(gs.guard.MinRequests)
+	if minRequests < 1 {
+		minRequests = 1
+	}
+	if s.RequestCount < minRequests {
+		gs.breachedSince = time.Time{}
+		return "", false
+	}
+	v := gs.rule.Value(s)
+	if !gs.rule.Check(v) {
+		gs.breachedSince = time.Time{}
+		return "", false
+	}
+	if gs.breachedSince.IsZero() {
+		gs.breachedSince = now
+	}
+	if now.Sub(gs.breachedSince) < time.Duration(gs.guard.For)*time.Second {
+		return "", false
+	}
+	scope := "run"
+	if gs.guard.PlanID != 0 {
+		scope = fmt.Sprintf("testid %d", gs.guard.PlanID)
+		if gs.guard.Label != "" {
+			scope = fmt.Sprintf("%s label %s", scope, gs.guard.Label)
+		}
+	}
+	return fmt.Sprintf("%s: %s of the %s was %.2f over the last %ds", gs.guard.Rule, gs.rule.Metric, scope, v,
+		gs.window), true
+}
+
+// runGuards evaluates the abort_on rules of a collection while the run is in progress
+type runGuards struct {
+	mu     sync.Mutex
+	states []*guardState
+}
+
+func newRunGuards(guards []*model.Guard) (*runGuards, error) {
+	rg := &runGuards{}
+	for _, g := range guards {
+		gs, err := newGuardState(g)
+		if err != nil {
+			return nil, err
+		}
+		rg.states = append(rg.states, gs)
+	}
+	return rg, nil
+}
+
+func (rg *runGuards) add(planID int64, metric enginesModel.ShibuyaMetric, t time.Time) {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+
+	for _, gs := range rg.states {
+		if gs.matches(planID, metric.Label) {
+			gs.add(metric, t)
+		}
+	}
+}
+
+func (rg *runGuards) check(now time.Time) (string, bool) {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+
+	for _, gs := range rg.states {
+		if reason, breached := gs.check(now); breached {
+			return reason, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,68 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	enginesModel "github.com/rakutentech/shibuya/shibuya/engines/model"
+	"github.com/rakutentech/shibuya/shibuya/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGuardErrorRate(t *testing.T) {
+	rg, err := newRunGuards([]*model.Guard{{Rule: "error_rate > 5%", Window: 10, For: 3, MinRequests: 10}})
+	assert.Nil(t, err)
+	start := time.Unix(1000, 0)
+	// healthy traffic does not abort
+	for i := 0; i < 10; i++ {
+		now := start.Add(time.Duration(i) * time.Second)
+		rg.add(1, enginesModel.ShibuyaMetric{Latency: 10, Status: "200"}, now)
+		_, breached := rg.check(now)
+		assert.False(t, breached)
+	}
+	// every request fails from now on, the rule needs to be breached for 3 seconds
+	var reason string
+	var breached bool
+	var abortedAt int
+	for i := 10; i < 20; i++ {
+		now := start.Add(time.Duration(i) * time.Second)
+		rg.add(1, enginesModel.ShibuyaMetric{Latency: 10, Status: "500"}, now)
+		if reason, breached = rg.check(now); breached {
+			abortedAt = i
+			break
+		}
+	}
+	assert.True(t, breached)
+	assert.Equal(t, 13, abortedAt)
+	assert.Contains(t, reason, "error_rate > 5%")
+}
+
+func TestGuardMinRequests(t *testing.T) {
+	rg, err := newRunGuards([]*model.Guard{{Rule: "error_rate > 5%", MinRequests: 5}})
+	assert.Nil(t, err)
+	now := time.Unix(1000, 0)
+	rg.add(1, enginesModel.ShibuyaMetric{Latency: 10, Status: "500"}, now)
+	_, breached := rg.check(now)
+	assert.False(t, breached)
+}
+
+func TestGuardWindowSlides(t *testing.T) {
+	gs, err := newGuardState(&model.Guard{Rule: "p99 > 1s", PlanID: 1, Label: "slow", Window: 5})
+	assert.Nil(t, err)
+	assert.False(t, gs.matches(2, "slow"))
+	assert.False(t, gs.matches(1, "fast"))
+	assert.True(t, gs.matches(1, "slow"))
+
+	start := time.Unix(1000, 0)
+	gs.add(enginesModel.ShibuyaMetric{Latency: 2000, Status: "200"}, start)
+	_, breached := gs.check(start)
+	assert.True(t, breached)
+	// the slow request is out of the window
+	later := start.Add(5 * time.Second)
+	gs.add(enginesModel.ShibuyaMetric{Latency: 100, Status: "200"}, later)
+	s := gs.windowSummary(later)
+	assert.Equal(t, int64(1), s.RequestCount)
+	assert.Equal(t, float64(0.2), s.Throughput)
+	_, breached = gs.check(later)
+	assert.False(t, breached)
+}
@@ -2,15 +2,11 @@ package controller
 
 import (
 	"context"
-	"crypto/tls"
-	"crypto/x509"
-	"net/http"
 	"strconv"
 	"sync"
 	"time"
 
 	"github.com/rakutentech/shibuya/shibuya/config"
-	cdrclient "github.com/rakutentech/shibuya/shibuya/coordinator/client"
 	"github.com/rakutentech/shibuya/shibuya/model"
 	"github.com/rakutentech/shibuya/shibuya/object_storage"
 	"github.com/rakutentech/shibuya/shibuya/scheduler"
@@ -19,42 +15,41 @@ import (
 
 type Controller struct {
 	readingEngineRecords   sync.Map
+	runRecorders           sync.Map
 	ApiNewClients          chan *ApiMetricStream
 	ApiClosingClients      chan *ApiMetricStream
 	filePath               string
-	httpClient             *http.Client
 	schedulerKind          string
 	Scheduler              scheduler.EngineScheduler
 	clientStreamingWorkers int
 	sc                     config.ShibuyaConfig
-	cdrclient              *cdrclient.Client
 	storageClient          object_storage.StorageInterface
+	// clusters are all the executor clusters, starting with the default one. Scheduler is the
+	// scheduler of the default cluster.
+	clusters []*cluster
+	// admitted are the collections admitted in the quotas by the time of their admission. They are
+	// only known to this controller, so the deployments going through several controllers at the
+	// same time can exceed the quotas until their engines are reported by the schedulers.
+	admissionMu sync.Mutex
+	admitted    map[int64]time.Time
+	// lastAdmissions are when a collection was last deployed in the clusters with a limited capacity
+	queueMu        sync.Mutex
+	lastAdmissions map[string]time.Time
 }
 
 func NewController(sc config.ShibuyaConfig) *Controller {
-	pool := x509.NewCertPool()
-	pool.AddCert(sc.CAPair.Cert)
-	httpClient := &http.Client{
-		Timeout: 5 * time.Second,
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				RootCAs: pool,
-			},
-		},
-	}
 	c := &Controller{
 		filePath:               "/test-data",
-		httpClient:             httpClient,
 		ApiClosingClients:      make(chan *ApiMetricStream),
 		ApiNewClients:          make(chan *ApiMetricStream),
 		clientStreamingWorkers: 5,
 		sc:                     sc,
-		cdrclient:              cdrclient.NewClient(httpClient),
 		storageClient:          object_storage.CreateObjStorageClient(sc),
 	}
 
 	c.schedulerKind = sc.ExecutorConfig.Cluster.Kind
-	c.Scheduler = scheduler.NewEngineScheduler(sc)
+	c.clusters = newClusters(sc)
+	c.Scheduler = c.clusters[0].scheduler
 	return c
 }
 
@@ -62,7 +57,9 @@ type subscribeState struct {
 	cancelfunc     context.CancelFunc
 	ctx            context.Context
 	readingEngines []*Engine
-	readyToClose   chan struct{}
+	// recorder is set when the client listens to the recorder of the run instead of the engines
+	recorder     *runRecorder
+	readyToClose chan struct{}
 }
 
 type ApiMetricStream struct {
@@ -90,6 +87,8 @@ func (c *Controller) StartRunning() {
 func (c *Controller) IsolateBackgroundTasks() {
 	go c.AutoPurgeDeployments()
 	go c.CheckRunningThenTerminate()
+	go c.RunSchedules()
+	go c.RunDeployQueue()
 	c.AutoPurgeProjectIngressController()
 }
 
@@ -103,6 +102,25 @@ func (c *Controller) handleStreamForClient(item *ApiMetricStream) error {
 	if err != nil {
 		return err
 	}
+	// the recorder of the run is already subscribed to all the engines
+	if t, ok := c.runRecorders.Load(cid); ok {
+		rr := t.(*runRecorder)
+		if metrics, ok := rr.listen(item.ClientID); ok {
+			ctx, cancel := context.WithCancel(context.Background())
+			ss := subscribeState{
+				cancelfunc:   cancel,
+				ctx:          ctx,
+				recorder:     rr,
+				readyToClose: make(chan struct{}),
+			}
+			c.readingEngineRecords.Store(item.ClientID, ss)
+			go func() {
+				forwardMetrics(ctx, metrics, item.StreamClient)
+				ss.readyToClose <- struct{}{}
+			}()
+			return nil
+		}
+	}
 	readingEngines, err := c.SubscribeCollection(collection)
 	if err != nil {
 		return err
@@ -143,6 +161,28 @@ func (c *Controller) handleStreamForClient(item *ApiMetricStream) error {
 	return nil
 }
 
+func forwardMetrics(ctx context.Context, metrics chan *shibuyaMetric, client chan *ApiMetricStreamEvent) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case metric, ok := <-metrics:
+			if !ok {
+				return
+			}
+			select {
+			case client <- &ApiMetricStreamEvent{
+				CollectionID: metric.collectionID,
+				PlanID:       metric.planID,
+				Raw:          metric.raw,
+			}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
 func (c *Controller) streamToApi() {
 	workerQueue := make(chan *ApiMetricStream)
 	for i := 0; i < c.clientStreamingWorkers; i++ {
@@ -169,6 +209,9 @@ func (c *Controller) streamToApi() {
 					}(e)
 				}
 				ss.cancelfunc()
+				if ss.recorder != nil {
+					ss.recorder.unlisten(clientID)
+				}
 				<-ss.readyToClose
 				close(item.StreamClient)
 				c.readingEngineRecords.Delete(clientID)
@@ -0,0 +1,231 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rakutentech/shibuya/shibuya/model"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// engines which are not reachable after this are considered as failed to deploy
+	engineReadyTimeout = 15 * time.Minute
+	// MaxRunTimeout is the lifespan of the token given to the scraper
+	MaxRunTimeout = 24 * time.Hour
+)
+
+var runPollInterval = 10 * time.Second
+
+var errWaitTimeout = errors.New("timed out")
+
+// waitFor calls cond every interval until it returns true, an error or the timeout is reached
+func waitFor(cond func() (bool, error), interval, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		ok, err := cond()
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		if time.Now().Add(interval).After(deadline) {
+			return errWaitTimeout
+		}
+		time.Sleep(interval)
+	}
+}
+
+// CheckRunnable returns why the collection cannot be run from the deployment to the purge. A collection
+// deployed by someone else is not run, as its engines would be purged at the end of the run.
+func (c *Controller) CheckRunnable(collection *model.Collection) error {
+	eps, err := collection.GetExecutionPlans()
+	if err != nil {
+		return err
+	}
+	if len(eps) == 0 {
+		return errors.New("collection does not have any plans")
+	}
+	if runID, err := collection.GetCurrentRun(); err != nil {
+		return err
+	} else if runID != 0 {
+		return fmt.Errorf("collection is already running run %d", runID)
+	}
+	if active, err := collection.HasActiveOneShotRun(); err != nil {
+		return err
+	} else if active {
+		return model.ErrOneShotRunActive
+	}
+	if launched, err := collection.HasLaunchInProgress(); err != nil {
+		return err
+	} else if launched {
+		return errors.New("collection is already deployed, purge it first")
+	}
+	if n := c.PodReadyCount(collection.ID); n > 0 {
+		return fmt.Errorf("collection already has %d engines deployed, purge them first", n)
+	}
+	if qd, err := collection.GetQueuedDeployment(); err == nil && !qd.Failed {
+		return errors.New("deployment of the collection is already queued")
+	}
+	return nil
+}
+
+// runSteps are how a run is driven through the collection. They are replaced in the tests.
+type runSteps struct {
+	// deploy requests the deployment and tells whether it's queued
+	deploy func() (bool, error)
+	// admitted tells whether the queued deployment left the queue
+	admitted func() (bool, error)
+	// ready tells whether all the engines are reachable
+	ready func() (bool, error)
+	// trigger starts the run and returns its id
+	trigger  func() (int64, error)
+	finished func(runID int64) (bool, error)
+	purge    func() error
+}
+
+func (c *Controller) makeRunSteps(collection *model.Collection, token, createdBy string) *runSteps {
+	return &runSteps{
+		deploy: func() (bool, error) {
+			qd, err := c.RequestDeployment(collection, token, createdBy)
+			return qd != nil, err
+		},
+		admitted: func() (bool, error) {
+			qd, err := collection.GetQueuedDeployment()
+			if err != nil {
+				var dbErr *model.DBError
+				if errors.As(err, &dbErr) {
+					return true, nil
+				}
+				return false, err
+			}
+			if qd.Failed {
+				return false, errors.New(qd.Message)
+			}
+			return false, nil
+		},
+		ready: func() (bool, error) {
+			cs, err := c.CollectionStatus(collection)
+			if err != nil {
+				return false, err
+			}
+			return cs.CanBeTriggered(), nil
+		},
+		trigger: func() (int64, error) {
+			if err := c.TriggerCollection(collection); err != nil {
+				return 0, err
+			}
+			return collection.GetCurrentRun()
+		},
+		finished: func(runID int64) (bool, error) {
+			rh, err := model.GetRun(runID)
+			if err != nil {
+				return false, err
+			}
+			return !rh.EndTime.IsZero(), nil
+		},
+		purge: func() error {
+			return c.TermAndPurgeCollection(collection)
+		},
+	}
+}
+
+// driveRun deploys the collection, triggers it once all the engines are reachable and waits for the
+// run to finish. The deployment goes through the deploy queue and the time spent in the queue counts
+// in the timeout. onPhase is called every time the run moves to a new phase.
+// The engines are always purged when a step fails so they are not leaked. Otherwise, they are only
+// purged when purgeAfter is set. Engines deployed by someone else in the meantime are never purged.
+func driveRun(steps *runSteps, purgeAfter bool, timeout time.Duration, onPhase func(phase string, runID int64)) (err error) {
+	deadline := time.Now().Add(timeout)
+	runID := int64(0)
+	deployed := false
+	defer func() {
+		if !deployed || (err == nil && !purgeAfter) {
+			return
+		}
+		onPhase(model.PhasePurging, runID)
+		if e := steps.purge(); e != nil && err == nil {
+			err = e
+		}
+	}()
+	onPhase(model.PhaseDeploying, runID)
+	queued, err := steps.deploy()
+	// the launch of someone else is left alone, otherwise a failed deployment could leave engines
+	deployed = !errors.Is(err, model.ErrLaunchInProgress)
+	if err != nil {
+		return err
+	}
+	if queued {
+		onPhase(model.PhaseQueued, runID)
+		if err := waitFor(steps.admitted, runPollInterval, time.Until(deadline)); err != nil {
+			if errors.Is(err, errWaitTimeout) {
+				return fmt.Errorf("deployment is still queued: %w", err)
+			}
+			return err
+		}
+		onPhase(model.PhaseDeploying, runID)
+	}
+	readyTimeout := engineReadyTimeout
+	if remaining := time.Until(deadline); remaining < readyTimeout {
+		readyTimeout = remaining
+	}
+	if err := waitFor(steps.ready, runPollInterval, readyTimeout); err != nil {
+		return fmt.Errorf("engines are not ready: %w", err)
+	}
+	if runID, err = steps.trigger(); err != nil {
+		return err
+	}
+	onPhase(model.PhaseRunning, runID)
+	err = waitFor(func() (bool, error) {
+		return steps.finished(runID)
+	}, runPollInterval, time.Until(deadline))
+	if err != nil {
+		return fmt.Errorf("run %d did not finish: %w", runID, err)
+	}
+	return nil
+}
+
+// driveOneShot drives the run and reports every phase with setPhase, the last one being terminal
+func driveOneShot(steps *runSteps, purgeAfter bool, timeout time.Duration,
+	setPhase func(phase string, runID int64, message string) error) error {
+	runID := int64(0)
+	onPhase := func(phase string, id int64) {
+		runID = id
+		if err := setPhase(phase, id, ""); err != nil {
+			log.Error(err)
+		}
+	}
+	phase, message := model.PhaseFinished, ""
+	err := driveRun(steps, purgeAfter, timeout, onPhase)
+	if err != nil {
+		phase, message = model.PhaseFailed, err.Error()
+	}
+	if e := setPhase(phase, runID, message); e != nil {
+		log.Error(e)
+	}
+	return err
+}
+
+// RunOneShot starts running the collection in the background and returns the handle of the run,
+// whose phase is updated as the run goes on.
+func (c *Controller) RunOneShot(collection *model.Collection, token, createdBy string, purgeAfter bool,
+	timeout time.Duration) (*model.OneShotRun, error) {
+	if err := c.CheckRunnable(collection); err != nil {
+		return nil, err
+	}
+	o, err := collection.CreateOneShotRun(createdBy, purgeAfter, timeout)
+	if err != nil {
+		return nil, err
+	}
+	// the returned handle is not updated so it can be rendered while the run goes on
+	handle := *o
+	go func(o *model.OneShotRun) {
+		steps := c.makeRunSteps(collection, token, createdBy)
+		if err := driveOneShot(steps, purgeAfter, timeout, o.SetPhase); err != nil {
+			log.Errorf("One-shot run %d of collection %d failed: %v", o.ID, collection.ID, err)
+		}
+	}(&handle)
+	return o, nil
+}
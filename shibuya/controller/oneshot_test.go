@@ -0,0 +1,190 @@
+package controller
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rakutentech/shibuya/shibuya/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWaitFor(t *testing.T) {
+	calls := 0
+	err := waitFor(func() (bool, error) {
+		calls++
+		return calls == 3, nil
+	}, time.Millisecond, time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, calls)
+
+	err = waitFor(func() (bool, error) {
+		return false, nil
+	}, time.Millisecond, 5*time.Millisecond)
+	assert.Equal(t, errWaitTimeout, err)
+
+	failed := errors.New("failed")
+	err = waitFor(func() (bool, error) {
+		return false, failed
+	}, time.Millisecond, time.Second)
+	assert.Equal(t, failed, err)
+}
+
+type fakeRun struct {
+	deployErr error
+	// queuedPolls is how many times the deployment is still queued when polled
+	queuedPolls int
+	queueErr    error
+	ready       bool
+	runID       int64
+	finished    bool
+	purged      int
+}
+
+func (f *fakeRun) steps() *runSteps {
+	return &runSteps{
+		deploy: func() (bool, error) {
+			return f.queuedPolls > 0, f.deployErr
+		},
+		admitted: func() (bool, error) {
+			if f.queueErr != nil {
+				return false, f.queueErr
+			}
+			f.queuedPolls--
+			return f.queuedPolls <= 0, nil
+		},
+		ready: func() (bool, error) {
+			return f.ready, nil
+		},
+		trigger: func() (int64, error) {
+			return f.runID, nil
+		},
+		finished: func(runID int64) (bool, error) {
+			return f.finished, nil
+		},
+		purge: func() error {
+			f.purged++
+			return nil
+		},
+	}
+}
+
+type phaseRecorder struct {
+	phases   []string
+	runIDs   []int64
+	messages []string
+}
+
+func (pr *phaseRecorder) onPhase(phase string, runID int64) {
+	pr.setPhase(phase, runID, "")
+}
+
+func (pr *phaseRecorder) setPhase(phase string, runID int64, message string) error {
+	pr.phases = append(pr.phases, phase)
+	pr.runIDs = append(pr.runIDs, runID)
+	pr.messages = append(pr.messages, message)
+	return nil
+}
+
+func TestDriveRun(t *testing.T) {
+	runPollInterval = time.Millisecond
+
+	f := &fakeRun{ready: true, runID: 7, finished: true}
+	pr := &phaseRecorder{}
+	assert.Nil(t, driveRun(f.steps(), false, time.Second, pr.onPhase))
+	assert.Equal(t, []string{model.PhaseDeploying, model.PhaseRunning}, pr.phases)
+	assert.Equal(t, []int64{0, 7}, pr.runIDs)
+	assert.Equal(t, 0, f.purged)
+
+	f = &fakeRun{ready: true, runID: 7, finished: true}
+	pr = &phaseRecorder{}
+	assert.Nil(t, driveRun(f.steps(), true, time.Second, pr.onPhase))
+	assert.Equal(t, []string{model.PhaseDeploying, model.PhaseRunning, model.PhasePurging}, pr.phases)
+	assert.Equal(t, 1, f.purged)
+
+	// the engines are purged when a step fails even without purgeAfter
+	failed := errors.New("failed")
+	f = &fakeRun{deployErr: failed}
+	pr = &phaseRecorder{}
+	assert.Equal(t, failed, driveRun(f.steps(), false, time.Second, pr.onPhase))
+	assert.Equal(t, []string{model.PhaseDeploying, model.PhasePurging}, pr.phases)
+	assert.Equal(t, 1, f.purged)
+}
+
+func TestDriveRunAlreadyDeployed(t *testing.T) {
+	runPollInterval = time.Millisecond
+
+	// the engines deployed by someone else are not purged by the run
+	deployErr := &model.DBError{Err: model.ErrLaunchInProgress, Message: "There is a launch in progress. Please either wait or purge."}
+	f := &fakeRun{deployErr: deployErr}
+	pr := &phaseRecorder{}
+	assert.ErrorIs(t, driveRun(f.steps(), true, time.Second, pr.onPhase), model.ErrLaunchInProgress)
+	assert.Equal(t, []string{model.PhaseDeploying}, pr.phases)
+	assert.Equal(t, 0, f.purged)
+}
+
+func TestDriveRunQueued(t *testing.T) {
+	runPollInterval = time.Millisecond
+
+	f := &fakeRun{queuedPolls: 3, ready: true, runID: 7, finished: true}
+	pr := &phaseRecorder{}
+	assert.Nil(t, driveRun(f.steps(), false, time.Second, pr.onPhase))
+	assert.Equal(t, []string{model.PhaseDeploying, model.PhaseQueued, model.PhaseDeploying, model.PhaseRunning}, pr.phases)
+	assert.Equal(t, 0, f.queuedPolls)
+
+	// the failure of the queued deployment fails the run
+	f = &fakeRun{queuedPolls: 3, queueErr: errors.New("Failed to deploy: no context")}
+	pr = &phaseRecorder{}
+	err := driveRun(f.steps(), false, time.Second, pr.onPhase)
+	assert.EqualError(t, err, "Failed to deploy: no context")
+	assert.Equal(t, []string{model.PhaseDeploying, model.PhaseQueued, model.PhasePurging}, pr.phases)
+	assert.Equal(t, 1, f.purged)
+}
+
+func TestDriveRunDeadline(t *testing.T) {
+	runPollInterval = time.Millisecond
+
+	// the time in the queue counts in the timeout
+	f := &fakeRun{queuedPolls: 1000}
+	pr := &phaseRecorder{}
+	err := driveRun(f.steps(), false, 20*time.Millisecond, pr.onPhase)
+	assert.ErrorIs(t, err, errWaitTimeout)
+	assert.Contains(t, err.Error(), "deployment is still queued")
+	assert.Equal(t, []string{model.PhaseDeploying, model.PhaseQueued, model.PhasePurging}, pr.phases)
+	assert.Equal(t, 1, f.purged)
+
+	f = &fakeRun{}
+	pr = &phaseRecorder{}
+	err = driveRun(f.steps(), false, 20*time.Millisecond, pr.onPhase)
+	assert.ErrorIs(t, err, errWaitTimeout)
+	assert.Contains(t, err.Error(), "engines are not ready")
+	assert.Equal(t, []string{model.PhaseDeploying, model.PhasePurging}, pr.phases)
+	assert.Equal(t, 1, f.purged)
+
+	f = &fakeRun{ready: true, runID: 7}
+	pr = &phaseRecorder{}
+	err = driveRun(f.steps(), false, 20*time.Millisecond, pr.onPhase)
+	assert.ErrorIs(t, err, errWaitTimeout)
+	assert.Contains(t, err.Error(), "run 7 did not finish")
+	assert.Equal(t, []string{model.PhaseDeploying, model.PhaseRunning, model.PhasePurging}, pr.phases)
+	assert.Equal(t, []int64{0, 7, 7}, pr.runIDs)
+	assert.Equal(t, 1, f.purged)
+}
+
+func TestDriveOneShot(t *testing.T) {
+	runPollInterval = time.Millisecond
+
+	f := &fakeRun{ready: true, runID: 7, finished: true}
+	pr := &phaseRecorder{}
+	assert.Nil(t, driveOneShot(f.steps(), true, time.Second, pr.setPhase))
+	assert.Equal(t, []string{model.PhaseDeploying, model.PhaseRunning, model.PhasePurging, model.PhaseFinished}, pr.phases)
+	assert.Equal(t, []int64{0, 7, 7, 7}, pr.runIDs)
+	assert.Equal(t, "", pr.messages[3])
+
+	f = &fakeRun{ready: true, runID: 7}
+	pr = &phaseRecorder{}
+	assert.NotNil(t, driveOneShot(f.steps(), false, 20*time.Millisecond, pr.setPhase))
+	assert.Equal(t, []string{model.PhaseDeploying, model.PhaseRunning, model.PhasePurging, model.PhaseFailed}, pr.phases)
+	assert.Equal(t, int64(7), pr.runIDs[3])
+	assert.Contains(t, pr.messages[3], "run 7 did not finish")
+}
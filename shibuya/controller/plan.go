@@ -7,6 +7,7 @@ import (
 	"github.com/rakutentech/shibuya/shibuya/config"
 	cdrclient "github.com/rakutentech/shibuya/shibuya/coordinator/client"
 	enginesModel "github.com/rakutentech/shibuya/shibuya/engines/model"
+	"github.com/rakutentech/shibuya/shibuya/engines/registry"
 	"github.com/rakutentech/shibuya/shibuya/model"
 	"github.com/rakutentech/shibuya/shibuya/object_storage"
 	"github.com/rakutentech/shibuya/shibuya/scheduler"
@@ -32,12 +33,24 @@ func NewPlanController(ep *model.ExecutionPlan, collection *model.Collection, sc
 	}
 }
 
+// makeEngineConfig returns the container of the engines of the plan in the cluster of the executor config
+func makeEngineConfig(ep *model.ExecutionPlan, ec *config.ExecutorConfig) (*config.ExecutorContainer, error) {
+	plan, err := model.GetPlan(ep.PlanID)
+	if err != nil {
+		return nil, err
+	}
+	engineConfig, err := registry.ContainerConfig(plan.Kind, ec)
+	if err != nil {
+		return nil, err
+	}
+	return ep.ExecutorOverrides.Apply(engineConfig), nil
+}
+
 func (pc *PlanController) deploy(serviceIP string) error {
-	plan, err := model.GetPlan(pc.ep.PlanID)
+	engineConfig, err := makeEngineConfig(pc.ep, pc.sc.ExecutorConfig)
 	if err != nil {
 		return err
 	}
-	engineConfig := pc.sc.ExecutorConfig.EnginesContainer[string(plan.Kind)]
 	if err := pc.scheduler.DeployPlan(pc.collection.ProjectID, pc.collection.ID, pc.ep.PlanID,
 		pc.ep.Engines, serviceIP, engineConfig); err != nil {
 		return err
@@ -129,6 +142,10 @@ func (pc *PlanController) progress(cdrclient *cdrclient.Client, ro cdrclient.Req
 	return false
 }
 
+func (pc *PlanController) scale(cdrclient *cdrclient.Client, ro cdrclient.ReqOpts, concurrency int) error {
+	return cdrclient.ScalePlan(ro, pc.collection.ID, pc.ep.PlanID, concurrency)
+}
+
 // TODO: what was the past around force?
 func (pc *PlanController) term(cdrclient *cdrclient.Client, ro cdrclient.ReqOpts) error {
 	ep := pc.ep
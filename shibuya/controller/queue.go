@@ -0,0 +1,330 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/rakutentech/shibuya/shibuya/config"
+	authtoken "github.com/rakutentech/shibuya/shibuya/http/auth/token"
+	"github.com/rakutentech/shibuya/shibuya/model"
+	smodel "github.com/rakutentech/shibuya/shibuya/scheduler/model"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// deployQueueInterval is also how long a cluster is considered busy after a deployment, as the
+// engines only take the capacity of the nodes once their pods are created
+var deployQueueInterval = 15 * time.Second
+
+// engineDemand is what an engine requests from a node, in millicores and bytes
+type engineDemand struct {
+	cpu          int64
+	mem          int64
+	nodeSelector map[string]string
+}
+
+// makeEngineDemands returns the demands of all the engines of the plans, the biggest first
+func makeEngineDemands(eps []*model.ExecutionPlan, ec *config.ExecutorConfig) ([]engineDemand, error) {
+	demands := []engineDemand{}
+	for _, ep := range eps {
+		engineConfig, err := makeEngineConfig(ep, ec)
+		if err != nil {
+			return nil, err
+		}
+		cpu, err := resource.ParseQuantity(engineConfig.CPU)
+		if err != nil {
+			return nil, err
+		}
+		mem, err := resource.ParseQuantity(engineConfig.Mem)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < ep.Engines; i++ {
+			demands = append(demands, engineDemand{cpu.MilliValue(), mem.Value(), engineConfig.NodeSelector})
+		}
+	}
+	sort.SliceStable(demands, func(i, j int) bool {
+		if demands[i].cpu != demands[j].cpu {
+			return demands[i].cpu > demands[j].cpu
+		}
+		return demands[i].mem > demands[j].mem
+	})
+	return demands, nil
+}
+
+func matchLabels(labels, selector map[string]string) bool {
+	for k, v := range selector {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// fitEngines places every engine on the first node with enough resources and tells whether all the
+// engines fit. The free resources of the nodes are used unless empty is set.
+func fitEngines(nodes []*smodel.NodeCapacity, demands []engineDemand, empty bool) bool {
+	cpu := make([]int64, len(nodes))
+	mem := make([]int64, len(nodes))
+	for i, n := range nodes {
+		cpu[i], mem[i] = n.FreeCPU, n.FreeMem
+		if empty {
+			cpu[i], mem[i] = n.CPU, n.Mem
+		}
+	}
+	for _, d := range demands {
+		placed := false
+		for i, n := range nodes {
+			if cpu[i] >= d.cpu && mem[i] >= d.mem && matchLabels(n.Labels, d.nodeSelector) {
+				cpu[i] -= d.cpu
+				mem[i] -= d.mem
+				placed = true
+				break
+			}
+		}
+		if !placed {
+			return false
+		}
+	}
+	return true
+}
+
+// checkCapacity returns the clusters of the collection with a limited capacity, and why the engines
+// cannot be deployed there now. The reason is empty when the engines fit in the free capacity. An
+// error is returned when the engines would not fit even in the empty nodes.
+// It needs to be called with the queue lock.
+func (c *Controller) checkCapacity(groups []*clusterPlans) ([]string, string, error) {
+	limited := []string{}
+	reason := ""
+	for _, g := range groups {
+		cc, err := g.scheduler.GetCapacity()
+		if err != nil {
+			return nil, "", err
+		}
+		if cc == nil {
+			continue
+		}
+		limited = append(limited, g.context)
+		demands, err := makeEngineDemands(g.eps, g.sc.ExecutorConfig)
+		if err != nil {
+			return nil, "", err
+		}
+		if !fitEngines(cc.Nodes, demands, true) {
+			return nil, "", makeCapacityExceededError(g.context)
+		}
+		if reason != "" {
+			continue
+		}
+		switch {
+		case time.Since(c.lastAdmissions[g.context]) < deployQueueInterval:
+			reason = fmt.Sprintf("The engines of another collection are being deployed in cluster %s", g.context)
+		case cc.PendingPods > 0:
+			reason = fmt.Sprintf("%d engines are waiting for nodes in cluster %s", cc.PendingPods, g.context)
+		case !fitEngines(cc.Nodes, demands, false):
+			reason = fmt.Sprintf("The engines do not fit in the free capacity of cluster %s", g.context)
+		}
+	}
+	return limited, reason, nil
+}
+
+func (c *Controller) markAdmitted(contexts []string) {
+	if c.lastAdmissions == nil {
+		c.lastAdmissions = make(map[string]time.Time)
+	}
+	for _, context := range contexts {
+		c.lastAdmissions[context] = time.Now()
+	}
+}
+
+// deployPriority is the priority of the queued deployments of the project
+func (c *Controller) deployPriority(project *model.Project) int {
+	if c.sc.Quotas == nil {
+		return 0
+	}
+	quota, _ := c.sc.Quotas.ForProject(project.ID, project.Owner)
+	if quota == nil {
+		return 0
+	}
+	return quota.Priority
+}
+
+// RequestDeployment deploys the collection when its engines fit in the free capacity of its clusters
+// and no deployment is queued before it. Otherwise the deployment is queued and returned, and the
+// collection is deployed for createdBy once it's admitted by the deploy queue.
+func (c *Controller) RequestDeployment(collection *model.Collection, apiToken, createdBy string) (*model.QueuedDeployment, error) {
+	if qd, err := collection.GetQueuedDeployment(); err == nil {
+		if !qd.Failed {
+			return qd, nil
+		}
+		// the failure is only kept until the collection is deployed again
+		if err := collection.DequeueDeployment(); err != nil {
+			return nil, err
+		}
+	}
+	eps, err := collection.GetExecutionPlans()
+	if err != nil {
+		return nil, err
+	}
+	groups, err := c.groupByCluster(eps)
+	if err != nil {
+		return nil, err
+	}
+	// the engines of a deployed collection already take their capacity
+	for _, g := range groups {
+		deployed, err := g.scheduler.GetDeployedCollections()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := deployed[collection.ID]; ok {
+			return nil, c.DeployCollection(collection, apiToken)
+		}
+	}
+	c.queueMu.Lock()
+	defer c.queueMu.Unlock()
+	limited, reason, err := c.checkCapacity(groups)
+	if err != nil {
+		return nil, err
+	}
+	if len(limited) > 0 && reason == "" {
+		queue, err := model.GetQueuedDeployments()
+		if err != nil {
+			return nil, err
+		}
+		if len(queue) > 0 {
+			reason = fmt.Sprintf("%d deployments are queued before", len(queue))
+		}
+	}
+	if reason == "" {
+		if err := c.DeployCollection(collection, apiToken); err != nil {
+			return nil, err
+		}
+		c.markAdmitted(limited)
+		return nil, nil
+	}
+	project, err := model.GetProject(collection.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+	return collection.QueueDeployment(createdBy, c.deployPriority(project), reason)
+}
+
+// RunDeployQueue admits the queued deployments as the capacity of the clusters is freed
+func (c *Controller) RunDeployQueue() {
+	log.Info("Start the loop for admitting the queued deployments")
+	for {
+		if err := c.admitQueuedDeployments(); err != nil {
+			log.Error(err)
+		}
+		time.Sleep(deployQueueInterval)
+	}
+}
+
+// admitQueuedDeployments deploys the queued collections in the order of the queue. A deployment
+// waiting for a cluster makes the ones after it wait for the cluster too, so the big collections
+// are not starved by the small ones.
+func (c *Controller) admitQueuedDeployments() error {
+	c.queueMu.Lock()
+	defer c.queueMu.Unlock()
+	queue, err := model.GetQueuedDeployments()
+	if err != nil {
+		return err
+	}
+	waiting := make(map[string]bool)
+	for _, qd := range queue {
+		collection, err := model.GetCollection(qd.CollectionID)
+		if err != nil {
+			log.Errorf("Queued collection %d cannot be found: %v", qd.CollectionID, err)
+			gone := &model.Collection{ID: qd.CollectionID}
+			if err := gone.DequeueDeployment(); err != nil {
+				log.Error(err)
+			}
+			continue
+		}
+		eps, err := collection.GetExecutionPlans()
+		if err != nil {
+			failQueued(qd, err)
+			continue
+		}
+		groups, err := c.groupByCluster(eps)
+		if err != nil {
+			failQueued(qd, err)
+			continue
+		}
+		limited, reason, err := c.checkCapacity(groups)
+		if err != nil {
+			if errors.Is(err, CapacityError) {
+				failQueued(qd, err)
+				continue
+			}
+			// the capacity could not be read this time, the deployment is checked again in the next pass
+			log.Errorf("Failed to check the capacity for the queued collection %d: %v", qd.CollectionID, err)
+			continue
+		}
+		for _, context := range limited {
+			if reason == "" && waiting[context] {
+				reason = fmt.Sprintf("Waiting for the deployments queued before in cluster %s", context)
+			}
+		}
+		if reason != "" {
+			for _, context := range limited {
+				waiting[context] = true
+			}
+			if reason != qd.Message {
+				if err := qd.SetMessage(reason); err != nil {
+					log.Error(err)
+				}
+			}
+			continue
+		}
+		// the deployment is taken out of the queue first, so a cancel made by the users in the
+		// meantime is not deployed
+		claimed, err := qd.Claim()
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		if !claimed {
+			log.Infof("Queued deployment of collection %d was cancelled", qd.CollectionID)
+			continue
+		}
+		if err := c.deployQueued(collection, qd); err != nil {
+			// the deployment stays queued until the quota of the project is freed
+			if errors.Is(err, QuotaError) {
+				if err := qd.Requeue(false, err.Error()); err != nil {
+					log.Error(err)
+				}
+				continue
+			}
+			log.Errorf("Failed to deploy the queued collection %d: %v", qd.CollectionID, err)
+			if err := qd.Requeue(true, fmt.Sprintf("Failed to deploy: %v", err)); err != nil {
+				log.Error(err)
+			}
+			continue
+		}
+		c.markAdmitted(limited)
+	}
+	return nil
+}
+
+// failQueued takes the deployment out of the queue and keeps the error for the users
+func failQueued(qd *model.QueuedDeployment, err error) {
+	log.Errorf("Failed to deploy the queued collection %d: %v", qd.CollectionID, err)
+	if err := qd.Fail(fmt.Sprintf("Failed to deploy: %v", err)); err != nil {
+		log.Error(err)
+	}
+}
+
+func (c *Controller) deployQueued(collection *model.Collection, qd *model.QueuedDeployment) error {
+	project, err := model.GetProject(collection.ProjectID)
+	if err != nil {
+		return err
+	}
+	token, err := authtoken.GenToken(qd.CreatedBy, []string{project.Owner}, MaxRunTimeout)
+	if err != nil {
+		return err
+	}
+	log.Infof("Queued collection %d is admitted after waiting for %s", collection.ID, time.Since(qd.CreatedTime))
+	return c.DeployCollection(collection, token)
+}
@@ -0,0 +1,39 @@
+package controller
+
+import (
+	"testing"
+
+	smodel "github.com/rakutentech/shibuya/shibuya/scheduler/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFitEngines(t *testing.T) {
+	gi := int64(1024 * 1024 * 1024)
+	nodes := []*smodel.NodeCapacity{
+		{Name: "node-1", CPU: 4000, Mem: 16 * gi, FreeCPU: 1000, FreeMem: 4 * gi},
+		{Name: "node-2", CPU: 4000, Mem: 16 * gi, FreeCPU: 3000, FreeMem: 4 * gi, Labels: map[string]string{"pool": "large"}},
+	}
+	engine := engineDemand{cpu: 1000, mem: 2 * gi}
+	engines := func(n int, d engineDemand) []engineDemand {
+		demands := make([]engineDemand, n)
+		for i := range demands {
+			demands[i] = d
+		}
+		return demands
+	}
+	// node-1 fits one engine and node-2 fits two, as the memory is short
+	assert.True(t, fitEngines(nodes, engines(3, engine), false))
+	assert.False(t, fitEngines(nodes, engines(4, engine), false))
+	assert.True(t, fitEngines(nodes, engines(8, engine), true))
+	assert.False(t, fitEngines(nodes, engines(9, engine), true))
+
+	// the node selector restricts the engines to the nodes with the labels
+	large := engineDemand{cpu: 1000, mem: 2 * gi, nodeSelector: map[string]string{"pool": "large"}}
+	assert.True(t, fitEngines(nodes, engines(2, large), false))
+	assert.False(t, fitEngines(nodes, engines(3, large), false))
+
+	// the nodes are not changed by the placement
+	assert.Equal(t, int64(1000), nodes[0].FreeCPU)
+	assert.True(t, fitEngines(nodes, nil, false))
+	assert.False(t, fitEngines(nil, engines(1, engine), true))
+}
@@ -0,0 +1,247 @@
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rakutentech/shibuya/shibuya/config"
+	"github.com/rakutentech/shibuya/shibuya/model"
+)
+
+// admissionGracePeriod is how long an admitted collection counts in the quotas before its engines
+// are reported by the schedulers, as the engines are deployed in the background
+var admissionGracePeriod = 5 * time.Minute
+
+// quotaScope is the projects sharing a quota
+type quotaScope struct {
+	// projectID is only set when the project has its own quota
+	projectID int64
+	owner     string
+	quotas    *config.QuotasConfig
+}
+
+func newQuotaScope(qc *config.QuotasConfig, project *model.Project) (*config.Quota, quotaScope) {
+	quota, own := qc.ForProject(project.ID, project.Owner)
+	scope := quotaScope{owner: project.Owner, quotas: qc}
+	if own {
+		scope.projectID = project.ID
+	}
+	return quota, scope
+}
+
+func (s quotaScope) contains(p *model.Project) bool {
+	if s.projectID != 0 {
+		return p.ID == s.projectID
+	}
+	if _, own := s.quotas.Projects[p.ID]; own {
+		return false
+	}
+	return p.Owner == s.owner
+}
+
+func (s quotaScope) String() string {
+	if s.projectID != 0 {
+		return fmt.Sprintf("project %d", s.projectID)
+	}
+	return fmt.Sprintf("owner %s", s.owner)
+}
+
+type quotaUsage struct {
+	engines int
+	vu      int
+	vuh     float64
+}
+
+// makeQuotaUsage is what the plans use. scaled are the concurrencies of the plans scaled during
+// the run, by plan id.
+func makeQuotaUsage(eps []*model.ExecutionPlan, scaled map[int64]int) quotaUsage {
+	u := quotaUsage{}
+	for _, ep := range eps {
+		concurrency := ep.Concurrency
+		if c, ok := scaled[ep.PlanID]; ok {
+			concurrency = c
+		}
+		u.engines += ep.Engines
+		u.vu += ep.Engines * concurrency
+	}
+	return u
+}
+
+// scaledConcurrencies returns the concurrencies of the running plans of the collection which are
+// scaled during the run
+func scaledConcurrencies(collectionID int64) (map[int64]int, error) {
+	rps, err := model.GetRunningPlansByCollection(collectionID)
+	if err != nil {
+		return nil, err
+	}
+	scaled := make(map[int64]int)
+	for _, rp := range rps {
+		if rp.Concurrency > 0 {
+			scaled[rp.PlanID] = rp.Concurrency
+		}
+	}
+	return scaled, nil
+}
+
+// checkQuota tells whether the requested engines fit in the quota with what the scope already uses
+func checkQuota(quota *config.Quota, scope quotaScope, used, requested quotaUsage) error {
+	if quota.Engines > 0 && used.engines+requested.engines > quota.Engines {
+		return makeQuotaExceededError(scope.String(), fmt.Sprintf("%d engines can be deployed at the same time. %d are deployed and the collection needs %d",
+			quota.Engines, used.engines, requested.engines))
+	}
+	if quota.VU > 0 && used.vu+requested.vu > quota.VU {
+		return makeQuotaExceededError(scope.String(), fmt.Sprintf("%d vu can be deployed at the same time. %d are deployed and the collection needs %d",
+			quota.VU, used.vu, requested.vu))
+	}
+	if quota.VUHPerMonth > 0 && used.vuh >= quota.VUHPerMonth {
+		return makeQuotaExceededError(scope.String(), fmt.Sprintf("%.0f of the %.0f vu-hours of the month are used",
+			used.vuh, quota.VUHPerMonth))
+	}
+	return nil
+}
+
+// admitCollection rejects the deployment of the collection when it does not fit in the quota of its
+// project. The quota is checked against the collections deployed in all the clusters and the ones
+// admitted recently, whose engines could still be being deployed.
+func (c *Controller) admitCollection(collection *model.Collection, eps []*model.ExecutionPlan) error {
+	if c.sc.Quotas == nil {
+		return nil
+	}
+	project, err := model.GetProject(collection.ProjectID)
+	if err != nil {
+		return err
+	}
+	quota, scope := newQuotaScope(c.sc.Quotas, project)
+	if quota == nil {
+		return nil
+	}
+	// the check and the admission are done together so two collections cannot both take the
+	// remaining capacity
+	c.admissionMu.Lock()
+	defer c.admissionMu.Unlock()
+	used, err := c.quotaUsage(quota, scope, collection.ID)
+	if err != nil {
+		return err
+	}
+	if err := checkQuota(quota, scope, used, makeQuotaUsage(eps, nil)); err != nil {
+		return err
+	}
+	if c.admitted == nil {
+		c.admitted = make(map[int64]time.Time)
+	}
+	c.admitted[collection.ID] = time.Now()
+	return nil
+}
+
+// admitScale rejects scaling the running plan of the collection to the concurrency when the
+// collection would not fit in the quota of its project anymore. Otherwise the concurrency is
+// recorded so it's counted in the quotas until the end of the run, and the previous one is returned
+// to be restored if the engines cannot be scaled.
+func (c *Controller) admitScale(collection *model.Collection, planID int64, concurrency int) (int, error) {
+	scaled, err := scaledConcurrencies(collection.ID)
+	if err != nil {
+		return 0, err
+	}
+	previous := scaled[planID]
+	if c.sc.Quotas != nil {
+		project, err := model.GetProject(collection.ProjectID)
+		if err != nil {
+			return 0, err
+		}
+		quota, scope := newQuotaScope(c.sc.Quotas, project)
+		if quota != nil {
+			c.admissionMu.Lock()
+			defer c.admissionMu.Unlock()
+			used, err := c.quotaUsage(quota, scope, collection.ID)
+			if err != nil {
+				return 0, err
+			}
+			eps, err := collection.GetExecutionPlans()
+			if err != nil {
+				return 0, err
+			}
+			scaled[planID] = concurrency
+			if err := checkQuota(quota, scope, used, makeQuotaUsage(eps, scaled)); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return previous, model.SetRunningPlanConcurrency(collection.ID, planID, concurrency)
+}
+
+// forgetAdmission stops counting the collection in the quotas until its engines are reported
+func (c *Controller) forgetAdmission(collectionID int64) {
+	c.admissionMu.Lock()
+	defer c.admissionMu.Unlock()
+	delete(c.admitted, collectionID)
+}
+
+// quotaUsage is what the other collections of the scope use. It needs to be called with the
+// admission lock.
+func (c *Controller) quotaUsage(quota *config.Quota, scope quotaScope, collectionID int64) (quotaUsage, error) {
+	used := quotaUsage{}
+	deployed := make(map[int64]struct{})
+	for _, cl := range c.clusters {
+		collections, err := cl.scheduler.GetDeployedCollections()
+		if err != nil {
+			return used, err
+		}
+		for cid := range collections {
+			deployed[cid] = struct{}{}
+		}
+	}
+	now := time.Now()
+	for cid, admittedAt := range c.admitted {
+		if now.Sub(admittedAt) > admissionGracePeriod {
+			delete(c.admitted, cid)
+			continue
+		}
+		deployed[cid] = struct{}{}
+	}
+	// a redeployment does not count the engines of the collection twice
+	delete(deployed, collectionID)
+
+	projects := make(map[int64]*model.Project)
+	inScope := func(projectID int64) bool {
+		p, ok := projects[projectID]
+		if !ok {
+			var err error
+			if p, err = model.GetProject(projectID); err != nil {
+				p = nil
+			}
+			projects[projectID] = p
+		}
+		return p != nil && scope.contains(p)
+	}
+	for cid := range deployed {
+		// the collection could have been deleted before its engines are purged
+		collection, err := model.GetCollection(cid)
+		if err != nil || !inScope(collection.ProjectID) {
+			continue
+		}
+		eps, err := collection.GetExecutionPlans()
+		if err != nil {
+			return used, err
+		}
+		scaled, err := scaledConcurrencies(cid)
+		if err != nil {
+			return used, err
+		}
+		u := makeQuotaUsage(eps, scaled)
+		used.engines += u.engines
+		used.vu += u.vu
+	}
+	if quota.VUHPerMonth > 0 {
+		monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+		vuhByProject, err := model.GetVUHByProject(monthStart.Format(model.MySQLFormat), now.Format(model.MySQLFormat))
+		if err != nil {
+			return used, err
+		}
+		for projectID, vuh := range vuhByProject {
+			if inScope(projectID) {
+				used.vuh += vuh
+			}
+		}
+	}
+	return used, nil
+}
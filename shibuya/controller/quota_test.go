@@ -0,0 +1,60 @@
+package controller
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/rakutentech/shibuya/shibuya/config"
+	"github.com/rakutentech/shibuya/shibuya/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuotaScope(t *testing.T) {
+	qc := &config.QuotasConfig{
+		Default:  &config.Quota{Engines: 10},
+		Owners:   map[string]*config.Quota{"perf": {Engines: 300}},
+		Projects: map[int64]*config.Quota{3: {Engines: 20}},
+	}
+	// the projects of an owner share its quota, except the ones with their own quota
+	quota, scope := newQuotaScope(qc, &model.Project{ID: 1, Owner: "perf"})
+	assert.Equal(t, 300, quota.Engines)
+	assert.Equal(t, "owner perf", scope.String())
+	assert.True(t, scope.contains(&model.Project{ID: 2, Owner: "perf"}))
+	assert.False(t, scope.contains(&model.Project{ID: 3, Owner: "perf"}))
+	assert.False(t, scope.contains(&model.Project{ID: 4, Owner: "web"}))
+
+	quota, scope = newQuotaScope(qc, &model.Project{ID: 3, Owner: "perf"})
+	assert.Equal(t, 20, quota.Engines)
+	assert.Equal(t, "project 3", scope.String())
+	assert.True(t, scope.contains(&model.Project{ID: 3, Owner: "perf"}))
+	assert.False(t, scope.contains(&model.Project{ID: 1, Owner: "perf"}))
+
+	quota, _ = newQuotaScope(qc, &model.Project{ID: 4, Owner: "web"})
+	assert.Equal(t, 10, quota.Engines)
+	quota, _ = newQuotaScope(&config.QuotasConfig{}, &model.Project{ID: 4, Owner: "web"})
+	assert.Nil(t, quota)
+}
+
+func TestCheckQuota(t *testing.T) {
+	scope := quotaScope{owner: "perf"}
+	eps := []*model.ExecutionPlan{{PlanID: 1, Engines: 2, Concurrency: 100}, {PlanID: 2, Engines: 3, Concurrency: 50}}
+	requested := makeQuotaUsage(eps, nil)
+	assert.Equal(t, quotaUsage{engines: 5, vu: 350}, requested)
+	// the plans scaled during the run count with their new concurrency
+	assert.Equal(t, quotaUsage{engines: 5, vu: 500}, makeQuotaUsage(eps, map[int64]int{2: 100}))
+
+	quota := &config.Quota{Engines: 10, VU: 1000, VUHPerMonth: 500}
+	assert.Nil(t, checkQuota(quota, scope, quotaUsage{engines: 5, vu: 650, vuh: 499}, requested))
+
+	err := checkQuota(quota, scope, quotaUsage{engines: 6}, requested)
+	assert.True(t, errors.Is(err, QuotaError))
+	assert.Contains(t, err.Error(), "owner perf")
+	assert.Contains(t, err.Error(), "10 engines")
+	err = checkQuota(quota, scope, quotaUsage{vu: 651}, requested)
+	assert.Contains(t, err.Error(), "1000 vu")
+	err = checkQuota(quota, scope, quotaUsage{vuh: 500}, requested)
+	assert.Contains(t, err.Error(), "vu-hours")
+
+	// the zero values are no limit
+	assert.Nil(t, checkQuota(&config.Quota{}, scope, quotaUsage{engines: 1000, vu: 100000, vuh: 1e6}, requested))
+}
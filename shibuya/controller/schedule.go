@@ -0,0 +1,89 @@
+package controller
+
+import (
+	"time"
+
+	authtoken "github.com/rakutentech/shibuya/shibuya/http/auth/token"
+	"github.com/rakutentech/shibuya/shibuya/model"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	scheduleCheckInterval = 30 * time.Second
+)
+
+// RunSchedules starts the due schedules. A schedule is claimed before it's started so in distributed
+// mode, every fire of a schedule is only run by one controller.
+func (c *Controller) RunSchedules() {
+	log.Info("Start the loop for running collection schedules")
+	for {
+		schedules, err := model.GetDueSchedules(time.Now())
+		if err != nil {
+			log.Error(err)
+		}
+		for _, s := range schedules {
+			claimed, err := s.Claim(time.Now())
+			if err != nil {
+				log.Error(err)
+				continue
+			}
+			if !claimed {
+				continue
+			}
+			go c.runSchedule(s)
+		}
+		time.Sleep(scheduleCheckInterval)
+	}
+}
+
+func (c *Controller) runSchedule(s *model.Schedule) {
+	sr, err := s.NewRun()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	log.Infof("Schedule %d starts running collection %d", s.ID, s.CollectionID)
+	status, message := model.ScheduleRunFinished, ""
+	if err := c.executeSchedule(s, sr); err != nil {
+		log.Errorf("Schedule %d failed to run collection %d: %v", s.ID, s.CollectionID, err)
+		status, message = model.ScheduleRunFailed, err.Error()
+	}
+	if err := sr.Finish(status, message); err != nil {
+		log.Error(err)
+	}
+}
+
+// executeSchedule runs the collection from the deployment to the purge
+func (c *Controller) executeSchedule(s *model.Schedule, sr *model.ScheduleRun) error {
+	collection, err := model.GetCollection(s.CollectionID)
+	if err != nil {
+		return err
+	}
+	if err := c.CheckRunnable(collection); err != nil {
+		return err
+	}
+	project, err := model.GetProject(collection.ProjectID)
+	if err != nil {
+		return err
+	}
+	token, err := authtoken.GenToken(s.CreatedBy, []string{project.Owner}, MaxRunTimeout)
+	if err != nil {
+		return err
+	}
+	return driveRun(c.makeRunSteps(collection, token, s.CreatedBy), true, MaxRunTimeout, onScheduleRunPhase(sr.SetRunID))
+}
+
+// onScheduleRunPhase records the run in the schedule run once the collection is triggered
+func onScheduleRunPhase(setRunID func(runID int64) error) func(phase string, runID int64) {
+	recorded := false
+	return func(phase string, runID int64) {
+		if runID == 0 || recorded {
+			return
+		}
+		if err := setRunID(runID); err != nil {
+			log.Error(err)
+			return
+		}
+		recorded = true
+	}
+}
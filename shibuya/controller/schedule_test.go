@@ -0,0 +1,35 @@
+package controller
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/rakutentech/shibuya/shibuya/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOnScheduleRunPhase(t *testing.T) {
+	recorded := []int64{}
+	onPhase := onScheduleRunPhase(func(runID int64) error {
+		recorded = append(recorded, runID)
+		return nil
+	})
+	onPhase(model.PhaseDeploying, 0)
+	onPhase(model.PhaseRunning, 3)
+	onPhase(model.PhasePurging, 3)
+	assert.Equal(t, []int64{3}, recorded)
+
+	// the run is recorded again with the next phase when it failed
+	calls := 0
+	onPhase = onScheduleRunPhase(func(runID int64) error {
+		calls++
+		if calls == 1 {
+			return errors.New("failed")
+		}
+		return nil
+	})
+	onPhase(model.PhaseRunning, 3)
+	onPhase(model.PhasePurging, 3)
+	onPhase(model.PhasePurging, 3)
+	assert.Equal(t, 2, calls)
+}
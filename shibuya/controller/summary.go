@@ -0,0 +1,403 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	enginesModel "github.com/rakutentech/shibuya/shibuya/engines/model"
+	"github.com/rakutentech/shibuya/shibuya/engines/registry"
+	"github.com/rakutentech/shibuya/shibuya/model"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	runFinishCheckInterval = 10 * time.Second
+	// listenerBufferSize is how many metrics a slow api client can lag behind before its metrics
+	// are dropped, so it cannot hold back the recording of the run
+	listenerBufferSize = 1000
+)
+
+type latencyStats struct {
+	count    int64
+	errors   int64
+	statuses map[string]int64
+	min      float64
+	max      float64
+	sum      float64
+	// Latencies are rounded to milliseconds. The number of distinct values is bounded by the
+	// slowest request so the memory does not grow with the number of requests.
+	buckets map[int64]int64
+	first   time.Time
+	last    time.Time
+}
+
+func newLatencyStats() *latencyStats {
+	return &latencyStats{
+		statuses: make(map[string]int64),
+		buckets:  make(map[int64]int64),
+	}
+}
+
+// Same as the dashboards, only 2xx responses are considered as successful.
+func isErrorStatus(status string) bool {
+	return !strings.HasPrefix(status, "2")
+}
+
+func (ls *latencyStats) add(metric enginesModel.ShibuyaMetric, t time.Time) {
+	if ls.count == 0 || metric.Latency < ls.min {
+		ls.min = metric.Latency
+	}
+	if metric.Latency > ls.max {
+		ls.max = metric.Latency
+	}
+	if ls.first.IsZero() {
+		ls.first = t
+	}
+	ls.last = t
+	ls.count++
+	ls.sum += metric.Latency
+	ls.statuses[metric.Status]++
+	if isErrorStatus(metric.Status) {
+		ls.errors++
+	}
+	ls.buckets[int64(metric.Latency+0.5)]++
+}
+
+func (ls *latencyStats) percentiles(ps ...float64) []float64 {
+	keys := make([]int64, 0, len(ls.buckets))
+	for k := range ls.buckets {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i] < keys[j]
+	})
+	r := make([]float64, len(ps))
+	for i, p := range ps {
+		rank := int64(p*float64(ls.count) + 0.5)
+		if rank < 1 {
+			rank = 1
+		}
+		seen := int64(0)
+		for _, k := range keys {
+			seen += ls.buckets[k]
+			if seen >= rank {
+				r[i] = float64(k)
+				break
+			}
+		}
+	}
+	return r
+}
+
+func (ls *latencyStats) toSummary(planID int64, label string) *model.RunSummary {
+	s := &model.RunSummary{
+		PlanID:       planID,
+		Label:        label,
+		RequestCount: ls.count,
+		ErrorCount:   ls.errors,
+		StatusCounts: ls.statuses,
+		MinLatency:   ls.min,
+		MaxLatency:   ls.max,
+	}
+	if ls.count == 0 {
+		return s
+	}
+	s.MeanLatency = ls.sum / float64(ls.count)
+	p := ls.percentiles(0.5, 0.9, 0.95, 0.99)
+	s.P50Latency, s.P90Latency, s.P95Latency, s.P99Latency = p[0], p[1], p[2], p[3]
+	// samples within the same second are counted as one second
+	elapsed := ls.last.Sub(ls.first).Seconds()
+	if elapsed < 1 {
+		elapsed = 1
+	}
+	s.Throughput = float64(ls.count) / elapsed
+	return s
+}
+
+// runSummaryBuilder aggregates the metrics of a run at three levels: the run, the plans and the
+// labels within the plans.
+type runSummaryBuilder struct {
+	mu           sync.Mutex
+	collectionID int64
+	run          *latencyStats
+	plans        map[int64]*latencyStats
+	labels       map[int64]map[string]*latencyStats
+}
+
+func newRunSummaryBuilder(collectionID int64) *runSummaryBuilder {
+	return &runSummaryBuilder{
+		collectionID: collectionID,
+		run:          newLatencyStats(),
+		plans:        make(map[int64]*latencyStats),
+		labels:       make(map[int64]map[string]*latencyStats),
+	}
+}
+
+func (b *runSummaryBuilder) add(planID int64, metric enginesModel.ShibuyaMetric, t time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.run.add(metric, t)
+	ps, ok := b.plans[planID]
+	if !ok {
+		ps = newLatencyStats()
+		b.plans[planID] = ps
+		b.labels[planID] = make(map[string]*latencyStats)
+	}
+	ps.add(metric, t)
+	ls, ok := b.labels[planID][metric.Label]
+	if !ok {
+		ls = newLatencyStats()
+		b.labels[planID][metric.Label] = ls
+	}
+	ls.add(metric, t)
+}
+
+func (b *runSummaryBuilder) summaries() []*model.RunSummary {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	r := []*model.RunSummary{b.run.toSummary(0, "")}
+	for planID, ps := range b.plans {
+		r = append(r, ps.toSummary(planID, ""))
+		for label, ls := range b.labels[planID] {
+			// the empty label is reserved for the plan summary
+			if label == "" {
+				continue
+			}
+			r = append(r, ls.toSummary(planID, label))
+		}
+	}
+	for _, s := range r {
+		s.CollectionID = b.collectionID
+	}
+	return r
+}
+
+// runRecorder subscribes to all the engines of a run regardless of whether there are any UI clients.
+// The UI clients of the collection listen to the recorder instead of subscribing to the engines again.
+// While the run is in progress, it aborts the run when one of the guards is breached.
+// When the run finishes, it stores the summary and evaluates the criteria of the collection.
+type runRecorder struct {
+	collection  *model.Collection
+	runID       int64
+	builder     *runSummaryBuilder
+	guards      *runGuards
+	engines     []*Engine
+	wg          sync.WaitGroup
+	done        chan struct{}
+	once        sync.Once
+	listenersMu sync.Mutex
+	listeners   map[string]chan *shibuyaMetric
+}
+
+func (c *Controller) recordRun(collection *model.Collection, plans []*model.Plan, runID int64) {
+	rr := &runRecorder{
+		collection: collection,
+		runID:      runID,
+		builder:    newRunSummaryBuilder(collection.ID),
+		done:       make(chan struct{}),
+		listeners:  make(map[string]chan *shibuyaMetric),
+	}
+	if guards, err := collection.GetGuards(); err != nil {
+		log.Error(err)
+	} else if len(guards) > 0 {
+		if rr.guards, err = newRunGuards(guards); err != nil {
+			log.Error(err)
+		}
+	}
+	kinds := make(map[int64]model.PlanKind, len(plans))
+	for _, p := range plans {
+		kinds[p.ID] = p.Kind
+	}
+	for _, ep := range collection.ExecutionPlans {
+		kind, ok := kinds[ep.PlanID]
+		if !ok {
+			log.Errorf("Plan %d is not triggered in run %d", ep.PlanID, runID)
+			continue
+		}
+		engine, err := registry.Get(kind)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		pc, err := c.newPlanController(ep, collection)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		engines, err := pc.subscribe()
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		for _, e := range engines {
+			rr.engines = append(rr.engines, e)
+			rr.wg.Add(1)
+			go rr.read(e, engine.MetricParser())
+		}
+	}
+	c.runRecorders.Store(collection.ID, rr)
+	// In distributed mode, the run could be finished by another controller so we also watch the
+	// run history.
+	ticker := time.NewTicker(runFinishCheckInterval)
+	defer ticker.Stop()
+	var guardTicker <-chan time.Time
+	if rr.guards != nil {
+		gt := time.NewTicker(guardCheckInterval)
+		defer gt.Stop()
+		guardTicker = gt.C
+	}
+	for {
+		select {
+		case <-rr.done:
+			return
+		case <-ticker.C:
+			if rh, err := model.GetRun(runID); err == nil && !rh.EndTime.IsZero() {
+				c.stopRecording(collection.ID, runID)
+			}
+		case now := <-guardTicker:
+			reason, breached := rr.guards.check(now)
+			if !breached {
+				continue
+			}
+			log.Infof("Aborting run %d in collection %d, %s", runID, collection.ID, reason)
+			if err := collection.AbortRun(runID, reason); err != nil {
+				log.Error(err)
+			}
+			// the guards are only triggered once per run
+			guardTicker = nil
+			// termination stops this recorder so it cannot be waited here
+			go func() {
+				if err := c.TermCollection(collection, false); err != nil {
+					log.Error(err)
+				}
+			}()
+		}
+	}
+}
+
+func (rr *runRecorder) read(e *Engine, parser func(string) (enginesModel.ShibuyaMetric, error)) {
+	defer rr.wg.Done()
+	// drain the channel until the stream is closed, otherwise the reading goroutine would leak
+	for m := range e.readMetrics() {
+		rr.broadcast(m)
+		metric, err := parser(m.raw)
+		if err != nil {
+			continue
+		}
+		now := time.Now()
+		rr.builder.add(e.planID, metric, now)
+		if rr.guards != nil {
+			rr.guards.add(e.planID, metric, now)
+		}
+	}
+}
+
+func (rr *runRecorder) stop() error {
+	var err error
+	rr.once.Do(func() {
+		close(rr.done)
+		for _, e := range rr.engines {
+			e.closeStream()
+		}
+		rr.wg.Wait()
+		rr.listenersMu.Lock()
+		for clientID, ch := range rr.listeners {
+			close(ch)
+			delete(rr.listeners, clientID)
+		}
+		rr.listenersMu.Unlock()
+		summaries := rr.builder.summaries()
+		// the verdict is given from the summaries in memory so it does not depend on storing them
+		var storeErr, evalErr error
+		if e := model.StoreRunSummaries(rr.runID, summaries); e != nil {
+			storeErr = fmt.Errorf("failed to store the summary of run %d: %w", rr.runID, e)
+		}
+		if e := rr.evaluate(summaries); e != nil {
+			evalErr = fmt.Errorf("failed to give a verdict to run %d: %w", rr.runID, e)
+		}
+		err = errors.Join(storeErr, evalErr)
+	})
+	return err
+}
+
+// listen returns the metrics of the run for an api client. The channel is closed when the recording
+// stops or the client stops listening. It returns false when the recording is already stopped.
+func (rr *runRecorder) listen(clientID string) (chan *shibuyaMetric, bool) {
+	rr.listenersMu.Lock()
+	defer rr.listenersMu.Unlock()
+	select {
+	case <-rr.done:
+		return nil, false
+	default:
+	}
+	ch := make(chan *shibuyaMetric, listenerBufferSize)
+	rr.listeners[clientID] = ch
+	return ch, true
+}
+
+func (rr *runRecorder) unlisten(clientID string) {
+	rr.listenersMu.Lock()
+	defer rr.listenersMu.Unlock()
+	if ch, ok := rr.listeners[clientID]; ok {
+		close(ch)
+		delete(rr.listeners, clientID)
+	}
+}
+
+func (rr *runRecorder) broadcast(m *shibuyaMetric) {
+	rr.listenersMu.Lock()
+	defer rr.listenersMu.Unlock()
+	for _, ch := range rr.listeners {
+		select {
+		case ch <- m:
+		default:
+		}
+	}
+}
+
+// evaluate gives the run a verdict when the collection has criteria
+func (rr *runRecorder) evaluate(summaries []*model.RunSummary) error {
+	criteria, err := rr.collection.GetCriteria()
+	if err != nil {
+		return err
+	}
+	// the criteria could have been removed during the run
+	if len(criteria) == 0 {
+		return rr.collection.StoreRunWithoutCriteria(rr.runID)
+	}
+	verdict, results := model.EvaluateCriteria(criteria, summaries)
+	log.Infof("Run %d in collection %d is %s", rr.runID, rr.collection.ID, verdict)
+	return rr.collection.StoreRunVerdict(rr.runID, verdict, results)
+}
+
+func (c *Controller) stopRecording(collectionID, runID int64) {
+	t, ok := c.runRecorders.Load(collectionID)
+	if !ok {
+		return
+	}
+	rr := t.(*runRecorder)
+	if rr.runID != runID {
+		return
+	}
+	c.runRecorders.Delete(collectionID)
+	if err := rr.stop(); err != nil {
+		log.Error(err)
+		return
+	}
+	log.Infof("Summary of run %d in collection %d is stored", runID, collectionID)
+}
+
+// finishRun marks the run as finished and stores its summary if the run is recorded by this controller.
+func (c *Controller) finishRun(collection *model.Collection, runID int64) error {
+	if err := collection.RunFinish(runID); err != nil {
+		return err
+	}
+	c.stopRecording(collection.ID, runID)
+	return nil
+}
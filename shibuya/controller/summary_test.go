@@ -0,0 +1,87 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	enginesModel "github.com/rakutentech/shibuya/shibuya/engines/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLatencyStats(t *testing.T) {
+	ls := newLatencyStats()
+	start := time.Now()
+	for i := 1; i <= 100; i++ {
+		status := "200"
+		if i%10 == 0 {
+			status = "500"
+		}
+		ls.add(enginesModel.ShibuyaMetric{Latency: float64(i), Status: status}, start.Add(time.Duration(i)*100*time.Millisecond))
+	}
+	s := ls.toSummary(1, "")
+	assert.Equal(t, int64(100), s.RequestCount)
+	assert.Equal(t, int64(10), s.ErrorCount)
+	assert.Equal(t, int64(90), s.StatusCounts["200"])
+	assert.Equal(t, float64(1), s.MinLatency)
+	assert.Equal(t, float64(100), s.MaxLatency)
+	assert.Equal(t, 50.5, s.MeanLatency)
+	assert.Equal(t, float64(50), s.P50Latency)
+	assert.Equal(t, float64(90), s.P90Latency)
+	assert.Equal(t, float64(95), s.P95Latency)
+	assert.Equal(t, float64(99), s.P99Latency)
+	assert.InDelta(t, 100/9.9, s.Throughput, 0.01)
+
+	empty := newLatencyStats().toSummary(1, "")
+	assert.Equal(t, int64(0), empty.RequestCount)
+	assert.Equal(t, float64(0), empty.Throughput)
+}
+
+func TestRunSummaryBuilder(t *testing.T) {
+	b := newRunSummaryBuilder(1)
+	now := time.Now()
+	b.add(1, enginesModel.ShibuyaMetric{Label: "a", Latency: 10, Status: "200"}, now)
+	b.add(1, enginesModel.ShibuyaMetric{Label: "b", Latency: 20, Status: "404"}, now)
+	b.add(2, enginesModel.ShibuyaMetric{Label: "a", Latency: 30, Status: "200"}, now)
+	summaries := b.summaries()
+	// run + 2 plans + 3 labels
+	assert.Equal(t, 6, len(summaries))
+	run := summaries[0]
+	assert.Equal(t, int64(0), run.PlanID)
+	assert.Equal(t, int64(3), run.RequestCount)
+	assert.Equal(t, int64(1), run.ErrorCount)
+	for _, s := range summaries {
+		assert.Equal(t, int64(1), s.CollectionID)
+		if s.PlanID == 1 && s.Label == "" {
+			assert.Equal(t, int64(2), s.RequestCount)
+			assert.Equal(t, float64(15), s.MeanLatency)
+		}
+	}
+}
+
+func TestRunRecorderListeners(t *testing.T) {
+	rr := &runRecorder{
+		done:      make(chan struct{}),
+		listeners: make(map[string]chan *shibuyaMetric),
+	}
+	a, ok := rr.listen("a")
+	assert.True(t, ok)
+	b, ok := rr.listen("b")
+	assert.True(t, ok)
+	m := &shibuyaMetric{raw: "raw", planID: "1"}
+	rr.broadcast(m)
+	assert.Equal(t, m, <-a)
+	assert.Equal(t, m, <-b)
+
+	rr.unlisten("a")
+	_, open := <-a
+	assert.False(t, open)
+	// a full listener does not block the others
+	for i := 0; i < listenerBufferSize+1; i++ {
+		rr.broadcast(m)
+	}
+	assert.Equal(t, listenerBufferSize, len(b))
+
+	close(rr.done)
+	_, ok = rr.listen("c")
+	assert.False(t, ok)
+}
@@ -16,18 +16,25 @@ import (
 	httproute "github.com/rakutentech/shibuya/shibuya/http/route"
 
 	enginesModel "github.com/rakutentech/shibuya/shibuya/engines/model"
-	pubsub "github.com/reqfleet/pubsub/server"
+	"github.com/reqfleet/pubsub/messages"
 )
 
+// Broadcaster sends the messages to the engines subscribing to a topic. The pubsub server of the
+// coordinator is the default one.
+type Broadcaster interface {
+	Broadcast(topic string, m messages.Message) error
+	NumberOfClients(topic string) int
+}
+
 type APIServer struct {
 	// client used for engine progress check
 	httpClient   *http.Client
 	apiKey       string
-	pubsubServer *pubsub.PubSubServer
+	pubsubServer Broadcaster
 	inventory    *upstream.Inventory
 }
 
-func NewAPIServer(server *pubsub.PubSubServer, inventory *upstream.Inventory, apiKey string) *APIServer {
+func NewAPIServer(server Broadcaster, inventory *upstream.Inventory, apiKey string) *APIServer {
 	client := &http.Client{
 		Timeout: 3 * time.Second,
 	}
@@ -36,7 +43,7 @@ func NewAPIServer(server *pubsub.PubSubServer, inventory *upstream.Inventory, ap
 }
 
 func engineProgress(endpoint, apiKey string, httpClient *http.Client) bool {
-	req, err := http.NewRequest("GET", fmt.Sprintf("http://%s/progress", endpoint), nil)
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/progress", upstream.EngineURL(endpoint)), nil)
 	if err != nil {
 		return false
 	}
@@ -84,6 +91,18 @@ func (s *APIServer) Router() *httproute.Router {
 			Path:        "/{collection_id}/{plan_id}",
 			HandlerFunc: s.planTerminationHandler,
 		},
+		{
+			Name:        "Scale a plan",
+			Method:      "PATCH",
+			Path:        "/{collection_id}/{plan_id}",
+			HandlerFunc: s.planScaleHandler,
+		},
+		{
+			Name:        "Scrape targets of a collection",
+			Method:      "GET",
+			Path:        "/{collection_id}/targets",
+			HandlerFunc: s.collectionTargetsHandler,
+		},
 	}
 	collectionRouter := &httproute.Router{
 		Name: "collection handlers",
@@ -95,6 +114,14 @@ func (s *APIServer) Router() *httproute.Router {
 		Path: "/api",
 	}
 	apiRouter.Mount(collectionRouter)
+	apiRouter.AddRoutes(httproute.Routes{
+		{
+			Name:        "Replace the inventory",
+			Method:      "PUT",
+			Path:        "/inventory",
+			HandlerFunc: s.inventoryHandler,
+		},
+	})
 	return apiRouter
 }
 
@@ -189,6 +216,28 @@ func (s *APIServer) planTerminationHandler(w http.ResponseWriter, r *http.Reques
 	}
 }
 
+// planScaleHandler asks the running engines of the plan to change their concurrency
+func (s *APIServer) planScaleHandler(w http.ResponseWriter, r *http.Request) {
+	cid := r.PathValue("collection_id")
+	pid := r.PathValue("plan_id")
+	r.ParseForm()
+	concurrency, err := strconv.Atoi(r.Form.Get("concurrency"))
+	if err != nil || concurrency <= 0 {
+		http.Error(w, "concurrency should be a positive number", http.StatusBadRequest)
+		return
+	}
+	pm := make(payload.PlanMessage)
+	pm[pid] = &payload.EngineMessage{Verb: "scale", Concurrency: concurrency}
+	p := &payload.Payload{
+		PlanMessage: pm,
+		Verb:        "scale",
+	}
+	if err := s.pubsubServer.Broadcast(fmt.Sprintf("collection:%s", cid), p); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
 func (s *APIServer) collectionHealthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	collectionID := r.PathValue("collection_id")
 	topic := fmt.Sprintf("collection:%s", collectionID)
@@ -222,6 +271,29 @@ func (s *APIServer) collectionTermHandler(w http.ResponseWriter, r *http.Request
 	}
 }
 
+// collectionTargetsHandler serves the engines of the collection in the format of the prometheus http
+// service discovery, for the scrapers which cannot discover the engines by themselves
+func (s *APIServer) collectionTargetsHandler(w http.ResponseWriter, r *http.Request) {
+	cid := r.PathValue("collection_id")
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.inventory.GetTargetGroups(cid)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// inventoryHandler replaces the inventory of the coordinator reading the engines from a file
+func (s *APIServer) inventoryHandler(w http.ResponseWriter, r *http.Request) {
+	var endpoints []upstream.FileEndpoint
+	if err := json.NewDecoder(r.Body).Decode(&endpoints); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.inventory.ReplaceFile(endpoints); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
 func findObj(r *http.Request, key string) (int64, error) {
 	t := r.PathValue(key)
 	tid, err := strconv.ParseInt(t, 10, 64)
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rakutentech/shibuya/shibuya/coordinator/upstream"
+	httptoken "github.com/rakutentech/shibuya/shibuya/http/auth/token"
+	"github.com/reqfleet/pubsub/messages"
+)
+
+// HTTPBroadcaster pushes the messages to the engines of the inventory by http. It's used when the
+// engines cannot reach the pubsub port of the coordinator, like on Cloud Run which only routes http
+// to the services.
+type HTTPBroadcaster struct {
+	inventory  *upstream.Inventory
+	apiKey     string
+	httpClient *http.Client
+}
+
+func NewHTTPBroadcaster(inventory *upstream.Inventory, apiKey string) *HTTPBroadcaster {
+	return &HTTPBroadcaster{
+		inventory:  inventory,
+		apiKey:     apiKey,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func collectionOfTopic(topic string) string {
+	return strings.TrimPrefix(topic, "collection:")
+}
+
+func (b *HTTPBroadcaster) push(endpoint string, body []byte) error {
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/messages", upstream.EngineURL(endpoint)), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(httptoken.AuthHeader, fmt.Sprintf("%s %s", httptoken.BEARER_PREFIX, b.apiKey))
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("engine %s returns %d", endpoint, resp.StatusCode)
+	}
+	return nil
+}
+
+// Broadcast pushes the message to all the engines of the collection. If any of the engines fails, we
+// only return the last error.
+func (b *HTTPBroadcaster) Broadcast(topic string, m messages.Message) error {
+	body, err := m.ToJSON()
+	if err != nil {
+		return err
+	}
+	endpoints := b.inventory.GetCollectionEndpoints(collectionOfTopic(topic))
+	errs := make(chan error, len(endpoints))
+	var wg sync.WaitGroup
+	for _, ep := range endpoints {
+		wg.Add(1)
+		go func(ep string) {
+			defer wg.Done()
+			errs <- b.push(ep, body)
+		}(ep)
+	}
+	wg.Wait()
+	close(errs)
+	var lastError error
+	for err := range errs {
+		if err != nil {
+			lastError = err
+		}
+	}
+	return lastError
+}
+
+// NumberOfClients returns the number of the engines in the inventory, as the engines do not keep
+// connections to the coordinator
+func (b *HTTPBroadcaster) NumberOfClients(topic string) int {
+	return b.inventory.GetEndpointsCountByCollection(collectionOfTopic(topic))
+}
@@ -0,0 +1,80 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rakutentech/shibuya/shibuya/coordinator/api"
+	"github.com/rakutentech/shibuya/shibuya/coordinator/payload"
+	"github.com/rakutentech/shibuya/shibuya/coordinator/upstream"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeEngine struct {
+	mu       sync.Mutex
+	server   *httptest.Server
+	payloads []*payload.Payload
+	auth     string
+}
+
+func newFakeEngine(t *testing.T) *fakeEngine {
+	e := &fakeEngine{}
+	e.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pl := new(payload.Payload)
+		if r.URL.Path != "/messages" || json.NewDecoder(r.Body).Decode(pl) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		e.payloads = append(e.payloads, pl)
+		e.auth = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(e.server.Close)
+	return e
+}
+
+func TestHTTPBroadcaster(t *testing.T) {
+	e1, e2 := newFakeEngine(t), newFakeEngine(t)
+	inventory := upstream.NewFileInventory(filepath.Join(t.TempDir(), "inventory.json"))
+	server := api.NewAPIServer(nil, inventory, "key")
+	router := server.Router().Mux()
+	body := `[{"collection_id": "1", "plan_id": "1", "engine": "engine-1-1-1-0", "addr": "` + e1.server.URL + `"},
+		{"collection_id": "1", "plan_id": "2", "engine": "engine-1-1-2-0", "addr": "` + e2.server.URL + `"}]`
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("PUT", "/api/inventory", strings.NewReader(body)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("PUT", "/api/inventory", strings.NewReader("engines")))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/api/collections/1/targets", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var targetGroups []upstream.TargetGroup
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &targetGroups))
+	assert.Len(t, targetGroups, 2)
+	assert.Equal(t, []string{strings.TrimPrefix(e1.server.URL, "http://")}, targetGroups[0].Targets)
+
+	b := api.NewHTTPBroadcaster(inventory, "key")
+	assert.Equal(t, 2, b.NumberOfClients("collection:1"))
+	assert.Equal(t, 0, b.NumberOfClients("collection:2"))
+	pl := &payload.Payload{Verb: "stop", PlanMessage: payload.PlanMessage{"1": {}}}
+	assert.Nil(t, b.Broadcast("collection:1", pl))
+	for _, e := range []*fakeEngine{e1, e2} {
+		assert.Len(t, e.payloads, 1)
+		assert.Equal(t, "stop", e.payloads[0].Verb)
+		assert.Equal(t, "Bearer key", e.auth)
+	}
+
+	// an engine is gone
+	e2.server.Close()
+	assert.NotNil(t, b.Broadcast("collection:1", pl))
+	assert.Len(t, e1.payloads, 2)
+}
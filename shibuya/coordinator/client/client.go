@@ -26,6 +26,7 @@ var (
 	CollectionTriggerError = errors.New("Collection trigger error")
 	CollectionTermError    = errors.New("Collection term error")
 	PlanTermError          = errors.New("Plan term error")
+	PlanScaleError         = errors.New("Plan scale error")
 )
 
 type ReqOpts struct {
@@ -114,6 +115,21 @@ func (c *Client) TermPlan(ro ReqOpts, collectionID, planID int64) error {
 	return nil
 }
 
+func (c *Client) ScalePlan(ro ReqOpts, collectionID, planID int64, concurrency int) error {
+	endpoint := c.makeUrl(ro.Endpoint, collectionID)
+	resourceUrl := fmt.Sprintf("%s/%d", endpoint, planID)
+	values := url.Values{}
+	values.Add("concurrency", strconv.Itoa(concurrency))
+	req, err := c.makeRequestWithValues(resourceUrl, http.MethodPatch, values)
+	if err != nil {
+		return err
+	}
+	if err := c.sendRequest(req, ro); err != nil {
+		return fmt.Errorf("%w:%w", PlanScaleError, err)
+	}
+	return nil
+}
+
 func (c *Client) FetchFile(ro ReqOpts, path string) ([]byte, error) {
 	resourceUrl := fmt.Sprintf("https://%s/%s", ro.Endpoint, path)
 	req, err := http.NewRequest("GET", resourceUrl, nil)
@@ -141,7 +157,7 @@ func (c *Client) makeRequestWithValues(resourceUrl, method string, values url.Va
 		}
 		req.URL.RawQuery = values.Encode()
 		return req, nil
-	case http.MethodPut, http.MethodPost:
+	case http.MethodPut, http.MethodPost, http.MethodPatch:
 		req, err := http.NewRequest(method, resourceUrl, strings.NewReader(values.Encode()))
 		if err != nil {
 			return nil, err
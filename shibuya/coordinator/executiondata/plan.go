@@ -1,22 +1,12 @@
 package executiondata
 
 import (
-	"fmt"
-
 	"github.com/rakutentech/shibuya/shibuya/coordinator/payload"
 	"github.com/rakutentech/shibuya/shibuya/coordinator/storage"
-	"github.com/rakutentech/shibuya/shibuya/engines/jmeter"
-	"github.com/rakutentech/shibuya/shibuya/engines/locust"
 	enginesModel "github.com/rakutentech/shibuya/shibuya/engines/model"
-	"github.com/rakutentech/shibuya/shibuya/model"
+	"github.com/rakutentech/shibuya/shibuya/engines/registry"
 )
 
-var TestFileHandlerByPlanKind = map[model.PlanKind]func(*storage.PlanFiles, string, string, []byte,
-	enginesModel.PlanEnginesConfig) error{
-	model.JmeterPlan: jmeter.MakeTestPlan,
-	model.LocustPlan: locust.MakeTestPlan,
-}
-
 func HandlePlanData(pf *storage.PlanFiles, filename string, fileBytes []byte,
 	edc []*enginesModel.EngineDataConfig, planPayload payload.PlanMessage) error {
 	if err := pf.StoreDataFile(filename, fileBytes, edc); err != nil {
@@ -28,9 +18,9 @@ func HandlePlanData(pf *storage.PlanFiles, filename string, fileBytes []byte,
 }
 
 func HandlePlanTestFile(pf *storage.PlanFiles, pec enginesModel.PlanEnginesConfig, filename string, fileBytes []byte) error {
-	handlerFunc, ok := TestFileHandlerByPlanKind[pec.Kind]
-	if !ok {
-		return fmt.Errorf("%s is not supported", string(pec.Kind))
+	engine, err := registry.Get(pec.Kind)
+	if err != nil {
+		return err
 	}
-	return handlerFunc(pf, pec.Name, filename, fileBytes, pec)
+	return engine.MakeTestPlan(pf, pec.Name, filename, fileBytes, pec)
 }
@@ -4,6 +4,7 @@ import (
 	"os"
 
 	cdrserver "github.com/rakutentech/shibuya/shibuya/coordinator/server"
+	_ "github.com/rakutentech/shibuya/shibuya/engines/builtin"
 	log "github.com/sirupsen/logrus"
 
 	_ "go.uber.org/automaxprocs"
@@ -15,14 +16,23 @@ func initFromEnv() cdrserver.CoordinatorConfig {
 	logLevel := os.Getenv("log_level")
 	listenAddr := os.Getenv("listen_addr")
 	APIKey := os.Getenv("api_key")
+	tlsDir := os.Getenv("tls_dir")
+	if tlsDir == "" {
+		tlsDir = "/tls"
+	}
+	// the coordinator is served by plain http when the tls is terminated in front of it, like on Cloud Run
+	enableTLS := os.Getenv("enable_tls") != "false"
 	return cdrserver.CoordinatorConfig{
-		Namespace:  namespace,
-		ProjectID:  projectID,
-		LogLevel:   logLevel,
-		ListenAddr: listenAddr,
-		InCluster:  true,
-		EnableTLS:  true,
-		APIKey:     APIKey,
+		Namespace:     namespace,
+		ProjectID:     projectID,
+		LogLevel:      logLevel,
+		ListenAddr:    listenAddr,
+		InCluster:     true,
+		EnableTLS:     enableTLS,
+		APIKey:        APIKey,
+		TLSDir:        tlsDir,
+		InventoryFile: os.Getenv("inventory_file"),
+		PushMessages:  os.Getenv("push_messages") == "true",
 	}
 }
 
@@ -37,4 +37,6 @@ type EngineMessage struct {
 	RunID     int64               `json:"run_id"`
 	TestFile  string              `json:"test_file"`
 	DataFiles map[string]struct{} `json:"data_files"`
+	// Concurrency is the new concurrency of every engine of the plan. It's only used by the scale verb.
+	Concurrency int `json:"concurrency,omitempty"`
 }
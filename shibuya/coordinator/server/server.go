@@ -23,8 +23,8 @@ import (
 )
 
 const (
-	certFile = "/tls/tls.crt"
-	keyFile  = "/tls/tls.key"
+	certFile = "tls.crt"
+	keyFile  = "tls.key"
 )
 
 type ShibuyaCoordinator struct {
@@ -62,6 +62,13 @@ type CoordinatorConfig struct {
 	EnableTLS  bool
 	InCluster  bool
 	APIKey     string
+	// TLSDir has the cert and the key of the coordinator
+	TLSDir string
+	// InventoryFile lists the engines when they are not in a k8s cluster
+	InventoryFile string
+	// PushMessages pushes the messages to the engines by http instead of the pubsub server, for the
+	// engines which cannot reach the pubsub port
+	PushMessages bool
 }
 
 func newFileServer() httproute.Routes {
@@ -83,28 +90,47 @@ func NewShibuyaCoordinator(cc CoordinatorConfig) *ShibuyaCoordinator {
 	log.Infof("Engine namespace %s", cc.Namespace)
 	log.Infof("Project ID: %s", cc.ProjectID)
 
-	inventory, err := upstream.NewInventory(cc.Namespace, cc.InCluster)
-	if err != nil {
-		log.Fatal(err)
-	}
-	serverOpts := pubsub.ServerOpts{
-		Mode:     pubsub.TCP,
-		Password: cc.APIKey,
+	var inventory *upstream.Inventory
+	if cc.InventoryFile != "" {
+		log.Infof("Engine inventory file %s", cc.InventoryFile)
+		inventory = upstream.NewFileInventory(cc.InventoryFile)
+	} else {
+		var err error
+		inventory, err = upstream.NewInventory(cc.Namespace, cc.InCluster)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 	s := &ShibuyaCoordinator{inventory: inventory}
-	pub := pubsub.NewPubSubServer(serverOpts)
 	ctx, cancel := context.WithCancel(context.TODO())
 	s.ctx = ctx
-	go func() {
+	var broadcaster api.Broadcaster
+	if cc.PushMessages {
+		broadcaster = api.NewHTTPBroadcaster(inventory, cc.APIKey)
 		go func() {
 			sig := make(chan os.Signal, 1)
 			signal.Notify(sig, httpserver.STOPSIGNALS...)
 			<-sig
-			pub.Shutdown()
 			cancel()
 		}()
-		pub.Listen()
-	}()
+	} else {
+		serverOpts := pubsub.ServerOpts{
+			Mode:     pubsub.TCP,
+			Password: cc.APIKey,
+		}
+		pub := pubsub.NewPubSubServer(serverOpts)
+		broadcaster = pub
+		go func() {
+			go func() {
+				sig := make(chan os.Signal, 1)
+				signal.Notify(sig, httpserver.STOPSIGNALS...)
+				<-sig
+				pub.Shutdown()
+				cancel()
+			}()
+			pub.Listen()
+		}()
+	}
 	go s.inventory.MakeInventory(cc.ProjectID)
 	rp := httputil.ReverseProxy{
 		Rewrite:   s.rewriteURL,
@@ -114,7 +140,7 @@ func NewShibuyaCoordinator(cc CoordinatorConfig) *ShibuyaCoordinator {
 		Name: "shibuya coordinator",
 		Path: "",
 	}
-	apiserver := api.NewAPIServer(pub, inventory, cc.APIKey)
+	apiserver := api.NewAPIServer(broadcaster, inventory, cc.APIKey)
 	rootRouter.Mount(apiserver.Router())
 	rootRouter.AddRoutes(newFileServer())
 	mux := rootRouter.Mux()
@@ -144,7 +170,7 @@ func (sic *ShibuyaCoordinator) rewriteURL(r *httputil.ProxyRequest) {
 		log.Warnf("Cannot find pod ip for %s", engine)
 		return
 	}
-	target, err := url.Parse(fmt.Sprintf("http://%s", podIP))
+	target, err := url.Parse(upstream.EngineURL(podIP))
 	if err != nil {
 		log.Error(err)
 		return
@@ -167,7 +193,7 @@ func (s *ShibuyaCoordinator) ListenHTTP() error {
 		Handler: s.Handler,
 	}
 	if cc.EnableTLS {
-		return httpserver.StartServer(server, certFile, keyFile, s.ctx)
+		return httpserver.StartServer(server, path.Join(cc.TLSDir, certFile), path.Join(cc.TLSDir, keyFile), s.ctx)
 	}
 	return httpserver.StartServer(server, "", "", s.ctx)
 }
@@ -0,0 +1,94 @@
+package upstream
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// FileEndpoint is an engine in the inventory file. Schedulers without the k8s endpoints, like the
+// local scheduler, list the engines of the project in the file once all the engines of a
+// collection are running.
+type FileEndpoint struct {
+	CollectionID string `json:"collection_id"`
+	PlanID       string `json:"plan_id"`
+	Engine       string `json:"engine"`
+	Addr         string `json:"addr"`
+}
+
+// NewFileInventory makes the inventory from the inventory file instead of the k8s endpoints
+func NewFileInventory(file string) *Inventory {
+	return &Inventory{
+		inventoryByCollection: make(map[string][]EngineEndPoint),
+		engineInventory:       make(map[string]EngineEndPoint),
+		file:                  file,
+	}
+}
+
+// WriteInventoryFile replaces the inventory file. The file is renamed into place so the
+// coordinator never reads a partially written file.
+func WriteInventoryFile(file string, endpoints []FileEndpoint) error {
+	raw, err := json.Marshal(endpoints)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// the coordinator could be run by another user in a container
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// ReplaceFile replaces the inventory file with the endpoints and updates the inventory at once. It's
+// used when the scheduler cannot write the file by itself, like on Cloud Run.
+func (ivt *Inventory) ReplaceFile(endpoints []FileEndpoint) error {
+	if ivt.file == "" {
+		return errors.New("the inventory is not read from a file")
+	}
+	if err := WriteInventoryFile(ivt.file, endpoints); err != nil {
+		return err
+	}
+	ivt.updateInventory(groupFileEndpoints(endpoints))
+	return nil
+}
+
+func readInventoryFile(file string) (map[string][]EngineEndPoint, error) {
+	raw, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return make(map[string][]EngineEndPoint), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var endpoints []FileEndpoint
+	if err := json.Unmarshal(raw, &endpoints); err != nil {
+		return nil, err
+	}
+	return groupFileEndpoints(endpoints), nil
+}
+
+func groupFileEndpoints(endpoints []FileEndpoint) map[string][]EngineEndPoint {
+	inventoryByCollection := make(map[string][]EngineEndPoint)
+	for _, e := range endpoints {
+		inventoryByCollection[e.CollectionID] = append(inventoryByCollection[e.CollectionID], EngineEndPoint{
+			path:         e.Engine,
+			addr:         e.Addr,
+			collectionID: e.CollectionID,
+			planID:       e.PlanID,
+		})
+	}
+	return inventoryByCollection
+}
@@ -0,0 +1,53 @@
+package upstream
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileInventory(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "inventory.json")
+	inventoryByCollection, err := readInventoryFile(file)
+	assert.Nil(t, err)
+	assert.Empty(t, inventoryByCollection)
+
+	err = WriteInventoryFile(file, []FileEndpoint{
+		{CollectionID: "1", PlanID: "1", Engine: "engine-1-1-1-0", Addr: "127.0.0.1:9000"},
+		{CollectionID: "1", PlanID: "2", Engine: "engine-1-1-2-0", Addr: "127.0.0.1:9001"},
+	})
+	assert.Nil(t, err)
+	inventory := NewFileInventory(file)
+	inventoryByCollection, err = readInventoryFile(file)
+	assert.Nil(t, err)
+	inventory.updateInventory(inventoryByCollection)
+	assert.Equal(t, 2, inventory.GetEndpointsCountByCollection("1"))
+	assert.Equal(t, "127.0.0.1:9001", inventory.FindPodIP("engine-1-1-2-0"))
+	assert.Equal(t, []string{"127.0.0.1:9000"}, inventory.GetPlanEndpoints("1", "1"))
+}
+
+func TestReplaceFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "inventory.json")
+	inventory := NewFileInventory(file)
+	err := inventory.ReplaceFile([]FileEndpoint{
+		{CollectionID: "1", PlanID: "1", Engine: "engine-1-1-1-0", Addr: "https://engine-1-1-1-0.run.app"},
+		{CollectionID: "1", PlanID: "1", Engine: "engine-1-1-1-1", Addr: "https://engine-1-1-1-1.run.app"},
+		{CollectionID: "1", PlanID: "2", Engine: "engine-1-1-2-0", Addr: "10.0.0.1:8080"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, inventory.GetEndpointsCountByCollection("1"))
+	assert.Len(t, inventory.GetCollectionEndpoints("1"), 3)
+	assert.Equal(t, []TargetGroup{
+		{Targets: []string{"engine-1-1-1-0.run.app", "engine-1-1-1-1.run.app"}, Labels: map[string]string{"plan": "1"}},
+		{Targets: []string{"10.0.0.1:8080"}, Labels: map[string]string{"plan": "2"}},
+	}, inventory.GetTargetGroups("1"))
+	// the file is read again by the coordinator
+	inventoryByCollection, err := readInventoryFile(file)
+	assert.Nil(t, err)
+	assert.Len(t, inventoryByCollection["1"], 3)
+
+	assert.NotNil(t, (&Inventory{}).ReplaceFile(nil))
+	assert.Equal(t, "https://engine-1-1-1-0.run.app", EngineURL("https://engine-1-1-1-0.run.app"))
+	assert.Equal(t, "http://10.0.0.1:8080", EngineURL("10.0.0.1:8080"))
+}
@@ -3,7 +3,10 @@ package upstream
 import (
 	"context"
 	"fmt"
+	"net/url"
 	"path"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -22,6 +25,8 @@ type Inventory struct {
 	engineInventory       map[string]EngineEndPoint
 	mu                    sync.RWMutex
 	client                *kubernetes.Clientset
+	// file is the inventory file the engines are read from instead of the k8s endpoints
+	file string
 }
 
 func NewInventory(namespace string, inCluster bool) (*Inventory, error) {
@@ -89,79 +94,92 @@ func (ivt *Inventory) updateInventory(inventoryByCollection map[string][]EngineE
 }
 
 func (ivt *Inventory) MakeInventory(projectID string) {
-	labelSelector := fmt.Sprintf("project=%s", projectID)
-	client := ivt.client
 	for {
 		time.Sleep(3 * time.Second)
-		resp, err := client.CoreV1().Endpoints(ivt.namespace).List(context.TODO(), metav1.ListOptions{
-			LabelSelector: labelSelector,
-		})
+		var inventoryByCollection map[string][]EngineEndPoint
+		var err error
+		if ivt.file != "" {
+			inventoryByCollection, err = readInventoryFile(ivt.file)
+		} else {
+			inventoryByCollection, err = ivt.listEndpoints(projectID)
+		}
 		if err != nil {
 			log.Error(err)
 			continue
 		}
-		// can we have the race condition that the inventory we make could make the shibuya controller mistakenly thinks the engines are ready?
-		// controller is already checking whether all the engines within one collection are in running state
-		// How can ensure the atomicity?
-		inventoryByCollection := make(map[string][]EngineEndPoint)
-		skipedCollections := make(map[string]struct{})
-		for _, planEndpoints := range resp.Items {
-			// need to sort the endpoints and update the inventory
-			collectionID := planEndpoints.Labels["collection"]
-
-			// If any of the plans inside the collection is not ready, we skip the further check
-			if _, ok := skipedCollections[collectionID]; ok {
-				log.Debugf("Collection %s is not ready, skip.", collectionID)
-				continue
-			}
-			projectID := planEndpoints.Labels["project"]
-			planID := planEndpoints.Labels["plan"]
-			kind := planEndpoints.Labels["kind"]
+		ivt.updateInventory(inventoryByCollection)
+	}
+}
 
-			if kind != "executor" {
-				continue
-			}
-			collectionReady := true
-			subsets := planEndpoints.Subsets
-			var engineEndpoints []apiv1.EndpointAddress
-			if len(subsets) == 0 {
+func (ivt *Inventory) listEndpoints(projectID string) (map[string][]EngineEndPoint, error) {
+	labelSelector := fmt.Sprintf("project=%s", projectID)
+	resp, err := ivt.client.CoreV1().Endpoints(ivt.namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: labelSelector,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// can we have the race condition that the inventory we make could make the shibuya controller mistakenly thinks the engines are ready?
+	// controller is already checking whether all the engines within one collection are in running state
+	// How can ensure the atomicity?
+	inventoryByCollection := make(map[string][]EngineEndPoint)
+	skipedCollections := make(map[string]struct{})
+	for _, planEndpoints := range resp.Items {
+		// need to sort the endpoints and update the inventory
+		collectionID := planEndpoints.Labels["collection"]
+
+		// If any of the plans inside the collection is not ready, we skip the further check
+		if _, ok := skipedCollections[collectionID]; ok {
+			log.Debugf("Collection %s is not ready, skip.", collectionID)
+			continue
+		}
+		projectID := planEndpoints.Labels["project"]
+		planID := planEndpoints.Labels["plan"]
+		kind := planEndpoints.Labels["kind"]
+
+		if kind != "executor" {
+			continue
+		}
+		collectionReady := true
+		subsets := planEndpoints.Subsets
+		var engineEndpoints []apiv1.EndpointAddress
+		if len(subsets) == 0 {
+			collectionReady = false
+		} else { // only some engines could be in ready state. We need to check whether they are fully ready
+			engineEndpoints = subsets[0].Addresses
+			planEngineCount, err := ivt.getPlanEnginesCount(projectID, collectionID, planID)
+			if err != nil {
+				log.Debugf("Getting count error %v", err)
 				collectionReady = false
-			} else { // only some engines could be in ready state. We need to check whether they are fully ready
-				engineEndpoints = subsets[0].Addresses
-				planEngineCount, err := ivt.getPlanEnginesCount(projectID, collectionID, planID)
-				if err != nil {
-					log.Debugf("Getting count error %v", err)
-					collectionReady = false
-				}
-				// If the engpoints are less than the pod count, it means the pods are not ready yet, we should skip
-				log.Debugf("Engine endpoints count %d", len(engineEndpoints))
-				log.Debugf("Number of engines in the plan %d", planEngineCount)
-				if len(engineEndpoints) < planEngineCount {
-					collectionReady = false
-				}
-			}
-			if !collectionReady {
-				skipedCollections[collectionID] = struct{}{}
-				continue
 			}
-			ports := subsets[0].Ports
-			if len(ports) == 0 {
-				//TODO is this an error? Shall we handle it?
-				continue
-			}
-			port := ports[0].Port
-			for _, e := range engineEndpoints {
-				podName := e.TargetRef.Name
-				inventoryByCollection[collectionID] = append(inventoryByCollection[collectionID], EngineEndPoint{
-					path:         podName,
-					addr:         fmt.Sprintf("%s:%d", e.IP, port),
-					collectionID: collectionID,
-					planID:       planID,
-				})
+			// If the engpoints are less than the pod count, it means the pods are not ready yet, we should skip
+			log.Debugf("Engine endpoints count %d", len(engineEndpoints))
+			log.Debugf("Number of engines in the plan %d", planEngineCount)
+			if len(engineEndpoints) < planEngineCount {
+				collectionReady = false
 			}
 		}
-		ivt.updateInventory(inventoryByCollection)
+		if !collectionReady {
+			skipedCollections[collectionID] = struct{}{}
+			continue
+		}
+		ports := subsets[0].Ports
+		if len(ports) == 0 {
+			//TODO is this an error? Shall we handle it?
+			continue
+		}
+		port := ports[0].Port
+		for _, e := range engineEndpoints {
+			podName := e.TargetRef.Name
+			inventoryByCollection[collectionID] = append(inventoryByCollection[collectionID], EngineEndPoint{
+				path:         podName,
+				addr:         fmt.Sprintf("%s:%d", e.IP, port),
+				collectionID: collectionID,
+				planID:       planID,
+			})
+		}
 	}
+	return inventoryByCollection, nil
 }
 
 func (ivt *Inventory) GetPlanEndpoints(collectionID, planID string) []string {
@@ -178,6 +196,60 @@ func (ivt *Inventory) GetPlanEndpoints(collectionID, planID string) []string {
 	return endpointsByPlan
 }
 
+// GetCollectionEndpoints returns the addresses of all the engines of the collection
+func (ivt *Inventory) GetCollectionEndpoints(collectionID string) []string {
+	ivt.mu.RLock()
+	defer ivt.mu.RUnlock()
+	endpoints := make([]string, 0)
+	for _, ep := range ivt.inventoryByCollection[collectionID] {
+		endpoints = append(endpoints, ep.addr)
+	}
+	return endpoints
+}
+
+// TargetGroup is the format of the prometheus http service discovery
+type TargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// GetTargetGroups returns the engines of the collection as the scrape targets, grouped by plans
+func (ivt *Inventory) GetTargetGroups(collectionID string) []TargetGroup {
+	ivt.mu.RLock()
+	defer ivt.mu.RUnlock()
+	groups := make(map[string]*TargetGroup)
+	planIDs := []string{}
+	for _, ep := range ivt.inventoryByCollection[collectionID] {
+		group, ok := groups[ep.planID]
+		if !ok {
+			group = &TargetGroup{Targets: []string{}, Labels: map[string]string{"plan": ep.planID}}
+			groups[ep.planID] = group
+			planIDs = append(planIDs, ep.planID)
+		}
+		u, err := url.Parse(EngineURL(ep.addr))
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		group.Targets = append(group.Targets, u.Host)
+	}
+	sort.Strings(planIDs)
+	targetGroups := make([]TargetGroup, len(planIDs))
+	for i, planID := range planIDs {
+		targetGroups[i] = *groups[planID]
+	}
+	return targetGroups
+}
+
+// EngineURL returns the url of the engine at the address. The addresses of the engines served by
+// https, like the ones on Cloud Run, come with the scheme.
+func EngineURL(addr string) string {
+	if strings.Contains(addr, "://") {
+		return addr
+	}
+	return fmt.Sprintf("http://%s", addr)
+}
+
 func (ivt *Inventory) getPlanEnginesCount(projectID, collectionID, planID string) (int, error) {
 	planName := fmt.Sprintf("engine-%s-%s-%s", projectID, collectionID, planID)
 	resp, err := ivt.client.AppsV1().StatefulSets(ivt.namespace).Get(context.TODO(), planName, metav1.GetOptions{})
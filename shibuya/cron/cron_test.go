@@ -0,0 +1,75 @@
+package cron
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func mustParse(t *testing.T, expr string) *Schedule {
+	s, err := Parse(expr)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	return s
+}
+
+func TestParseInvalid(t *testing.T) {
+	exprs := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 7",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"@every",
+	}
+	for _, expr := range exprs {
+		_, err := Parse(expr)
+		assert.NotNil(t, err, expr)
+	}
+}
+
+func TestNext(t *testing.T) {
+	start := time.Date(2026, 10, 18, 10, 7, 30, 0, time.UTC)
+	cases := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2026, 10, 18, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, 10, 18, 10, 15, 0, 0, time.UTC)},
+		{"5/10 * * * *", time.Date(2026, 10, 18, 10, 15, 0, 0, time.UTC)},
+		{"0 9-17 * * *", time.Date(2026, 10, 18, 11, 0, 0, 0, time.UTC)},
+		{"30 2 * * *", time.Date(2026, 10, 19, 2, 30, 0, 0, time.UTC)},
+		{"0 0 1,15 * *", time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)},
+		// 2026-10-18 is a Sunday
+		{"0 8 * * 1-5", time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)},
+		// either the day of month or the day of week
+		{"0 0 20 * 0", time.Date(2026, 10, 20, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2026, 10, 18, 11, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, c := range cases {
+		s := mustParse(t, c.expr)
+		assert.Equal(t, c.want, s.Next(start), c.expr)
+	}
+}
+
+func TestNextInLocation(t *testing.T) {
+	loc, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skip(err)
+	}
+	s := mustParse(t, "0 9 * * *")
+	start := time.Date(2026, 10, 18, 1, 0, 0, 0, time.UTC)
+	next := s.Next(start.In(loc))
+	assert.Equal(t, time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC), next.UTC())
+}
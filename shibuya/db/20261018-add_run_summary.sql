@@ -0,0 +1,35 @@
+use shibuya;
+
+-- plan_id = 0 is the summary of the whole run and label = '' is the summary of the whole plan.
+-- Labels and statuses are compared as they are, so the labels differing only by case are different.
+CREATE TABLE IF NOT EXISTS run_summary (
+    id INT unsigned NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    run_id INT unsigned NOT NULL,
+    collection_id INT unsigned NOT NULL,
+    plan_id INT unsigned NOT NULL DEFAULT 0,
+    label VARCHAR(191) COLLATE utf8mb4_bin NOT NULL DEFAULT '',
+    request_count BIGINT unsigned NOT NULL DEFAULT 0,
+    error_count BIGINT unsigned NOT NULL DEFAULT 0,
+    min_latency DOUBLE NOT NULL DEFAULT 0,
+    mean_latency DOUBLE NOT NULL DEFAULT 0,
+    max_latency DOUBLE NOT NULL DEFAULT 0,
+    p50_latency DOUBLE NOT NULL DEFAULT 0,
+    p90_latency DOUBLE NOT NULL DEFAULT 0,
+    p95_latency DOUBLE NOT NULL DEFAULT 0,
+    p99_latency DOUBLE NOT NULL DEFAULT 0,
+    throughput DOUBLE NOT NULL DEFAULT 0,
+    UNIQUE KEY (run_id, plan_id, label),
+    key (collection_id, run_id)
+)CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS run_summary_status (
+    id INT unsigned NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    run_id INT unsigned NOT NULL,
+    collection_id INT unsigned NOT NULL,
+    plan_id INT unsigned NOT NULL DEFAULT 0,
+    label VARCHAR(191) COLLATE utf8mb4_bin NOT NULL DEFAULT '',
+    status VARCHAR(191) COLLATE utf8mb4_bin NOT NULL,
+    count BIGINT unsigned NOT NULL DEFAULT 0,
+    UNIQUE KEY (run_id, plan_id, label, status),
+    key (collection_id, run_id)
+)CHARSET=utf8mb4;
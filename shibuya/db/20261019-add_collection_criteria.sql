@@ -0,0 +1,29 @@
+use shibuya;
+
+-- plan_id = 0 means the rule is evaluated against the whole run. The labels are stored like the ones
+-- of run_summary, so a rule matches the summary of its label.
+CREATE TABLE IF NOT EXISTS collection_criteria (
+    id INT unsigned NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    collection_id INT unsigned NOT NULL,
+    plan_id INT unsigned NOT NULL DEFAULT 0,
+    label VARCHAR(191) COLLATE utf8mb4_bin NOT NULL DEFAULT '',
+    rule VARCHAR(255) NOT NULL,
+    key (collection_id)
+)CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS run_criteria_result (
+    id INT unsigned NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    run_id INT unsigned NOT NULL,
+    collection_id INT unsigned NOT NULL,
+    plan_id INT unsigned NOT NULL DEFAULT 0,
+    label VARCHAR(191) COLLATE utf8mb4_bin NOT NULL DEFAULT '',
+    rule VARCHAR(255) NOT NULL,
+    actual DOUBLE NOT NULL DEFAULT 0,
+    passed TINYINT(1) NOT NULL DEFAULT 0,
+    message VARCHAR(255) NOT NULL DEFAULT '',
+    key (run_id),
+    key (collection_id, run_id)
+)CHARSET=utf8mb4;
+
+-- passed or failed. It stays NULL when the collection does not have any criteria
+ALTER TABLE collection_run_history ADD COLUMN verdict VARCHAR(10) NULL DEFAULT NULL;
@@ -0,0 +1,16 @@
+use shibuya;
+
+-- plan_id = 0 means the rule is evaluated against the whole run
+CREATE TABLE IF NOT EXISTS collection_guard (
+    id INT unsigned NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    collection_id INT unsigned NOT NULL,
+    plan_id INT unsigned NOT NULL DEFAULT 0,
+    label VARCHAR(255) NOT NULL DEFAULT '',
+    rule VARCHAR(255) NOT NULL,
+    window_seconds INT unsigned NOT NULL DEFAULT 0,
+    for_seconds INT unsigned NOT NULL DEFAULT 0,
+    min_requests INT unsigned NOT NULL DEFAULT 0,
+    key (collection_id)
+)CHARSET=utf8mb4;
+
+ALTER TABLE collection_run_history ADD COLUMN abort_reason VARCHAR(255) NULL DEFAULT NULL;
@@ -0,0 +1,4 @@
+use shibuya;
+
+-- stages are stored as a json array. NULL means the plan uses the flat concurrency and rampup
+ALTER TABLE collection_plan ADD COLUMN stages TEXT NULL DEFAULT NULL;
@@ -0,0 +1,4 @@
+use shibuya;
+
+-- 0 means the plan is a closed model driven by the concurrency only
+ALTER TABLE collection_plan ADD COLUMN target_rps DOUBLE NOT NULL DEFAULT 0;
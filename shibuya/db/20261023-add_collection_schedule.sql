@@ -0,0 +1,28 @@
+use shibuya;
+
+-- next_run is stored in UTC. Controllers claim a due schedule by moving its next_run forward.
+CREATE TABLE IF NOT EXISTS collection_schedule (
+    id INT unsigned NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    collection_id INT unsigned NOT NULL,
+    cron VARCHAR(100) NOT NULL,
+    timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+    enabled TINYINT(1) NOT NULL DEFAULT 1,
+    created_by VARCHAR(50) NOT NULL,
+    next_run DATETIME NULL DEFAULT NULL,
+    created_time TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    key (collection_id),
+    key (enabled, next_run)
+)CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS collection_schedule_run (
+    id INT unsigned NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    schedule_id INT unsigned NOT NULL,
+    collection_id INT unsigned NOT NULL,
+    run_id INT unsigned NOT NULL DEFAULT 0,
+    status VARCHAR(20) NOT NULL,
+    message TEXT NULL,
+    started_time TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    end_time TIMESTAMP NULL DEFAULT NULL,
+    key (schedule_id),
+    key (collection_id)
+)CHARSET=utf8mb4;
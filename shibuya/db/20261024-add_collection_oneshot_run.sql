@@ -0,0 +1,16 @@
+use shibuya;
+
+-- A one-shot run deploys, triggers and purges a collection on behalf of the client
+CREATE TABLE IF NOT EXISTS collection_oneshot_run (
+    id INT unsigned NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    collection_id INT unsigned NOT NULL,
+    run_id INT unsigned NOT NULL DEFAULT 0,
+    phase VARCHAR(20) NOT NULL,
+    error TEXT NULL,
+    purge_after TINYINT(1) NOT NULL DEFAULT 1,
+    timeout_seconds INT unsigned NOT NULL,
+    created_by VARCHAR(50) NOT NULL,
+    started_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    end_time TIMESTAMP NULL DEFAULT NULL,
+    key (collection_id)
+)CHARSET=utf8mb4;
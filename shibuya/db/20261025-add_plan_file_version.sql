@@ -0,0 +1,33 @@
+use shibuya;
+
+-- Every upload of a plan file creates an immutable version. Versions are numbered per plan.
+CREATE TABLE IF NOT EXISTS plan_file_version (
+    id INT unsigned NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    plan_id INT unsigned NOT NULL,
+    version INT unsigned NOT NULL,
+    filename VARCHAR(191) NOT NULL,
+    test_file TINYINT(1) NOT NULL DEFAULT 0,
+    hash CHAR(64) NOT NULL,
+    uploaded_by VARCHAR(50) NOT NULL DEFAULT '',
+    created_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (plan_id, version),
+    key (plan_id, filename)
+)CHARSET=utf8mb4;
+
+-- 0 means the latest test file of the plan
+ALTER TABLE collection_plan ADD COLUMN test_file_version INT unsigned NOT NULL DEFAULT 0;
+
+-- The versions of the plan files used by a run. version = 0 means the file was uploaded before
+-- the files were versioned.
+CREATE TABLE IF NOT EXISTS run_plan_file (
+    id INT unsigned NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    run_id INT unsigned NOT NULL,
+    collection_id INT unsigned NOT NULL,
+    plan_id INT unsigned NOT NULL,
+    version INT unsigned NOT NULL DEFAULT 0,
+    filename VARCHAR(191) NOT NULL,
+    test_file TINYINT(1) NOT NULL DEFAULT 0,
+    hash CHAR(64) NOT NULL DEFAULT '',
+    key (run_id),
+    key (collection_id, run_id)
+)CHARSET=utf8mb4;
@@ -0,0 +1,16 @@
+use shibuya;
+
+ALTER TABLE project ADD COLUMN description VARCHAR(1024) NOT NULL DEFAULT '';
+ALTER TABLE collection ADD COLUMN description VARCHAR(1024) NOT NULL DEFAULT '';
+ALTER TABLE plan ADD COLUMN description VARCHAR(1024) NOT NULL DEFAULT '';
+
+-- Free-form tags of projects, collections and plans. kind is the kind of the resource.
+CREATE TABLE IF NOT EXISTS resource_tag (
+    id INT unsigned NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    kind VARCHAR(20) NOT NULL,
+    resource_id INT unsigned NOT NULL,
+    name VARCHAR(63) NOT NULL,
+    value VARCHAR(255) NOT NULL DEFAULT '',
+    UNIQUE (kind, resource_id, name),
+    key (kind, name, value)
+)CHARSET=utf8mb4;
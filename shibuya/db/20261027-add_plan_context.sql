@@ -0,0 +1,4 @@
+use shibuya;
+
+-- The context of the executor cluster the plan runs in. Empty is the default cluster.
+ALTER TABLE collection_plan ADD COLUMN context varchar(20) NOT NULL DEFAULT '';
@@ -0,0 +1,4 @@
+use shibuya;
+
+-- the overrides of the engine containers are stored as a json object. NULL means the plan uses the containers of its kind
+ALTER TABLE collection_plan ADD COLUMN overrides TEXT NULL DEFAULT NULL;
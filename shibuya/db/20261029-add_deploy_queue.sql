@@ -0,0 +1,12 @@
+use shibuya;
+
+-- the deployments waiting for the capacity of the clusters. created_by is the account the engines
+-- are deployed for once the collection is admitted.
+CREATE TABLE IF NOT EXISTS deploy_queue (
+    collection_id INT unsigned NOT NULL PRIMARY KEY,
+    priority INT NOT NULL DEFAULT 0,
+    created_by VARCHAR(50) NOT NULL,
+    message TEXT NULL,
+    created_time TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    key (priority, created_time)
+)CHARSET=utf8mb4;
@@ -0,0 +1,4 @@
+use shibuya;
+
+-- Scalable plans can change their concurrency during a run.
+ALTER TABLE collection_plan ADD COLUMN scalable tinyint(1) NOT NULL DEFAULT 0;
@@ -0,0 +1,5 @@
+use shibuya;
+
+-- a failed deployment leaves the queue but is kept with its message until the collection is
+-- deployed again or the deployment is cancelled
+ALTER TABLE deploy_queue ADD COLUMN failed tinyint(1) NOT NULL DEFAULT 0;
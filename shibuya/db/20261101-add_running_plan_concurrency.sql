@@ -0,0 +1,4 @@
+use shibuya;
+
+-- The concurrency a running plan is scaled to. 0 means the concurrency of the collection config.
+ALTER TABLE running_plan ADD COLUMN concurrency INT unsigned NOT NULL DEFAULT 0;
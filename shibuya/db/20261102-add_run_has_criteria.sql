@@ -0,0 +1,6 @@
+use shibuya;
+
+-- whether the collection had criteria when the run ran, so a run without a verdict is only reported
+-- as not judged when it should have been judged. The older runs had criteria when they got a verdict.
+ALTER TABLE collection_run_history ADD COLUMN has_criteria tinyint(1) NOT NULL DEFAULT 0;
+UPDATE collection_run_history SET has_criteria = 1 WHERE verdict IS NOT NULL;
@@ -1,12 +1,21 @@
 package agentserver
 
-import "os/exec"
+import (
+	"os"
+	"os/exec"
+)
 
 type Command struct {
 	Command string
 	Args    []string
+	// Env is appended to the environment of the agent process
+	Env []string
 }
 
 func (c Command) ToExec() *exec.Cmd {
-	return exec.Command(c.Command, c.Args...)
+	cmd := exec.Command(c.Command, c.Args...)
+	if len(c.Env) > 0 {
+		cmd.Env = append(os.Environ(), c.Env...)
+	}
+	return cmd
 }
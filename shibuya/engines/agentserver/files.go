@@ -31,7 +31,12 @@ func (af AgentDir) Dir() AgentDirectory {
 	return AgentDirectory(af.dir)
 }
 
+// TestFilesDir is /test-data unless TEST_DATA_DIR is set, which is needed when several engines run
+// on the same host
 func (af AgentDir) TestFilesDir() TestFilesDirectory {
+	if dir := os.Getenv("TEST_DATA_DIR"); dir != "" {
+		return TestFilesDirectory(dir)
+	}
 	return TestFilesDirectory(path.Join("", "/test-data"))
 }
 
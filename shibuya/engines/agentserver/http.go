@@ -1,10 +1,12 @@
 package agentserver
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rakutentech/shibuya/shibuya/coordinator/payload"
 	httpauth "github.com/rakutentech/shibuya/shibuya/http/auth"
 	httproute "github.com/rakutentech/shibuya/shibuya/http/route"
 )
@@ -27,6 +29,11 @@ func (as *AgentServer) HTTPRouter() *httproute.Router {
 			Method:      "GET",
 			HandlerFunc: promhttp.Handler().ServeHTTP,
 		},
+		{
+			Path:        "/messages",
+			Method:      "POST",
+			HandlerFunc: as.handleMessage,
+		},
 	})
 	return router
 }
@@ -34,8 +41,12 @@ func (as *AgentServer) HTTPRouter() *httproute.Router {
 func (as *AgentServer) startHTTPServer() error {
 	router := as.HTTPRouter()
 	handlers := http.Handler(router.Mux())
+	addr := as.options.EngineMeta.ListenAddr
+	if addr == "" {
+		addr = ":8080"
+	}
 	// Running in http mode should be ok because engines are never directly exposed to public network
-	return http.ListenAndServe(":8080",
+	return http.ListenAndServe(addr,
 		httpauth.AuthRequiredWithToken(handlers, as.options.EngineMeta.APIKey))
 }
 
@@ -47,6 +58,26 @@ func (as *AgentServer) handleProcessCheck(w http.ResponseWriter, _ *http.Request
 	w.WriteHeader(http.StatusNotFound)
 }
 
+// handleMessage receives the messages pushed by the coordinator, which are handled like the ones of
+// the pubsub server
+func (as *AgentServer) handleMessage(w http.ResponseWriter, r *http.Request) {
+	if !as.options.EngineMeta.PushMessages {
+		http.Error(w, "the engine subscribes to the coordinator", http.StatusNotFound)
+		return
+	}
+	pl := new(payload.Payload)
+	if err := json.NewDecoder(r.Body).Decode(pl); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	select {
+	case as.messages <- pl:
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		http.Error(w, "too many messages are waiting", http.StatusServiceUnavailable)
+	}
+}
+
 func (as *AgentServer) StreamHandler(w http.ResponseWriter, r *http.Request) {
 	messageChan := make(chan string)
 	flusher, ok := w.(http.Flusher)
@@ -7,6 +7,7 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
 	"sync"
 	"time"
@@ -46,6 +47,8 @@ type AgentServer struct {
 	runID           int64
 	mu              sync.RWMutex
 	processLock     sync.RWMutex
+	// messages are the messages pushed by the coordinator by http
+	messages chan messages.Message
 }
 
 func NewAgentServer(opts AgentServerOptions) *AgentServer {
@@ -68,6 +71,7 @@ func NewAgentServer(opts AgentServerOptions) *AgentServer {
 		writer:          mw,
 		logger:          opts.Logger,
 		angentDir:       NewAgentDirHandler(""),
+		messages:        make(chan messages.Message, 10),
 	}
 	log.SetOutput(mw)
 	return as
@@ -115,25 +119,46 @@ func (as *AgentServer) makePromMetrics(line string) {
 	metric.ToPrometheus()
 }
 
-func (as *AgentServer) tailFunc(filepath string) {
+// Some engines(for example, Gatling) write the results into a folder generated per run, so the result
+// file could be a glob pattern. We tail the first matched file.
+func resolveResultFile(pattern string) string {
+	matches, err := filepath.Glob(pattern)
+	if err != nil || len(matches) == 0 {
+		return pattern
+	}
+	return matches[0]
+}
+
+func (as *AgentServer) tailFunc(pattern string) {
 	var t *tail.Tail
 	var err error
+	var resultFile string
 	for {
-		t, err = tail.TailFile(filepath, tail.Config{MustExist: true, Follow: true, Poll: true})
+		select {
+		case <-as.ctx.Done():
+			as.logger.Infof("Test finished before the result file %s is created", pattern)
+			return
+		default:
+		}
+		resultFile = resolveResultFile(pattern)
+		t, err = tail.TailFile(resultFile, tail.Config{MustExist: true, Follow: true, Poll: true})
 		if err != nil {
 			time.Sleep(time.Second)
 			continue
 		}
 		break
 	}
-	as.logger.Infof("Start tailing result file %s", filepath)
+	as.logger.Infof("Start tailing result file %s", resultFile)
 	for {
 		select {
 		case <-as.ctx.Done():
 			t.Stop()
-			as.logger.Infof("Stop tailing the result file %s", filepath)
+			as.logger.Infof("Stop tailing the result file %s", resultFile)
 			return
 		case line := <-t.Lines:
+			if filter := as.options.LineFilter; filter != nil && !filter(line.Text) {
+				continue
+			}
 			as.bus <- line.Text
 		}
 	}
@@ -171,8 +196,11 @@ func (as *AgentServer) reportOwnMetrics(interval time.Duration) error {
 
 func (as *AgentServer) SubscribeToCoordinator() (chan messages.Message, error) {
 	collectionID := as.options.EngineMeta.CollectionID
-	client := &client.PubSubClient{Addr: fmt.Sprintf("%s:2416", as.reqOpts.Endpoint),
-		Password: as.reqOpts.APIKey}
+	addr := as.options.EngineMeta.PubSubAddr
+	if addr == "" {
+		addr = fmt.Sprintf("%s:2416", as.reqOpts.Endpoint)
+	}
+	client := &client.PubSubClient{Addr: addr, Password: as.reqOpts.APIKey}
 	msgChan, _, err := client.Subscribe(fmt.Sprintf("collection:%s", collectionID), &payload.Payload{})
 	if err != nil {
 		return nil, err
@@ -247,7 +275,7 @@ func (as *AgentServer) finishCommand() {
 	}
 }
 
-func (as *AgentServer) runCommand(runID int64) error {
+func (as *AgentServer) runCommand(runID int64, startCommand Command) error {
 	// command will wait for the shutdown signal. Once it's done, the command
 	// func should finish
 	resultDir := as.angentDir.ResultFilesDir()
@@ -257,7 +285,7 @@ func (as *AgentServer) runCommand(runID int64) error {
 			return err
 		}
 	}
-	command := as.options.StartCommand.ToExec()
+	command := startCommand.ToExec()
 	as.logger.Infof("command is %s", command.String())
 	command.Stderr = as.writer
 	if err := command.Start(); err != nil {
@@ -312,7 +340,25 @@ func (as *AgentServer) handleStart(payload *payload.EngineMessage) error {
 			return err
 		}
 	}
-	return as.runCommand(payload.RunID)
+	startCommand := as.options.StartCommand
+	if as.options.PrepareCommand != nil {
+		startCommand, err = as.options.PrepareCommand(startCommand)
+		if err != nil {
+			return err
+		}
+	}
+	return as.runCommand(payload.RunID, startCommand)
+}
+
+func (as *AgentServer) handleScale(payload *payload.EngineMessage) error {
+	if as.options.ScaleCommand == nil {
+		return fmt.Errorf("the engine does not support changing the concurrency during a run")
+	}
+	if as.getProcess() == nil {
+		return fmt.Errorf("there is no running test to scale")
+	}
+	as.logger.Infof("Changing the concurrency to %d", payload.Concurrency)
+	return as.options.ScaleCommand(payload.Concurrency)
 }
 
 func (as *AgentServer) listenToCoordinator(msgChan chan messages.Message) {
@@ -331,6 +377,13 @@ func (as *AgentServer) listenToCoordinator(msgChan chan messages.Message) {
 				continue
 			}
 			as.stopTestByCancel()
+		case "scale":
+			if planMsg == nil {
+				continue
+			}
+			if err := as.handleScale(planMsg); err != nil {
+				as.logger.Error(err)
+			}
 		}
 	}
 }
@@ -341,6 +394,14 @@ type EngineMeta struct {
 	PlanID        string
 	EngineID      int
 	APIKey        string
+	// ListenAddr is the address the agent serves on. It defaults to :8080.
+	ListenAddr string
+	// PubSubAddr is the address of the pubsub server of the coordinator. It defaults to port 2416
+	// of the coordinator.
+	PubSubAddr string
+	// PushMessages makes the agent receive the messages pushed by the coordinator by http instead of
+	// subscribing to the pubsub server
+	PushMessages bool
 }
 
 func (em EngineMeta) MakeReqOpts() cdrclient.ReqOpts {
@@ -356,9 +417,19 @@ type AgentServerOptions struct {
 	StartCommand Command
 	StopCommand  *Command
 	MetricParser func(string) (enginesModel.ShibuyaMetric, error)
+	// LineFilter drops the lines of the result file the subscribers do not need, so they are not
+	// streamed to the controller. All the lines are streamed without it.
+	LineFilter   func(string) bool
 	ResultFile   string
 	Logger       *log.Entry
 	ConfFileName string
+	// PrepareCommand is called after all the files are fetched from the coordinator and
+	// before the start command is executed. Engines can use it to lay out the test files
+	// or to derive the command from the downloaded conf file.
+	PrepareCommand func(Command) (Command, error)
+	// ScaleCommand changes the concurrency of the running test. Engines without it cannot be scaled
+	// during a run.
+	ScaleCommand func(concurrency int) error
 }
 
 func MakeAgentServer(options AgentServerOptions) *AgentServer {
@@ -378,16 +449,20 @@ func (as *AgentServer) Run() error {
 			options.Logger.Fatal(err)
 		}
 	}()
-	go func() {
-		for {
-			time.Sleep(2 * time.Second)
-			msgChan, err := as.SubscribeToCoordinator()
-			if err != nil {
-				continue
+	if options.EngineMeta.PushMessages {
+		go as.listenToCoordinator(as.messages)
+	} else {
+		go func() {
+			for {
+				time.Sleep(2 * time.Second)
+				msgChan, err := as.SubscribeToCoordinator()
+				if err != nil {
+					continue
+				}
+				as.listenToCoordinator(msgChan)
 			}
-			as.listenToCoordinator(msgChan)
-		}
-	}()
+		}()
+	}
 	go as.handleMetricStream()
 	return as.startHTTPServer()
 }
@@ -403,5 +478,8 @@ func FetchEngineMeta() EngineMeta {
 		PlanID:        os.Getenv("plan_id"),
 		EngineID:      engineID,
 		APIKey:        os.Getenv("api_key"),
+		ListenAddr:    os.Getenv("listen_addr"),
+		PubSubAddr:    os.Getenv("coordinator_pubsub_addr"),
+		PushMessages:  os.Getenv("push_messages") == "true",
 	}
 }
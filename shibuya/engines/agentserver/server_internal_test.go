@@ -0,0 +1,37 @@
+package agentserver
+
+import (
+	"context"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTailFilter(t *testing.T) {
+	resultFile := path.Join(t.TempDir(), "result.json")
+	assert.Nil(t, os.WriteFile(resultFile, []byte("keep 1\ndrop 2\nkeep 3\n"), 0644))
+	as := MakeAgentServer(AgentServerOptions{
+		LineFilter: func(line string) bool {
+			return strings.HasPrefix(line, "keep")
+		},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	as.assignCtx(ctx, cancel)
+	go as.tailFunc(resultFile)
+
+	lines := []string{}
+	for len(lines) < 2 {
+		select {
+		case line := <-as.bus:
+			lines = append(lines, line)
+		case <-time.After(5 * time.Second):
+			t.Fatal("lines are not tailed")
+		}
+	}
+	assert.Equal(t, []string{"keep 1", "keep 3"}, lines)
+}
@@ -1,9 +1,13 @@
 package agentserver_test
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/rakutentech/shibuya/shibuya/engines/agentserver"
+	"github.com/stretchr/testify/assert"
 )
 
 func startDummyAgent(startCommand agentserver.Command, stopCommand *agentserver.Command) error {
@@ -43,3 +47,23 @@ func TestSever(t *testing.T) {
 		startDummyAgent(startCommand, nil)
 	}()
 }
+
+func TestHandleMessage(t *testing.T) {
+	post := func(as *agentserver.AgentServer, body string) int {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/messages", strings.NewReader(body))
+		as.HTTPRouter().Mux().ServeHTTP(rec, req)
+		return rec.Code
+	}
+	options := agentserver.AgentServerOptions{
+		EngineMeta: agentserver.EngineMeta{PlanID: "1", PushMessages: true},
+	}
+	as := agentserver.MakeAgentServer(options)
+	assert.Equal(t, http.StatusAccepted, post(as, `{"verb": "stop", "plan_message": {"1": {}}}`))
+	assert.Equal(t, http.StatusBadRequest, post(as, "stop"))
+
+	// the engines subscribing to the pubsub server do not take the pushed messages
+	options.EngineMeta.PushMessages = false
+	as = agentserver.MakeAgentServer(options)
+	assert.Equal(t, http.StatusNotFound, post(as, `{"verb": "stop"}`))
+}
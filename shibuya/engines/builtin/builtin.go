@@ -0,0 +1,10 @@
+// Package builtin registers all the engines shipped with shibuya. Binaries that look engines up
+// from the registry should blank import this package.
+package builtin
+
+import (
+	_ "github.com/rakutentech/shibuya/shibuya/engines/gatling"
+	_ "github.com/rakutentech/shibuya/shibuya/engines/jmeter"
+	_ "github.com/rakutentech/shibuya/shibuya/engines/k6"
+	_ "github.com/rakutentech/shibuya/shibuya/engines/locust"
+)
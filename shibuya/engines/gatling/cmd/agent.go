@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/rakutentech/shibuya/shibuya/engines/agentserver"
+	"github.com/rakutentech/shibuya/shibuya/engines/gatling"
+)
+
+const (
+	// The uploaded file could be a scala/java source or a zip bundle. The real name is restored
+	// from the conf file before the test starts.
+	TEST_FILE_NAME   = "simulation.upload"
+	GATLING_BIN      = "/opt/gatling/bin/gatling.sh"
+	SIMULATIONS_NAME = "simulations"
+	RESULT_FILE_NAME = "simulation.log"
+)
+
+var (
+	agentDir        = agentserver.NewAgentDirHandler("")
+	CONF_FILE       = agentDir.ConfFilesDir().Filepath(gatling.ConfFileName)
+	TEST_FILE       = agentDir.TestFilesDir().Filepath(TEST_FILE_NAME)
+	SIMULATIONS_DIR = agentDir.Dir().Filepath(SIMULATIONS_NAME)
+	RESULTS_DIR     = agentDir.ResultFilesDir().ResultFile()
+	// Gatling creates a new folder for every run inside the results folder
+	RESULT_FILE = agentDir.ResultFilesDir().ResultFile("*", RESULT_FILE_NAME)
+)
+
+func resetDir(dir string) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	return os.MkdirAll(dir, 0700)
+}
+
+// Data files are put in the test data folder, which is used as the resources folder of Gatling
+// so feeders can find them by their names.
+func prepareCommand(command agentserver.Command) (agentserver.Command, error) {
+	content, err := os.ReadFile(CONF_FILE)
+	if err != nil {
+		return command, err
+	}
+	conf := new(gatling.Conf)
+	if err := json.Unmarshal(content, conf); err != nil {
+		return command, err
+	}
+	if err := resetDir(SIMULATIONS_DIR); err != nil {
+		return command, err
+	}
+	if err := resetDir(RESULTS_DIR); err != nil {
+		return command, err
+	}
+	if gatling.IsBundle(conf.TestFile) {
+		if err := gatling.ExtractBundle(TEST_FILE, SIMULATIONS_DIR); err != nil {
+			return command, err
+		}
+		if err := os.Remove(TEST_FILE); err != nil {
+			return command, err
+		}
+	} else {
+		if err := os.Rename(TEST_FILE, filepath.Join(SIMULATIONS_DIR, filepath.Base(conf.TestFile))); err != nil {
+			return command, err
+		}
+	}
+	command.Env = []string{"JAVA_OPTS=" + conf.JavaOpts}
+	return command, nil
+}
+
+func main() {
+	engineMeta := agentserver.FetchEngineMeta()
+	startCommand := agentserver.Command{
+		Command: GATLING_BIN,
+		Args: []string{"-sf", SIMULATIONS_DIR, "-rsf", string(agentDir.TestFilesDir()), "-rf", RESULTS_DIR,
+			"-nr", "-rm", "local", "-rd", "shibuya"},
+	}
+	options := agentserver.AgentServerOptions{
+		TestFileName:   TEST_FILE_NAME,
+		StartCommand:   startCommand,
+		PrepareCommand: prepareCommand,
+		EngineMeta:     engineMeta,
+		MetricParser:   gatling.Engine{}.MetricParser(),
+		ConfFileName:   gatling.ConfFileName,
+		ResultFile:     RESULT_FILE,
+	}
+	as := agentserver.MakeAgentServer(options)
+	if err := as.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
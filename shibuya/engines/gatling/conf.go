@@ -0,0 +1,168 @@
+package gatling
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/rakutentech/shibuya/shibuya/coordinator/storage"
+	enginesModel "github.com/rakutentech/shibuya/shibuya/engines/model"
+)
+
+const (
+	ConfFileName = "gatling.json"
+)
+
+var (
+	simulationExtensions = []string{".scala", ".java"}
+	NoSimulationErr      = errors.New("no simulation source(.scala or .java) is found in the bundle")
+)
+
+// Conf is generated by the coordinator and consumed by the agent.
+// Gatling does not read the load settings from a file so we pass them to the simulation as java system
+// properties. Users should read them inside the simulation, for example:
+// Integer.getInteger("users", 1), Integer.getInteger("duration", 60) and Integer.getInteger("rampup", 0)
+// When the plan has stages, they are also passed as `stages`, a comma separated list of
+// seconds:target pairs such as 300:100,600:500, which simulations can turn into rampConcurrentUsers steps.
+// The target rps of the engine is passed as `rps` for simulations using an open injection profile or throttling.
+type Conf struct {
+	// TestFile is the original name of the uploaded test file. The agent needs it to restore the
+	// extension of the simulation or to know the file is a bundle.
+	TestFile string `json:"test_file"`
+	JavaOpts string `json:"java_opts"`
+}
+
+func IsBundle(filename string) bool {
+	return strings.HasSuffix(filename, ".zip")
+}
+
+func isSimulation(filename string) bool {
+	for _, e := range simulationExtensions {
+		if strings.HasSuffix(filename, e) {
+			return true
+		}
+	}
+	return false
+}
+
+func makeJavaOpts(pec enginesModel.PlanEnginesConfig) (string, error) {
+	duration, err := strconv.Atoi(pec.Duration)
+	if err != nil {
+		return "", err
+	}
+	concurrency, err := strconv.Atoi(pec.Concurrency)
+	if err != nil {
+		return "", err
+	}
+	rampup, err := strconv.Atoi(pec.Rampup)
+	if err != nil {
+		return "", err
+	}
+	opts := fmt.Sprintf("-Dusers=%d -Dduration=%d -Drampup=%d", concurrency, duration*60, rampup)
+	if pec.TargetRPS > 0 {
+		opts = fmt.Sprintf("%s -Drps=%s", opts, strconv.FormatFloat(pec.TargetRPS, 'f', -1, 64))
+	}
+	if len(pec.Stages) > 0 {
+		stages := make([]string, len(pec.Stages))
+		for i, s := range pec.Stages {
+			seconds, err := s.Seconds()
+			if err != nil {
+				return "", err
+			}
+			stages[i] = fmt.Sprintf("%d:%d", seconds, s.Target)
+		}
+		opts = fmt.Sprintf("%s -Dstages=%s", opts, strings.Join(stages, ","))
+	}
+	return opts, nil
+}
+
+func validateBundle(fileBytes []byte) error {
+	r, err := zip.NewReader(bytes.NewReader(fileBytes), int64(len(fileBytes)))
+	if err != nil {
+		return err
+	}
+	for _, f := range r.File {
+		if isSimulation(f.Name) {
+			return nil
+		}
+	}
+	return NoSimulationErr
+}
+
+// ExtractBundle unzips the bundle into dst. Entries pointing outside of dst are rejected.
+func ExtractBundle(src, dst string) error {
+	r, err := zip.OpenReader(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	root := filepath.Clean(dst) + string(os.PathSeparator)
+	for _, f := range r.File {
+		target := filepath.Join(dst, f.Name)
+		if !strings.HasPrefix(target, root) {
+			return fmt.Errorf("illegal file path in the bundle: %s", f.Name)
+		}
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0700); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+			return err
+		}
+		if err := extractFile(f, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractFile(f *zip.File, target string) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = io.Copy(out, rc)
+	return err
+}
+
+func writeConfig(filepath, filename string, pec enginesModel.PlanEnginesConfig) error {
+	javaOpts, err := makeJavaOpts(pec)
+	if err != nil {
+		return err
+	}
+	content, err := json.Marshal(Conf{TestFile: filename, JavaOpts: javaOpts})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath, content, 0600)
+}
+
+func MakeTestPlan(pf *storage.PlanFiles, planName, filename string, fileBytes []byte, pec enginesModel.PlanEnginesConfig) error {
+	if IsBundle(filename) {
+		if err := validateBundle(fileBytes); err != nil {
+			return err
+		}
+	}
+	if err := pf.StoreTestPlan(filename, fileBytes); err != nil {
+		return err
+	}
+	if err := writeConfig(pf.TestFilePath(ConfFileName), filename, pec); err != nil {
+		return err
+	}
+	return nil
+}
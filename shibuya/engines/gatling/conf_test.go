@@ -0,0 +1,71 @@
+package gatling
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	enginesModel "github.com/rakutentech/shibuya/shibuya/engines/model"
+	"github.com/rakutentech/shibuya/shibuya/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func makeBundle(t *testing.T, files ...string) []byte {
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	for _, f := range files {
+		fw, err := w.Create(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte("content"))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestMakeJavaOpts(t *testing.T) {
+	pec := enginesModel.PlanEnginesConfig{
+		Duration:    "5",
+		Concurrency: "10",
+		Rampup:      "60",
+	}
+	opts, err := makeJavaOpts(pec)
+	assert.Nil(t, err)
+	assert.Equal(t, "-Dusers=10 -Dduration=300 -Drampup=60", opts)
+
+	pec.Stages = []*model.Stage{{Duration: "5m", Target: 100}, {Duration: "30s", Target: 0}}
+	opts, err = makeJavaOpts(pec)
+	assert.Nil(t, err)
+	assert.Equal(t, "-Dusers=10 -Dduration=300 -Drampup=60 -Dstages=300:100,30:0", opts)
+
+	pec.Stages = nil
+	pec.TargetRPS = 2.5
+	opts, err = makeJavaOpts(pec)
+	assert.Nil(t, err)
+	assert.Equal(t, "-Dusers=10 -Dduration=300 -Drampup=60 -Drps=2.5", opts)
+
+	pec.Duration = "abc"
+	_, err = makeJavaOpts(pec)
+	assert.NotNil(t, err)
+}
+
+func TestBundle(t *testing.T) {
+	assert.Nil(t, validateBundle(makeBundle(t, "sim/BasicSimulation.scala", "data.csv")))
+	assert.Equal(t, NoSimulationErr, validateBundle(makeBundle(t, "data.csv")))
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "bundle.zip")
+	os.WriteFile(src, makeBundle(t, "sim/BasicSimulation.scala"), 0600)
+	dst := filepath.Join(dir, "out")
+	assert.Nil(t, ExtractBundle(src, dst))
+	_, err := os.Stat(filepath.Join(dst, "sim/BasicSimulation.scala"))
+	assert.Nil(t, err)
+
+	os.WriteFile(src, makeBundle(t, "../evil.scala"), 0600)
+	assert.NotNil(t, ExtractBundle(src, dst))
+}
@@ -0,0 +1,36 @@
+package gatling
+
+import (
+	"github.com/rakutentech/shibuya/shibuya/config"
+	"github.com/rakutentech/shibuya/shibuya/coordinator/storage"
+	"github.com/rakutentech/shibuya/shibuya/engines/gatling/metrics"
+	enginesModel "github.com/rakutentech/shibuya/shibuya/engines/model"
+	"github.com/rakutentech/shibuya/shibuya/engines/registry"
+	"github.com/rakutentech/shibuya/shibuya/model"
+)
+
+type Engine struct{}
+
+func init() {
+	registry.Register(Engine{})
+}
+
+func (Engine) Kind() model.PlanKind {
+	return model.GatlingPlan
+}
+
+func (Engine) TestFileExtensions() []string {
+	return []string{".scala", ".java", ".zip"}
+}
+
+func (Engine) MakeTestPlan(pf *storage.PlanFiles, planName, filename string, fileBytes []byte, pec enginesModel.PlanEnginesConfig) error {
+	return MakeTestPlan(pf, planName, filename, fileBytes, pec)
+}
+
+func (Engine) DefaultContainer() *config.ExecutorContainer {
+	return &config.ExecutorContainer{Image: "shibuya:gatling", CPU: "1", Mem: "2Gi"}
+}
+
+func (Engine) MetricParser() func(string) (enginesModel.ShibuyaMetric, error) {
+	return metrics.NewParser().ParseRawMetrics
+}
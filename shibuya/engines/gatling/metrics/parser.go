@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	enginesModel "github.com/rakutentech/shibuya/shibuya/engines/model"
+)
+
+// Gatling(3.9 and before) writes the records into simulation.log as tab separated lines. For example:
+// USER	scenario	START	1700000000100
+// REQUEST		request_1	1700000000200	1700000000350	OK
+// REQUEST		request_2	1700000000400	1700000000500	KO	status.find.in(200,201), but actually found 404
+// USER	scenario	END	1700000000900
+// The second column of a request record is the group hierarchy, which could be empty.
+var (
+	ignoredLineErr = errors.New("line is not a request record")
+	statusCodeRe   = regexp.MustCompile(`found (\d{3})`)
+)
+
+// Parser counts the active users from the USER records as Gatling does not attach the number of
+// users to every request record.
+type Parser struct {
+	users atomic.Int64
+}
+
+func NewParser() *Parser {
+	return &Parser{}
+}
+
+// Gatling does not record the status code in the request record. A successful request is treated
+// as 200 and the status code of a failed one is extracted from the check message if possible.
+func extractStatus(status, message string) string {
+	if status == "OK" {
+		return "200"
+	}
+	if m := statusCodeRe.FindStringSubmatch(message); m != nil {
+		return m[1]
+	}
+	return status
+}
+
+func (p *Parser) ParseRawMetrics(rawLine string) (enginesModel.ShibuyaMetric, error) {
+	items := strings.Split(rawLine, "\t")
+	switch items[0] {
+	case "USER":
+		if len(items) < 3 {
+			return enginesModel.ShibuyaMetric{}, fmt.Errorf("invalid user record %s", rawLine)
+		}
+		switch items[2] {
+		case "START":
+			p.users.Add(1)
+		case "END":
+			p.users.Add(-1)
+		}
+		return enginesModel.ShibuyaMetric{}, ignoredLineErr
+	case "REQUEST":
+		if len(items) < 6 {
+			return enginesModel.ShibuyaMetric{}, fmt.Errorf("invalid request record %s", rawLine)
+		}
+		start, err := strconv.ParseInt(items[3], 10, 64)
+		if err != nil {
+			return enginesModel.ShibuyaMetric{}, err
+		}
+		end, err := strconv.ParseInt(items[4], 10, 64)
+		if err != nil {
+			return enginesModel.ShibuyaMetric{}, err
+		}
+		message := ""
+		if len(items) > 6 {
+			message = items[6]
+		}
+		return enginesModel.ShibuyaMetric{
+			Threads: float64(p.users.Load()),
+			Label:   items[2],
+			Status:  extractStatus(items[5], message),
+			Latency: float64(end - start),
+			Raw:     rawLine,
+		}, nil
+	}
+	return enginesModel.ShibuyaMetric{}, ignoredLineErr
+}
@@ -0,0 +1,41 @@
+package metrics_test
+
+import (
+	"testing"
+
+	"github.com/rakutentech/shibuya/shibuya/engines/gatling/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricParsing(t *testing.T) {
+	parser := metrics.NewParser()
+	for _, line := range []string{"USER\tscenario\tSTART\t1700000000100", "USER\tscenario\tSTART\t1700000000110"} {
+		_, err := parser.ParseRawMetrics(line)
+		assert.NotNil(t, err)
+	}
+
+	line := "REQUEST\t\t/asdf\t1700000000200\t1700000000350\tOK\t "
+	metric, err := parser.ParseRawMetrics(line)
+	assert.Nil(t, err)
+	assert.Equal(t, "200", metric.Status)
+	assert.Equal(t, float64(2), metric.Threads)
+	assert.Equal(t, "/asdf", metric.Label)
+	assert.Equal(t, float64(150), metric.Latency)
+
+	_, err = parser.ParseRawMetrics("USER\tscenario\tEND\t1700000000400")
+	assert.NotNil(t, err)
+
+	line = "REQUEST\tgroup\t/asdf\t1700000000400\t1700000000500\tKO\tstatus.find.in(200,201), but actually found 404"
+	metric, err = parser.ParseRawMetrics(line)
+	assert.Nil(t, err)
+	assert.Equal(t, "404", metric.Status)
+	assert.Equal(t, float64(1), metric.Threads)
+
+	line = "REQUEST\t\t/asdf\t1700000000400\t1700000000500\tKO\tj.n.ConnectException: Connection refused"
+	metric, err = parser.ParseRawMetrics(line)
+	assert.Nil(t, err)
+	assert.Equal(t, "KO", metric.Status)
+
+	_, err = parser.ParseRawMetrics("RUN\tBasicSimulation\tbasicsimulation\t1700000000000\t \t3.9.5")
+	assert.NotNil(t, err)
+}
@@ -2,11 +2,13 @@ package main
 
 import (
 	"log"
+	"os"
+	"strconv"
 
 	_ "go.uber.org/automaxprocs"
 
 	"github.com/rakutentech/shibuya/shibuya/engines/agentserver"
-	"github.com/rakutentech/shibuya/shibuya/engines/jmeter/metrics"
+	"github.com/rakutentech/shibuya/shibuya/engines/jmeter"
 )
 
 var (
@@ -20,14 +22,26 @@ var (
 	JMETER_SHUTDOWN   = agentDir.Dir().Filepath(JMETER_BIN_FOLER, "stoptest.sh")
 	JMX_FILEPATH      = agentDir.TestFilesDir().Filepath(JMX_FILENAME)
 	RESULT_FILE       = agentDir.ResultFilesDir().ResultFile(RESULT_FILE_NAME)
+	CONCURRENCY_FILE  = agentDir.ConfFilesDir().Filepath(jmeter.ConcurrencyFileName)
 )
 
+// scale rewrites the concurrency file read by the thread groups. The file is replaced atomically so
+// jmeter never reads a partially written number.
+func scale(concurrency int) error {
+	tmp := CONCURRENCY_FILE + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.Itoa(concurrency)), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, CONCURRENCY_FILE)
+}
+
 func main() {
 	engineMeta := agentserver.FetchEngineMeta()
 	startCommand := agentserver.Command{
 		Command: JMETER_EXECUTABLE,
 		Args: []string{"-n", "-t", JMX_FILEPATH, "-l", RESULT_FILE, "-q",
-			PROPERTY_FILE, "-G", PROPERTY_FILE, "-j", agentserver.STDERR},
+			PROPERTY_FILE, "-G", PROPERTY_FILE, "-j", agentserver.STDERR,
+			"-J" + jmeter.ConcurrencyFileProperty + "=" + CONCURRENCY_FILE},
 	}
 	stopCommand := &agentserver.Command{
 		Command: JMETER_SHUTDOWN,
@@ -35,10 +49,12 @@ func main() {
 	options := agentserver.AgentServerOptions{
 		TestFileName: JMX_FILENAME,
 		EngineMeta:   engineMeta,
-		MetricParser: metrics.ParseRawMetrics,
+		MetricParser: jmeter.Engine{}.MetricParser(),
 		StopCommand:  stopCommand,
 		StartCommand: startCommand,
 		ResultFile:   RESULT_FILE,
+		ConfFileName: jmeter.ConcurrencyFileName,
+		ScaleCommand: scale,
 	}
 	as := agentserver.MakeAgentServer(options)
 	if err := as.Run(); err != nil {
@@ -2,14 +2,17 @@ package jmeter
 
 import (
 	"errors"
+	"fmt"
 	"strconv"
 
 	"github.com/beevik/etree"
 	"github.com/rakutentech/shibuya/shibuya/coordinator/storage"
 	enginesModel "github.com/rakutentech/shibuya/shibuya/engines/model"
+	"github.com/rakutentech/shibuya/shibuya/model"
 )
 
-func getThreadGroups(planDoc *etree.Document) ([]*etree.Element, error) {
+// getTestPlanTree returns the hash tree holding the elements of the test plan
+func getTestPlanTree(planDoc *etree.Document) (*etree.Element, error) {
 	jtp := planDoc.SelectElement("jmeterTestPlan")
 	if jtp == nil {
 		return nil, errors.New("Missing Jmeter Test plan in jmx")
@@ -22,6 +25,14 @@ func getThreadGroups(planDoc *etree.Document) ([]*etree.Element, error) {
 	if ht == nil {
 		return nil, errors.New("Missing hash tree inside hash tree in jmx")
 	}
+	return ht, nil
+}
+
+func getThreadGroups(planDoc *etree.Document) ([]*etree.Element, error) {
+	ht, err := getTestPlanTree(planDoc)
+	if err != nil {
+		return nil, err
+	}
 	tgs := ht.SelectElements("ThreadGroup")
 	stgs := ht.SelectElements("SetupThreadGroup")
 	tgs = append(tgs, stgs...)
@@ -36,6 +47,181 @@ func parseTestPlan(file []byte) (*etree.Document, error) {
 	return doc, nil
 }
 
+const (
+	// ConcurrencyFileName holds the concurrency of every thread group. It's generated along with the
+	// test plan and rewritten by the agent when the plan is scaled.
+	ConcurrencyFileName = "concurrency"
+	// ConcurrencyFileProperty is set by the agent to the path of the concurrency file
+	ConcurrencyFileProperty = "shibuya.concurrency.file"
+)
+
+// utgRow is a row of the Ultimate Thread Group schedule. Every row starts its own threads so the
+// concurrency at any moment is the sum of the active rows. All the values are in seconds.
+type utgRow struct {
+	threads  int
+	delay    int
+	startup  int
+	hold     int
+	shutdown int
+}
+
+// makeUTGRows translates the stages into Ultimate Thread Group rows. An increase of the target adds a
+// row that starts the missing threads during the stage. A decrease stops the most recently started
+// rows one after another during the stage, splitting a row when only part of it needs to stop.
+func makeUTGRows(stages []*model.Stage) ([]*utgRow, error) {
+	rows := []*utgRow{}
+	active := []*utgRow{}
+	current, elapsed := 0, 0
+	for _, s := range stages {
+		seconds, err := s.Seconds()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case s.Target > current:
+			row := &utgRow{threads: s.Target - current, delay: elapsed, startup: seconds}
+			rows = append(rows, row)
+			active = append(active, row)
+		case s.Target < current:
+			toStop := current - s.Target
+			stopAt := float64(elapsed)
+			for toStop > 0 {
+				row := active[len(active)-1]
+				if row.threads > toStop {
+					// keep the remaining threads in a new row with the same start
+					rest := &utgRow{threads: row.threads - toStop, delay: row.delay, startup: row.startup}
+					rows = append(rows, rest)
+					active[len(active)-1] = rest
+					row.threads = toStop
+				} else {
+					active = active[:len(active)-1]
+				}
+				shutdown := float64(seconds) * float64(row.threads) / float64(current-s.Target)
+				row.hold = int(stopAt+0.5) - row.delay - row.startup
+				row.shutdown = int(stopAt+shutdown+0.5) - int(stopAt+0.5)
+				stopAt += shutdown
+				toStop -= row.threads
+			}
+		}
+		current = s.Target
+		elapsed += seconds
+	}
+	for _, row := range active {
+		row.hold = elapsed - row.delay - row.startup
+	}
+	return rows, nil
+}
+
+func addStringProp(parent *etree.Element, name, value string) {
+	p := parent.CreateElement("stringProp")
+	p.CreateAttr("name", name)
+	p.SetText(value)
+}
+
+// rewriteThreadGroup changes the class of a thread group in place and drops the settings of the
+// standard thread group. The samplers of the thread group live in the following hashTree so they are
+// not affected. The new classes come from the Custom Thread Groups plugin.
+func rewriteThreadGroup(tg *etree.Element, class string) {
+	tg.Tag = class
+	tg.CreateAttr("guiclass", class+"Gui")
+	tg.CreateAttr("testclass", class)
+	for _, child := range tg.ChildElements() {
+		switch child.SelectAttrValue("name", "") {
+		case "ThreadGroup.main_controller", "ThreadGroup.on_sample_error":
+		default:
+			tg.RemoveChild(child)
+		}
+	}
+}
+
+func toUltimateThreadGroup(tg *etree.Element, rows []*utgRow) {
+	rewriteThreadGroup(tg, "kg.apc.jmeter.threads.UltimateThreadGroup")
+	data := tg.CreateElement("collectionProp")
+	data.CreateAttr("name", "ultimatethreadgroupdata")
+	for i, row := range rows {
+		r := data.CreateElement("collectionProp")
+		r.CreateAttr("name", strconv.Itoa(i))
+		addStringProp(r, "threads", strconv.Itoa(row.threads))
+		addStringProp(r, "delay", strconv.Itoa(row.delay))
+		addStringProp(r, "startup", strconv.Itoa(row.startup))
+		addStringProp(r, "hold", strconv.Itoa(row.hold))
+		addStringProp(r, "shutdown", strconv.Itoa(row.shutdown))
+	}
+	// the schedule decides when the threads stop so the loop should never end the test earlier
+	for _, ep := range tg.SelectElements("elementProp") {
+		if ep.SelectAttrValue("name", "") != "ThreadGroup.main_controller" {
+			continue
+		}
+		for _, child := range ep.ChildElements() {
+			if child.SelectAttrValue("name", "") == "LoopController.loops" {
+				child.SetText("-1")
+			}
+		}
+	}
+}
+
+// tstRow is a row of the Throughput Shaping Timer load profile
+type tstRow struct {
+	start    float64
+	end      float64
+	duration int
+}
+
+// makeTSTRows ramps the rps up within the rampup and keeps it until the end of the test
+func makeTSTRows(rps float64, rampup, total int) []tstRow {
+	if rampup > total {
+		rampup = total
+	}
+	rows := []tstRow{}
+	if rampup > 0 {
+		rows = append(rows, tstRow{start: 0, end: rps, duration: rampup})
+	}
+	if hold := total - rampup; hold > 0 {
+		rows = append(rows, tstRow{start: rps, end: rps, duration: hold})
+	}
+	return rows
+}
+
+// addThroughputShapingTimer adds the timer to the top of the test plan so it limits all the samplers
+// of the engine. It requires the Throughput Shaping Timer plugin.
+func addThroughputShapingTimer(planTree *etree.Element, rows []tstRow) {
+	timer := etree.NewElement("kg.apc.jmeter.timers.VariableThroughputTimer")
+	timer.CreateAttr("guiclass", "kg.apc.jmeter.timers.VariableThroughputTimerGui")
+	timer.CreateAttr("testclass", "kg.apc.jmeter.timers.VariableThroughputTimer")
+	timer.CreateAttr("testname", "Shibuya Throughput Shaping Timer")
+	timer.CreateAttr("enabled", "true")
+	profile := timer.CreateElement("collectionProp")
+	profile.CreateAttr("name", "load_profile")
+	for i, row := range rows {
+		r := profile.CreateElement("collectionProp")
+		r.CreateAttr("name", strconv.Itoa(i))
+		addStringProp(r, "start", strconv.FormatFloat(row.start, 'f', -1, 64))
+		addStringProp(r, "end", strconv.FormatFloat(row.end, 'f', -1, 64))
+		addStringProp(r, "duration", strconv.Itoa(row.duration))
+	}
+	planTree.InsertChildAt(0, timer)
+	planTree.InsertChildAt(1, etree.NewElement("hashTree"))
+}
+
+// toConcurrencyThreadGroup reads the concurrency of the thread group from the concurrency file.
+// The Concurrency Thread Group evaluates its target every second so the agent only needs to rewrite
+// the file to scale the running test.
+func toConcurrencyThreadGroup(tg *etree.Element, rampup, hold int) {
+	rewriteThreadGroup(tg, "com.blazemeter.jmeter.threads.concurrency.ConcurrencyThreadGroup")
+	// the threads of the concurrency thread group loop until the end of the test
+	if mc := tg.FindElement("./elementProp[@name='ThreadGroup.main_controller']"); mc != nil {
+		tg.RemoveChild(mc)
+	}
+	mc := tg.CreateElement("elementProp")
+	mc.CreateAttr("name", "ThreadGroup.main_controller")
+	mc.CreateAttr("elementType", "com.blazemeter.jmeter.control.VirtualUserController")
+	addStringProp(tg, "TargetLevel", fmt.Sprintf("${__FileToString(${__P(%s)},,)}", ConcurrencyFileProperty))
+	addStringProp(tg, "RampUp", strconv.Itoa(rampup))
+	addStringProp(tg, "Steps", "")
+	addStringProp(tg, "Hold", strconv.Itoa(hold))
+	addStringProp(tg, "Unit", "S")
+}
+
 func modifyJMX(file []byte, pec enginesModel.PlanEnginesConfig) ([]byte, error) {
 	planDoc, err := parseTestPlan(file)
 	if err != nil {
@@ -50,7 +236,38 @@ func modifyJMX(file []byte, pec enginesModel.PlanEnginesConfig) ([]byte, error)
 	if err != nil {
 		return nil, err
 	}
+	rampup, err := strconv.Atoi(pec.Rampup)
+	if err != nil {
+		return nil, err
+	}
+	if pec.TargetRPS > 0 {
+		planTree, err := getTestPlanTree(planDoc)
+		if err != nil {
+			return nil, err
+		}
+		addThroughputShapingTimer(planTree, makeTSTRows(pec.TargetRPS, rampup, durationInt*60))
+	}
+	var rows []*utgRow
+	if len(pec.Stages) > 0 {
+		if rows, err = makeUTGRows(pec.Stages); err != nil {
+			return nil, err
+		}
+	}
+	hold := durationInt*60 - rampup
+	if hold < 0 {
+		hold = 0
+	}
 	for _, tg := range threadGroups {
+		// setup thread groups only run once before the test so they keep the standard config
+		if rows != nil && tg.Tag == "ThreadGroup" {
+			toUltimateThreadGroup(tg, rows)
+			continue
+		}
+		// only the scalable plans need the plugin, the others keep the settings of their thread groups
+		if pec.Scalable && tg.Tag == "ThreadGroup" {
+			toConcurrencyThreadGroup(tg, rampup, hold)
+			continue
+		}
 		children := tg.ChildElements()
 		for _, child := range children {
 			attrName := child.SelectAttrValue("name", "")
@@ -77,5 +294,8 @@ func MakeTestPlan(pf *storage.PlanFiles, planName, filename string, fileBytes []
 	if err := pf.StoreTestPlan(filename, modified); err != nil {
 		return err
 	}
+	if err := pf.StoreTestPlan(ConcurrencyFileName, []byte(pec.Concurrency)); err != nil {
+		return err
+	}
 	return err
 }
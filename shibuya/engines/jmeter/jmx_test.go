@@ -0,0 +1,169 @@
+package jmeter
+
+import (
+	"testing"
+
+	enginesModel "github.com/rakutentech/shibuya/shibuya/engines/model"
+	"github.com/rakutentech/shibuya/shibuya/model"
+	"github.com/stretchr/testify/assert"
+)
+
+var testJMX = `<?xml version="1.0" encoding="UTF-8"?>
+<jmeterTestPlan version="1.2" properties="3.2" jmeter="3.3 r1808647">
+  <hashTree>
+    <TestPlan guiclass="TestPlanGui" testclass="TestPlan" testname="Test Plan" enabled="true"/>
+    <hashTree>
+      <ThreadGroup guiclass="ThreadGroupGui" testclass="ThreadGroup" testname="Thread Group" enabled="true">
+        <stringProp name="ThreadGroup.on_sample_error">continue</stringProp>
+        <elementProp name="ThreadGroup.main_controller" elementType="LoopController" guiclass="LoopControlPanel" testclass="LoopController" testname="Loop Controller" enabled="true">
+          <boolProp name="LoopController.continue_forever">false</boolProp>
+          <stringProp name="LoopController.loops">1</stringProp>
+        </elementProp>
+        <stringProp name="ThreadGroup.num_threads">1</stringProp>
+        <stringProp name="ThreadGroup.ramp_time">1</stringProp>
+        <boolProp name="ThreadGroup.scheduler">false</boolProp>
+        <stringProp name="ThreadGroup.duration"></stringProp>
+      </ThreadGroup>
+      <hashTree>
+        <HTTPSamplerProxy guiclass="HttpTestSampleGui" testclass="HTTPSamplerProxy" testname="HTTP Request" enabled="true"/>
+        <hashTree/>
+      </hashTree>
+      <SetupThreadGroup guiclass="SetupThreadGroupGui" testclass="SetupThreadGroup" testname="setUp Thread Group" enabled="true">
+        <stringProp name="ThreadGroup.num_threads">1</stringProp>
+      </SetupThreadGroup>
+      <hashTree/>
+    </hashTree>
+  </hashTree>
+</jmeterTestPlan>
+`
+
+func TestMakeUTGRows(t *testing.T) {
+	// ramp up to 100, step up to 300, spike down to 0
+	stages := []*model.Stage{
+		{Duration: "60s", Target: 100},
+		{Duration: "120s", Target: 100},
+		{Duration: "10s", Target: 300},
+		{Duration: "60s", Target: 300},
+		{Duration: "30s", Target: 50},
+		{Duration: "30s", Target: 0},
+	}
+	rows, err := makeUTGRows(stages)
+	assert.Nil(t, err)
+	assert.Equal(t, []*utgRow{
+		// the first 100 threads are split, 50 of them stop right after the 200 threads of the step
+		{threads: 50, delay: 0, startup: 60, hold: 214, shutdown: 6},
+		{threads: 200, delay: 180, startup: 10, hold: 60, shutdown: 24},
+		{threads: 50, delay: 0, startup: 60, hold: 220, shutdown: 30},
+	}, rows)
+
+	_, err = makeUTGRows([]*model.Stage{{Duration: "abc", Target: 1}})
+	assert.NotNil(t, err)
+}
+
+func TestModifyJMXWithStages(t *testing.T) {
+	pec := enginesModel.PlanEnginesConfig{
+		Duration:    "2",
+		Concurrency: "100",
+		Rampup:      "0",
+		Stages:      []*model.Stage{{Duration: "1m", Target: 100}, {Duration: "1m", Target: 0}},
+	}
+	modified, err := modifyJMX([]byte(testJMX), pec)
+	assert.Nil(t, err)
+	doc, err := parseTestPlan(modified)
+	assert.Nil(t, err)
+	tgs, err := getThreadGroups(doc)
+	assert.Nil(t, err)
+	// the ultimate thread group is not returned any more and the setup thread group is untouched
+	assert.Equal(t, 1, len(tgs))
+	assert.Equal(t, "100", tgs[0].SelectElement("stringProp").Text())
+
+	utg := doc.FindElement("//kg.apc.jmeter.threads.UltimateThreadGroup")
+	assert.NotNil(t, utg)
+	assert.Nil(t, utg.FindElement("./stringProp[@name='ThreadGroup.num_threads']"))
+	assert.Equal(t, "-1", utg.FindElement("./elementProp/stringProp[@name='LoopController.loops']").Text())
+	row := utg.FindElement("./collectionProp[@name='ultimatethreadgroupdata']/collectionProp")
+	assert.NotNil(t, row)
+	values := []string{}
+	for _, c := range row.ChildElements() {
+		values = append(values, c.Text())
+	}
+	assert.Equal(t, []string{"100", "0", "60", "0", "60"}, values)
+	// samplers stay under the thread group
+	assert.NotNil(t, doc.FindElement("//HTTPSamplerProxy"))
+}
+
+func TestMakeTSTRows(t *testing.T) {
+	assert.Equal(t, []tstRow{{start: 0, end: 12.5, duration: 60}, {start: 12.5, end: 12.5, duration: 240}}, makeTSTRows(12.5, 60, 300))
+	assert.Equal(t, []tstRow{{start: 10, end: 10, duration: 300}}, makeTSTRows(10, 0, 300))
+	assert.Equal(t, []tstRow{{start: 0, end: 10, duration: 300}}, makeTSTRows(10, 600, 300))
+}
+
+func TestModifyJMXWithTargetRPS(t *testing.T) {
+	pec := enginesModel.PlanEnginesConfig{
+		Duration:    "5",
+		Concurrency: "100",
+		Rampup:      "60",
+		TargetRPS:   12.5,
+	}
+	modified, err := modifyJMX([]byte(testJMX), pec)
+	assert.Nil(t, err)
+	doc, err := parseTestPlan(modified)
+	assert.Nil(t, err)
+	planTree, err := getTestPlanTree(doc)
+	assert.Nil(t, err)
+	children := planTree.ChildElements()
+	assert.Equal(t, "kg.apc.jmeter.timers.VariableThroughputTimer", children[0].Tag)
+	assert.Equal(t, "hashTree", children[1].Tag)
+	rows := children[0].FindElements("./collectionProp[@name='load_profile']/collectionProp")
+	assert.Equal(t, 2, len(rows))
+	assert.Equal(t, "12.5", rows[0].FindElement("./stringProp[@name='end']").Text())
+	assert.Equal(t, "240", rows[1].FindElement("./stringProp[@name='duration']").Text())
+	// the thread group still gets the flat config
+	tgs, err := getThreadGroups(doc)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(tgs))
+}
+
+func TestModifyJMX(t *testing.T) {
+	pec := enginesModel.PlanEnginesConfig{
+		Duration:    "5",
+		Concurrency: "100",
+		Rampup:      "60",
+	}
+	modified, err := modifyJMX([]byte(testJMX), pec)
+	assert.Nil(t, err)
+	doc, err := parseTestPlan(modified)
+	assert.Nil(t, err)
+	assert.Nil(t, doc.FindElement("//com.blazemeter.jmeter.threads.concurrency.ConcurrencyThreadGroup"))
+	tg := doc.FindElement("//ThreadGroup")
+	assert.NotNil(t, tg)
+	assert.Equal(t, "100", tg.FindElement("./stringProp[@name='ThreadGroup.num_threads']").Text())
+	assert.Equal(t, "60", tg.FindElement("./stringProp[@name='ThreadGroup.ramp_time']").Text())
+	assert.Equal(t, "300", tg.FindElement("./stringProp[@name='ThreadGroup.duration']").Text())
+}
+
+func TestModifyJMXScalable(t *testing.T) {
+	pec := enginesModel.PlanEnginesConfig{
+		Duration:    "5",
+		Concurrency: "100",
+		Rampup:      "60",
+		Scalable:    true,
+	}
+	modified, err := modifyJMX([]byte(testJMX), pec)
+	assert.Nil(t, err)
+	doc, err := parseTestPlan(modified)
+	assert.Nil(t, err)
+	ctg := doc.FindElement("//com.blazemeter.jmeter.threads.concurrency.ConcurrencyThreadGroup")
+	assert.NotNil(t, ctg)
+	assert.Equal(t, "${__FileToString(${__P(shibuya.concurrency.file)},,)}", ctg.FindElement("./stringProp[@name='TargetLevel']").Text())
+	assert.Equal(t, "60", ctg.FindElement("./stringProp[@name='RampUp']").Text())
+	assert.Equal(t, "240", ctg.FindElement("./stringProp[@name='Hold']").Text())
+	assert.Equal(t, "continue", ctg.FindElement("./stringProp[@name='ThreadGroup.on_sample_error']").Text())
+	assert.Nil(t, ctg.FindElement("./stringProp[@name='ThreadGroup.num_threads']"))
+	mc := ctg.FindElement("./elementProp[@name='ThreadGroup.main_controller']")
+	assert.Equal(t, "com.blazemeter.jmeter.control.VirtualUserController", mc.SelectAttrValue("elementType", ""))
+
+	// setup thread groups keep the standard config
+	setup := doc.FindElement("//SetupThreadGroup")
+	assert.Equal(t, "100", setup.FindElement("./stringProp[@name='ThreadGroup.num_threads']").Text())
+}
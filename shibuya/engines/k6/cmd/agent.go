@@ -0,0 +1,44 @@
+package main
+
+import (
+	"log"
+
+	"github.com/rakutentech/shibuya/shibuya/engines/agentserver"
+	"github.com/rakutentech/shibuya/shibuya/engines/k6"
+	"github.com/rakutentech/shibuya/shibuya/engines/k6/metrics"
+)
+
+const (
+	TEST_FILE_NAME   = "script.js"
+	RESULT_FILE_NAME = "result.json"
+)
+
+var (
+	agentDir    = agentserver.NewAgentDirHandler("")
+	CONF_FILE   = agentDir.ConfFilesDir().Filepath(k6.ConfFileName)
+	TEST_FILE   = agentDir.TestFilesDir().Filepath(TEST_FILE_NAME)
+	RESULT_FILE = agentDir.ResultFilesDir().ResultFile(RESULT_FILE_NAME)
+)
+
+func main() {
+	engineMeta := agentserver.FetchEngineMeta()
+	// k6 writes every sample as a json line into the result file, which will be tailed by the agent.
+	// Only the lines needed by the parser are streamed.
+	startCommand := agentserver.Command{
+		Command: "k6",
+		Args:    []string{"run", "--quiet", "--config", CONF_FILE, "--out", "json=" + RESULT_FILE, TEST_FILE},
+	}
+	options := agentserver.AgentServerOptions{
+		TestFileName: TEST_FILE_NAME,
+		StartCommand: startCommand,
+		EngineMeta:   engineMeta,
+		MetricParser: k6.Engine{}.MetricParser(),
+		LineFilter:   metrics.KeepLine,
+		ConfFileName: k6.ConfFileName,
+		ResultFile:   RESULT_FILE,
+	}
+	as := agentserver.MakeAgentServer(options)
+	if err := as.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,150 @@
+package k6
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+
+	"github.com/rakutentech/shibuya/shibuya/coordinator/storage"
+	enginesModel "github.com/rakutentech/shibuya/shibuya/engines/model"
+)
+
+const (
+	ConfFileName = "k6.json"
+)
+
+type stage struct {
+	Duration string `json:"duration"`
+	Target   int    `json:"target"`
+}
+
+// scenario uses the ramping-arrival-rate executor, k6's open model. The targets of the stages are
+// iterations per second.
+type scenario struct {
+	Executor        string  `json:"executor"`
+	StartRate       int     `json:"startRate"`
+	TimeUnit        string  `json:"timeUnit"`
+	PreAllocatedVUs int     `json:"preAllocatedVUs"`
+	MaxVUs          int     `json:"maxVUs"`
+	Stages          []stage `json:"stages"`
+}
+
+type options struct {
+	Stages    []stage              `json:"stages,omitempty"`
+	Scenarios map[string]*scenario `json:"scenarios,omitempty"`
+}
+
+// makeArrivalRateScenario ramps the rate up to the target rps within the rampup and keeps it until
+// the end of the duration. The concurrency caps the number of vus k6 can use to reach the rate.
+func makeArrivalRateScenario(pec enginesModel.PlanEnginesConfig) (*scenario, error) {
+	duration, err := strconv.Atoi(pec.Duration)
+	if err != nil {
+		return nil, err
+	}
+	concurrency, err := strconv.Atoi(pec.Concurrency)
+	if err != nil {
+		return nil, err
+	}
+	rampup, err := strconv.Atoi(pec.Rampup)
+	if err != nil {
+		return nil, err
+	}
+	// k6 only supports integer rates so the time unit is shrunk until the rate is precise enough
+	target := int(math.Round(pec.TargetRPS * 1000))
+	total := duration * 60
+	if rampup > total {
+		rampup = total
+	}
+	sc := &scenario{
+		Executor:        "ramping-arrival-rate",
+		TimeUnit:        "1000s",
+		PreAllocatedVUs: concurrency,
+		MaxVUs:          concurrency,
+		Stages:          []stage{},
+	}
+	if rampup > 0 {
+		sc.Stages = append(sc.Stages, stage{Duration: fmt.Sprintf("%ds", rampup), Target: target})
+	} else {
+		sc.StartRate = target
+	}
+	if hold := total - rampup; hold > 0 {
+		sc.Stages = append(sc.Stages, stage{Duration: fmt.Sprintf("%ds", hold), Target: target})
+	}
+	return sc, nil
+}
+
+// k6 has no notion of rampup so we translate the plan config into stages.
+// The first stage ramps up to the concurrency and the second one keeps it until the
+// end of the duration. Like Jmeter, the rampup is counted as part of the duration.
+// Stages of the plan are passed through as they are.
+func makeStages(pec enginesModel.PlanEnginesConfig) ([]stage, error) {
+	if len(pec.Stages) > 0 {
+		stages := make([]stage, len(pec.Stages))
+		for i, s := range pec.Stages {
+			seconds, err := s.Seconds()
+			if err != nil {
+				return nil, err
+			}
+			stages[i] = stage{Duration: fmt.Sprintf("%ds", seconds), Target: s.Target}
+		}
+		return stages, nil
+	}
+	duration, err := strconv.Atoi(pec.Duration)
+	if err != nil {
+		return nil, err
+	}
+	concurrency, err := strconv.Atoi(pec.Concurrency)
+	if err != nil {
+		return nil, err
+	}
+	rampup, err := strconv.Atoi(pec.Rampup)
+	if err != nil {
+		return nil, err
+	}
+	total := duration * 60
+	stages := []stage{}
+	if rampup > 0 {
+		stages = append(stages, stage{Duration: fmt.Sprintf("%ds", rampup), Target: concurrency})
+	}
+	if hold := total - rampup; hold > 0 {
+		stages = append(stages, stage{Duration: fmt.Sprintf("%ds", hold), Target: concurrency})
+	}
+	return stages, nil
+}
+
+// The config file is passed to k6 via --config. Please note that k6 gives the options defined
+// inside the script a higher priority than the ones from the config file, so users should not
+// define vus/duration/stages in their scripts.
+func writeConfig(filepath string, pec enginesModel.PlanEnginesConfig) error {
+	opts := options{}
+	if pec.TargetRPS > 0 {
+		sc, err := makeArrivalRateScenario(pec)
+		if err != nil {
+			return err
+		}
+		opts.Scenarios = map[string]*scenario{"shibuya": sc}
+	} else {
+		stages, err := makeStages(pec)
+		if err != nil {
+			return err
+		}
+		opts.Stages = stages
+	}
+	content, err := json.Marshal(opts)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath, content, 0600)
+}
+
+func MakeTestPlan(pf *storage.PlanFiles, planName, filename string, fileBytes []byte, pec enginesModel.PlanEnginesConfig) error {
+	if err := pf.StoreTestPlan(filename, fileBytes); err != nil {
+		return err
+	}
+	if err := writeConfig(pf.TestFilePath(ConfFileName), pec); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package k6
+
+import (
+	"testing"
+
+	enginesModel "github.com/rakutentech/shibuya/shibuya/engines/model"
+	"github.com/rakutentech/shibuya/shibuya/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMakeStages(t *testing.T) {
+	pec := enginesModel.PlanEnginesConfig{
+		Duration:    "5",
+		Concurrency: "10",
+		Rampup:      "60",
+	}
+	stages, err := makeStages(pec)
+	assert.Nil(t, err)
+	assert.Equal(t, []stage{{Duration: "60s", Target: 10}, {Duration: "240s", Target: 10}}, stages)
+
+	pec.Rampup = "0"
+	stages, err = makeStages(pec)
+	assert.Nil(t, err)
+	assert.Equal(t, []stage{{Duration: "300s", Target: 10}}, stages)
+
+	pec.Stages = []*model.Stage{{Duration: "5m", Target: 100}, {Duration: "1m30s", Target: 0}}
+	stages, err = makeStages(pec)
+	assert.Nil(t, err)
+	assert.Equal(t, []stage{{Duration: "300s", Target: 100}, {Duration: "90s", Target: 0}}, stages)
+	pec.Stages = nil
+
+	pec.Concurrency = "abc"
+	_, err = makeStages(pec)
+	assert.NotNil(t, err)
+}
+
+func TestMakeArrivalRateScenario(t *testing.T) {
+	pec := enginesModel.PlanEnginesConfig{
+		Duration:    "5",
+		Concurrency: "50",
+		Rampup:      "60",
+		TargetRPS:   12.5,
+	}
+	sc, err := makeArrivalRateScenario(pec)
+	assert.Nil(t, err)
+	assert.Equal(t, "ramping-arrival-rate", sc.Executor)
+	assert.Equal(t, 0, sc.StartRate)
+	assert.Equal(t, 50, sc.MaxVUs)
+	assert.Equal(t, []stage{{Duration: "60s", Target: 12500}, {Duration: "240s", Target: 12500}}, sc.Stages)
+
+	pec.Rampup = "0"
+	sc, err = makeArrivalRateScenario(pec)
+	assert.Nil(t, err)
+	assert.Equal(t, 12500, sc.StartRate)
+	assert.Equal(t, []stage{{Duration: "300s", Target: 12500}}, sc.Stages)
+}
@@ -0,0 +1,36 @@
+package k6
+
+import (
+	"github.com/rakutentech/shibuya/shibuya/config"
+	"github.com/rakutentech/shibuya/shibuya/coordinator/storage"
+	"github.com/rakutentech/shibuya/shibuya/engines/k6/metrics"
+	enginesModel "github.com/rakutentech/shibuya/shibuya/engines/model"
+	"github.com/rakutentech/shibuya/shibuya/engines/registry"
+	"github.com/rakutentech/shibuya/shibuya/model"
+)
+
+type Engine struct{}
+
+func init() {
+	registry.Register(Engine{})
+}
+
+func (Engine) Kind() model.PlanKind {
+	return model.K6Plan
+}
+
+func (Engine) TestFileExtensions() []string {
+	return []string{".js"}
+}
+
+func (Engine) MakeTestPlan(pf *storage.PlanFiles, planName, filename string, fileBytes []byte, pec enginesModel.PlanEnginesConfig) error {
+	return MakeTestPlan(pf, planName, filename, fileBytes, pec)
+}
+
+func (Engine) DefaultContainer() *config.ExecutorContainer {
+	return &config.ExecutorContainer{Image: "shibuya:k6", CPU: "1", Mem: "1Gi"}
+}
+
+func (Engine) MetricParser() func(string) (enginesModel.ShibuyaMetric, error) {
+	return metrics.NewParser().ParseRawMetrics
+}
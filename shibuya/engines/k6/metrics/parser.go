@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	enginesModel "github.com/rakutentech/shibuya/shibuya/engines/model"
+)
+
+// k6 json output is line delimited. Every line is either a metric definition or a data point
+// of a metric. For example:
+// {"type":"Point","metric":"http_req_duration","data":{"time":"...","value":12.3,"tags":{"name":"http://a/","status":"200"}}}
+type k6Line struct {
+	Type   string `json:"type"`
+	Metric string `json:"metric"`
+	Data   struct {
+		Value float64           `json:"value"`
+		Tags  map[string]string `json:"tags"`
+	} `json:"data"`
+}
+
+var (
+	ignoredLineErr = errors.New("line is not a request sample")
+)
+
+// Parser keeps the latest number of vus because k6 reports it as a separate metric
+// instead of attaching it to every request sample.
+type Parser struct {
+	vus atomic.Uint64
+}
+
+func NewParser() *Parser {
+	return &Parser{}
+}
+
+// KeepLine tells whether the line is needed by the parser. k6 writes a dozen points per request,
+// of which only the request durations and the vus are parsed.
+func KeepLine(rawLine string) bool {
+	line := new(k6Line)
+	if err := json.Unmarshal([]byte(rawLine), line); err != nil {
+		return false
+	}
+	return line.Type == "Point" && (line.Metric == "http_req_duration" || line.Metric == "vus")
+}
+
+func (p *Parser) ParseRawMetrics(rawLine string) (enginesModel.ShibuyaMetric, error) {
+	line := new(k6Line)
+	if err := json.Unmarshal([]byte(rawLine), line); err != nil {
+		return enginesModel.ShibuyaMetric{}, fmt.Errorf("invalid k6 line %s: %w", rawLine, err)
+	}
+	if line.Type != "Point" {
+		return enginesModel.ShibuyaMetric{}, ignoredLineErr
+	}
+	switch line.Metric {
+	case "vus":
+		p.vus.Store(uint64(line.Data.Value))
+		return enginesModel.ShibuyaMetric{}, ignoredLineErr
+	case "http_req_duration":
+		return enginesModel.ShibuyaMetric{
+			Threads: float64(p.vus.Load()),
+			Label:   line.Data.Tags["name"],
+			Status:  line.Data.Tags["status"],
+			Latency: line.Data.Value,
+			Raw:     rawLine,
+		}, nil
+	}
+	return enginesModel.ShibuyaMetric{}, ignoredLineErr
+}
@@ -0,0 +1,39 @@
+package metrics_test
+
+import (
+	"testing"
+
+	"github.com/rakutentech/shibuya/shibuya/engines/k6/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricParsing(t *testing.T) {
+	parser := metrics.NewParser()
+	vusLine := `{"type":"Point","data":{"time":"2025-02-08T11:06:24.1+09:00","value":5,"tags":null},"metric":"vus"}`
+	_, err := parser.ParseRawMetrics(vusLine)
+	assert.NotNil(t, err)
+
+	line := `{"type":"Point","data":{"time":"2025-02-08T11:06:24.2+09:00","value":154.3,"tags":{"group":"","method":"GET","name":"/asdf","proto":"HTTP/1.1","scenario":"default","status":"404","url":"http://localhost/asdf"}},"metric":"http_req_duration"}`
+	metric, err := parser.ParseRawMetrics(line)
+	assert.Nil(t, err)
+	assert.Equal(t, "404", metric.Status)
+	assert.Equal(t, float64(5), metric.Threads)
+	assert.Equal(t, "/asdf", metric.Label)
+	assert.Equal(t, 154.3, metric.Latency)
+
+	metricDef := `{"type":"Metric","data":{"name":"http_req_duration","type":"trend","contains":"time"},"metric":"http_req_duration"}`
+	_, err = parser.ParseRawMetrics(metricDef)
+	assert.NotNil(t, err)
+
+	_, err = parser.ParseRawMetrics("not a json line")
+	assert.NotNil(t, err)
+}
+
+func TestKeepLine(t *testing.T) {
+	assert.True(t, metrics.KeepLine(`{"type":"Point","data":{"value":5},"metric":"vus"}`))
+	assert.True(t, metrics.KeepLine(`{"type":"Point","data":{"value":154.3},"metric":"http_req_duration"}`))
+	assert.False(t, metrics.KeepLine(`{"type":"Point","data":{"value":0.1},"metric":"http_req_blocked"}`))
+	assert.False(t, metrics.KeepLine(`{"type":"Point","data":{"value":512},"metric":"data_sent"}`))
+	assert.False(t, metrics.KeepLine(`{"type":"Metric","data":{"name":"http_req_duration"},"metric":"http_req_duration"}`))
+	assert.False(t, metrics.KeepLine("not a json line"))
+}
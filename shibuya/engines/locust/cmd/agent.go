@@ -1,10 +1,14 @@
 package main
 
 import (
+	"fmt"
 	"log"
+	"net/http"
+	"net/url"
+	"strconv"
 
 	"github.com/rakutentech/shibuya/shibuya/engines/agentserver"
-	"github.com/rakutentech/shibuya/shibuya/engines/locust/metrics"
+	"github.com/rakutentech/shibuya/shibuya/engines/locust"
 )
 
 const (
@@ -20,6 +24,23 @@ var (
 	RESULT_FILE = agentDir.ResultFilesDir().ResultFile(RESULT_FILE_NAME)
 )
 
+// scale changes the users through the swarm api of the web ui, which is also how the ui edits a
+// running test. The new users are spawned within a second.
+func scale(concurrency int) error {
+	values := url.Values{}
+	values.Add("user_count", strconv.Itoa(concurrency))
+	values.Add("spawn_rate", strconv.Itoa(concurrency))
+	resp, err := http.PostForm(fmt.Sprintf("http://%s/swarm", locust.WebAddr), values)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("locust swarm api returns %d", resp.StatusCode)
+	}
+	return nil
+}
+
 func main() {
 	engineMeta := agentserver.FetchEngineMeta()
 	// result.csv in this command is just a placehoder. The actual results are stored in the
@@ -32,9 +53,10 @@ func main() {
 		TestFileName: TEST_FILE_NAME,
 		StartCommand: startCommand,
 		EngineMeta:   engineMeta,
-		MetricParser: metrics.ParseRawMetrics,
+		MetricParser: locust.Engine{}.MetricParser(),
 		ConfFileName: CONF_FILE_NAME,
 		ResultFile:   RESULT_FILE,
+		ScaleCommand: scale,
 	}
 	as := agentserver.MakeAgentServer(options)
 	if err := as.Run(); err != nil {
@@ -1,16 +1,32 @@
 package locust
 
 import (
+	"fmt"
 	"html/template"
 	"os"
+	"strconv"
 
 	"github.com/rakutentech/shibuya/shibuya/coordinator/storage"
 	enginesModel "github.com/rakutentech/shibuya/shibuya/engines/model"
 )
 
+const (
+	// WebAddr is where the web ui of locust listens. It's only reachable inside the engine and
+	// the agent uses it to change the users of the running test.
+	WebAddr = "127.0.0.1:8089"
+)
+
 var (
-	tmpl = `
+	// autostart runs the test right away like headless but keeps the web ui running, so the users
+	// of the scalable plans can be changed
+	tmpl = `{{ if .Scalable }}
+autostart = true
+autoquit = 0
+web-host = 127.0.0.1
+web-port = 8089
+{{ else }}
 headless = true
+{{ end -}}
 users = {{ .Concurrency }}
 run-time = {{ .Duration }}m
 spawn-rate = {{ .Rampup }}
@@ -23,8 +39,65 @@ def on_locust_init(environment, **kwargs):
     jmeter.JmeterListener(env=environment, testplan="{{ .PlanName }}",
                           flush_size=1, results_filename="/shibuya-agent/test-result/result.csv")
 `
+
+	// Locust ignores users and spawn-rate in the config when the locustfile has a shape class
+	StagesShape = `
+from locust import LoadTestShape
+class ShibuyaStagesShape(LoadTestShape):
+    stages = [{{ range .Stages }}
+        {"end": {{ .End }}, "users": {{ .Target }}, "spawn_rate": {{ .SpawnRate }}},{{ end }}
+    ]
+
+    def tick(self):
+        run_time = self.get_run_time()
+        for stage in self.stages:
+            if run_time < stage["end"]:
+                return (stage["users"], stage["spawn_rate"])
+        return None
+`
+
+	// constant_throughput is per user so the target rps of the engine is divided by the users.
+	// The rps ramps up together with the users.
+	TargetRPSListener = `
+from locust import events, constant_throughput
+@events.init.add_listener
+def on_shibuya_target_rps(environment, **kwargs):
+    for user_class in environment.user_classes:
+        user_class.wait_time = constant_throughput({{ .Throughput }})
+`
 )
 
+type shapeStage struct {
+	End       int
+	Target    int
+	SpawnRate string
+}
+
+// makeShapeStages converts the stages into the cumulative end time of every stage and the spawn
+// rate that reaches the target linearly within the stage.
+func makeShapeStages(pec enginesModel.PlanEnginesConfig) ([]shapeStage, error) {
+	r := make([]shapeStage, len(pec.Stages))
+	end, prev := 0, 0
+	for i, s := range pec.Stages {
+		seconds, err := s.Seconds()
+		if err != nil {
+			return nil, err
+		}
+		end += seconds
+		diff := s.Target - prev
+		if diff < 0 {
+			diff = -diff
+		}
+		rate := float64(diff) / float64(seconds)
+		if rate < 1 {
+			rate = 1
+		}
+		r[i] = shapeStage{End: end, Target: s.Target, SpawnRate: strconv.FormatFloat(rate, 'f', 2, 64)}
+		prev = s.Target
+	}
+	return r, nil
+}
+
 func writeConfig(filepath string, pec enginesModel.PlanEnginesConfig) error {
 	file, err := os.Create(filepath)
 	if err != nil {
@@ -61,6 +134,46 @@ func appendJmeterListener(filepath string, planName string) error {
 	return t.Execute(file, data)
 }
 
+func appendStagesShape(filepath string, pec enginesModel.PlanEnginesConfig) error {
+	stages, err := makeShapeStages(pec)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filepath, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	t, err := template.New("shape").Parse(StagesShape)
+	if err != nil {
+		return err
+	}
+	return t.Execute(file, map[string][]shapeStage{"Stages": stages})
+}
+
+// appendTargetRPSListener overrides the wait time of all the user classes. Locust counts tasks, not
+// requests, so the target is only accurate when every task sends one request.
+func appendTargetRPSListener(filepath string, pec enginesModel.PlanEnginesConfig) error {
+	concurrency, err := strconv.Atoi(pec.Concurrency)
+	if err != nil {
+		return err
+	}
+	if concurrency <= 0 {
+		return fmt.Errorf("concurrency should be positive to reach the target rps")
+	}
+	file, err := os.OpenFile(filepath, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	t, err := template.New("rps").Parse(TargetRPSListener)
+	if err != nil {
+		return err
+	}
+	throughput := strconv.FormatFloat(pec.TargetRPS/float64(concurrency), 'f', -1, 64)
+	return t.Execute(file, map[string]string{"Throughput": throughput})
+}
+
 func MakeTestPlan(pf *storage.PlanFiles, planName, filename string, fileBytes []byte, pec enginesModel.PlanEnginesConfig) error {
 	if err := pf.StoreTestPlan(filename, fileBytes); err != nil {
 		return err
@@ -68,6 +181,16 @@ func MakeTestPlan(pf *storage.PlanFiles, planName, filename string, fileBytes []
 	if err := appendJmeterListener(pf.TestFilePath(filename), planName); err != nil {
 		return err
 	}
+	if pec.TargetRPS > 0 {
+		if err := appendTargetRPSListener(pf.TestFilePath(filename), pec); err != nil {
+			return err
+		}
+	}
+	if len(pec.Stages) > 0 {
+		if err := appendStagesShape(pf.TestFilePath(filename), pec); err != nil {
+			return err
+		}
+	}
 	if err := writeConfig(pf.TestFilePath("locust.conf"), pec); err != nil {
 		return err
 	}
@@ -0,0 +1,71 @@
+package locust
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	enginesModel "github.com/rakutentech/shibuya/shibuya/engines/model"
+	"github.com/rakutentech/shibuya/shibuya/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMakeShapeStages(t *testing.T) {
+	pec := enginesModel.PlanEnginesConfig{
+		Stages: []*model.Stage{{Duration: "10s", Target: 100}, {Duration: "1m", Target: 100}, {Duration: "20s", Target: 0}},
+	}
+	stages, err := makeShapeStages(pec)
+	assert.Nil(t, err)
+	assert.Equal(t, []shapeStage{
+		{End: 10, Target: 100, SpawnRate: "10.00"},
+		{End: 70, Target: 100, SpawnRate: "1.00"},
+		{End: 90, Target: 0, SpawnRate: "5.00"},
+	}, stages)
+
+	pec.Stages = []*model.Stage{{Duration: "abc", Target: 1}}
+	_, err = makeShapeStages(pec)
+	assert.NotNil(t, err)
+}
+
+func TestAppendStagesShape(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "locustfile.py")
+	os.WriteFile(f, []byte("from locust import HttpUser\n"), 0600)
+	pec := enginesModel.PlanEnginesConfig{
+		Stages: []*model.Stage{{Duration: "10s", Target: 100}},
+	}
+	assert.Nil(t, appendStagesShape(f, pec))
+	content, err := os.ReadFile(f)
+	assert.Nil(t, err)
+	assert.Contains(t, string(content), "class ShibuyaStagesShape(LoadTestShape):")
+	assert.Contains(t, string(content), `{"end": 10, "users": 100, "spawn_rate": 10.00},`)
+}
+
+func TestAppendTargetRPSListener(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "locustfile.py")
+	os.WriteFile(f, []byte("from locust import HttpUser\n"), 0600)
+	pec := enginesModel.PlanEnginesConfig{Concurrency: "20", TargetRPS: 50}
+	assert.Nil(t, appendTargetRPSListener(f, pec))
+	content, err := os.ReadFile(f)
+	assert.Nil(t, err)
+	assert.Contains(t, string(content), "user_class.wait_time = constant_throughput(2.5)")
+
+	pec.Concurrency = "0"
+	assert.NotNil(t, appendTargetRPSListener(f, pec))
+}
+
+func TestWriteConfig(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "locust.conf")
+	pec := enginesModel.PlanEnginesConfig{Concurrency: "20", Duration: "5", Rampup: "0"}
+	assert.Nil(t, writeConfig(f, pec))
+	content, err := os.ReadFile(f)
+	assert.Nil(t, err)
+	assert.Equal(t, "\nheadless = true\nusers = 20\nrun-time = 5m\nspawn-rate = 1\n", string(content))
+
+	pec.Scalable = true
+	assert.Nil(t, writeConfig(f, pec))
+	content, err = os.ReadFile(f)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(content), "headless")
+	assert.Contains(t, string(content), "autostart = true\nautoquit = 0\nweb-host = 127.0.0.1\nweb-port = 8089\n")
+	assert.Contains(t, string(content), "users = 20\n")
+}
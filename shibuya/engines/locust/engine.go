@@ -0,0 +1,40 @@
+package locust
+
+import (
+	"github.com/rakutentech/shibuya/shibuya/config"
+	"github.com/rakutentech/shibuya/shibuya/coordinator/storage"
+	"github.com/rakutentech/shibuya/shibuya/engines/locust/metrics"
+	enginesModel "github.com/rakutentech/shibuya/shibuya/engines/model"
+	"github.com/rakutentech/shibuya/shibuya/engines/registry"
+	"github.com/rakutentech/shibuya/shibuya/model"
+)
+
+type Engine struct{}
+
+func init() {
+	registry.Register(Engine{})
+}
+
+func (Engine) Kind() model.PlanKind {
+	return model.LocustPlan
+}
+
+func (Engine) TestFileExtensions() []string {
+	return []string{".py"}
+}
+
+func (Engine) MakeTestPlan(pf *storage.PlanFiles, planName, filename string, fileBytes []byte, pec enginesModel.PlanEnginesConfig) error {
+	return MakeTestPlan(pf, planName, filename, fileBytes, pec)
+}
+
+func (Engine) DefaultContainer() *config.ExecutorContainer {
+	return &config.ExecutorContainer{Image: "shibuya:locust", CPU: "1", Mem: "1Gi"}
+}
+
+func (Engine) MetricParser() func(string) (enginesModel.ShibuyaMetric, error) {
+	return metrics.ParseRawMetrics
+}
+
+func (Engine) SupportsLiveScaling() bool {
+	return true
+}
@@ -16,7 +16,8 @@ import (
 )
 
 type GlobalConfig struct {
-	ScrapeInterval time.Duration `yaml:"scrape_interval"`
+	ScrapeInterval time.Duration     `yaml:"scrape_interval"`
+	ExternalLabels map[string]string `yaml:"external_labels,omitempty"`
 }
 
 type RelabelConfig struct {
@@ -27,11 +28,21 @@ type RelabelConfig struct {
 
 type ScrapeConfig struct {
 	JobName                 string             `yaml:"job_name"`
+	Scheme                  string             `yaml:"scheme,omitempty"`
 	RelabelConfigs          []*relabel.Config  `yaml:"relabel_configs"`
-	ServiceDiscoveryConfigs []discovery.Config `yaml:"kubernetes_sd_configs"`
+	ServiceDiscoveryConfigs []discovery.Config `yaml:"kubernetes_sd_configs,omitempty"`
+	HTTPSDConfigs           []*HTTPSDConfig    `yaml:"http_sd_configs,omitempty"`
 	HTTPConfig              HTTPConfig         `yaml:",inline"`
 }
 
+// HTTPSDConfig is the http service discovery of prometheus. The scrapers outside of k8s find the
+// engines of the collection by the targets served by the coordinator.
+type HTTPSDConfig struct {
+	URL             string         `yaml:"url"`
+	RefreshInterval time.Duration  `yaml:"refresh_interval"`
+	Authorization   *Authorization `yaml:"authorization"`
+}
+
 type Authorization struct {
 	Type        string `yaml:"type"`
 	Credentials string `yaml:"credentials"`
@@ -102,7 +113,7 @@ func makeRemoteWriteConfig(item config.MetricStorage, headers map[string]string)
 	return remoteWriteConfig, nil
 }
 
-func MakeScraperConfig(apiToken, token string, collectionID int64, namespace string, ms []config.MetricStorage) (*PromConfig, error) {
+func makeBaseScraperConfig(apiToken string, collectionID int64, context string, ms []config.MetricStorage) (*PromConfig, error) {
 	remoteWriteConfigs := make([]*RemoteWriteConfig, len(ms))
 	headers := map[string]string{
 		"collection_id": strconv.Itoa(int(collectionID)),
@@ -122,6 +133,20 @@ func MakeScraperConfig(apiToken, token string, collectionID int64, namespace str
 	pc.RemoteWriteConfigs = remoteWriteConfigs
 	pc.GlobalConfig = &GlobalConfig{}
 	pc.GlobalConfig.ScrapeInterval = time.Duration(time.Second)
+	if context != "" {
+		pc.GlobalConfig.ExternalLabels = map[string]string{"context": context}
+	}
+	return pc, nil
+}
+
+// MakeScraperConfig makes the config of the scraper of a collection. The metrics are labelled with
+// the context of the cluster, so the metrics of a collection running in several clusters can be
+// told apart.
+func MakeScraperConfig(apiToken, token string, collectionID int64, namespace, context string, ms []config.MetricStorage) (*PromConfig, error) {
+	pc, err := makeBaseScraperConfig(apiToken, collectionID, context, ms)
+	if err != nil {
+		return nil, err
+	}
 	sd := &k8sDiscovery.SDConfig{
 		Role: k8sDiscovery.Role("pod"),
 		NamespaceDiscovery: k8sDiscovery.NamespaceDiscovery{
@@ -153,3 +178,33 @@ func MakeScraperConfig(apiToken, token string, collectionID int64, namespace str
 	}
 	return pc, nil
 }
+
+// MakeHTTPSDScraperConfig makes the config of the scraper of a collection running outside of k8s, like
+// on Cloud Run. The scraper finds the engines by the targets served by the coordinator at targetsUrl.
+func MakeHTTPSDScraperConfig(apiToken, token string, collectionID int64, targetsUrl, scheme, context string, ms []config.MetricStorage) (*PromConfig, error) {
+	pc, err := makeBaseScraperConfig(apiToken, collectionID, context, ms)
+	if err != nil {
+		return nil, err
+	}
+	authorization := &Authorization{
+		Type:        authtoken.BEARER_PREFIX,
+		Credentials: token,
+	}
+	pc.ScrapeConfigs = []*ScrapeConfig{
+		{
+			JobName: "shibuya-metrics",
+			Scheme:  scheme,
+			HTTPSDConfigs: []*HTTPSDConfig{
+				{
+					URL:             targetsUrl,
+					RefreshInterval: 5 * time.Second,
+					Authorization:   authorization,
+				},
+			},
+			HTTPConfig: HTTPConfig{
+				Authorization: authorization,
+			},
+		},
+	}
+	return pc, nil
+}
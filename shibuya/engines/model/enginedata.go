@@ -3,11 +3,15 @@ package model
 import "github.com/rakutentech/shibuya/shibuya/model"
 
 type PlanEnginesConfig struct {
-	Kind          model.PlanKind      `json:"kind"`
-	Name          string              `json:"Name"`
-	Duration      string              `json:"duration"`
-	Concurrency   string              `json:"concurrency"`
-	Rampup        string              `json:"rampup"`
+	Kind        model.PlanKind `json:"kind"`
+	Name        string         `json:"Name"`
+	Duration    string         `json:"duration"`
+	Concurrency string         `json:"concurrency"`
+	Rampup      string         `json:"rampup"`
+	Stages      []*model.Stage `json:"stages"`
+	TargetRPS   float64        `json:"target_rps"` // per engine, 0 means there is no target
+	// Scalable plans can change their concurrency during a run
+	Scalable      bool                `json:"scalable"`
 	EnginesConfig []*EngineDataConfig `json:"engine_data_config"`
 }
 type EngineDataConfig struct {
@@ -0,0 +1,111 @@
+package registry
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/rakutentech/shibuya/shibuya/config"
+	"github.com/rakutentech/shibuya/shibuya/coordinator/storage"
+	enginesModel "github.com/rakutentech/shibuya/shibuya/engines/model"
+	"github.com/rakutentech/shibuya/shibuya/model"
+)
+
+// Engine describes everything shibuya needs to know about a load generator.
+// Built-in engines register themselves in their init func and are pulled in by the
+// engines/builtin package. An in-house engine only needs to implement this interface, call Register
+// in its init func and be blank imported by the api, controller and coordinator binaries.
+type Engine interface {
+	Kind() model.PlanKind
+	// TestFileExtensions are the extensions of the test files accepted by the engine, for example .jmx
+	TestFileExtensions() []string
+	// MakeTestPlan rewrites the uploaded test file with the plan config and stores it, along with
+	// any conf file the engine needs, in the plan files of the coordinator.
+	MakeTestPlan(pf *storage.PlanFiles, planName, filename string, fileBytes []byte, pec enginesModel.PlanEnginesConfig) error
+	// DefaultContainer is used when the engine is not configured in executors.engines_container
+	DefaultContainer() *config.ExecutorContainer
+	// MetricParser returns a new parser for the result lines of the engine. Parsers could be
+	// stateful so every agent should have its own.
+	MetricParser() func(string) (enginesModel.ShibuyaMetric, error)
+}
+
+// LiveScaler is implemented by the engines whose agents can change the concurrency of a running test
+type LiveScaler interface {
+	SupportsLiveScaling() bool
+}
+
+type UnsupportedKindError struct {
+	Kind model.PlanKind
+}
+
+func (e *UnsupportedKindError) Error() string {
+	return fmt.Sprintf("%s is not supported", string(e.Kind))
+}
+
+var (
+	mu      sync.RWMutex
+	engines = make(map[model.PlanKind]Engine)
+)
+
+// Register makes an engine available by its kind. Like database/sql drivers, registering the
+// same kind twice is a programming error so it panics.
+func Register(e Engine) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, ok := engines[e.Kind()]; ok {
+		panic(fmt.Sprintf("engine %s is already registered", e.Kind()))
+	}
+	engines[e.Kind()] = e
+	// model needs to know the test files of the plan when storing the uploaded files
+	model.RegisterPlanKind(e.Kind(), e.TestFileExtensions())
+}
+
+func Get(kind model.PlanKind) (Engine, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	e, ok := engines[kind]
+	if !ok {
+		return nil, &UnsupportedKindError{Kind: kind}
+	}
+	return e, nil
+}
+
+func Kinds() []model.PlanKind {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	kinds := make([]model.PlanKind, 0, len(engines))
+	for k := range engines {
+		kinds = append(kinds, k)
+	}
+	sort.Slice(kinds, func(i, j int) bool {
+		return kinds[i] < kinds[j]
+	})
+	return kinds
+}
+
+// ContainerConfig returns the container configured for the kind and falls back to the default
+// container of the engine.
+func ContainerConfig(kind model.PlanKind, ec *config.ExecutorConfig) (*config.ExecutorContainer, error) {
+	e, err := Get(kind)
+	if err != nil {
+		return nil, err
+	}
+	if ec != nil {
+		if c, ok := ec.EnginesContainer[string(kind)]; ok && c != nil {
+			return c, nil
+		}
+	}
+	return e.DefaultContainer(), nil
+}
+
+func SupportsLiveScaling(kind model.PlanKind) bool {
+	e, err := Get(kind)
+	if err != nil {
+		return false
+	}
+	ls, ok := e.(LiveScaler)
+	return ok && ls.SupportsLiveScaling()
+}
@@ -0,0 +1,99 @@
+package registry
+
+import (
+	"testing"
+
+	"github.com/rakutentech/shibuya/shibuya/config"
+	"github.com/rakutentech/shibuya/shibuya/coordinator/storage"
+	enginesModel "github.com/rakutentech/shibuya/shibuya/engines/model"
+	"github.com/rakutentech/shibuya/shibuya/model"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeEngine struct{}
+
+func (fakeEngine) Kind() model.PlanKind {
+	return model.PlanKind("grpc")
+}
+
+func (fakeEngine) TestFileExtensions() []string {
+	return []string{".proto"}
+}
+
+func (fakeEngine) MakeTestPlan(pf *storage.PlanFiles, planName, filename string, fileBytes []byte, pec enginesModel.PlanEnginesConfig) error {
+	return nil
+}
+
+func (fakeEngine) DefaultContainer() *config.ExecutorContainer {
+	return &config.ExecutorContainer{Image: "grpc:latest", CPU: "1", Mem: "1Gi"}
+}
+
+func (fakeEngine) MetricParser() func(string) (enginesModel.ShibuyaMetric, error) {
+	return func(string) (enginesModel.ShibuyaMetric, error) {
+		return enginesModel.ShibuyaMetric{}, nil
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	kind := model.PlanKind("grpc")
+	_, err := Get(kind)
+	assert.IsType(t, &UnsupportedKindError{}, err)
+	assert.False(t, kind.IsSupported())
+
+	Register(fakeEngine{})
+	e, err := Get(kind)
+	assert.Nil(t, err)
+	assert.Equal(t, kind, e.Kind())
+	assert.Contains(t, Kinds(), kind)
+	// the kind should be known by the model after registering
+	assert.True(t, kind.IsSupported())
+	p := &model.Plan{Kind: kind}
+	assert.True(t, p.IsThePlanFileValid("a.proto"))
+
+	assert.Panics(t, func() {
+		Register(fakeEngine{})
+	})
+}
+
+func TestContainerConfig(t *testing.T) {
+	kind := model.PlanKind("grpc")
+	c, err := ContainerConfig(kind, &config.ExecutorConfig{})
+	assert.Nil(t, err)
+	assert.Equal(t, "grpc:latest", c.Image)
+
+	ec := &config.ExecutorConfig{
+		EnginesContainer: map[string]*config.ExecutorContainer{
+			"grpc": {Image: "grpc:custom"},
+		},
+	}
+	c, err = ContainerConfig(kind, ec)
+	assert.Nil(t, err)
+	assert.Equal(t, "grpc:custom", c.Image)
+
+	_, err = ContainerConfig(model.PlanKind("asdf"), ec)
+	assert.NotNil(t, err)
+}
+
+type scalableEngine struct {
+	fakeEngine
+}
+
+func (scalableEngine) Kind() model.PlanKind {
+	return model.PlanKind("websocket")
+}
+
+func (scalableEngine) TestFileExtensions() []string {
+	return []string{".ws"}
+}
+
+func (scalableEngine) SupportsLiveScaling() bool {
+	return true
+}
+
+func TestSupportsLiveScaling(t *testing.T) {
+	Register(scalableEngine{})
+	assert.True(t, SupportsLiveScaling(model.PlanKind("websocket")))
+	// registered in TestRegistry without live scaling
+	assert.False(t, SupportsLiveScaling(model.PlanKind("grpc")))
+	assert.False(t, SupportsLiveScaling(model.PlanKind("asdf")))
+}
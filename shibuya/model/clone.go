@@ -0,0 +1,230 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/rakutentech/shibuya/shibuya/object_storage"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrNameTaken is returned when the project already has a plan or a collection with the name of a clone
+var ErrNameTaken = errors.New("name is already taken in the project")
+
+// cloneSuffix is added to the name of a clone in the same project when no name is given
+const cloneSuffix = " (copy)"
+
+// cloneName returns the name of the clone when no name is given. The names are unique in a project,
+// which the apply of manifests relies on.
+func cloneName(name, sourceName string, sourceProjectID, projectID int64) string {
+	if name != "" {
+		return name
+	}
+	if projectID == sourceProjectID {
+		return sourceName + cloneSuffix
+	}
+	return sourceName
+}
+
+func checkNameTaken(table string, projectID int64, name string) error {
+	db := getDB()
+	var count int
+	err := db.QueryRow(fmt.Sprintf("select count(*) from %s where project_id=? and name=?", table), projectID, name).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("%w: there is already a %s named %s", ErrNameTaken, table, name)
+	}
+	return nil
+}
+
+// freePlanName returns the name with the lowest suffix which is not taken by a plan of the project
+func freePlanName(projectID int64, name string) (string, error) {
+	candidate := name
+	for i := 1; ; i++ {
+		err := checkNameTaken("plan", projectID, candidate)
+		if !errors.Is(err, ErrNameTaken) {
+			return candidate, err
+		}
+		candidate = name + cloneSuffix
+		if i > 1 {
+			candidate = fmt.Sprintf("%s (copy %d)", name, i)
+		}
+	}
+}
+
+// copyFile copies a stored file through the object storage
+func copyFile(objStorage object_storage.StorageInterface, f *ShibuyaFile, store func(content io.ReadCloser) error) error {
+	content, err := objStorage.Download(f.Filepath)
+	if err != nil {
+		return err
+	}
+	return store(readCloser(content))
+}
+
+// Clone copies the plan together with its test file, data files, description and tags into the
+// project. The versions of the files are not copied, the current files become the first versions
+// of the clone. Without a name, the clone gets the name of the plan, suffixed in the same project.
+func (p *Plan) Clone(objStorage object_storage.StorageInterface, projectID int64, name, clonedBy string) (*Plan, error) {
+	name = cloneName(name, p.Name, p.ProjectID, projectID)
+	if err := checkNameTaken("plan", projectID, name); err != nil {
+		return nil, err
+	}
+	planID, err := CreatePlan(name, projectID, p.Kind)
+	if err != nil {
+		return nil, err
+	}
+	clone, err := GetPlan(planID)
+	if err != nil {
+		return nil, err
+	}
+	files := p.Data
+	if p.TestFile != nil {
+		files = append([]*ShibuyaFile{p.TestFile}, files...)
+	}
+	for _, f := range files {
+		err := copyFile(objStorage, f, func(content io.ReadCloser) error {
+			return clone.StoreFile(objStorage, content, f.Filename, clonedBy)
+		})
+		if err != nil {
+			clone.discard(objStorage)
+			return nil, err
+		}
+	}
+	if err := clone.Update(name, p.Description); err != nil {
+		clone.discard(objStorage)
+		return nil, err
+	}
+	if err := clone.StoreTags(p.Tags); err != nil {
+		clone.discard(objStorage)
+		return nil, err
+	}
+	return GetPlan(planID)
+}
+
+// discard removes a partially cloned plan
+func (p *Plan) discard(objStorage object_storage.StorageInterface) {
+	if plan, err := GetPlan(p.ID); err == nil {
+		p = plan
+	}
+	if err := p.Delete(objStorage); err != nil {
+		log.Error(err)
+	}
+}
+
+// Clone copies the collection with its config, data files, description and tags into the project. Within the same
+// project, the clone uses the same plans. Otherwise the plans are cloned as well, because a
+// collection can only use the plans of its own project, with the pinned test files becoming the
+// test files of the cloned plans. The cloned plans keep their names unless the project already has
+// plans with them, in which case they are suffixed. Runs and schedules are not copied. Without a
+// name, the clone gets the name of the collection, suffixed in the same project.
+func (c *Collection) Clone(objStorage object_storage.StorageInterface, projectID int64, name, clonedBy string) (*Collection, error) {
+	name = cloneName(name, c.Name, c.ProjectID, projectID)
+	if err := checkNameTaken("collection", projectID, name); err != nil {
+		return nil, err
+	}
+	ec, err := c.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	planIDs := make(map[int64]int64, len(ec.Tests))
+	var clonedPlans []*Plan
+	discard := func() {
+		for _, p := range clonedPlans {
+			p.discard(objStorage)
+		}
+	}
+	for _, ep := range ec.Tests {
+		if projectID == c.ProjectID {
+			planIDs[ep.PlanID] = ep.PlanID
+			continue
+		}
+		if _, ok := planIDs[ep.PlanID]; ok {
+			continue
+		}
+		plan, err := GetPlan(ep.PlanID)
+		if err != nil {
+			discard()
+			return nil, err
+		}
+		// the clone gets the pinned test file as its own, so it runs what the source collection runs
+		if ep.TestFileVersion != 0 {
+			if err := plan.PinTestFile(ep.TestFileVersion); err != nil {
+				discard()
+				return nil, err
+			}
+		}
+		// the project might already have a plan with the name, e.g. from an earlier clone of
+		// another collection using the plan
+		planName, err := freePlanName(projectID, plan.Name)
+		if err != nil {
+			discard()
+			return nil, err
+		}
+		clone, err := plan.Clone(objStorage, projectID, planName, clonedBy)
+		if err != nil {
+			discard()
+			return nil, err
+		}
+		clonedPlans = append(clonedPlans, clone)
+		planIDs[ep.PlanID] = clone.ID
+	}
+	collectionID, err := CreateCollection(name, projectID)
+	if err != nil {
+		discard()
+		return nil, err
+	}
+	clone, err := GetCollection(collectionID)
+	if err != nil {
+		discard()
+		return nil, err
+	}
+	fail := func(err error) (*Collection, error) {
+		if c, err := GetCollection(collectionID); err == nil {
+			clone = c
+		}
+		if err := clone.Delete(objStorage); err != nil {
+			log.Error(err)
+		}
+		discard()
+		return nil, err
+	}
+	for _, f := range c.Data {
+		err := copyFile(objStorage, f, func(content io.ReadCloser) error {
+			return clone.StoreFile(objStorage, f.Filename, content)
+		})
+		if err != nil {
+			return fail(err)
+		}
+	}
+	ec.Name, ec.ProjectID, ec.CollectionID = name, projectID, collectionID
+	for _, ep := range ec.Tests {
+		ep.PlanID = planIDs[ep.PlanID]
+		// the cloned plans don't have the versions of the source plans, their test file is the pinned one
+		if projectID != c.ProjectID {
+			ep.TestFileVersion = 0
+		}
+	}
+	for _, cr := range ec.Criteria {
+		if cr.PlanID != 0 {
+			cr.PlanID = planIDs[cr.PlanID]
+		}
+	}
+	for _, g := range ec.AbortOn {
+		if g.PlanID != 0 {
+			g.PlanID = planIDs[g.PlanID]
+		}
+	}
+	if err := clone.Store(ec); err != nil {
+		return fail(err)
+	}
+	if err := clone.Update(name, c.Description); err != nil {
+		return fail(err)
+	}
+	if err := clone.StoreTags(c.Tags); err != nil {
+		return fail(err)
+	}
+	return GetCollection(collectionID)
+}
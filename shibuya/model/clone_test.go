@@ -0,0 +1,166 @@
+package model
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/rakutentech/shibuya/shibuya/object_storage"
+	"github.com/stretchr/testify/assert"
+)
+
+type memStorage map[string][]byte
+
+func (m memStorage) Upload(filename string, content io.ReadCloser) error {
+	raw, err := io.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	m[filename] = raw
+	return nil
+}
+
+func (m memStorage) Delete(filename string) error {
+	delete(m, filename)
+	return nil
+}
+
+func (m memStorage) Download(filename string) ([]byte, error) {
+	raw, ok := m[filename]
+	if !ok {
+		return nil, object_storage.FileNotFound{}
+	}
+	return raw, nil
+}
+
+func TestCloneCollection(t *testing.T) {
+	registerTestPlanKind(t, JmeterPlan, []string{".jmx"})
+	storage := memStorage{}
+	planID, err := CreatePlan("plan", 1, JmeterPlan)
+	assert.Nil(t, err)
+	plan, err := GetPlan(planID)
+	assert.Nil(t, err)
+	assert.Nil(t, plan.StoreFile(storage, io.NopCloser(strings.NewReader("<jmx/>")), "test.jmx", ""))
+	assert.Nil(t, plan.StoreFile(storage, io.NopCloser(strings.NewReader("user")), "users.csv", ""))
+
+	collectionID, err := CreateCollection("collection", 1)
+	assert.Nil(t, err)
+	c, err := GetCollection(collectionID)
+	assert.Nil(t, err)
+	assert.Nil(t, c.StoreFile(storage, "ids.csv", io.NopCloser(strings.NewReader("1"))))
+	assert.Nil(t, c.Store(&ExecutionCollection{
+		Tests:    []*ExecutionPlan{{PlanID: planID, Engines: 1, Concurrency: 10, Duration: 1}},
+		Criteria: []*Criterion{{Rule: "p95 < 300ms", PlanID: planID}},
+	}))
+	c, err = GetCollection(collectionID)
+	assert.Nil(t, err)
+
+	// the same project shares the plans
+	clone, err := c.Clone(storage, 1, "staging", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "staging", clone.Name)
+	eps, err := clone.GetExecutionPlans()
+	assert.Nil(t, err)
+	assert.Equal(t, planID, eps[0].PlanID)
+	assert.Equal(t, []byte("1"), storage[clone.MakeFileName("ids.csv")])
+
+	// another project gets its own plans
+	clone, err = c.Clone(storage, 2, "production", "")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), clone.ProjectID)
+	eps, err = clone.GetExecutionPlans()
+	assert.Nil(t, err)
+	assert.NotEqual(t, planID, eps[0].PlanID)
+	assert.Equal(t, 10, eps[0].Concurrency)
+	criteria, err := clone.GetCriteria()
+	assert.Nil(t, err)
+	assert.Equal(t, eps[0].PlanID, criteria[0].PlanID)
+	clonedPlan, err := GetPlan(eps[0].PlanID)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), clonedPlan.ProjectID)
+	assert.Equal(t, "test.jmx", clonedPlan.TestFile.Filename)
+	assert.Equal(t, []byte("user"), storage[clonedPlan.MakeFileName("users.csv")])
+
+	// a pinned test file becomes the test file of the cloned plan
+	assert.Nil(t, plan.StoreFile(storage, io.NopCloser(strings.NewReader("<jmx v2/>")), "test.jmx", ""))
+	assert.Nil(t, c.Store(&ExecutionCollection{
+		Tests: []*ExecutionPlan{{PlanID: planID, Engines: 1, Concurrency: 10, Duration: 1, TestFileVersion: 1}},
+	}))
+	clone, err = c.Clone(storage, 3, "pinned", "")
+	assert.Nil(t, err)
+	eps, err = clone.GetExecutionPlans()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), eps[0].TestFileVersion)
+	clonedPlan, err = GetPlan(eps[0].PlanID)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("<jmx/>"), storage[clonedPlan.MakeFileName("test.jmx")])
+}
+
+func TestCloneInSameProject(t *testing.T) {
+	registerTestPlanKind(t, JmeterPlan, []string{".jmx"})
+	storage := memStorage{}
+	projectID := int64(4)
+	planID, err := CreatePlan("checkout", projectID, JmeterPlan)
+	assert.Nil(t, err)
+	plan, err := GetPlan(planID)
+	assert.Nil(t, err)
+	collectionID, err := CreateCollection("smoke", projectID)
+	assert.Nil(t, err)
+	c, err := GetCollection(collectionID)
+	assert.Nil(t, err)
+
+	// without a name, the clones are suffixed so the names stay unique in the project
+	planClone, err := plan.Clone(storage, projectID, "", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "checkout (copy)", planClone.Name)
+	collectionClone, err := c.Clone(storage, projectID, "", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "smoke (copy)", collectionClone.Name)
+
+	// the names already in the project are rejected
+	for _, name := range []string{"", "checkout", "checkout (copy)"} {
+		_, err = plan.Clone(storage, projectID, name, "")
+		assert.ErrorIs(t, err, ErrNameTaken, name)
+	}
+	for _, name := range []string{"", "smoke", "smoke (copy)"} {
+		_, err = c.Clone(storage, projectID, name, "")
+		assert.ErrorIs(t, err, ErrNameTaken, name)
+	}
+
+	// another project keeps the names
+	planClone, err = plan.Clone(storage, projectID+1, "", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "checkout", planClone.Name)
+}
+
+func TestCloneCollectionsSharingPlan(t *testing.T) {
+	registerTestPlanKind(t, JmeterPlan, []string{".jmx"})
+	storage := memStorage{}
+	projectID, targetProjectID := int64(6), int64(7)
+	planID, err := CreatePlan("search", projectID, JmeterPlan)
+	assert.Nil(t, err)
+	planNames := []string{}
+	for _, name := range []string{"search-smoke", "search-load", "search-soak"} {
+		collectionID, err := CreateCollection(name, projectID)
+		assert.Nil(t, err)
+		c, err := GetCollection(collectionID)
+		assert.Nil(t, err)
+		assert.Nil(t, c.Store(&ExecutionCollection{
+			Tests: []*ExecutionPlan{{PlanID: planID, Engines: 1, Concurrency: 10, Duration: 1}},
+		}))
+		c, err = GetCollection(collectionID)
+		assert.Nil(t, err)
+
+		// every clone gets its own plan, suffixed when the name is taken by an earlier clone
+		clone, err := c.Clone(storage, targetProjectID, "", "")
+		assert.Nil(t, err)
+		assert.Equal(t, name, clone.Name)
+		eps, err := clone.GetExecutionPlans()
+		assert.Nil(t, err)
+		clonedPlan, err := GetPlan(eps[0].PlanID)
+		assert.Nil(t, err)
+		assert.Equal(t, targetProjectID, clonedPlan.ProjectID)
+		planNames = append(planNames, clonedPlan.Name)
+	}
+	assert.Equal(t, []string{"search", "search (copy)", "search (copy 2)"}, planNames)
+}
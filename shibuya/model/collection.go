@@ -28,6 +28,8 @@ type Collection struct {
 	ID             int64            `json:"id"`
 	Name           string           `json:"name"`
 	ProjectID      int64            `json:"project_id"`
+	Description    string           `json:"description"`
+	Tags           Tags             `json:"tags"`
 	ExecutionPlans []*ExecutionPlan `json:"execution_plans"`
 	RunHistories   []*RunHistory    `json:"run_history"`
 	CreatedTime    time.Time        `json:"created_time"`
@@ -64,7 +66,7 @@ func CreateCollection(name string, projectID int64) (int64, error) {
 func GetCollection(ID int64) (*Collection, error) {
 	db := getDB()
 
-	q, err := db.Prepare("select id, name, project_id, created_time, csv_split from collection where id=?")
+	q, err := db.Prepare("select id, name, project_id, description, created_time, csv_split from collection where id=?")
 	if err != nil {
 		return nil, err
 	}
@@ -72,24 +74,76 @@ func GetCollection(ID int64) (*Collection, error) {
 
 	collection := new(Collection)
 	err = q.QueryRow(ID).Scan(&collection.ID, &collection.Name, &collection.ProjectID,
-		&collection.CreatedTime, &collection.CSVSplit)
+		&collection.Description, &collection.CreatedTime, &collection.CSVSplit)
 	if err != nil {
 		return nil, &DBError{Err: err, Message: "collection not found"}
 	}
+	tags, err := getTags(collectionTag, []int64{collection.ID})
+	if err != nil {
+		return nil, err
+	}
+	collection.Tags = tags[collection.ID]
 	if collection.Data, err = collection.getCollectionFiles(); err != nil {
 		return collection, err
 	}
 	return collection, nil
 }
 
+// Update renames the collection and changes its description. The new name needs to be free in the
+// project.
+func (c *Collection) Update(name, description string) error {
+	if name != c.Name {
+		if err := checkNameTaken("collection", c.ProjectID, name); err != nil {
+			return err
+		}
+	}
+	db := getDB()
+	q, err := db.Prepare("update collection set name=?,description=? where id=?")
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+	if _, err := q.Exec(name, description, c.ID); err != nil {
+		return err
+	}
+	c.Name, c.Description = name, description
+	return nil
+}
+
+func (c *Collection) StoreTags(tags Tags) error {
+	if err := storeTags(collectionTag, c.ID, tags); err != nil {
+		return err
+	}
+	c.Tags = tags
+	return nil
+}
+
 func (c *Collection) Delete(objectStorage object_storage.StorageInterface) error {
 	DBC := getDB()
+	if err := deleteTags(collectionTag, c.ID); err != nil {
+		return err
+	}
 	if err := c.DeleteExecutionPlans(); err != nil {
 		return err
 	}
 	if err := c.DeleteRunHistory(); err != nil {
 		return err
 	}
+	if err := c.StoreCriteria(nil); err != nil {
+		return err
+	}
+	if err := c.StoreGuards(nil); err != nil {
+		return err
+	}
+	if err := c.DeleteSchedules(); err != nil {
+		return err
+	}
+	if err := c.DequeueDeployment(); err != nil {
+		return err
+	}
+	if err := c.DeleteOneShotRuns(); err != nil {
+		return err
+	}
 	if err := c.DeleteAllFiles(objectStorage); err != nil {
 		return err
 	}
@@ -107,19 +161,30 @@ func (c *Collection) Delete(objectStorage object_storage.StorageInterface) error
 }
 
 func (c *Collection) AddExecutionPlan(ep *ExecutionPlan) error {
-	var CSVSplitDB int8
+	var CSVSplitDB, scalableDB int8
 	if ep.CSVSplit {
 		CSVSplitDB = 1
 	}
+	if ep.Scalable {
+		scalableDB = 1
+	}
+	stages, err := marshalStages(ep.Stages)
+	if err != nil {
+		return err
+	}
+	overrides, err := marshalOverrides(ep.ExecutorOverrides)
+	if err != nil {
+		return err
+	}
 	db := getDB()
 	q, err := db.Prepare(
-		"insert into collection_plan (plan_id, collection_id, rampup, concurrency, duration, engines, csv_split) values (?,?,?,?,?,?,?) on duplicate key update rampup=?, concurrency=?, duration=?, engines=?, csv_split=?")
+		"insert into collection_plan (plan_id, collection_id, rampup, concurrency, duration, engines, csv_split, stages, target_rps, test_file_version, context, overrides, scalable) values (?,?,?,?,?,?,?,?,?,?,?,?,?) on duplicate key update rampup=?, concurrency=?, duration=?, engines=?, csv_split=?, stages=?, target_rps=?, test_file_version=?, context=?, overrides=?, scalable=?")
 	if err != nil {
 		return err
 	}
 	defer q.Close()
-	_, err = q.Exec(ep.PlanID, c.ID, ep.Rampup, ep.Concurrency, ep.Duration, ep.Engines, CSVSplitDB, ep.Rampup, ep.Concurrency,
-		ep.Duration, ep.Engines, CSVSplitDB)
+	_, err = q.Exec(ep.PlanID, c.ID, ep.Rampup, ep.Concurrency, ep.Duration, ep.Engines, CSVSplitDB, stages, ep.TargetRPS, ep.TestFileVersion, ep.Context, overrides, scalableDB,
+		ep.Rampup, ep.Concurrency, ep.Duration, ep.Engines, CSVSplitDB, stages, ep.TargetRPS, ep.TestFileVersion, ep.Context, overrides, scalableDB)
 	if err != nil {
 		return err
 	}
@@ -128,7 +193,7 @@ func (c *Collection) AddExecutionPlan(ep *ExecutionPlan) error {
 
 func (c *Collection) GetExecutionPlans() ([]*ExecutionPlan, error) {
 	db := getDB()
-	q, err := db.Prepare("select plan_id, rampup, concurrency, duration, engines, csv_split from collection_plan where collection_id=?")
+	q, err := db.Prepare("select plan_id, rampup, concurrency, duration, engines, csv_split, stages, target_rps, test_file_version, context, overrides, scalable from collection_plan where collection_id=?")
 	if err != nil {
 		return nil, err
 	}
@@ -141,9 +206,17 @@ func (c *Collection) GetExecutionPlans() ([]*ExecutionPlan, error) {
 	r := []*ExecutionPlan{}
 	for rows.Next() {
 		ep := new(ExecutionPlan)
-		var CSVSplitDB int8
-		rows.Scan(&ep.PlanID, &ep.Rampup, &ep.Concurrency, &ep.Duration, &ep.Engines, &CSVSplitDB)
+		var CSVSplitDB, scalableDB int8
+		var stages, overrides sql.NullString
+		rows.Scan(&ep.PlanID, &ep.Rampup, &ep.Concurrency, &ep.Duration, &ep.Engines, &CSVSplitDB, &stages, &ep.TargetRPS, &ep.TestFileVersion, &ep.Context, &overrides, &scalableDB)
 		ep.CSVSplit = CSVSplitDB == 1
+		ep.Scalable = scalableDB == 1
+		if ep.Stages, err = unmarshalStages(stages); err != nil {
+			return nil, err
+		}
+		if ep.ExecutorOverrides, err = unmarshalOverrides(overrides); err != nil {
+			return nil, err
+		}
 		r = append(r, ep)
 	}
 	err = rows.Err()
@@ -155,19 +228,27 @@ func (c *Collection) GetExecutionPlans() ([]*ExecutionPlan, error) {
 
 func GetExecutionPlan(collectionID, planID int64) (*ExecutionPlan, error) {
 	db := getDB()
-	q, err := db.Prepare("select plan_id, rampup, concurrency, duration, engines, csv_split from collection_plan where collection_id=? and plan_id=?")
+	q, err := db.Prepare("select plan_id, rampup, concurrency, duration, engines, csv_split, stages, target_rps, test_file_version, context, overrides, scalable from collection_plan where collection_id=? and plan_id=?")
 	if err != nil {
 		return nil, err
 	}
 	defer q.Close()
 
 	ep := new(ExecutionPlan)
-	var CSVSplitDB int8
-	err = q.QueryRow(collectionID, planID).Scan(&ep.PlanID, &ep.Rampup, &ep.Concurrency, &ep.Duration, &ep.Engines, &CSVSplitDB)
+	var CSVSplitDB, scalableDB int8
+	var stages, overrides sql.NullString
+	err = q.QueryRow(collectionID, planID).Scan(&ep.PlanID, &ep.Rampup, &ep.Concurrency, &ep.Duration, &ep.Engines, &CSVSplitDB, &stages, &ep.TargetRPS, &ep.TestFileVersion, &ep.Context, &overrides, &scalableDB)
 	if err != nil {
 		return nil, err
 	}
 	ep.CSVSplit = CSVSplitDB == 1
+	ep.Scalable = scalableDB == 1
+	if ep.Stages, err = unmarshalStages(stages); err != nil {
+		return nil, err
+	}
+	if ep.ExecutorOverrides, err = unmarshalOverrides(overrides); err != nil {
+		return nil, err
+	}
 	return ep, nil
 }
 
@@ -202,6 +283,9 @@ func (c *Collection) DeleteExecutionPlans() error {
 }
 
 func (c *Collection) DeleteRunHistory() error {
+	if err := deleteRunResults("collection_id=?", c.ID); err != nil {
+		return err
+	}
 	db := getDB()
 	q, err := db.Prepare("delete from collection_run_history where collection_id=?")
 	if err != nil {
@@ -264,7 +348,10 @@ outer:
 	if err != nil {
 		return err
 	}
-	return nil
+	if err := c.StoreCriteria(ec.Criteria); err != nil {
+		return err
+	}
+	return c.StoreGuards(ec.AbortOn)
 }
 
 func (c *Collection) MakeFileName(filename string) string {
@@ -351,15 +438,17 @@ func (c *Collection) getCollectionFiles() ([]*ShibuyaFile, error) {
 	return r, nil
 }
 
+// NewRun records the run along with whether the collection has criteria at the start of the run
 func (c *Collection) NewRun(runID int64) error {
 	db := getDB()
-	q, err := db.Prepare("insert into collection_run_history (collection_id, run_id) values (?, ?)")
+	q, err := db.Prepare(`insert into collection_run_history (collection_id, run_id, has_criteria)
+select ?, ?, exists(select 1 from collection_criteria where collection_id=?)`)
 	if err != nil {
 		return err
 	}
 	defer q.Close()
 
-	_, err = q.Query(c.ID, runID)
+	_, err = q.Exec(c.ID, runID, c.ID)
 	if err != nil {
 		return err
 	}
@@ -386,11 +475,18 @@ type RunHistory struct {
 	CollectionID int64     `json:"collection_id"`
 	StartedTime  time.Time `json:"started_time"`
 	EndTime      time.Time `json:"end_time"`
+	// Verdict is either passed or failed. It's empty if the collection does not have criteria
+	Verdict string `json:"verdict"`
+	// AbortReason is set when the run is terminated by a guard
+	AbortReason string `json:"abort_reason"`
+	// HasCriteria tells whether the collection had criteria when it ran, so a run without a verdict
+	// was not judged
+	HasCriteria bool `json:"has_criteria"`
 }
 
 func GetRun(runID int64) (*RunHistory, error) {
 	db := getDB()
-	q, err := db.Prepare("select run_id, collection_id, started_time, end_time from collection_run_history where run_id=?")
+	q, err := db.Prepare("select run_id, collection_id, started_time, end_time, verdict, abort_reason, has_criteria from collection_run_history where run_id=?")
 	if err != nil {
 		return nil, err
 	}
@@ -398,19 +494,22 @@ func GetRun(runID int64) (*RunHistory, error) {
 
 	r := new(RunHistory)
 	var endTime mysql.NullTime
-	err = q.QueryRow(runID).Scan(&r.ID, &r.CollectionID, &r.StartedTime, &endTime)
+	var verdict, abortReason sql.NullString
+	err = q.QueryRow(runID).Scan(&r.ID, &r.CollectionID, &r.StartedTime, &endTime, &verdict, &abortReason, &r.HasCriteria)
 	if err != nil {
 		return nil, err
 	}
 	if endTime.Valid {
 		r.EndTime = endTime.Time
 	}
+	r.Verdict = verdict.String
+	r.AbortReason = abortReason.String
 	return r, nil
 }
 
 func (c *Collection) GetRuns() ([]*RunHistory, error) {
 	db := getDB()
-	q, err := db.Prepare("select run_id, collection_id, started_time, end_time from collection_run_history where collection_id=? order by started_time desc")
+	q, err := db.Prepare("select run_id, collection_id, started_time, end_time, verdict, abort_reason, has_criteria from collection_run_history where collection_id=? order by started_time desc")
 	if err != nil {
 		return nil, err
 	}
@@ -424,7 +523,14 @@ func (c *Collection) GetRuns() ([]*RunHistory, error) {
 	defer rs.Close()
 	for rs.Next() {
 		run := new(RunHistory)
-		rs.Scan(&run.ID, &run.CollectionID, &run.StartedTime, &run.EndTime)
+		var endTime mysql.NullTime
+		var verdict, abortReason sql.NullString
+		rs.Scan(&run.ID, &run.CollectionID, &run.StartedTime, &endTime, &verdict, &abortReason, &run.HasCriteria)
+		if endTime.Valid {
+			run.EndTime = endTime.Time
+		}
+		run.Verdict = verdict.String
+		run.AbortReason = abortReason.String
 		r = append(r, run)
 	}
 	return r, nil
@@ -524,7 +630,29 @@ func (c *Collection) HasRunningPlan() (bool, error) {
 	return false, nil
 }
 
-func (c *Collection) NewLaunchEntry(owner, cxt string, enginesCount, machinesCount, vu int64) error {
+// LaunchUsage is the usage of a launch in the cluster of a context
+type LaunchUsage struct {
+	Context      string
+	EnginesCount int64
+	NodesCount   int64
+	VU           int64
+}
+
+// ErrLaunchInProgress is returned when the collection is deployed again before it's purged
+var ErrLaunchInProgress = errors.New("launch in progress")
+
+// HasLaunchInProgress tells whether the collection is deployed and not purged yet
+func (c *Collection) HasLaunchInProgress() (bool, error) {
+	db := getDB()
+	var count int
+	if err := db.QueryRow("select count(*) from collection_launch where collection_id=?", c.ID).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// NewLaunchEntry records a launch of the collection with an entry of usage per context
+func (c *Collection) NewLaunchEntry(owner string, usages []*LaunchUsage) error {
 	db := getDB()
 	ct := context.TODO()
 	tx, err := db.BeginTx(ct, nil)
@@ -536,7 +664,7 @@ func (c *Collection) NewLaunchEntry(owner, cxt string, enginesCount, machinesCou
 	if err != nil {
 		if driverErr, ok := err.(*mysql.MySQLError); ok {
 			if driverErr.Number == 1062 {
-				return &DBError{Err: err, Message: "There is a launch in progress. Please either wait or purge."}
+				return &DBError{Err: ErrLaunchInProgress, Message: "There is a launch in progress. Please either wait or purge."}
 			}
 			return err
 		}
@@ -546,15 +674,18 @@ func (c *Collection) NewLaunchEntry(owner, cxt string, enginesCount, machinesCou
 	if err != nil {
 		return err
 	}
-	_, err = tx.Exec("insert collection_launch_history2 set collection_id=?,context=?,engines_count=?,nodes_count=?,vu=?,owner=?,launch_id=?",
-		c.ID, cxt, enginesCount, machinesCount, vu, owner, launchID)
-	if err != nil {
-		return err
+	for _, u := range usages {
+		_, err = tx.Exec("insert collection_launch_history2 set collection_id=?,context=?,engines_count=?,nodes_count=?,vu=?,owner=?,launch_id=?",
+			c.ID, u.Context, u.EnginesCount, u.NodesCount, u.VU, owner, launchID)
+		if err != nil {
+			return err
+		}
 	}
 	return tx.Commit()
 }
 
-func (c *Collection) MarkUsageFinished(cxt string, vu int64) error {
+// MarkUsageFinished ends the current launch of the collection with the final vu of every context
+func (c *Collection) MarkUsageFinished(vus map[string]int64) error {
 	db := getDB()
 	ct := context.TODO()
 
@@ -573,8 +704,14 @@ func (c *Collection) MarkUsageFinished(cxt string, vu int64) error {
 		}
 		return nil
 	}
-	_, err = tx.Exec("update collection_launch_history2 set end_time=?, vu=? where launch_id=?",
-		time.Now().Format(MySQLFormat), vu, launchID)
+	now := time.Now().Format(MySQLFormat)
+	for cxt, vu := range vus {
+		_, err = tx.Exec("update collection_launch_history2 set vu=? where launch_id=? and context=?", vu, launchID, cxt)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("update collection_launch_history2 set end_time=? where launch_id=?", now, launchID)
 	if err != nil {
 		return err
 	}
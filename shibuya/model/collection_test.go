@@ -164,6 +164,29 @@ func TestCollectionRuns(t *testing.T) {
 
 }
 
+func TestRunHasCriteria(t *testing.T) {
+	collectionID, err := CreateCollection("collection", 1)
+	assert.Nil(t, err)
+	c, err := GetCollection(collectionID)
+	assert.Nil(t, err)
+	assert.Nil(t, c.NewRun(11))
+	assert.Nil(t, c.StoreCriteria([]*Criterion{{Rule: "error_rate < 1%"}}))
+	assert.Nil(t, c.NewRun(12))
+
+	// the criteria added later do not change the older runs
+	r, err := GetRun(11)
+	assert.Nil(t, err)
+	assert.False(t, r.HasCriteria)
+	r, err = GetRun(12)
+	assert.Nil(t, err)
+	assert.True(t, r.HasCriteria)
+
+	assert.Nil(t, c.StoreRunWithoutCriteria(12))
+	r, err = GetRun(12)
+	assert.Nil(t, err)
+	assert.False(t, r.HasCriteria)
+}
+
 func TestCollectionRun(t *testing.T) {
 	collectionName := "collection"
 	collectionID, err := CreateCollection(collectionName, 1)
@@ -0,0 +1,247 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	RunPassed = "passed"
+	RunFailed = "failed"
+)
+
+// Criterion is a pass/fail rule of a collection, for example `p95 < 300ms`, `error_rate < 1%` or
+// `rps > 500`. Without a plan, the rule is evaluated against the whole run. Label can only be used
+// together with a plan.
+type Criterion struct {
+	Rule   string `yaml:"rule" json:"rule"`
+	PlanID int64  `yaml:"testid,omitempty" json:"plan_id"`
+	Label  string `yaml:"label,omitempty" json:"label"`
+	// Plan refers to the plan by its name in manifests
+	Plan string `yaml:"plan,omitempty" json:"-"`
+}
+
+// Rule is the parsed form of a rule string
+type Rule struct {
+	Metric    string
+	Op        string
+	Threshold float64
+}
+
+// Latencies are compared in milliseconds, error rate in percent and rps in requests per second
+var (
+	ruleRe         = regexp.MustCompile(`^\s*([a-z0-9_]+)\s*(<=|>=|<|>)\s*([0-9.]+)\s*(ms|s|%)?\s*$`)
+	latencyMetrics = map[string]func(*RunSummary) float64{
+		"p50":  func(s *RunSummary) float64 { return s.P50Latency },
+		"p90":  func(s *RunSummary) float64 { return s.P90Latency },
+		"p95":  func(s *RunSummary) float64 { return s.P95Latency },
+		"p99":  func(s *RunSummary) float64 { return s.P99Latency },
+		"mean": func(s *RunSummary) float64 { return s.MeanLatency },
+		"min":  func(s *RunSummary) float64 { return s.MinLatency },
+		"max":  func(s *RunSummary) float64 { return s.MaxLatency },
+	}
+)
+
+func ParseRule(rule string) (*Rule, error) {
+	m := ruleRe.FindStringSubmatch(rule)
+	if m == nil {
+		return nil, fmt.Errorf("invalid rule %q, rules should look like `p95 < 300ms`", rule)
+	}
+	threshold, err := strconv.ParseFloat(m[3], 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid threshold in rule %q", rule)
+	}
+	metric, unit := m[1], m[4]
+	switch {
+	case latencyMetrics[metric] != nil:
+		switch unit {
+		case "s":
+			threshold *= 1000
+		case "ms", "":
+		default:
+			return nil, fmt.Errorf("invalid unit %s for %s in rule %q", unit, metric, rule)
+		}
+	case metric == "error_rate":
+		if unit != "" && unit != "%" {
+			return nil, fmt.Errorf("invalid unit %s for %s in rule %q", unit, metric, rule)
+		}
+	case metric == "rps":
+		if unit != "" {
+			return nil, fmt.Errorf("invalid unit %s for %s in rule %q", unit, metric, rule)
+		}
+	default:
+		return nil, fmt.Errorf("unknown metric %s in rule %q", metric, rule)
+	}
+	return &Rule{Metric: metric, Op: m[2], Threshold: threshold}, nil
+}
+
+func (pr *Rule) Value(s *RunSummary) float64 {
+	switch pr.Metric {
+	case "error_rate":
+		return s.ErrorRate()
+	case "rps":
+		return s.Throughput
+	}
+	return latencyMetrics[pr.Metric](s)
+}
+
+func (pr *Rule) Check(v float64) bool {
+	switch pr.Op {
+	case "<":
+		return v < pr.Threshold
+	case "<=":
+		return v <= pr.Threshold
+	case ">":
+		return v > pr.Threshold
+	case ">=":
+		return v >= pr.Threshold
+	}
+	return false
+}
+
+func (c *Criterion) Validate() error {
+	if c.Label != "" && c.PlanID == 0 {
+		return fmt.Errorf("label %s in rule %q requires a testid", c.Label, c.Rule)
+	}
+	_, err := ParseRule(c.Rule)
+	return err
+}
+
+type CriterionResult struct {
+	Criterion
+	Actual  float64 `json:"actual"`
+	Passed  bool    `json:"passed"`
+	Message string  `json:"message,omitempty"`
+}
+
+// EvaluateCriteria returns the verdict of a run along with the result of every rule. A rule whose
+// plan or label has no results is considered as failed. The labels are compared as they are stored,
+// so a rule is evaluated against the stored summary of its label.
+func EvaluateCriteria(criteria []*Criterion, summaries []*RunSummary) (string, []*CriterionResult) {
+	byKey := make(map[summaryKey]*RunSummary)
+	for _, s := range makeStorableSummaries(summaries) {
+		byKey[summaryKey{planID: s.PlanID, label: s.Label}] = s
+	}
+	verdict := RunPassed
+	results := make([]*CriterionResult, len(criteria))
+	for i, c := range criteria {
+		cr := &CriterionResult{Criterion: *c}
+		results[i] = cr
+		pr, err := ParseRule(c.Rule)
+		if err != nil {
+			cr.Message = err.Error()
+		} else if s, ok := byKey[summaryKey{planID: c.PlanID, label: truncateSummaryField(c.Label)}]; !ok || s.RequestCount == 0 {
+			cr.Message = "no results are found"
+		} else {
+			cr.Actual = pr.Value(s)
+			cr.Passed = pr.Check(cr.Actual)
+		}
+		if !cr.Passed {
+			verdict = RunFailed
+		}
+	}
+	return verdict, results
+}
+
+func (c *Collection) StoreCriteria(criteria []*Criterion) error {
+	db := getDB()
+	tx, err := db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("delete from collection_criteria where collection_id=?", c.ID); err != nil {
+		return err
+	}
+	for _, cr := range criteria {
+		_, err := tx.Exec("insert collection_criteria set collection_id=?,plan_id=?,label=?,rule=?",
+			c.ID, cr.PlanID, truncateSummaryField(cr.Label), strings.TrimSpace(cr.Rule))
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (c *Collection) GetCriteria() ([]*Criterion, error) {
+	db := getDB()
+	q, err := db.Prepare("select plan_id, label, rule from collection_criteria where collection_id=? order by id")
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+	rs, err := q.Query(c.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rs.Close()
+	r := []*Criterion{}
+	for rs.Next() {
+		cr := new(Criterion)
+		if err := rs.Scan(&cr.PlanID, &cr.Label, &cr.Rule); err != nil {
+			return nil, err
+		}
+		r = append(r, cr)
+	}
+	return r, nil
+}
+
+// StoreRunWithoutCriteria records that the run is not judged as the collection has no criteria anymore
+func (c *Collection) StoreRunWithoutCriteria(runID int64) error {
+	db := getDB()
+	_, err := db.Exec("update collection_run_history set has_criteria=0 where collection_id=? and run_id=?", c.ID, runID)
+	return err
+}
+
+// StoreRunVerdict replaces the verdict of the run and the results of every rule
+func (c *Collection) StoreRunVerdict(runID int64, verdict string, results []*CriterionResult) error {
+	db := getDB()
+	tx, err := db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("update collection_run_history set verdict=?,has_criteria=1 where collection_id=? and run_id=?",
+		verdict, c.ID, runID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("delete from run_criteria_result where run_id=?", runID); err != nil {
+		return err
+	}
+	for _, r := range results {
+		_, err := tx.Exec("insert run_criteria_result set run_id=?,collection_id=?,plan_id=?,label=?,rule=?,actual=?,passed=?,message=?",
+			runID, c.ID, r.PlanID, truncateSummaryField(r.Label), r.Rule, r.Actual, r.Passed, r.Message)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func GetRunCriteriaResults(runID int64) ([]*CriterionResult, error) {
+	db := getDB()
+	q, err := db.Prepare("select plan_id, label, rule, actual, passed, message from run_criteria_result where run_id=? order by id")
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+	rs, err := q.Query(runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rs.Close()
+	r := []*CriterionResult{}
+	for rs.Next() {
+		cr := new(CriterionResult)
+		if err := rs.Scan(&cr.PlanID, &cr.Label, &cr.Rule, &cr.Actual, &cr.Passed, &cr.Message); err != nil {
+			return nil, err
+		}
+		r = append(r, cr)
+	}
+	return r, nil
+}
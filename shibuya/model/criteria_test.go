@@ -0,0 +1,69 @@
+package model
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRule(t *testing.T) {
+	pr, err := ParseRule("p95 < 300ms")
+	assert.Nil(t, err)
+	assert.Equal(t, &Rule{Metric: "p95", Op: "<", Threshold: 300}, pr)
+
+	pr, err = ParseRule("p99<=1.5s")
+	assert.Nil(t, err)
+	assert.Equal(t, float64(1500), pr.Threshold)
+
+	pr, err = ParseRule("error_rate < 1%")
+	assert.Nil(t, err)
+	assert.Equal(t, float64(1), pr.Threshold)
+
+	pr, err = ParseRule("rps > 500")
+	assert.Nil(t, err)
+	assert.Equal(t, ">", pr.Op)
+
+	for _, invalid := range []string{"p95 300ms", "p42 < 1ms", "rps > 5%", "error_rate < 1s", ""} {
+		_, err = ParseRule(invalid)
+		assert.NotNil(t, err, invalid)
+	}
+
+	c := &Criterion{Rule: "p95 < 300ms", Label: "/a"}
+	assert.NotNil(t, c.Validate())
+}
+
+func TestEvaluateCriteria(t *testing.T) {
+	summaries := []*RunSummary{
+		{PlanID: 0, RequestCount: 1000, ErrorCount: 5, P95Latency: 250, Throughput: 600},
+		{PlanID: 1, Label: "/a", RequestCount: 100, P95Latency: 400, Throughput: 50},
+	}
+	criteria := []*Criterion{
+		{Rule: "p95 < 300ms"},
+		{Rule: "error_rate < 1%"},
+		{Rule: "rps > 500"},
+	}
+	verdict, results := EvaluateCriteria(criteria, summaries)
+	assert.Equal(t, RunPassed, verdict)
+	assert.Equal(t, 0.5, results[1].Actual)
+
+	criteria = append(criteria, &Criterion{Rule: "p95 < 300ms", PlanID: 1, Label: "/a"},
+		&Criterion{Rule: "p95 < 300ms", PlanID: 1, Label: "/b"})
+	verdict, results = EvaluateCriteria(criteria, summaries)
+	assert.Equal(t, RunFailed, verdict)
+	assert.False(t, results[3].Passed)
+	assert.Equal(t, float64(400), results[3].Actual)
+	assert.False(t, results[4].Passed)
+	assert.NotEmpty(t, results[4].Message)
+
+	// the labels too long to be stored are compared as they are stored
+	longLabel := "/search?q=" + strings.Repeat("a", summaryFieldLength)
+	summaries = append(summaries, &RunSummary{PlanID: 1, Label: longLabel, RequestCount: 10, P95Latency: 100})
+	criteria = []*Criterion{
+		{Rule: "p95 < 300ms", PlanID: 1, Label: longLabel},
+		{Rule: "p95 < 300ms", PlanID: 1, Label: truncateSummaryField(longLabel)},
+	}
+	verdict, results = EvaluateCriteria(criteria, summaries)
+	assert.Equal(t, RunPassed, verdict)
+	assert.Equal(t, float64(100), results[0].Actual)
+}
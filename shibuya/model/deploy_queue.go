@@ -0,0 +1,163 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+
+	mysql "github.com/go-sql-driver/mysql"
+)
+
+// QueuedDeployment is a deployment of a collection waiting for the capacity of the clusters. The
+// deployments with a higher priority are admitted first, then the oldest ones. Message tells why
+// the deployment is still waiting, or why it failed.
+type QueuedDeployment struct {
+	CollectionID int64     `json:"collection_id"`
+	Priority     int       `json:"priority"`
+	CreatedBy    string    `json:"created_by"`
+	Message      string    `json:"message"`
+	CreatedTime  time.Time `json:"created_time"`
+	// Position starts from 1 for the next deployment to be admitted. It's 0 for a failed deployment.
+	Position int `json:"position"`
+	// Failed deployments are not in the queue anymore
+	Failed bool `json:"failed"`
+}
+
+func (c *Collection) QueueDeployment(createdBy string, priority int, message string) (*QueuedDeployment, error) {
+	db := getDB()
+	q, err := db.Prepare("insert deploy_queue set collection_id=?,priority=?,created_by=?,message=?")
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+	if _, err := q.Exec(c.ID, priority, createdBy, message); err != nil {
+		if driverErr, ok := err.(*mysql.MySQLError); ok && driverErr.Number == 1062 {
+			return nil, &DBError{Err: err, Message: "The deployment of the collection is already queued"}
+		}
+		return nil, err
+	}
+	return c.GetQueuedDeployment()
+}
+
+// GetQueuedDeployments returns the queue in the order of admission
+func GetQueuedDeployments() ([]*QueuedDeployment, error) {
+	db := getDB()
+	q, err := db.Prepare("select collection_id, priority, created_by, message, created_time from deploy_queue where failed=0 order by priority desc, created_time, collection_id")
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+	rs, err := q.Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rs.Close()
+	r := []*QueuedDeployment{}
+	for rs.Next() {
+		qd := new(QueuedDeployment)
+		var message sql.NullString
+		if err := rs.Scan(&qd.CollectionID, &qd.Priority, &qd.CreatedBy, &message, &qd.CreatedTime); err != nil {
+			return nil, err
+		}
+		qd.Message = message.String
+		qd.Position = len(r) + 1
+		r = append(r, qd)
+	}
+	return r, nil
+}
+
+func (c *Collection) GetQueuedDeployment() (*QueuedDeployment, error) {
+	queue, err := GetQueuedDeployments()
+	if err != nil {
+		return nil, err
+	}
+	for _, qd := range queue {
+		if qd.CollectionID == c.ID {
+			return qd, nil
+		}
+	}
+	db := getDB()
+	q, err := db.Prepare("select collection_id, priority, created_by, message, created_time from deploy_queue where collection_id=? and failed=1")
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+	qd := &QueuedDeployment{Failed: true}
+	var message sql.NullString
+	err = q.QueryRow(c.ID).Scan(&qd.CollectionID, &qd.Priority, &qd.CreatedBy, &message, &qd.CreatedTime)
+	if err == sql.ErrNoRows {
+		return nil, &DBError{Err: err, Message: "The deployment of the collection is not queued"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	qd.Message = message.String
+	return qd, nil
+}
+
+func (qd *QueuedDeployment) SetPriority(priority int) error {
+	db := getDB()
+	if _, err := db.Exec("update deploy_queue set priority=? where collection_id=?", priority, qd.CollectionID); err != nil {
+		return err
+	}
+	qd.Priority = priority
+	return nil
+}
+
+func (qd *QueuedDeployment) SetMessage(message string) error {
+	db := getDB()
+	if _, err := db.Exec("update deploy_queue set message=? where collection_id=?", message, qd.CollectionID); err != nil {
+		return err
+	}
+	qd.Message = message
+	return nil
+}
+
+// Fail takes the deployment out of the queue and keeps why it failed
+func (qd *QueuedDeployment) Fail(message string) error {
+	db := getDB()
+	if _, err := db.Exec("update deploy_queue set failed=1,message=? where collection_id=?", message, qd.CollectionID); err != nil {
+		return err
+	}
+	qd.Failed = true
+	qd.Message = message
+	qd.Position = 0
+	return nil
+}
+
+// Claim takes the deployment out of the queue before it's deployed, so it cannot be cancelled while
+// being deployed. It tells false when the deployment has been cancelled or has failed meanwhile.
+func (qd *QueuedDeployment) Claim() (bool, error) {
+	db := getDB()
+	r, err := db.Exec("delete from deploy_queue where collection_id=? and failed=0", qd.CollectionID)
+	if err != nil {
+		return false, err
+	}
+	n, err := r.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// Requeue puts the claimed deployment back at its position in the queue when it could not be
+// deployed yet, or keeps it as failed. It does nothing when the collection has been queued again
+// meanwhile.
+func (qd *QueuedDeployment) Requeue(failed bool, message string) error {
+	db := getDB()
+	_, err := db.Exec("insert ignore deploy_queue set collection_id=?,priority=?,created_by=?,message=?,created_time=?,failed=?",
+		qd.CollectionID, qd.Priority, qd.CreatedBy, message, qd.CreatedTime, failed)
+	if err != nil {
+		return err
+	}
+	qd.Failed = failed
+	qd.Message = message
+	return nil
+}
+
+// DequeueDeployment removes the deployment of the collection from the queue. It does nothing when
+// the deployment is not queued.
+func (c *Collection) DequeueDeployment() error {
+	db := getDB()
+	_, err := db.Exec("delete from deploy_queue where collection_id=?", c.ID)
+	return err
+}
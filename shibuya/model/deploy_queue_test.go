@@ -0,0 +1,34 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClaimQueuedDeployment(t *testing.T) {
+	c := &Collection{ID: 4242}
+	defer c.DequeueDeployment()
+	qd, err := c.QueueDeployment("alice", 1, "waiting")
+	assert.Nil(t, err)
+
+	claimed, err := qd.Claim()
+	assert.Nil(t, err)
+	assert.True(t, claimed)
+	// a deployment being deployed cannot be cancelled
+	_, err = c.GetQueuedDeployment()
+	assert.NotNil(t, err)
+
+	// the deployment gets its position back when it could not be deployed yet
+	assert.Nil(t, qd.Requeue(false, "quota"))
+	requeued, err := c.GetQueuedDeployment()
+	assert.Nil(t, err)
+	assert.Equal(t, qd.CreatedTime, requeued.CreatedTime)
+	assert.Equal(t, "quota", requeued.Message)
+
+	// a cancelled deployment is not claimed
+	assert.Nil(t, c.DequeueDeployment())
+	claimed, err = qd.Claim()
+	assert.Nil(t, err)
+	assert.False(t, claimed)
+}
@@ -8,3 +8,7 @@ type DBError struct {
 func (e *DBError) Error() string {
 	return e.Message
 }
+
+func (e *DBError) Unwrap() error {
+	return e.Err
+}
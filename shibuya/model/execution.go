@@ -8,6 +8,27 @@ type ExecutionPlan struct {
 	Engines     int    `yaml:"engines" json:"engines"`
 	Duration    int    `yaml:"duration" json:"duration"`
 	CSVSplit    bool   `yaml:"csv_split" json:"csv_split"` // go-sql-driver does not support tinyint mapped to bool directly: https://github.com/go-sql-driver/mysql/issues/440
+	// When stages are set, they replace concurrency, rampup and duration
+	Stages []*Stage `yaml:"stages,omitempty" json:"stages,omitempty"`
+	// TargetRPS turns the plan into an open model. The requests per second are split evenly across
+	// the engines and ramped up within the rampup. Concurrency still needs to be high enough to reach it.
+	TargetRPS float64 `yaml:"target_rps,omitempty" json:"target_rps"`
+	// TestFileVersion pins the plan to a version of its test file. 0 means the latest test file.
+	TestFileVersion int64 `yaml:"test_file_version,omitempty" json:"test_file_version"`
+	// Context is the executor cluster the engines of the plan run in. Empty means the default cluster.
+	Context string `yaml:"context,omitempty" json:"context"`
+	// Scalable plans run with engine settings that let their concurrency change during the run
+	Scalable bool `yaml:"scalable,omitempty" json:"scalable"`
+	// ExecutorOverrides change the engine containers of the plan from the ones of its kind
+	ExecutorOverrides `yaml:",inline"`
+}
+
+// EngineRPS is the share of the target rps of every engine
+func (ep *ExecutionPlan) EngineRPS() float64 {
+	if ep.Engines <= 0 {
+		return 0
+	}
+	return ep.TargetRPS / float64(ep.Engines)
 }
 
 type ExecutionCollection struct {
@@ -16,6 +37,8 @@ type ExecutionCollection struct {
 	CollectionID int64            `yaml:"collectionid"`
 	Tests        []*ExecutionPlan `yaml:"tests"`
 	CSVSplit     bool             `yaml:"csv_split"`
+	Criteria     []*Criterion     `yaml:"criteria,omitempty"`
+	AbortOn      []*Guard         `yaml:"abort_on,omitempty"`
 }
 
 type ExecutionWrapper struct {
@@ -0,0 +1,13 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEngineRPS(t *testing.T) {
+	assert.Equal(t, 25.0, (&ExecutionPlan{Engines: 4, TargetRPS: 100}).EngineRPS())
+	assert.Equal(t, 0.0, (&ExecutionPlan{Engines: 4}).EngineRPS())
+	assert.Equal(t, 0.0, (&ExecutionPlan{TargetRPS: 100}).EngineRPS())
+}
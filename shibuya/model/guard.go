@@ -0,0 +1,106 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+const (
+	DefaultGuardWindow = 30
+	// MaxGuardSeconds bounds window and for as the guard keeps one slot per second of its window
+	MaxGuardSeconds = 3600
+)
+
+// Guard aborts a running collection when its rule is breached. Unlike criteria, the rule describes
+// the abort condition, for example `error_rate > 5%` or `p99 > 2s`.
+// The rule is evaluated every second against the metrics of the last Window seconds and the
+// collection is terminated once the rule has been breached for For seconds.
+type Guard struct {
+	Rule   string `yaml:"rule" json:"rule"`
+	PlanID int64  `yaml:"testid,omitempty" json:"plan_id"`
+	Label  string `yaml:"label,omitempty" json:"label"`
+	Window int    `yaml:"window,omitempty" json:"window"`
+	For    int    `yaml:"for,omitempty" json:"for"`
+	// MinRequests prevents a few failed requests at the beginning of the run from aborting it
+	MinRequests int `yaml:"min_requests,omitempty" json:"min_requests"`
+	// Plan refers to the plan by its name in manifests
+	Plan string `yaml:"plan,omitempty" json:"-"`
+}
+
+func (g *Guard) Validate() error {
+	if g.Label != "" && g.PlanID == 0 {
+		return fmt.Errorf("label %s in rule %q requires a testid", g.Label, g.Rule)
+	}
+	if g.Window < 0 || g.For < 0 || g.MinRequests < 0 {
+		return fmt.Errorf("window, for and min_requests in rule %q cannot be negative", g.Rule)
+	}
+	if g.Window > MaxGuardSeconds || g.For > MaxGuardSeconds {
+		return fmt.Errorf("window and for in rule %q cannot be more than %d seconds", g.Rule, MaxGuardSeconds)
+	}
+	_, err := ParseRule(g.Rule)
+	return err
+}
+
+func (g *Guard) WindowSeconds() int {
+	if g.Window == 0 {
+		return DefaultGuardWindow
+	}
+	return g.Window
+}
+
+func (c *Collection) StoreGuards(guards []*Guard) error {
+	db := getDB()
+	tx, err := db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("delete from collection_guard where collection_id=?", c.ID); err != nil {
+		return err
+	}
+	for _, g := range guards {
+		_, err := tx.Exec("insert collection_guard set collection_id=?,plan_id=?,label=?,rule=?,window_seconds=?,for_seconds=?,min_requests=?",
+			c.ID, g.PlanID, g.Label, strings.TrimSpace(g.Rule), g.Window, g.For, g.MinRequests)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (c *Collection) GetGuards() ([]*Guard, error) {
+	db := getDB()
+	q, err := db.Prepare("select plan_id, label, rule, window_seconds, for_seconds, min_requests from collection_guard where collection_id=? order by id")
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+	rs, err := q.Query(c.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rs.Close()
+	r := []*Guard{}
+	for rs.Next() {
+		g := new(Guard)
+		if err := rs.Scan(&g.PlanID, &g.Label, &g.Rule, &g.Window, &g.For, &g.MinRequests); err != nil {
+			return nil, err
+		}
+		r = append(r, g)
+	}
+	return r, nil
+}
+
+// AbortRun records why the run is aborted. The run itself is finished by the termination.
+func (c *Collection) AbortRun(runID int64, reason string) error {
+	db := getDB()
+	q, err := db.Prepare("update collection_run_history set abort_reason=? where collection_id=? and run_id=?")
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+	_, err = q.Exec(reason, c.ID, runID)
+	return err
+}
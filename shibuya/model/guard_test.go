@@ -0,0 +1,20 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGuardValidate(t *testing.T) {
+	assert.Nil(t, (&Guard{Rule: "error_rate > 5%", Window: 60, For: 10}).Validate())
+	assert.NotNil(t, (&Guard{Rule: "p99 > 2s", Label: "login"}).Validate())
+	assert.NotNil(t, (&Guard{Rule: "p99 > 2s", For: -1}).Validate())
+	assert.Nil(t, (&Guard{Rule: "p99 > 2s", Window: MaxGuardSeconds, For: MaxGuardSeconds}).Validate())
+	assert.NotNil(t, (&Guard{Rule: "p99 > 2s", Window: 2000000000}).Validate())
+	assert.NotNil(t, (&Guard{Rule: "p99 > 2s", For: MaxGuardSeconds + 1}).Validate())
+	assert.NotNil(t, (&Guard{Rule: "latency > 2s"}).Validate())
+
+	assert.Equal(t, DefaultGuardWindow, (&Guard{}).WindowSeconds())
+	assert.Equal(t, 60, (&Guard{Window: 60}).WindowSeconds())
+}
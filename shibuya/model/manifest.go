@@ -0,0 +1,278 @@
+package model
+
+import (
+	"fmt"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	ChangeCreate = "create"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+)
+
+// Manifest describes a project with its plans and collections. Collections use the same format as
+// the collection yaml, but refer to the plans by their names instead of testid. Files are referred
+// to by their paths relative to the manifest and are stored by their base names.
+type Manifest struct {
+	Project     *ManifestProject      `yaml:"project"`
+	Plans       []*ManifestPlan       `yaml:"plans"`
+	Collections []*ManifestCollection `yaml:"collections"`
+}
+
+type ManifestProject struct {
+	Name  string `yaml:"name"`
+	Owner string `yaml:"owner"`
+	SID   string `yaml:"sid,omitempty"`
+}
+
+type ManifestPlan struct {
+	Name      string   `yaml:"name"`
+	Kind      PlanKind `yaml:"kind"`
+	TestFile  string   `yaml:"test_file"`
+	DataFiles []string `yaml:"data_files,omitempty"`
+}
+
+type ManifestCollection struct {
+	ExecutionCollection `yaml:",inline"`
+	DataFiles           []string `yaml:"data_files,omitempty"`
+}
+
+// ManifestChange is a change needed to reconcile the current state with the manifest
+type ManifestChange struct {
+	Action string `json:"action"`
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type ApplyResult struct {
+	DryRun    bool              `json:"dry_run"`
+	ProjectID int64             `json:"project_id"`
+	Changes   []*ManifestChange `json:"changes"`
+}
+
+// ParseManifest only parses the manifest. It needs to be validated after the plan kinds are
+// registered.
+func ParseManifest(raw []byte) (*Manifest, error) {
+	m := new(Manifest)
+	if err := yaml.UnmarshalStrict(raw, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *Manifest) Validate() error {
+	if m.Project == nil || m.Project.Name == "" || m.Project.Owner == "" {
+		return fmt.Errorf("project name and owner cannot be empty")
+	}
+	plans := make(map[string]*ManifestPlan, len(m.Plans))
+	for _, p := range m.Plans {
+		if p.Name == "" {
+			return fmt.Errorf("plan name cannot be empty")
+		}
+		if _, ok := plans[p.Name]; ok {
+			return fmt.Errorf("plan %s is declared more than once", p.Name)
+		}
+		plans[p.Name] = p
+		if !p.Kind.IsSupported() {
+			return fmt.Errorf("invalid kind %s of plan %s", p.Kind, p.Name)
+		}
+		plan := &Plan{Kind: p.Kind}
+		if !plan.IsThePlanFileValid(p.TestFile) {
+			return fmt.Errorf("test file of plan %s should be one of %v", p.Name, p.Kind.TestFileExtensions())
+		}
+		for _, f := range p.DataFiles {
+			if plan.IsThePlanFileValid(f) {
+				return fmt.Errorf("data file %s of plan %s cannot be a test file", f, p.Name)
+			}
+		}
+		if err := uniqueBaseNames(append([]string{p.TestFile}, p.DataFiles...)); err != nil {
+			return fmt.Errorf("plan %s: %w", p.Name, err)
+		}
+	}
+	collections := make(map[string]struct{}, len(m.Collections))
+	for _, c := range m.Collections {
+		if c.Name == "" {
+			return fmt.Errorf("collection name cannot be empty")
+		}
+		if _, ok := collections[c.Name]; ok {
+			return fmt.Errorf("collection %s is declared more than once", c.Name)
+		}
+		collections[c.Name] = struct{}{}
+		if err := c.validate(plans); err != nil {
+			return fmt.Errorf("collection %s: %w", c.Name, err)
+		}
+	}
+	return nil
+}
+
+func (mc *ManifestCollection) validate(plans map[string]*ManifestPlan) error {
+	tests := make(map[string]struct{}, len(mc.Tests))
+	for _, ep := range mc.Tests {
+		if ep.PlanID != 0 {
+			return fmt.Errorf("tests should refer to the plans by name instead of testid")
+		}
+		if ep.TestFileVersion != 0 {
+			return fmt.Errorf("plan %s cannot be pinned as the test file is in the manifest", ep.Name)
+		}
+		if _, ok := plans[ep.Name]; !ok {
+			return fmt.Errorf("plan %q is not in the manifest", ep.Name)
+		}
+		if _, ok := tests[ep.Name]; ok {
+			return fmt.Errorf("plan %s is added more than once", ep.Name)
+		}
+		tests[ep.Name] = struct{}{}
+	}
+	check := func(planID int64, plan, rule string) error {
+		if planID != 0 {
+			return fmt.Errorf("rule %q should refer to the plan by name instead of testid", rule)
+		}
+		if _, ok := tests[plan]; plan != "" && !ok {
+			return fmt.Errorf("plan %q in rule %q is not in the collection", plan, rule)
+		}
+		return nil
+	}
+	for _, c := range mc.Criteria {
+		if err := check(c.PlanID, c.Plan, c.Rule); err != nil {
+			return err
+		}
+	}
+	for _, g := range mc.AbortOn {
+		if err := check(g.PlanID, g.Plan, g.Rule); err != nil {
+			return err
+		}
+	}
+	return uniqueBaseNames(mc.DataFiles)
+}
+
+func uniqueBaseNames(files []string) error {
+	names := make(map[string]string, len(files))
+	for _, f := range files {
+		name := path.Base(f)
+		if other, ok := names[name]; ok {
+			return fmt.Errorf("files %s and %s have the same name", other, f)
+		}
+		names[name] = f
+	}
+	return nil
+}
+
+// Files returns the paths of all the files referred to by the manifest
+func (m *Manifest) Files() []string {
+	seen := make(map[string]struct{})
+	files := []string{}
+	add := func(f string) {
+		if _, ok := seen[f]; !ok {
+			seen[f] = struct{}{}
+			files = append(files, f)
+		}
+	}
+	for _, p := range m.Plans {
+		add(p.TestFile)
+		for _, f := range p.DataFiles {
+			add(f)
+		}
+	}
+	for _, c := range m.Collections {
+		for _, f := range c.DataFiles {
+			add(f)
+		}
+	}
+	return files
+}
+
+// Resolve returns the collection config with the plan names replaced by their ids
+func (mc *ManifestCollection) Resolve(planIDs map[string]int64) *ExecutionCollection {
+	ec := mc.ExecutionCollection
+	ec.Tests = make([]*ExecutionPlan, len(mc.Tests))
+	for i, ep := range mc.Tests {
+		resolved := *ep
+		resolved.PlanID = planIDs[ep.Name]
+		ec.Tests[i] = &resolved
+	}
+	ec.Criteria = make([]*Criterion, len(mc.Criteria))
+	for i, c := range mc.Criteria {
+		resolved := *c
+		resolved.PlanID, resolved.Plan = planIDs[c.Plan], ""
+		ec.Criteria[i] = &resolved
+	}
+	ec.AbortOn = make([]*Guard, len(mc.AbortOn))
+	for i, g := range mc.AbortOn {
+		resolved := *g
+		resolved.PlanID, resolved.Plan = planIDs[g.Plan], ""
+		ec.AbortOn[i] = &resolved
+	}
+	return &ec
+}
+
+// nameForm returns the collection config referring to the plans by their names, which is the form
+// the configs are compared in
+func nameForm(ec *ExecutionCollection, planNames map[int64]string) *ExecutionCollection {
+	r := &ExecutionCollection{Name: ec.Name, CSVSplit: ec.CSVSplit}
+	for _, ep := range ec.Tests {
+		t := *ep
+		if name, ok := planNames[ep.PlanID]; ok {
+			t.Name = name
+		}
+		t.PlanID = 0
+		if len(t.Stages) == 0 {
+			t.Stages = nil
+		}
+		r.Tests = append(r.Tests, &t)
+	}
+	sort.Slice(r.Tests, func(i, j int) bool { return r.Tests[i].Name < r.Tests[j].Name })
+	for _, c := range ec.Criteria {
+		t := *c
+		if t.PlanID != 0 {
+			t.Plan = planNames[t.PlanID]
+		}
+		t.PlanID, t.Rule = 0, strings.TrimSpace(t.Rule)
+		r.Criteria = append(r.Criteria, &t)
+	}
+	for _, g := range ec.AbortOn {
+		t := *g
+		if t.PlanID != 0 {
+			t.Plan = planNames[t.PlanID]
+		}
+		t.PlanID, t.Rule = 0, strings.TrimSpace(t.Rule)
+		r.AbortOn = append(r.AbortOn, &t)
+	}
+	return r
+}
+
+// ConfigEqual tells whether two collection configs are the same. The configs are compared by the
+// plan names, so plans don't need to exist for the desired config.
+func ConfigEqual(current, desired *ExecutionCollection, planNames map[int64]string) bool {
+	return reflect.DeepEqual(nameForm(current, planNames), nameForm(desired, planNames))
+}
+
+// GetConfig returns the current config of the collection
+func (c *Collection) GetConfig() (*ExecutionCollection, error) {
+	eps, err := c.GetExecutionPlans()
+	if err != nil {
+		return nil, err
+	}
+	criteria, err := c.GetCriteria()
+	if err != nil {
+		return nil, err
+	}
+	guards, err := c.GetGuards()
+	if err != nil {
+		return nil, err
+	}
+	return &ExecutionCollection{
+		Name:         c.Name,
+		ProjectID:    c.ProjectID,
+		CollectionID: c.ID,
+		Tests:        eps,
+		CSVSplit:     c.CSVSplit,
+		Criteria:     criteria,
+		AbortOn:      guards,
+	}, nil
+}
@@ -0,0 +1,124 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testManifest = `
+project:
+  name: checkout
+  owner: team-a
+plans:
+  - name: browse
+    kind: jmeter
+    test_file: plans/browse.jmx
+    data_files:
+      - data/users.csv
+  - name: pay
+    kind: jmeter
+    test_file: plans/pay.jmx
+collections:
+  - name: smoke
+    tests:
+      - name: browse
+        engines: 1
+        concurrency: 10
+        duration: 5
+      - name: pay
+        engines: 2
+        concurrency: 20
+        duration: 5
+    criteria:
+      - rule: p95 < 300ms
+        plan: pay
+      - rule: error_rate < 1%
+    abort_on:
+      - rule: error_rate > 5%
+        plan: browse
+    data_files:
+      - data/users.csv
+`
+
+func TestParseManifest(t *testing.T) {
+	registerTestPlanKind(t, JmeterPlan, []string{".jmx"})
+	m, err := ParseManifest([]byte(testManifest))
+	assert.Nil(t, err)
+	assert.Nil(t, m.Validate())
+	assert.Equal(t, "team-a", m.Project.Owner)
+	assert.Len(t, m.Plans, 2)
+	assert.Equal(t, "smoke", m.Collections[0].Name)
+	assert.Equal(t, "pay", m.Collections[0].Criteria[0].Plan)
+	assert.Equal(t, []string{"plans/browse.jmx", "data/users.csv", "plans/pay.jmx"}, m.Files())
+
+	_, err = ParseManifest([]byte("project:\n  name: a\n  unknown: b\n"))
+	assert.NotNil(t, err)
+}
+
+func TestValidateManifest(t *testing.T) {
+	registerTestPlanKind(t, JmeterPlan, []string{".jmx"})
+	registerTestPlanKind(t, LocustPlan, []string{".py"})
+	cases := map[string]func(m *Manifest){
+		"no owner":           func(m *Manifest) { m.Project.Owner = "" },
+		"duplicated plan":    func(m *Manifest) { m.Plans[1].Name = "browse" },
+		"invalid kind":       func(m *Manifest) { m.Plans[0].Kind = "asdf" },
+		"wrong test file":    func(m *Manifest) { m.Plans[0].Kind = LocustPlan },
+		"test file as data":  func(m *Manifest) { m.Plans[0].DataFiles = []string{"other.jmx"} },
+		"same base names":    func(m *Manifest) { m.Plans[0].DataFiles = []string{"a/users.csv", "b/users.csv"} },
+		"unknown plan":       func(m *Manifest) { m.Collections[0].Tests[0].Name = "search" },
+		"testid":             func(m *Manifest) { m.Collections[0].Tests[0].PlanID = 1 },
+		"rule of other plan": func(m *Manifest) { m.Collections[0].Tests = m.Collections[0].Tests[:1] },
+		"guard testid":       func(m *Manifest) { m.Collections[0].AbortOn[0].PlanID = 1 },
+		"duplicated collection": func(m *Manifest) {
+			m.Collections = append(m.Collections, m.Collections[0])
+		},
+	}
+	for name, mutate := range cases {
+		m, err := ParseManifest([]byte(testManifest))
+		assert.Nil(t, err)
+		mutate(m)
+		assert.NotNil(t, m.Validate(), name)
+	}
+
+	// test files of other kinds are ordinary data files
+	m, err := ParseManifest([]byte(testManifest))
+	assert.Nil(t, err)
+	m.Plans[0].DataFiles = []string{"data/other.py"}
+	m.Collections[0].DataFiles = []string{"data/other.py"}
+	assert.Nil(t, m.Validate())
+}
+
+func TestResolveManifestCollection(t *testing.T) {
+	m, err := ParseManifest([]byte(testManifest))
+	assert.Nil(t, err)
+	mc := m.Collections[0]
+	planIDs := map[string]int64{"browse": 1, "pay": 2}
+	planNames := map[int64]string{1: "browse", 2: "pay"}
+	ec := mc.Resolve(planIDs)
+	assert.Equal(t, int64(1), ec.Tests[0].PlanID)
+	assert.Equal(t, int64(2), ec.Criteria[0].PlanID)
+	assert.Equal(t, int64(0), ec.Criteria[1].PlanID)
+	assert.Equal(t, "", ec.Criteria[0].Plan)
+	assert.Equal(t, int64(1), ec.AbortOn[0].PlanID)
+	// the manifest itself is not changed
+	assert.Equal(t, int64(0), mc.Tests[0].PlanID)
+	assert.Equal(t, "pay", mc.Criteria[0].Plan)
+
+	// the stored config has no names and a different order of the plans
+	current := &ExecutionCollection{
+		Name: "smoke",
+		Tests: []*ExecutionPlan{
+			{PlanID: 2, Engines: 2, Concurrency: 20, Duration: 5, Stages: []*Stage{}},
+			{PlanID: 1, Engines: 1, Concurrency: 10, Duration: 5},
+		},
+		Criteria: []*Criterion{{Rule: "p95 < 300ms", PlanID: 2}, {Rule: "error_rate < 1%"}},
+		AbortOn:  []*Guard{{Rule: "error_rate > 5%", PlanID: 1}},
+	}
+	assert.True(t, ConfigEqual(current, ec, planNames))
+	current.Tests[0].Engines = 3
+	assert.False(t, ConfigEqual(current, ec, planNames))
+	current.Tests[0].Engines = 2
+	current.Criteria[0].PlanID = 1
+	assert.False(t, ConfigEqual(current, ec, planNames))
+}
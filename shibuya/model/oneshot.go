@@ -0,0 +1,148 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	mysql "github.com/go-sql-driver/mysql"
+)
+
+// Phases of a one-shot run. Finished and failed are terminal.
+const (
+	PhaseQueued    = "queued"
+	PhaseDeploying = "deploying"
+	PhaseRunning   = "running"
+	PhasePurging   = "purging"
+	PhaseFinished  = "finished"
+	PhaseFailed    = "failed"
+)
+
+// OneShotRun is the handle of a collection run driven by the controller from the deployment of the
+// engines to their purge. RunID is 0 until the collection is triggered.
+type OneShotRun struct {
+	ID           int64     `json:"id"`
+	CollectionID int64     `json:"collection_id"`
+	RunID        int64     `json:"run_id"`
+	Phase        string    `json:"phase"`
+	Error        string    `json:"error"`
+	PurgeAfter   bool      `json:"purge_after"`
+	Timeout      int       `json:"timeout"`
+	CreatedBy    string    `json:"created_by"`
+	StartedTime  time.Time `json:"started_time"`
+	EndTime      time.Time `json:"end_time"`
+}
+
+// ErrOneShotRunActive is returned when a one-shot run of the collection is already in progress
+var ErrOneShotRunActive = errors.New("collection is already being run")
+
+const activeOneShotRunQuery = "select count(*) from collection_oneshot_run where collection_id=? and phase not in (?, ?) and started_time > NOW() - INTERVAL timeout_seconds SECOND"
+
+func IsTerminalPhase(phase string) bool {
+	return phase == PhaseFinished || phase == PhaseFailed
+}
+
+// CreateOneShotRun inserts the handle of a new one-shot run. The collection row is locked while
+// checking for an active run so concurrent requests cannot both start one.
+func (c *Collection) CreateOneShotRun(createdBy string, purgeAfter bool, timeout time.Duration) (*OneShotRun, error) {
+	db := getDB()
+	tx, err := db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var collectionID int64
+	if err := tx.QueryRow("select id from collection where id=? for update", c.ID).Scan(&collectionID); err != nil {
+		return nil, &DBError{Err: err, Message: "collection not found"}
+	}
+	var active int
+	if err := tx.QueryRow(activeOneShotRunQuery, c.ID, PhaseFinished, PhaseFailed).Scan(&active); err != nil {
+		return nil, err
+	}
+	if active > 0 {
+		return nil, ErrOneShotRunActive
+	}
+	seconds := int(timeout.Seconds())
+	r, err := tx.Exec("insert collection_oneshot_run set collection_id=?,phase=?,purge_after=?,timeout_seconds=?,created_by=?",
+		c.ID, PhaseDeploying, purgeAfter, seconds, createdBy)
+	if err != nil {
+		return nil, err
+	}
+	id, err := r.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &OneShotRun{
+		ID:           id,
+		CollectionID: c.ID,
+		Phase:        PhaseDeploying,
+		PurgeAfter:   purgeAfter,
+		Timeout:      seconds,
+		CreatedBy:    createdBy,
+		StartedTime:  time.Now(),
+	}, nil
+}
+
+func GetOneShotRun(id int64) (*OneShotRun, error) {
+	db := getDB()
+	q, err := db.Prepare("select id, collection_id, run_id, phase, error, purge_after, timeout_seconds, created_by, started_time, end_time from collection_oneshot_run where id=?")
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+	o := new(OneShotRun)
+	var runError sql.NullString
+	var endTime mysql.NullTime
+	err = q.QueryRow(id).Scan(&o.ID, &o.CollectionID, &o.RunID, &o.Phase, &runError, &o.PurgeAfter, &o.Timeout,
+		&o.CreatedBy, &o.StartedTime, &endTime)
+	if err != nil {
+		return nil, &DBError{Err: err, Message: "run handle not found"}
+	}
+	o.Error = runError.String
+	if endTime.Valid {
+		o.EndTime = endTime.Time
+	}
+	return o, nil
+}
+
+// HasActiveOneShotRun tells whether a one-shot run of the collection is in progress. Runs which are
+// past their timeout are ignored so a controller restart does not block the collection forever.
+func (c *Collection) HasActiveOneShotRun() (bool, error) {
+	db := getDB()
+	q, err := db.Prepare(activeOneShotRunQuery)
+	if err != nil {
+		return false, err
+	}
+	defer q.Close()
+	var count int
+	if err := q.QueryRow(c.ID, PhaseFinished, PhaseFailed).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (o *OneShotRun) SetPhase(phase string, runID int64, runError string) error {
+	db := getDB()
+	query := "update collection_oneshot_run set phase=?,run_id=?,error=? where id=?"
+	if IsTerminalPhase(phase) {
+		query = "update collection_oneshot_run set phase=?,run_id=?,error=?,end_time=NOW() where id=?"
+	}
+	if _, err := db.Exec(query, phase, runID, runError, o.ID); err != nil {
+		return err
+	}
+	o.Phase = phase
+	o.RunID = runID
+	o.Error = runError
+	return nil
+}
+
+func (c *Collection) DeleteOneShotRuns() error {
+	db := getDB()
+	_, err := db.Exec("delete from collection_oneshot_run where collection_id=?", c.ID)
+	return err
+}
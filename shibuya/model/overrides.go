@@ -0,0 +1,141 @@
+package model
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/rakutentech/shibuya/shibuya/config"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// reservedEnv is set by the schedulers for the engines to reach the coordinator
+var reservedEnv = map[string]struct{}{
+	"collection_id":           {},
+	"plan_id":                 {},
+	"coordinator_ip":          {},
+	"coordinator_pubsub_addr": {},
+	"engine_name":             {},
+	"api_key":                 {},
+	"push_messages":           {},
+}
+
+// ExecutorOverrides change the engine containers of a plan. The empty fields keep the values of
+// the containers of the plan kind.
+type ExecutorOverrides struct {
+	CPU string `yaml:"cpu,omitempty" json:"cpu,omitempty"`
+	Mem string `yaml:"mem,omitempty" json:"mem,omitempty"`
+	// Image needs to be in the allowed images of the executor config
+	Image        string            `yaml:"image,omitempty" json:"image,omitempty"`
+	Env          map[string]string `yaml:"env,omitempty" json:"env,omitempty"`
+	NodeSelector map[string]string `yaml:"node_selector,omitempty" json:"node_selector,omitempty"`
+}
+
+func (o ExecutorOverrides) IsEmpty() bool {
+	return o.CPU == "" && o.Mem == "" && o.Image == "" && len(o.Env) == 0 && len(o.NodeSelector) == 0
+}
+
+// Validate checks the overrides against the executor config of the cluster the plan runs in
+func (o ExecutorOverrides) Validate(ec *config.ExecutorConfig) error {
+	for name, q := range map[string]string{"cpu": o.CPU, "mem": o.Mem} {
+		if q == "" {
+			continue
+		}
+		v, err := resource.ParseQuantity(q)
+		if err != nil || v.Sign() <= 0 {
+			return fmt.Errorf("invalid %s %s", name, q)
+		}
+	}
+	if o.Image != "" {
+		allowed := false
+		for _, image := range ec.AllowedImages {
+			if image == o.Image {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("image %s is not allowed", o.Image)
+		}
+	}
+	for name := range o.Env {
+		if name == "" {
+			return fmt.Errorf("env name cannot be empty")
+		}
+		if _, ok := reservedEnv[name]; ok {
+			return fmt.Errorf("env %s is set by shibuya", name)
+		}
+	}
+	return nil
+}
+
+// Apply returns a copy of the container with the overrides. The env and the node selector are
+// merged into the ones of the container.
+func (o ExecutorOverrides) Apply(ec *config.ExecutorContainer) *config.ExecutorContainer {
+	c := *ec
+	if o.CPU != "" {
+		c.CPU = o.CPU
+	}
+	if o.Mem != "" {
+		c.Mem = o.Mem
+	}
+	if o.Image != "" {
+		c.Image = o.Image
+	}
+	c.Env = mergeMaps(ec.Env, o.Env)
+	c.NodeSelector = mergeMaps(ec.NodeSelector, o.NodeSelector)
+	return &c
+}
+
+func mergeMaps(base, overrides map[string]string) map[string]string {
+	if len(overrides) == 0 {
+		return base
+	}
+	m := make(map[string]string, len(base)+len(overrides))
+	for k, v := range base {
+		m[k] = v
+	}
+	for k, v := range overrides {
+		m[k] = v
+	}
+	return m
+}
+
+func (o ExecutorOverrides) Equal(other ExecutorOverrides) bool {
+	return o.CPU == other.CPU && o.Mem == other.Mem && o.Image == other.Image &&
+		sameMaps(o.Env, other.Env) && sameMaps(o.NodeSelector, other.NodeSelector)
+}
+
+func sameMaps(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
+
+func marshalOverrides(o ExecutorOverrides) (sql.NullString, error) {
+	if o.IsEmpty() {
+		return sql.NullString{}, nil
+	}
+	b, err := json.Marshal(o)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(b), Valid: true}, nil
+}
+
+func unmarshalOverrides(s sql.NullString) (ExecutorOverrides, error) {
+	o := ExecutorOverrides{}
+	if !s.Valid || s.String == "" {
+		return o, nil
+	}
+	if err := json.Unmarshal([]byte(s.String), &o); err != nil {
+		return o, err
+	}
+	return o, nil
+}
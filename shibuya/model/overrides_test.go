@@ -0,0 +1,61 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/rakutentech/shibuya/shibuya/config"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestExecutorOverrides(t *testing.T) {
+	ec := new(ExecutionCollection)
+	content := `
+tests:
+- testid: 1
+  engines: 2
+  cpu: "4"
+  image: shibuya:jmeter-plugins
+  env:
+    HEAP: -Xmx6g
+  node_selector:
+    pool: big
+`
+	assert.Nil(t, yaml.Unmarshal([]byte(content), ec))
+	o := ec.Tests[0].ExecutorOverrides
+	assert.Equal(t, "4", o.CPU)
+	assert.Equal(t, "-Xmx6g", o.Env["HEAP"])
+
+	cfg := &config.ExecutorConfig{AllowedImages: []string{"shibuya:jmeter-plugins"}}
+	assert.Nil(t, o.Validate(cfg))
+	assert.NotNil(t, o.Validate(&config.ExecutorConfig{}))
+	for _, invalid := range []ExecutorOverrides{
+		{CPU: "four"},
+		{Mem: "-1Gi"},
+		{Env: map[string]string{"api_key": "mine"}},
+	} {
+		assert.NotNil(t, invalid.Validate(cfg))
+	}
+
+	base := &config.ExecutorContainer{Image: "shibuya:jmeter", CPU: "1", Mem: "1Gi", Env: map[string]string{"TZ": "UTC"}}
+	c := o.Apply(base)
+	assert.Equal(t, "shibuya:jmeter-plugins", c.Image)
+	assert.Equal(t, "4", c.CPU)
+	assert.Equal(t, "1Gi", c.Mem)
+	assert.Equal(t, map[string]string{"TZ": "UTC", "HEAP": "-Xmx6g"}, c.Env)
+	assert.Equal(t, "big", c.NodeSelector["pool"])
+	// the container of the kind is shared by the plans
+	assert.Equal(t, "shibuya:jmeter", base.Image)
+	assert.Len(t, base.Env, 1)
+	assert.Equal(t, base, ExecutorOverrides{}.Apply(base))
+
+	s, err := marshalOverrides(o)
+	assert.Nil(t, err)
+	r, err := unmarshalOverrides(s)
+	assert.Nil(t, err)
+	assert.True(t, o.Equal(r))
+	assert.False(t, o.Equal(ExecutorOverrides{CPU: "4"}))
+	s, err = marshalOverrides(ExecutorOverrides{})
+	assert.Nil(t, err)
+	assert.False(t, s.Valid)
+}
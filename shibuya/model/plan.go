@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/rakutentech/shibuya/shibuya/object_storage"
@@ -17,41 +18,50 @@ type (
 		Name        string         `json:"name"`
 		Kind        PlanKind       `json:"kind"`
 		ProjectID   int64          `json:"project_id"`
+		Description string         `json:"description"`
+		Tags        Tags           `json:"tags"`
 		CreatedTime time.Time      `json:"created_time"`
 		TestFile    *ShibuyaFile   `json:"test_file"`
 		Data        []*ShibuyaFile `json:"data"`
+		// pinned is the version of the test file when the test file is pinned
+		pinned *PlanFileVersion
 	}
 )
 
 const (
-	JmeterPlan = PlanKind("jmeter")
-	LocustPlan = PlanKind("locust")
+	JmeterPlan  = PlanKind("jmeter")
+	LocustPlan  = PlanKind("locust")
+	K6Plan      = PlanKind("k6")
+	GatlingPlan = PlanKind("gatling")
 )
 
 var (
-	SupportedKinds  = []PlanKind{JmeterPlan, LocustPlan}
-	ValidExtensions = map[PlanKind]string{
-		JmeterPlan: ".jmx",
-		LocustPlan: ".py",
-	}
-	TestFileExtensions = func() []string {
-		t := make([]string, len(ValidExtensions))
-		i := 0
-		for _, v := range ValidExtensions {
-			t[i] = v
-			i += 1
-		}
-		return t
-	}()
+	kindsMu sync.RWMutex
+	// Test file extensions by plan kind. Kinds are registered by the engine registry
+	// so adding an engine does not need to touch this package.
+	planKinds = make(map[PlanKind][]string)
 )
 
+func RegisterPlanKind(kind PlanKind, extensions []string) {
+	kindsMu.Lock()
+	defer kindsMu.Unlock()
+
+	planKinds[kind] = extensions
+}
+
 func (pk PlanKind) IsSupported() bool {
-	for _, item := range SupportedKinds {
-		if item == pk {
-			return true
-		}
-	}
-	return false
+	kindsMu.RLock()
+	defer kindsMu.RUnlock()
+
+	_, ok := planKinds[pk]
+	return ok
+}
+
+func (pk PlanKind) TestFileExtensions() []string {
+	kindsMu.RLock()
+	defer kindsMu.RUnlock()
+
+	return planKinds[pk]
 }
 
 func CreatePlan(name string, projectID int64, kind PlanKind) (int64, error) {
@@ -72,17 +82,22 @@ func CreatePlan(name string, projectID int64, kind PlanKind) (int64, error) {
 
 func GetPlan(ID int64) (*Plan, error) {
 	db := getDB()
-	q, err := db.Prepare("select id, name, kind, project_id, created_time from plan where id=?")
+	q, err := db.Prepare("select id, name, kind, project_id, description, created_time from plan where id=?")
 	if err != nil {
 		return nil, err
 	}
 	defer q.Close()
 
 	plan := new(Plan)
-	err = q.QueryRow(ID).Scan(&plan.ID, &plan.Name, &plan.Kind, &plan.ProjectID, &plan.CreatedTime)
+	err = q.QueryRow(ID).Scan(&plan.ID, &plan.Name, &plan.Kind, &plan.ProjectID, &plan.Description, &plan.CreatedTime)
 	if err != nil {
 		return nil, &DBError{Err: err, Message: "plan not found"}
 	}
+	tags, err := getTags(planTag, []int64{plan.ID})
+	if err != nil {
+		return nil, err
+	}
+	plan.Tags = tags[plan.ID]
 	if plan.TestFile, plan.Data, err = plan.GetPlanFiles(); err != nil {
 		return plan, nil
 	}
@@ -128,14 +143,55 @@ func (p *Plan) GetPlanFiles() (*ShibuyaFile, []*ShibuyaFile, error) {
 	return t, r, nil
 }
 
+// IsThePlanFileValid tells whether the file is a test file of the plan. Only the extensions of the
+// plan's own kind count, a test file of another kind is an ordinary data file of this plan.
 func (p *Plan) IsThePlanFileValid(filename string) bool {
-	return strings.HasSuffix(filename, ValidExtensions[p.Kind])
+	for _, e := range p.Kind.TestFileExtensions() {
+		if strings.HasSuffix(filename, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// Update renames the plan and changes its description. The new name needs to be free in the project.
+func (p *Plan) Update(name, description string) error {
+	if name != p.Name {
+		if err := checkNameTaken("plan", p.ProjectID, name); err != nil {
+			return err
+		}
+	}
+	db := getDB()
+	q, err := db.Prepare("update plan set name=?,description=? where id=?")
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+	if _, err := q.Exec(name, description, p.ID); err != nil {
+		return err
+	}
+	p.Name, p.Description = name, description
+	return nil
+}
+
+func (p *Plan) StoreTags(tags Tags) error {
+	if err := storeTags(planTag, p.ID, tags); err != nil {
+		return err
+	}
+	p.Tags = tags
+	return nil
 }
 
 func (p *Plan) Delete(objStorage object_storage.StorageInterface) error {
+	if err := deleteTags(planTag, p.ID); err != nil {
+		return err
+	}
 	if err := p.DeleteAllFiles(objStorage); err != nil {
 		return err
 	}
+	if err := p.deleteVersions(objStorage); err != nil {
+		return err
+	}
 	db := getDB()
 	q, err := db.Prepare("delete from plan where id=?")
 	if err != nil {
@@ -153,24 +209,26 @@ func (p *Plan) MakeFileName(filename string) string {
 	return fmt.Sprintf("plans/%d/files/%s", p.ID, filename)
 }
 
-func (p *Plan) IsTestFile(filename string) bool {
-	isTestFile := false
-	for _, e := range TestFileExtensions {
-		if strings.HasSuffix(filename, e) {
-			isTestFile = true
-		}
+// StoreFile stores the file as the current file of its name and keeps a version of it. A test file
+// replaces the current test file of the plan.
+func (p *Plan) StoreFile(objStorage object_storage.StorageInterface, content io.ReadCloser, filename, uploadedBy string) error {
+	// the upload is spooled to disk as it's stored twice, and could be too big for the memory
+	sf, err := spoolFile(content)
+	content.Close()
+	if err != nil {
+		return err
 	}
-	return isTestFile
-}
-
-func (p *Plan) StoreFile(objStorage object_storage.StorageInterface, content io.ReadCloser, filename string) error {
-	table := "plan_data"
-	if p.IsTestFile(filename) {
-		table = "plan_test_file"
+	defer sf.remove()
+	if _, err := p.storeVersion(objStorage, filename, sf, uploadedBy); err != nil {
+		return err
+	}
+	query := "insert ignore into plan_data (plan_id, filename) values (?, ?)"
+	isTestFile := p.IsThePlanFileValid(filename)
+	if isTestFile {
+		query = "insert into plan_test_file (plan_id, filename) values (?, ?) on duplicate key update filename=values(filename)"
 	}
-	filenameForStorage := p.MakeFileName(filename)
 	db := getDB()
-	q, err := db.Prepare(fmt.Sprintf("insert into %s (plan_id, filename) values (?, ?)", table))
+	q, err := db.Prepare(query)
 	if err != nil {
 		return err
 	}
@@ -178,12 +236,20 @@ func (p *Plan) StoreFile(objStorage object_storage.StorageInterface, content io.
 	if _, err = q.Exec(p.ID, filename); err != nil {
 		return err
 	}
-	return objStorage.Upload(filenameForStorage, content)
+	if err := objStorage.Upload(p.MakeFileName(filename), sf.reader()); err != nil {
+		return err
+	}
+	if isTestFile && p.TestFile != nil && p.TestFile.Filename != filename {
+		if err := objStorage.Delete(p.MakeFileName(p.TestFile.Filename)); err != nil {
+			log.Error(err)
+		}
+	}
+	return nil
 }
 
 func (p *Plan) DeleteFile(objStorage object_storage.StorageInterface, filename string) error {
 	table := "plan_data"
-	if p.IsTestFile(filename) {
+	if p.IsThePlanFileValid(filename) {
 		table = "plan_test_file"
 	}
 	db := getDB()
@@ -248,6 +314,9 @@ type RunningPlan struct {
 	CollectionID int64     `json:"collection_id"`
 	PlanID       int64     `json:"plan_id"`
 	StartedTime  time.Time `json:"started_time"`
+	Context      string    `json:"context"`
+	// Concurrency is what the plan is scaled to during the run. 0 when it's not scaled.
+	Concurrency int `json:"concurrency"`
 }
 
 func GetRunningCollections(context string) ([]*RunningPlan, error) {
@@ -264,7 +333,7 @@ func GetRunningCollections(context string) ([]*RunningPlan, error) {
 	defer rs.Close()
 	rps := []*RunningPlan{}
 	for rs.Next() {
-		rp := new(RunningPlan)
+		rp := &RunningPlan{Context: context}
 		rs.Scan(&rp.CollectionID, &rp.StartedTime)
 		rps = append(rps, rp)
 	}
@@ -285,7 +354,7 @@ func GetRunningPlans(context string) ([]*RunningPlan, error) {
 	defer rs.Close()
 	rps := []*RunningPlan{}
 	for rs.Next() {
-		rp := new(RunningPlan)
+		rp := &RunningPlan{Context: context}
 		rs.Scan(&rp.CollectionID, &rp.PlanID, &rp.StartedTime)
 		rps = append(rps, rp)
 	}
@@ -338,7 +407,7 @@ func DeleteRunningPlan(collectionID, planID int64) error {
 func GetRunningPlansByCollection(collectionID int64) ([]*RunningPlan, error) {
 	db := getDB()
 	var rps []*RunningPlan
-	q, err := db.Prepare("select collection_id, plan_id, started_time from running_plan where collection_id=?")
+	q, err := db.Prepare("select collection_id, plan_id, started_time, concurrency from running_plan where collection_id=?")
 	if err != nil {
 		return rps, err
 	}
@@ -350,8 +419,15 @@ func GetRunningPlansByCollection(collectionID int64) ([]*RunningPlan, error) {
 	defer rows.Close()
 	for rows.Next() {
 		rp := new(RunningPlan)
-		rows.Scan(&rp.CollectionID, &rp.PlanID, &rp.StartedTime)
+		rows.Scan(&rp.CollectionID, &rp.PlanID, &rp.StartedTime, &rp.Concurrency)
 		rps = append(rps, rp)
 	}
 	return rps, nil
 }
+
+// SetRunningPlanConcurrency records the concurrency the running plan is scaled to
+func SetRunningPlanConcurrency(collectionID, planID int64, concurrency int) error {
+	db := getDB()
+	_, err := db.Exec("update running_plan set concurrency=? where collection_id=? and plan_id=?", concurrency, collectionID, planID)
+	return err
+}
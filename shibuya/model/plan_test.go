@@ -8,27 +8,52 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+// registerTestPlanKind registers the kind for the test only so it does not leak into other tests
+func registerTestPlanKind(t *testing.T, kind PlanKind, extensions []string) {
+	kindsMu.RLock()
+	previous, ok := planKinds[kind]
+	kindsMu.RUnlock()
+	RegisterPlanKind(kind, extensions)
+	t.Cleanup(func() {
+		kindsMu.Lock()
+		defer kindsMu.Unlock()
+
+		if ok {
+			planKinds[kind] = previous
+		} else {
+			delete(planKinds, kind)
+		}
+	})
+}
+
 func TestSupportedKinds(t *testing.T) {
 	k := PlanKind("asdf")
 	assert.False(t, k.IsSupported())
 
-	k = JmeterPlan
-	assert.True(t, k.IsSupported())
-
-	k = LocustPlan
-	assert.True(t, k.IsSupported())
+	// kinds are registered by the engine registry
+	t.Run("registered", func(t *testing.T) {
+		registerTestPlanKind(t, k, []string{".asdf"})
+		assert.True(t, k.IsSupported())
+		assert.Equal(t, []string{".asdf"}, k.TestFileExtensions())
+	})
+	assert.False(t, k.IsSupported())
 }
 
 func TestExtensions(t *testing.T) {
-	assert.True(t, len(ValidExtensions) == len(TestFileExtensions))
-	for _, item := range TestFileExtensions {
-		t.Log(item)
-	}
+	registerTestPlanKind(t, JmeterPlan, []string{".jmx"})
+	registerTestPlanKind(t, GatlingPlan, []string{".scala", ".zip"})
+	p := &Plan{Kind: JmeterPlan}
+	assert.True(t, p.IsThePlanFileValid("a.jmx"))
+	assert.False(t, p.IsThePlanFileValid("a.csv"))
+	// test files of another kind are data files of the plan
+	assert.False(t, p.IsThePlanFileValid("a.zip"))
+	assert.False(t, p.IsThePlanFileValid("a.scala"))
 }
 
 func TestCreateAndGetPlan(t *testing.T) {
 	name := "testplan"
 	projectID := int64(1)
+	registerTestPlanKind(t, LocustPlan, []string{".py"})
 	planID, err := CreatePlan(name, projectID, LocustPlan)
 	if err != nil {
 		t.Fatal(err)
@@ -0,0 +1,319 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/rakutentech/shibuya/shibuya/object_storage"
+	log "github.com/sirupsen/logrus"
+)
+
+// PlanFileVersion is an immutable copy of an uploaded plan file
+type PlanFileVersion struct {
+	PlanID      int64     `json:"plan_id"`
+	Version     int64     `json:"version"`
+	Filename    string    `json:"filename"`
+	TestFile    bool      `json:"test_file"`
+	Hash        string    `json:"hash"`
+	UploadedBy  string    `json:"uploaded_by"`
+	CreatedTime time.Time `json:"created_time"`
+}
+
+// RunPlanFile is a plan file used by a run. Version is 0 when the file was uploaded before the
+// files were versioned.
+type RunPlanFile struct {
+	PlanID   int64  `json:"plan_id"`
+	Version  int64  `json:"version"`
+	Filename string `json:"filename"`
+	TestFile bool   `json:"test_file"`
+	Hash     string `json:"hash"`
+}
+
+func readCloser(content []byte) io.ReadCloser {
+	return io.NopCloser(bytes.NewReader(content))
+}
+
+func hashContent(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// spooledFile is an upload copied to a temp file, so it can be stored several times without being
+// held in memory
+type spooledFile struct {
+	file *os.File
+	size int64
+	hash string
+}
+
+// spoolFile copies the content to a temp file and hashes it on the way
+func spoolFile(content io.Reader) (*spooledFile, error) {
+	f, err := os.CreateTemp("", "shibuya-upload-")
+	if err != nil {
+		return nil, err
+	}
+	sf := &spooledFile{file: f}
+	h := sha256.New()
+	if sf.size, err = io.Copy(f, io.TeeReader(content, h)); err != nil {
+		sf.remove()
+		return nil, err
+	}
+	sf.hash = hex.EncodeToString(h.Sum(nil))
+	return sf, nil
+}
+
+// reader reads the file from the start. Closing it does not close the file.
+func (sf *spooledFile) reader() io.ReadCloser {
+	return io.NopCloser(io.NewSectionReader(sf.file, 0, sf.size))
+}
+
+func (sf *spooledFile) remove() {
+	sf.file.Close()
+	if err := os.Remove(sf.file.Name()); err != nil {
+		log.Error(err)
+	}
+}
+
+func (p *Plan) MakeVersionFileName(version int64, filename string) string {
+	return fmt.Sprintf("plans/%d/versions/%d/%s", p.ID, version, filename)
+}
+
+// storeVersion uploads the content as the next version of the plan files. The version number is
+// reserved in its own transaction so the lock on the versions of the plan is not held during the
+// upload. The version is removed again if the upload fails.
+func (p *Plan) storeVersion(objStorage object_storage.StorageInterface, filename string, content *spooledFile, uploadedBy string) (*PlanFileVersion, error) {
+	v := &PlanFileVersion{
+		PlanID:     p.ID,
+		Filename:   filename,
+		TestFile:   p.IsThePlanFileValid(filename),
+		Hash:       content.hash,
+		UploadedBy: uploadedBy,
+	}
+	if err := p.reserveVersion(v); err != nil {
+		return nil, err
+	}
+	if err := objStorage.Upload(p.MakeVersionFileName(v.Version, filename), content.reader()); err != nil {
+		db := getDB()
+		if _, err := db.Exec("delete from plan_file_version where plan_id=? and version=?", p.ID, v.Version); err != nil {
+			log.Error(err)
+		}
+		return nil, err
+	}
+	return v, nil
+}
+
+// reserveVersion inserts the version with the next version number of the plan
+func (p *Plan) reserveVersion(v *PlanFileVersion) error {
+	db := getDB()
+	tx, err := db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow("select coalesce(max(version), 0) + 1 from plan_file_version where plan_id=? for update", p.ID).Scan(&v.Version)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("insert plan_file_version set plan_id=?,version=?,filename=?,test_file=?,hash=?,uploaded_by=?",
+		v.PlanID, v.Version, v.Filename, v.TestFile, v.Hash, v.UploadedBy)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func scanPlanFileVersion(scan func(dest ...any) error) (*PlanFileVersion, error) {
+	v := new(PlanFileVersion)
+	var testFile int8
+	if err := scan(&v.PlanID, &v.Version, &v.Filename, &testFile, &v.Hash, &v.UploadedBy, &v.CreatedTime); err != nil {
+		return nil, err
+	}
+	v.TestFile = testFile == 1
+	return v, nil
+}
+
+// GetVersions returns the versions of the plan files with the latest first
+func (p *Plan) GetVersions() ([]*PlanFileVersion, error) {
+	db := getDB()
+	q, err := db.Prepare("select plan_id, version, filename, test_file, hash, uploaded_by, created_time from plan_file_version where plan_id=? order by version desc")
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+	rs, err := q.Query(p.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rs.Close()
+	r := []*PlanFileVersion{}
+	for rs.Next() {
+		v, err := scanPlanFileVersion(rs.Scan)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, v)
+	}
+	return r, rs.Err()
+}
+
+func (p *Plan) GetVersion(version int64) (*PlanFileVersion, error) {
+	db := getDB()
+	q, err := db.Prepare("select plan_id, version, filename, test_file, hash, uploaded_by, created_time from plan_file_version where plan_id=? and version=?")
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+	v, err := scanPlanFileVersion(q.QueryRow(p.ID, version).Scan)
+	if err != nil {
+		return nil, &DBError{Err: err, Message: "plan file version not found"}
+	}
+	return v, nil
+}
+
+// latestVersion returns the latest version of the file, or nil if it has never been versioned
+func (p *Plan) latestVersion(filename string) (*PlanFileVersion, error) {
+	db := getDB()
+	q, err := db.Prepare("select plan_id, version, filename, test_file, hash, uploaded_by, created_time from plan_file_version where plan_id=? and filename=? order by version desc limit 1")
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+	v, err := scanPlanFileVersion(q.QueryRow(p.ID, filename).Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return v, err
+}
+
+// GetTestFileVersion returns the version, which needs to be a test file version of the plan
+func (p *Plan) GetTestFileVersion(version int64) (*PlanFileVersion, error) {
+	v, err := p.GetVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	if !v.TestFile {
+		return nil, fmt.Errorf("version %d of plan %d is not a test file", version, p.ID)
+	}
+	return v, nil
+}
+
+// PinTestFile makes the plan run with the version of the test file instead of the latest one
+func (p *Plan) PinTestFile(version int64) error {
+	v, err := p.GetTestFileVersion(version)
+	if err != nil {
+		return err
+	}
+	p.TestFile = &ShibuyaFile{
+		Filename: v.Filename,
+		Filepath: p.MakeVersionFileName(v.Version, v.Filename),
+	}
+	p.TestFile.Filelink = makeFilesUrl(p.TestFile.Filepath)
+	p.pinned = v
+	return nil
+}
+
+// RunFiles returns the versions of the files the plan runs with. The hashes are taken from the
+// downloaded contents when they are available.
+func (p *Plan) RunFiles() ([]*RunPlanFile, error) {
+	files := []*RunPlanFile{}
+	add := func(f *ShibuyaFile, testFile bool, v *PlanFileVersion) {
+		rf := &RunPlanFile{PlanID: p.ID, Filename: f.Filename, TestFile: testFile}
+		if v != nil {
+			rf.Version, rf.Hash = v.Version, v.Hash
+		}
+		if f.Content != nil {
+			rf.Hash = hashContent(f.Content)
+		}
+		files = append(files, rf)
+	}
+	if p.TestFile != nil {
+		v := p.pinned
+		if v == nil {
+			latest, err := p.latestVersion(p.TestFile.Filename)
+			if err != nil {
+				return nil, err
+			}
+			v = latest
+		}
+		add(p.TestFile, true, v)
+	}
+	for _, d := range p.Data {
+		v, err := p.latestVersion(d.Filename)
+		if err != nil {
+			return nil, err
+		}
+		add(d, false, v)
+	}
+	return files, nil
+}
+
+func (p *Plan) deleteVersions(objStorage object_storage.StorageInterface) error {
+	versions, err := p.GetVersions()
+	if err != nil {
+		return err
+	}
+	for _, v := range versions {
+		if err := objStorage.Delete(p.MakeVersionFileName(v.Version, v.Filename)); err != nil {
+			log.Error(err)
+		}
+	}
+	db := getDB()
+	_, err = db.Exec("delete from plan_file_version where plan_id=?", p.ID)
+	return err
+}
+
+// StoreRunPlanFiles records the versions of the plan files used by the run
+func (c *Collection) StoreRunPlanFiles(runID int64, files []*RunPlanFile) error {
+	db := getDB()
+	tx, err := db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("delete from run_plan_file where run_id=?", runID); err != nil {
+		return err
+	}
+	for _, f := range files {
+		_, err := tx.Exec("insert run_plan_file set run_id=?,collection_id=?,plan_id=?,version=?,filename=?,test_file=?,hash=?",
+			runID, c.ID, f.PlanID, f.Version, f.Filename, f.TestFile, f.Hash)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func GetRunPlanFiles(runID int64) ([]*RunPlanFile, error) {
+	db := getDB()
+	q, err := db.Prepare("select plan_id, version, filename, test_file, hash from run_plan_file where run_id=? order by id")
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+	rs, err := q.Query(runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rs.Close()
+	r := []*RunPlanFile{}
+	for rs.Next() {
+		f := new(RunPlanFile)
+		var testFile int8
+		if err := rs.Scan(&f.PlanID, &f.Version, &f.Filename, &testFile, &f.Hash); err != nil {
+			return nil, err
+		}
+		f.TestFile = testFile == 1
+		r = append(r, f)
+	}
+	return r, rs.Err()
+}
@@ -0,0 +1,62 @@
+package model
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanFileVersions(t *testing.T) {
+	registerTestPlanKind(t, JmeterPlan, []string{".jmx"})
+	storage := memStorage{}
+	planID, err := CreatePlan("plan", 1, JmeterPlan)
+	assert.Nil(t, err)
+	plan, err := GetPlan(planID)
+	assert.Nil(t, err)
+	assert.Nil(t, plan.StoreFile(storage, io.NopCloser(strings.NewReader("v1")), "test.jmx", "alice"))
+	plan, err = GetPlan(planID)
+	assert.Nil(t, err)
+	// uploading again replaces the test file and keeps the previous version
+	assert.Nil(t, plan.StoreFile(storage, io.NopCloser(strings.NewReader("v2")), "test.jmx", "bob"))
+	assert.Nil(t, plan.StoreFile(storage, io.NopCloser(strings.NewReader("user")), "users.csv", "bob"))
+
+	versions, err := plan.GetVersions()
+	assert.Nil(t, err)
+	assert.Len(t, versions, 3)
+	assert.Equal(t, int64(3), versions[0].Version)
+	assert.False(t, versions[0].TestFile)
+	assert.Equal(t, "alice", versions[2].UploadedBy)
+	assert.Equal(t, hashContent([]byte("v1")), versions[2].Hash)
+	assert.Equal(t, []byte("v1"), storage[plan.MakeVersionFileName(1, "test.jmx")])
+	assert.Equal(t, []byte("v2"), storage[plan.MakeFileName("test.jmx")])
+
+	_, err = plan.GetTestFileVersion(3)
+	assert.NotNil(t, err)
+	plan, err = GetPlan(planID)
+	assert.Nil(t, err)
+	assert.Nil(t, plan.PinTestFile(1))
+	files, err := plan.RunFiles()
+	assert.Nil(t, err)
+	assert.Equal(t, &RunPlanFile{PlanID: planID, Version: 1, Filename: "test.jmx", TestFile: true, Hash: hashContent([]byte("v1"))}, files[0])
+	assert.Equal(t, int64(3), files[1].Version)
+}
+
+func TestSpoolFile(t *testing.T) {
+	sf, err := spoolFile(strings.NewReader("v1"))
+	assert.Nil(t, err)
+	assert.Equal(t, hashContent([]byte("v1")), sf.hash)
+	// the file can be read several times
+	for i := 0; i < 2; i++ {
+		r := sf.reader()
+		content, err := io.ReadAll(r)
+		assert.Nil(t, err)
+		assert.Nil(t, r.Close())
+		assert.Equal(t, "v1", string(content))
+	}
+	sf.remove()
+	_, err = os.Stat(sf.file.Name())
+	assert.True(t, os.IsNotExist(err))
+}
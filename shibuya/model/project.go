@@ -15,6 +15,8 @@ type Project struct {
 	Owner       string `json:"owner"`
 	ssID        null.String
 	SID         string        `json:"sid"`
+	Description string        `json:"description"`
+	Tags        Tags          `json:"tags"`
 	CreatedTime time.Time     `json:"created_time"`
 	Collections []*Collection `json:"collections"`
 	Plans       []*Plan       `json:"plans"`
@@ -53,7 +55,7 @@ func GetProjectsByOwners(owners []string) ([]*Project, error) {
 		s := fmt.Sprintf("'%s'", o)
 		qs = append(qs, s)
 	}
-	query := fmt.Sprintf("select id, name, owner, sid, created_time from project where owner in (%s)",
+	query := fmt.Sprintf("select id, name, owner, sid, description, created_time from project where owner in (%s)",
 		strings.Join(qs, ","))
 	q, err := db.Prepare(query)
 	if err != nil {
@@ -67,7 +69,7 @@ func GetProjectsByOwners(owners []string) ([]*Project, error) {
 	defer rows.Close()
 	for rows.Next() {
 		p := new(Project)
-		rows.Scan(&p.ID, &p.Name, &p.Owner, &p.ssID, &p.CreatedTime)
+		rows.Scan(&p.ID, &p.Name, &p.Owner, &p.ssID, &p.Description, &p.CreatedTime)
 		p.SID = p.ssID.String
 		r = append(r, p)
 	}
@@ -75,28 +77,116 @@ func GetProjectsByOwners(owners []string) ([]*Project, error) {
 	if err != nil {
 		return r, err
 	}
+	ids := make([]int64, len(r))
+	for i, p := range r {
+		ids[i] = p.ID
+	}
+	tags, err := getTags(projectTag, ids)
+	if err != nil {
+		return r, err
+	}
+	for _, p := range r {
+		p.Tags = tags[p.ID]
+	}
 	return r, nil
 }
 
+func checkProjectNameTaken(owner, name string) error {
+	db := getDB()
+	var count int
+	if err := db.QueryRow("select count(*) from project where owner=? and name=?", owner, name).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("%w: %s already has a project named %s", ErrNameTaken, owner, name)
+	}
+	return nil
+}
+
+// GetProjectByName returns the first project with the name owned by the owner
+func GetProjectByName(owner, name string) (*Project, error) {
+	db := getDB()
+	q, err := db.Prepare("select id, name, owner, sid, description, created_time from project where owner=? and name=? order by id limit 1")
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+
+	project := new(Project)
+	err = q.QueryRow(owner, name).Scan(&project.ID, &project.Name, &project.Owner, &project.ssID, &project.Description, &project.CreatedTime)
+	if err != nil {
+		return nil, &DBError{Err: err, Message: "project not found"}
+	}
+	project.SID = project.ssID.String
+	if err := project.loadTags(); err != nil {
+		return nil, err
+	}
+	return project, nil
+}
+
 func GetProject(id int64) (*Project, error) {
 	db := getDB()
-	q, err := db.Prepare("select id, name, owner, sid, created_time from project where id=?")
+	q, err := db.Prepare("select id, name, owner, sid, description, created_time from project where id=?")
 	if err != nil {
 		return nil, err
 	}
 	defer q.Close()
 
 	project := new(Project)
-	err = q.QueryRow(id).Scan(&project.ID, &project.Name, &project.Owner, &project.ssID, &project.CreatedTime)
+	err = q.QueryRow(id).Scan(&project.ID, &project.Name, &project.Owner, &project.ssID, &project.Description, &project.CreatedTime)
 	if err != nil {
 		return nil, &DBError{Err: err, Message: "project not found"}
 	}
 	// TODO remove SSID as it's only supposed to be a temp solution
 	project.SID = project.ssID.String
+	if err := project.loadTags(); err != nil {
+		return nil, err
+	}
 	return project, nil
 }
 
+func (p *Project) loadTags() error {
+	tags, err := getTags(projectTag, []int64{p.ID})
+	if err != nil {
+		return err
+	}
+	p.Tags = tags[p.ID]
+	return nil
+}
+
+// Update changes the name, owner and description of the project. The owner cannot have another
+// project with the name, as the projects are looked up by the owner and name.
+func (p *Project) Update(name, owner, description string) error {
+	if name != p.Name || owner != p.Owner {
+		if err := checkProjectNameTaken(owner, name); err != nil {
+			return err
+		}
+	}
+	db := getDB()
+	q, err := db.Prepare("update project set name=?,owner=?,description=? where id=?")
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+	if _, err := q.Exec(name, owner, description, p.ID); err != nil {
+		return err
+	}
+	p.Name, p.Owner, p.Description = name, owner, description
+	return nil
+}
+
+func (p *Project) StoreTags(tags Tags) error {
+	if err := storeTags(projectTag, p.ID, tags); err != nil {
+		return err
+	}
+	p.Tags = tags
+	return nil
+}
+
 func (p *Project) Delete() error {
+	if err := deleteTags(projectTag, p.ID); err != nil {
+		return err
+	}
 	db := getDB()
 	q, err := db.Prepare("delete from project where id=?")
 	if err != nil {
@@ -114,7 +204,7 @@ func (p *Project) Delete() error {
 func (p *Project) GetCollections() ([]*Collection, error) {
 	db := getDB()
 	r := []*Collection{}
-	q, err := db.Prepare("select id, name from collection where project_id=?")
+	q, err := db.Prepare("select id, name, project_id, description, created_time from collection where project_id=?")
 	if err != nil {
 		return r, err
 	}
@@ -126,20 +216,31 @@ func (p *Project) GetCollections() ([]*Collection, error) {
 	defer rows.Close()
 	for rows.Next() {
 		collection := new(Collection)
-		rows.Scan(&collection.ID, &collection.Name)
+		rows.Scan(&collection.ID, &collection.Name, &collection.ProjectID, &collection.Description, &collection.CreatedTime)
 		r = append(r, collection)
 	}
 	err = rows.Err()
 	if err != nil {
 		return nil, err
 	}
+	ids := make([]int64, len(r))
+	for i, c := range r {
+		ids[i] = c.ID
+	}
+	tags, err := getTags(collectionTag, ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range r {
+		c.Tags = tags[c.ID]
+	}
 	return r, nil
 }
 
 func (p *Project) GetPlans() ([]*Plan, error) {
 	db := getDB()
 	r := []*Plan{}
-	q, err := db.Prepare("select id, name, project_id, created_time from plan where project_id=?")
+	q, err := db.Prepare("select id, name, kind, project_id, description, created_time from plan where project_id=?")
 	if err != nil {
 		return r, err
 	}
@@ -151,12 +252,23 @@ func (p *Project) GetPlans() ([]*Plan, error) {
 	defer rows.Close()
 	for rows.Next() {
 		plan := new(Plan)
-		rows.Scan(&plan.ID, &plan.Name, &plan.ProjectID, &plan.CreatedTime)
+		rows.Scan(&plan.ID, &plan.Name, &plan.Kind, &plan.ProjectID, &plan.Description, &plan.CreatedTime)
 		r = append(r, plan)
 	}
 	err = rows.Err()
 	if err != nil {
 		return nil, err
 	}
+	ids := make([]int64, len(r))
+	for i, p := range r {
+		ids[i] = p.ID
+	}
+	tags, err := getTags(planTag, ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range r {
+		p.Tags = tags[p.ID]
+	}
 	return r, nil
 }
@@ -45,3 +45,36 @@ func TestGetProjectCollections(t *testing.T) {
 		assert.Equal(t, collection_id, cid.ID)
 	}
 }
+
+func TestRenameClash(t *testing.T) {
+	registerTestPlanKind(t, JmeterPlan, []string{".jmx"})
+	projectID, err := CreateProject("checkout", "team-a", "")
+	assert.Nil(t, err)
+	_, err = CreateProject("payment", "team-a", "")
+	assert.Nil(t, err)
+	_, err = CreateProject("checkout", "team-b", "")
+	assert.Nil(t, err)
+	project, err := GetProject(projectID)
+	assert.Nil(t, err)
+
+	// the owner cannot have two projects with the same name
+	assert.ErrorIs(t, project.Update("payment", "team-a", ""), ErrNameTaken)
+	assert.ErrorIs(t, project.Update("checkout", "team-b", ""), ErrNameTaken)
+	assert.Nil(t, project.Update("checkout", "team-a", "unchanged name"))
+	assert.Nil(t, project.Update("cart", "team-b", ""))
+
+	for _, name := range []string{"smoke", "load"} {
+		_, err = CreatePlan(name, projectID, JmeterPlan)
+		assert.Nil(t, err)
+		_, err = CreateCollection(name, projectID)
+		assert.Nil(t, err)
+	}
+	plans, err := project.GetPlans()
+	assert.Nil(t, err)
+	assert.ErrorIs(t, plans[0].Update(plans[1].Name, ""), ErrNameTaken)
+	assert.Nil(t, plans[0].Update("soak", ""))
+	collections, err := project.GetCollections()
+	assert.Nil(t, err)
+	assert.ErrorIs(t, collections[0].Update(collections[1].Name, ""), ErrNameTaken)
+	assert.Nil(t, collections[0].Update("soak", ""))
+}
@@ -0,0 +1,135 @@
+package model
+
+// CompareThresholds decide whether a change between two runs is a regression.
+type CompareThresholds struct {
+	// Latency is the allowed increase of the latency percentiles, in percent
+	Latency float64 `json:"latency"`
+	// Throughput is the allowed decrease of the throughput, in percent
+	Throughput float64 `json:"throughput"`
+	// ErrorRate is the allowed increase of the error rate, in percentage points
+	ErrorRate float64 `json:"error_rate"`
+}
+
+var (
+	DefaultCompareThresholds = CompareThresholds{
+		Latency:    10,
+		Throughput: 10,
+		ErrorRate:  1,
+	}
+)
+
+type MetricDelta struct {
+	Base   float64 `json:"base"`
+	Target float64 `json:"target"`
+	Delta  float64 `json:"delta"`
+	// DeltaPercent is the change relative to the base. It's 0 when the base is 0.
+	DeltaPercent float64 `json:"delta_percent"`
+	Regressed    bool    `json:"regressed"`
+}
+
+// LabelComparison compares a label, a plan(empty label) or the whole run(plan id 0).
+// Missing is set to either base or target when the label only exists in one of the runs.
+type LabelComparison struct {
+	PlanID     int64        `json:"plan_id"`
+	Label      string       `json:"label"`
+	P50Latency *MetricDelta `json:"p50_latency"`
+	P90Latency *MetricDelta `json:"p90_latency"`
+	P95Latency *MetricDelta `json:"p95_latency"`
+	P99Latency *MetricDelta `json:"p99_latency"`
+	Throughput *MetricDelta `json:"throughput"`
+	ErrorRate  *MetricDelta `json:"error_rate"`
+	Missing    string       `json:"missing,omitempty"`
+	Regressed  bool         `json:"regressed"`
+}
+
+type RunComparison struct {
+	BaseRunID   int64              `json:"base_run_id"`
+	TargetRunID int64              `json:"target_run_id"`
+	Thresholds  CompareThresholds  `json:"thresholds"`
+	Summary     *LabelComparison   `json:"summary"`
+	Labels      []*LabelComparison `json:"labels"`
+	Regressed   bool               `json:"regressed"`
+}
+
+// ErrorRate is the percentage of the failed requests
+func (s *RunSummary) ErrorRate() float64 {
+	if s.RequestCount == 0 {
+		return 0
+	}
+	return float64(s.ErrorCount) / float64(s.RequestCount) * 100
+}
+
+func makeDelta(base, target float64) *MetricDelta {
+	md := &MetricDelta{
+		Base:   base,
+		Target: target,
+		Delta:  target - base,
+	}
+	if base != 0 {
+		md.DeltaPercent = md.Delta / base * 100
+	}
+	return md
+}
+
+func compareLatency(base, target, threshold float64) *MetricDelta {
+	md := makeDelta(base, target)
+	md.Regressed = md.DeltaPercent > threshold
+	return md
+}
+
+func compareSummary(base, target *RunSummary, th CompareThresholds) *LabelComparison {
+	lc := &LabelComparison{
+		PlanID:     base.PlanID,
+		Label:      base.Label,
+		P50Latency: compareLatency(base.P50Latency, target.P50Latency, th.Latency),
+		P90Latency: compareLatency(base.P90Latency, target.P90Latency, th.Latency),
+		P95Latency: compareLatency(base.P95Latency, target.P95Latency, th.Latency),
+		P99Latency: compareLatency(base.P99Latency, target.P99Latency, th.Latency),
+		Throughput: makeDelta(base.Throughput, target.Throughput),
+		ErrorRate:  makeDelta(base.ErrorRate(), target.ErrorRate()),
+	}
+	lc.Throughput.Regressed = -lc.Throughput.DeltaPercent > th.Throughput
+	lc.ErrorRate.Regressed = lc.ErrorRate.Delta > th.ErrorRate
+	for _, md := range []*MetricDelta{lc.P50Latency, lc.P90Latency, lc.P95Latency, lc.P99Latency,
+		lc.Throughput, lc.ErrorRate} {
+		lc.Regressed = lc.Regressed || md.Regressed
+	}
+	return lc
+}
+
+// CompareRuns matches the summaries of two runs by plan and label. Labels only existing in one of
+// the runs are reported as missing and are not considered as regressions.
+func CompareRuns(baseRunID, targetRunID int64, base, target []*RunSummary, th CompareThresholds) *RunComparison {
+	rc := &RunComparison{
+		BaseRunID:   baseRunID,
+		TargetRunID: targetRunID,
+		Thresholds:  th,
+		Labels:      []*LabelComparison{},
+	}
+	targetByKey := make(map[summaryKey]*RunSummary)
+	for _, s := range target {
+		targetByKey[summaryKey{planID: s.PlanID, label: s.Label}] = s
+	}
+	for _, b := range base {
+		key := summaryKey{planID: b.PlanID, label: b.Label}
+		t, ok := targetByKey[key]
+		if !ok {
+			rc.Labels = append(rc.Labels, &LabelComparison{PlanID: b.PlanID, Label: b.Label, Missing: "target"})
+			continue
+		}
+		delete(targetByKey, key)
+		lc := compareSummary(b, t, th)
+		if b.PlanID == 0 {
+			rc.Summary = lc
+		} else {
+			rc.Labels = append(rc.Labels, lc)
+		}
+		rc.Regressed = rc.Regressed || lc.Regressed
+	}
+	for _, t := range target {
+		if _, ok := targetByKey[summaryKey{planID: t.PlanID, label: t.Label}]; ok {
+			rc.Labels = append(rc.Labels, &LabelComparison{PlanID: t.PlanID, Label: t.Label, Missing: "base"})
+		}
+	}
+	return rc
+}
@@ -0,0 +1,39 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareRuns(t *testing.T) {
+	base := []*RunSummary{
+		{PlanID: 0, RequestCount: 100, ErrorCount: 1, P90Latency: 100, Throughput: 100},
+		{PlanID: 1, Label: "a", RequestCount: 100, ErrorCount: 1, P90Latency: 100, Throughput: 100},
+		{PlanID: 1, Label: "b", RequestCount: 100, P90Latency: 100, Throughput: 100},
+	}
+	target := []*RunSummary{
+		{PlanID: 0, RequestCount: 100, ErrorCount: 1, P90Latency: 105, Throughput: 95},
+		{PlanID: 1, Label: "a", RequestCount: 100, ErrorCount: 5, P90Latency: 120, Throughput: 80},
+		{PlanID: 1, Label: "c", RequestCount: 100, P90Latency: 100, Throughput: 100},
+	}
+	rc := CompareRuns(1, 2, base, target, DefaultCompareThresholds)
+	assert.False(t, rc.Summary.Regressed)
+	assert.Equal(t, float64(5), rc.Summary.P90Latency.DeltaPercent)
+	assert.True(t, rc.Regressed)
+	assert.Equal(t, 3, len(rc.Labels))
+
+	a := rc.Labels[0]
+	assert.Equal(t, "a", a.Label)
+	assert.True(t, a.P90Latency.Regressed)
+	assert.True(t, a.Throughput.Regressed)
+	assert.True(t, a.ErrorRate.Regressed)
+	assert.Equal(t, float64(4), a.ErrorRate.Delta)
+
+	assert.Equal(t, "target", rc.Labels[1].Missing)
+	assert.Equal(t, "base", rc.Labels[2].Missing)
+
+	loose := CompareThresholds{Latency: 50, Throughput: 50, ErrorRate: 10}
+	rc = CompareRuns(1, 2, base, target, loose)
+	assert.False(t, rc.Regressed)
+}
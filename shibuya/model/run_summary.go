@@ -0,0 +1,244 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// summaryFieldLength is the max length of the labels and statuses stored in the summaries
+const summaryFieldLength = 191
+
+// RunSummary is the aggregated result of a run. The summary with PlanID 0 covers the whole run
+// and the summary without a label covers the whole plan. Latencies are in milliseconds and
+// throughput is in requests per second.
+type RunSummary struct {
+	RunID        int64            `json:"run_id"`
+	CollectionID int64            `json:"collection_id"`
+	PlanID       int64            `json:"plan_id"`
+	Label        string           `json:"label"`
+	RequestCount int64            `json:"request_count"`
+	ErrorCount   int64            `json:"error_count"`
+	StatusCounts map[string]int64 `json:"status_counts"`
+	MinLatency   float64          `json:"min_latency"`
+	MeanLatency  float64          `json:"mean_latency"`
+	MaxLatency   float64          `json:"max_latency"`
+	P50Latency   float64          `json:"p50_latency"`
+	P90Latency   float64          `json:"p90_latency"`
+	P95Latency   float64          `json:"p95_latency"`
+	P99Latency   float64          `json:"p99_latency"`
+	Throughput   float64          `json:"throughput"`
+}
+
+type PlanRunResult struct {
+	PlanID  int64         `json:"plan_id"`
+	Summary *RunSummary   `json:"summary"`
+	Labels  []*RunSummary `json:"labels"`
+}
+
+// RunResult is the history of a run along with its summaries. Summary could be nil if the run is
+// still in progress or the results were not collected.
+type RunResult struct {
+	*RunHistory
+	Summary  *RunSummary        `json:"summary"`
+	Plans    []*PlanRunResult   `json:"plans"`
+	Criteria []*CriterionResult `json:"criteria"`
+	// Files are the versions of the plan files used by the run
+	Files []*RunPlanFile `json:"files"`
+}
+
+func truncateSummaryField(s string) string {
+	r := []rune(s)
+	if len(r) <= summaryFieldLength {
+		return s
+	}
+	return string(r[:summaryFieldLength])
+}
+
+// makeStorableSummaries truncates the labels and statuses which are too long to be stored. The
+// statuses which become the same are counted together, while a label which becomes the same as
+// another one of the plan is dropped as its stats cannot be merged.
+func makeStorableSummaries(summaries []*RunSummary) []*RunSummary {
+	r := make([]*RunSummary, 0, len(summaries))
+	seen := make(map[summaryKey]struct{})
+	for _, s := range summaries {
+		key := summaryKey{planID: s.PlanID, label: truncateSummaryField(s.Label)}
+		if _, ok := seen[key]; ok {
+			log.Warnf("Label %q of plan %d is too long to be stored in the summary", s.Label, s.PlanID)
+			continue
+		}
+		seen[key] = struct{}{}
+		ts := *s
+		ts.Label = key.label
+		ts.StatusCounts = make(map[string]int64, len(s.StatusCounts))
+		for status, count := range s.StatusCounts {
+			ts.StatusCounts[truncateSummaryField(status)] += count
+		}
+		r = append(r, &ts)
+	}
+	return r
+}
+
+// StoreRunSummaries replaces all the summaries of the run so it's safe to be called more than once.
+func StoreRunSummaries(runID int64, summaries []*RunSummary) error {
+	summaries = makeStorableSummaries(summaries)
+	db := getDB()
+	tx, err := db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("delete from run_summary where run_id=?", runID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("delete from run_summary_status where run_id=?", runID); err != nil {
+		return err
+	}
+	for _, s := range summaries {
+		_, err := tx.Exec(`insert run_summary set run_id=?,collection_id=?,plan_id=?,label=?,request_count=?,error_count=?,
+min_latency=?,mean_latency=?,max_latency=?,p50_latency=?,p90_latency=?,p95_latency=?,p99_latency=?,throughput=?`,
+			runID, s.CollectionID, s.PlanID, s.Label, s.RequestCount, s.ErrorCount, s.MinLatency, s.MeanLatency,
+			s.MaxLatency, s.P50Latency, s.P90Latency, s.P95Latency, s.P99Latency, s.Throughput)
+		if err != nil {
+			return err
+		}
+		for status, count := range s.StatusCounts {
+			_, err := tx.Exec("insert run_summary_status set run_id=?,collection_id=?,plan_id=?,label=?,status=?,count=?",
+				runID, s.CollectionID, s.PlanID, s.Label, status, count)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+type summaryKey struct {
+	planID int64
+	label  string
+}
+
+func GetRunSummaries(runID int64) ([]*RunSummary, error) {
+	db := getDB()
+	q, err := db.Prepare(`select collection_id, plan_id, label, request_count, error_count, min_latency, mean_latency,
+max_latency, p50_latency, p90_latency, p95_latency, p99_latency, throughput from run_summary where run_id=? order by plan_id, label`)
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+	rs, err := q.Query(runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rs.Close()
+
+	r := []*RunSummary{}
+	byKey := make(map[summaryKey]*RunSummary)
+	for rs.Next() {
+		s := &RunSummary{RunID: runID, StatusCounts: make(map[string]int64)}
+		if err := rs.Scan(&s.CollectionID, &s.PlanID, &s.Label, &s.RequestCount, &s.ErrorCount, &s.MinLatency,
+			&s.MeanLatency, &s.MaxLatency, &s.P50Latency, &s.P90Latency, &s.P95Latency, &s.P99Latency,
+			&s.Throughput); err != nil {
+			return nil, err
+		}
+		r = append(r, s)
+		byKey[summaryKey{planID: s.PlanID, label: s.Label}] = s
+	}
+	q2, err := db.Prepare("select plan_id, label, status, count from run_summary_status where run_id=?")
+	if err != nil {
+		return nil, err
+	}
+	defer q2.Close()
+	rs2, err := q2.Query(runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rs2.Close()
+	for rs2.Next() {
+		var key summaryKey
+		var status string
+		var count int64
+		if err := rs2.Scan(&key.planID, &key.label, &status, &count); err != nil {
+			return nil, err
+		}
+		if s, ok := byKey[key]; ok {
+			s.StatusCounts[status] = count
+		}
+	}
+	return r, nil
+}
+
+func GetRunResult(runID int64) (*RunResult, error) {
+	rh, err := GetRun(runID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, &DBError{Err: err, Message: "run not found"}
+		}
+		return nil, err
+	}
+	summaries, err := GetRunSummaries(runID)
+	if err != nil {
+		return nil, err
+	}
+	criteria, err := GetRunCriteriaResults(runID)
+	if err != nil {
+		return nil, err
+	}
+	files, err := GetRunPlanFiles(runID)
+	if err != nil {
+		return nil, err
+	}
+	rr := &RunResult{RunHistory: rh, Plans: []*PlanRunResult{}, Criteria: criteria, Files: files}
+	plans := make(map[int64]*PlanRunResult)
+	for _, s := range summaries {
+		if s.PlanID == 0 {
+			rr.Summary = s
+			continue
+		}
+		prr, ok := plans[s.PlanID]
+		if !ok {
+			prr = &PlanRunResult{PlanID: s.PlanID, Labels: []*RunSummary{}}
+			plans[s.PlanID] = prr
+			rr.Plans = append(rr.Plans, prr)
+		}
+		if s.Label == "" {
+			prr.Summary = s
+			continue
+		}
+		prr.Labels = append(prr.Labels, s)
+	}
+	return rr, nil
+}
+
+func deleteRunResults(query string, args ...any) error {
+	db := getDB()
+	for _, table := range []string{"run_summary", "run_summary_status", "run_criteria_result", "run_plan_file"} {
+		q, err := db.Prepare("delete from " + table + " where " + query)
+		if err != nil {
+			return err
+		}
+		_, err = q.Exec(args...)
+		q.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Collection) DeleteRun(runID int64) error {
+	if err := deleteRunResults("run_id=?", runID); err != nil {
+		return err
+	}
+	db := getDB()
+	q, err := db.Prepare("delete from collection_run_history where collection_id=? and run_id=?")
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+	_, err = q.Exec(c.ID, runID)
+	return err
+}
@@ -0,0 +1,30 @@
+package model
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMakeStorableSummaries(t *testing.T) {
+	long := strings.Repeat("a", summaryFieldLength)
+	summaries := []*RunSummary{
+		{PlanID: 1, Label: "/foo", StatusCounts: map[string]int64{"200": 1}},
+		{PlanID: 1, Label: long + "b", StatusCounts: map[string]int64{long + "1": 2, long + "2": 3, "200": 1}},
+		{PlanID: 1, Label: long + "c", RequestCount: 5},
+		{PlanID: 2, Label: long + "c"},
+	}
+	r := makeStorableSummaries(summaries)
+	assert.Equal(t, 3, len(r))
+	assert.Equal(t, "/foo", r[0].Label)
+	assert.Equal(t, long, r[1].Label)
+	assert.Equal(t, map[string]int64{long: 5, "200": 1}, r[1].StatusCounts)
+	assert.Equal(t, int64(2), r[2].PlanID)
+	assert.Equal(t, long, r[2].Label)
+	// the summaries of the run are not changed
+	assert.Equal(t, long+"b", summaries[1].Label)
+	assert.Equal(t, 3, len(summaries[1].StatusCounts))
+	// the length is counted in characters like mysql does
+	assert.Equal(t, summaryFieldLength, len([]rune(truncateSummaryField(strings.Repeat("é", 200)))))
+}
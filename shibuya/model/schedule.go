@@ -0,0 +1,304 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	mysql "github.com/go-sql-driver/mysql"
+	"github.com/rakutentech/shibuya/shibuya/cron"
+)
+
+const (
+	ScheduleRunRunning  = "running"
+	ScheduleRunFinished = "finished"
+	ScheduleRunFailed   = "failed"
+)
+
+// Schedule runs a collection periodically. Every time the cron expression fires, the collection is
+// deployed, triggered once the engines are ready and purged after the run.
+// The cron expression is evaluated in Timezone, which is an IANA name such as Asia/Tokyo.
+type Schedule struct {
+	ID           int64     `json:"id"`
+	CollectionID int64     `json:"collection_id"`
+	Cron         string    `json:"cron"`
+	Timezone     string    `json:"timezone"`
+	Enabled      bool      `json:"enabled"`
+	CreatedBy    string    `json:"created_by"`
+	NextRun      time.Time `json:"next_run"`
+	CreatedTime  time.Time `json:"created_time"`
+}
+
+// ScheduleRun is the history of a single execution of a schedule. RunID is 0 when the collection
+// could not be triggered.
+type ScheduleRun struct {
+	ID           int64     `json:"id"`
+	ScheduleID   int64     `json:"schedule_id"`
+	CollectionID int64     `json:"collection_id"`
+	RunID        int64     `json:"run_id"`
+	Status       string    `json:"status"`
+	Message      string    `json:"message"`
+	StartedTime  time.Time `json:"started_time"`
+	EndTime      time.Time `json:"end_time"`
+}
+
+func (s *Schedule) location() (*time.Location, error) {
+	if s.Timezone == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %s", s.Timezone)
+	}
+	return loc, nil
+}
+
+func (s *Schedule) Validate() error {
+	if _, err := cron.Parse(s.Cron); err != nil {
+		return err
+	}
+	_, err := s.location()
+	return err
+}
+
+// Next returns the first time after t the schedule fires, in UTC
+func (s *Schedule) Next(t time.Time) (time.Time, error) {
+	expr, err := cron.Parse(s.Cron)
+	if err != nil {
+		return time.Time{}, err
+	}
+	loc, err := s.location()
+	if err != nil {
+		return time.Time{}, err
+	}
+	next := expr.Next(t.In(loc))
+	if next.IsZero() {
+		return next, fmt.Errorf("cron expression %s never fires", s.Cron)
+	}
+	return next.UTC(), nil
+}
+
+func scanSchedule(rs interface{ Scan(...interface{}) error }) (*Schedule, error) {
+	s := new(Schedule)
+	var nextRun mysql.NullTime
+	if err := rs.Scan(&s.ID, &s.CollectionID, &s.Cron, &s.Timezone, &s.Enabled, &s.CreatedBy, &nextRun,
+		&s.CreatedTime); err != nil {
+		return nil, err
+	}
+	if nextRun.Valid {
+		s.NextRun = nextRun.Time
+	}
+	return s, nil
+}
+
+const scheduleColumns = "id, collection_id, cron, timezone, enabled, created_by, next_run, created_time"
+
+func querySchedules(query string, args ...interface{}) ([]*Schedule, error) {
+	db := getDB()
+	q, err := db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+	rs, err := q.Query(args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rs.Close()
+	r := []*Schedule{}
+	for rs.Next() {
+		s, err := scanSchedule(rs)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, s)
+	}
+	return r, nil
+}
+
+func (c *Collection) CreateSchedule(s *Schedule) error {
+	next, err := s.Next(time.Now())
+	if err != nil {
+		return err
+	}
+	db := getDB()
+	q, err := db.Prepare("insert collection_schedule set collection_id=?,cron=?,timezone=?,enabled=?,created_by=?,next_run=?")
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+	r, err := q.Exec(c.ID, s.Cron, s.Timezone, s.Enabled, s.CreatedBy, next)
+	if err != nil {
+		return err
+	}
+	if s.ID, err = r.LastInsertId(); err != nil {
+		return err
+	}
+	s.CollectionID = c.ID
+	s.NextRun = next
+	return nil
+}
+
+func GetSchedule(id int64) (*Schedule, error) {
+	db := getDB()
+	q, err := db.Prepare("select " + scheduleColumns + " from collection_schedule where id=?")
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+	s, err := scanSchedule(q.QueryRow(id))
+	if err != nil {
+		return nil, &DBError{Err: err, Message: "schedule not found"}
+	}
+	return s, nil
+}
+
+func (c *Collection) GetSchedules() ([]*Schedule, error) {
+	return querySchedules("select "+scheduleColumns+" from collection_schedule where collection_id=? order by id", c.ID)
+}
+
+// GetDueSchedules returns the enabled schedules that should have fired by now
+func GetDueSchedules(now time.Time) ([]*Schedule, error) {
+	return querySchedules("select "+scheduleColumns+" from collection_schedule where enabled=1 and next_run<=?",
+		now.UTC())
+}
+
+// Update stores the cron expression, timezone and enabled flag and recalculates the next run
+func (s *Schedule) Update() error {
+	next, err := s.Next(time.Now())
+	if err != nil {
+		return err
+	}
+	db := getDB()
+	q, err := db.Prepare("update collection_schedule set cron=?,timezone=?,enabled=?,next_run=? where id=?")
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+	if _, err := q.Exec(s.Cron, s.Timezone, s.Enabled, next, s.ID); err != nil {
+		return err
+	}
+	s.NextRun = next
+	return nil
+}
+
+// Claim moves the schedule to its next run. In distributed mode, only one of the controllers
+// succeeds in claiming a due schedule.
+func (s *Schedule) Claim(now time.Time) (bool, error) {
+	next, err := s.Next(now)
+	if err != nil {
+		return false, err
+	}
+	db := getDB()
+	q, err := db.Prepare("update collection_schedule set next_run=? where id=? and next_run=?")
+	if err != nil {
+		return false, err
+	}
+	defer q.Close()
+	r, err := q.Exec(next, s.ID, s.NextRun)
+	if err != nil {
+		return false, err
+	}
+	n, err := r.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		return false, nil
+	}
+	s.NextRun = next
+	return true, nil
+}
+
+func (s *Schedule) Delete() error {
+	db := getDB()
+	if _, err := db.Exec("delete from collection_schedule_run where schedule_id=?", s.ID); err != nil {
+		return err
+	}
+	_, err := db.Exec("delete from collection_schedule where id=?", s.ID)
+	return err
+}
+
+func (c *Collection) DeleteSchedules() error {
+	db := getDB()
+	if _, err := db.Exec("delete from collection_schedule_run where collection_id=?", c.ID); err != nil {
+		return err
+	}
+	_, err := db.Exec("delete from collection_schedule where collection_id=?", c.ID)
+	return err
+}
+
+func (s *Schedule) NewRun() (*ScheduleRun, error) {
+	db := getDB()
+	q, err := db.Prepare("insert collection_schedule_run set schedule_id=?,collection_id=?,status=?")
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+	r, err := q.Exec(s.ID, s.CollectionID, ScheduleRunRunning)
+	if err != nil {
+		return nil, err
+	}
+	id, err := r.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return &ScheduleRun{
+		ID:           id,
+		ScheduleID:   s.ID,
+		CollectionID: s.CollectionID,
+		Status:       ScheduleRunRunning,
+		StartedTime:  time.Now(),
+	}, nil
+}
+
+func (sr *ScheduleRun) SetRunID(runID int64) error {
+	db := getDB()
+	if _, err := db.Exec("update collection_schedule_run set run_id=? where id=?", runID, sr.ID); err != nil {
+		return err
+	}
+	sr.RunID = runID
+	return nil
+}
+
+func (sr *ScheduleRun) Finish(status, message string) error {
+	db := getDB()
+	_, err := db.Exec("update collection_schedule_run set status=?,message=?,end_time=NOW() where id=?",
+		status, message, sr.ID)
+	if err != nil {
+		return err
+	}
+	sr.Status = status
+	sr.Message = message
+	return nil
+}
+
+func (s *Schedule) GetRuns() ([]*ScheduleRun, error) {
+	db := getDB()
+	q, err := db.Prepare("select id, schedule_id, collection_id, run_id, status, message, started_time, end_time from collection_schedule_run where schedule_id=? order by id desc")
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+	rs, err := q.Query(s.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rs.Close()
+	r := []*ScheduleRun{}
+	for rs.Next() {
+		sr := new(ScheduleRun)
+		var message sql.NullString
+		var endTime mysql.NullTime
+		if err := rs.Scan(&sr.ID, &sr.ScheduleID, &sr.CollectionID, &sr.RunID, &sr.Status, &message,
+			&sr.StartedTime, &endTime); err != nil {
+			return nil, err
+		}
+		sr.Message = message.String
+		if endTime.Valid {
+			sr.EndTime = endTime.Time
+		}
+		r = append(r, sr)
+	}
+	return r, nil
+}
@@ -0,0 +1,33 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduleValidate(t *testing.T) {
+	assert.Nil(t, (&Schedule{Cron: "0 9 * * 1-5", Timezone: "UTC"}).Validate())
+	assert.Nil(t, (&Schedule{Cron: "@daily"}).Validate())
+	assert.NotNil(t, (&Schedule{Cron: "0 9 * *"}).Validate())
+	assert.NotNil(t, (&Schedule{Cron: "0 9 * * *", Timezone: "Mars/Olympus"}).Validate())
+}
+
+func TestScheduleNext(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	s := &Schedule{Cron: "0 9 * * *"}
+	next, err := s.Next(now)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC), next)
+
+	if _, err := time.LoadLocation("Asia/Tokyo"); err == nil {
+		s.Timezone = "Asia/Tokyo"
+		next, err = s.Next(now)
+		assert.Nil(t, err)
+		assert.Equal(t, time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC), next)
+	}
+
+	_, err = (&Schedule{Cron: "0 0 31 2 *"}).Next(now)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,79 @@
+package model
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Stage ramps the concurrency linearly from the target of the previous stage to its own target
+// within the duration. The first stage starts from zero.
+// Duration uses the Go duration format, for example 30s, 5m or 1h30m.
+type Stage struct {
+	Duration string `yaml:"duration" json:"duration"`
+	Target   int    `yaml:"target" json:"target"`
+}
+
+func (s *Stage) Seconds() (int, error) {
+	d, err := time.ParseDuration(s.Duration)
+	if err != nil {
+		return 0, fmt.Errorf("invalid stage duration %s", s.Duration)
+	}
+	if d < time.Second {
+		return 0, fmt.Errorf("stage duration %s should be at least one second", s.Duration)
+	}
+	return int(d.Seconds()), nil
+}
+
+// ApplyStages validates the stages of the plan and derives the flat config from them, so the
+// engine and vu calculations keep working. Concurrency becomes the peak of the stages and
+// duration is the total length rounded up to minutes.
+func (ep *ExecutionPlan) ApplyStages() error {
+	if len(ep.Stages) == 0 {
+		return nil
+	}
+	total, peak := 0, 0
+	for _, s := range ep.Stages {
+		seconds, err := s.Seconds()
+		if err != nil {
+			return err
+		}
+		if s.Target < 0 {
+			return fmt.Errorf("stage target %d cannot be negative", s.Target)
+		}
+		total += seconds
+		if s.Target > peak {
+			peak = s.Target
+		}
+	}
+	if peak == 0 {
+		return fmt.Errorf("at least one stage of test %d should have a target", ep.PlanID)
+	}
+	ep.Concurrency = peak
+	ep.Rampup = 0
+	ep.Duration = (total + 59) / 60
+	return nil
+}
+
+func marshalStages(stages []*Stage) (sql.NullString, error) {
+	if len(stages) == 0 {
+		return sql.NullString{}, nil
+	}
+	b, err := json.Marshal(stages)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(b), Valid: true}, nil
+}
+
+func unmarshalStages(s sql.NullString) ([]*Stage, error) {
+	if !s.Valid || s.String == "" {
+		return nil, nil
+	}
+	stages := []*Stage{}
+	if err := json.Unmarshal([]byte(s.String), &stages); err != nil {
+		return nil, err
+	}
+	return stages, nil
+}
@@ -0,0 +1,50 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyStages(t *testing.T) {
+	ep := &ExecutionPlan{Concurrency: 1, Rampup: 30, Duration: 1, Stages: []*Stage{
+		{Duration: "5m", Target: 100},
+		{Duration: "10m", Target: 500},
+		{Duration: "2m30s", Target: 0},
+	}}
+	assert.Nil(t, ep.ApplyStages())
+	assert.Equal(t, 500, ep.Concurrency)
+	assert.Equal(t, 0, ep.Rampup)
+	assert.Equal(t, 18, ep.Duration)
+
+	ep = &ExecutionPlan{Concurrency: 10, Duration: 5}
+	assert.Nil(t, ep.ApplyStages())
+	assert.Equal(t, 10, ep.Concurrency)
+
+	for _, stages := range [][]*Stage{
+		{{Duration: "5", Target: 100}},
+		{{Duration: "500ms", Target: 100}},
+		{{Duration: "5m", Target: -1}},
+		{{Duration: "5m", Target: 0}},
+	} {
+		ep = &ExecutionPlan{Stages: stages}
+		assert.NotNil(t, ep.ApplyStages())
+	}
+}
+
+func TestStagesMarshalling(t *testing.T) {
+	stages := []*Stage{{Duration: "5m", Target: 100}}
+	s, err := marshalStages(stages)
+	assert.Nil(t, err)
+	assert.True(t, s.Valid)
+	r, err := unmarshalStages(s)
+	assert.Nil(t, err)
+	assert.Equal(t, stages, r)
+
+	s, err = marshalStages(nil)
+	assert.Nil(t, err)
+	assert.False(t, s.Valid)
+	r, err = unmarshalStages(s)
+	assert.Nil(t, err)
+	assert.Nil(t, r)
+}
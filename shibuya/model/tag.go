@@ -0,0 +1,124 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	projectTag    = "project"
+	collectionTag = "collection"
+	planTag       = "plan"
+
+	maxTagValueLength = 255
+)
+
+var tagNameRe = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.\-/]{0,62}$`)
+
+// Tags are free-form labels, for example env=staging or team=checkout. A tag can have an empty
+// value.
+type Tags map[string]string
+
+// ParseTags parses tags in the form of `env=staging,team=checkout`. It's also used for the
+// selectors of the list endpoints, where a tag without a value matches any value.
+func ParseTags(s string) (Tags, error) {
+	tags := Tags{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(item, "=")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !tagNameRe.MatchString(name) {
+			return nil, fmt.Errorf("invalid tag name %q", name)
+		}
+		if len(value) > maxTagValueLength {
+			return nil, fmt.Errorf("value of tag %s is longer than %d", name, maxTagValueLength)
+		}
+		tags[name] = value
+	}
+	return tags, nil
+}
+
+func (t Tags) String() string {
+	items := make([]string, 0, len(t))
+	for name, value := range t {
+		if value == "" {
+			items = append(items, name)
+			continue
+		}
+		items = append(items, name+"="+value)
+	}
+	sort.Strings(items)
+	return strings.Join(items, ",")
+}
+
+// Match tells whether the tags have all the tags of the selector
+func (t Tags) Match(selector Tags) bool {
+	for name, value := range selector {
+		v, ok := t[name]
+		if !ok || (value != "" && v != value) {
+			return false
+		}
+	}
+	return true
+}
+
+func storeTags(kind string, resourceID int64, tags Tags) error {
+	db := getDB()
+	tx, err := db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("delete from resource_tag where kind=? and resource_id=?", kind, resourceID); err != nil {
+		return err
+	}
+	for name, value := range tags {
+		_, err := tx.Exec("insert resource_tag set kind=?,resource_id=?,name=?,value=?", kind, resourceID, name, value)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func deleteTags(kind string, resourceID int64) error {
+	db := getDB()
+	_, err := db.Exec("delete from resource_tag where kind=? and resource_id=?", kind, resourceID)
+	return err
+}
+
+// getTags returns the tags of the resources by their ids. Every resource gets non-nil tags.
+func getTags(kind string, resourceIDs []int64) (map[int64]Tags, error) {
+	r := make(map[int64]Tags, len(resourceIDs))
+	if len(resourceIDs) == 0 {
+		return r, nil
+	}
+	args := []any{kind}
+	for _, id := range resourceIDs {
+		r[id] = Tags{}
+		args = append(args, id)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(resourceIDs)), ",")
+	db := getDB()
+	rs, err := db.Query("select resource_id, name, value from resource_tag where kind=? and resource_id in ("+placeholders+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rs.Close()
+	for rs.Next() {
+		var id int64
+		var name, value string
+		if err := rs.Scan(&id, &name, &value); err != nil {
+			return nil, err
+		}
+		r[id][name] = value
+	}
+	return r, rs.Err()
+}
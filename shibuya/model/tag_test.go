@@ -0,0 +1,37 @@
+package model
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTags(t *testing.T) {
+	tags, err := ParseTags(" env = staging, team=checkout,critical,, ")
+	assert.Nil(t, err)
+	assert.Equal(t, Tags{"env": "staging", "team": "checkout", "critical": ""}, tags)
+	assert.Equal(t, "critical,env=staging,team=checkout", tags.String())
+
+	tags, err = ParseTags("")
+	assert.Nil(t, err)
+	assert.Empty(t, tags)
+
+	_, err = ParseTags("=staging")
+	assert.NotNil(t, err)
+	_, err = ParseTags("env name=staging")
+	assert.NotNil(t, err)
+	_, err = ParseTags("env=" + strings.Repeat("a", maxTagValueLength+1))
+	assert.NotNil(t, err)
+}
+
+func TestTagsMatch(t *testing.T) {
+	tags := Tags{"env": "staging", "critical": ""}
+	assert.True(t, tags.Match(nil))
+	assert.True(t, tags.Match(Tags{"env": "staging"}))
+	assert.True(t, tags.Match(Tags{"env": ""}))
+	assert.True(t, tags.Match(Tags{"env": "staging", "critical": ""}))
+	assert.False(t, tags.Match(Tags{"env": "production"}))
+	assert.False(t, tags.Match(Tags{"team": ""}))
+	assert.False(t, Tags(nil).Match(Tags{"env": ""}))
+}
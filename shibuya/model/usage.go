@@ -189,3 +189,23 @@ func GetUsageSummaryBySid(sid, startedTime, endTime string) (*OwnerUsageSummary,
 	s.History = sidHistory
 	return s, nil
 }
+
+// GetVUHByProject returns the vu-hours of the launches in the period by project. The launches of
+// the deleted projects are not counted.
+func GetVUHByProject(startedTime, endTime string) (map[int64]float64, error) {
+	history, err := GetHistory(startedTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	collectionsToProjects := makeCollectionsToProjects(history)
+	vuh := make(map[int64]float64)
+	for _, h := range history {
+		p, ok := collectionsToProjects[h.CollectionID]
+		if !ok {
+			continue
+		}
+		billingHours := calBillingHours(h.StartedTime, h.EndTime)
+		vuh[p.ID] += calVUH(billingHours, float64(h.Vu))
+	}
+	return vuh, nil
+}
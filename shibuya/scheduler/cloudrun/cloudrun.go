@@ -1,28 +1,48 @@
 package cloudrun
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/rakutentech/shibuya/shibuya/config"
+	"github.com/rakutentech/shibuya/shibuya/coordinator/upstream"
+	httptoken "github.com/rakutentech/shibuya/shibuya/http/auth/token"
 	model "github.com/rakutentech/shibuya/shibuya/model"
+	serrors "github.com/rakutentech/shibuya/shibuya/scheduler/errors"
 	smodel "github.com/rakutentech/shibuya/shibuya/scheduler/model"
 	log "github.com/sirupsen/logrus"
 	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
+	"google.golang.org/api/googleapi"
+	logging "google.golang.org/api/logging/v2"
 	"google.golang.org/api/option"
 	"google.golang.org/api/run/v1"
+	secretmanager "google.golang.org/api/secretmanager/v1"
+)
+
+const (
+	// the services of the engines and the coordinators keep the connections of the metric streams
+	requestTimeout = 3600
+	// enginesLabel is the number of the engines of the plan, which tells whether all of them are deployed
+	enginesLabel = "engines"
 )
 
 type CloudRun struct {
 	rs          *run.APIService
+	sm          *secretmanager.Service
+	ls          *logging.Service
 	projectID   string
 	nsProjectID string
 	region      string
+	sc          config.ShibuyaConfig
 
 	// cloud run admin api has quota. This queue is to protect we don't hit the quota
 	// If we hit the quota, we cannot do any operations
@@ -39,11 +59,29 @@ func NewCloudRun(sc config.ShibuyaConfig) *CloudRun {
 	if err != nil {
 		log.Fatal(err)
 	}
+	sm, err := secretmanager.NewService(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ls, err := logging.NewService(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cr := newCloudRun(sc, rs, sm, ls)
+	go cr.startWriteRequestWorker()
+	return cr
+}
+
+func newCloudRun(sc config.ShibuyaConfig, rs *run.APIService, sm *secretmanager.Service, ls *logging.Service) *CloudRun {
+	cfg := sc.ExecutorConfig
 	projectID := cfg.Cluster.Project
 	nsProjectID := fmt.Sprintf("namespaces/%s", projectID)
 	queue := make(chan *cloudRunRequest, 1000)
 
 	cr := &CloudRun{rs: rs,
+		sm:              sm,
+		ls:              ls,
+		sc:              sc,
 		projectID:       projectID,
 		nsProjectID:     nsProjectID,
 		throttlingQueue: queue,
@@ -51,7 +89,6 @@ func NewCloudRun(sc config.ShibuyaConfig) *CloudRun {
 	cr.httpClient = &http.Client{
 		Timeout: 30 * time.Second,
 	}
-	go cr.startWriteRequestWorker()
 	return cr
 }
 
@@ -59,29 +96,59 @@ func (cr *CloudRun) MakeName(projectID, collectionID, planID int64, engineID int
 	return fmt.Sprintf("engine-%d-%d-%d-%d", projectID, collectionID, planID, engineID)
 }
 
-func (cr *CloudRun) makeLabels(projectID, collectionID, planID int64, engineID int) map[string]string {
+func (cr *CloudRun) makeLabels(projectID, collectionID, planID int64, engineID, replicas int) map[string]string {
 	m := make(map[string]string)
 	fm := strconv.FormatInt
 	m["project"] = fm(projectID, 10)
 	m["collection"] = fm(collectionID, 10)
 	m["plan"] = fm(planID, 10)
 	m["engine"] = fm(int64(engineID), 10)
+	m["kind"] = smodel.Executor
+	m[enginesLabel] = strconv.Itoa(replicas)
 	return m
 }
 
-func (cr *CloudRun) makeService(projectID, collectionID, planID int64, engineID int, ec *config.ExecutorContainer) *run.Service {
-	m := cr.makeLabels(projectID, collectionID, planID, engineID)
+func (cr *CloudRun) serviceAccount() string {
+	if cr.sc.ExecutorConfig.CloudRun == nil {
+		return ""
+	}
+	return cr.sc.ExecutorConfig.CloudRun.ServiceAccount
+}
+
+func makeResources(cpu, mem string) *run.ResourceRequirements {
 	requests := map[string]string{
-		"cpu":    ec.CPU,
-		"memory": ec.Mem,
+		"cpu":    cpu,
+		"memory": mem,
+	}
+	return &run.ResourceRequirements{
+		Requests: requests,
+		Limits:   requests,
 	}
+}
+
+// makeSecretEnvVar reads the env var from the latest version of the secret in Secret Manager
+func makeSecretEnvVar(name, secret string) *run.EnvVar {
+	return &run.EnvVar{
+		Name: name,
+		ValueFrom: &run.EnvVarSource{
+			SecretKeyRef: &run.SecretKeySelector{
+				Name: secret,
+				Key:  "latest",
+			},
+		},
+	}
+}
+
+// makeRunService makes a service always running one instance. The cpu is always allocated as the
+// engines and the coordinators keep working between the requests.
+func (cr *CloudRun) makeRunService(name string, labels map[string]string, container *run.Container, volumes []*run.Volume) *run.Service {
 	return &run.Service{
 		ApiVersion: "serving.knative.dev/v1",
 		Kind:       "Service",
 		Metadata: &run.ObjectMeta{
-			Name:      cr.MakeName(projectID, collectionID, planID, engineID),
+			Name:      name,
 			Namespace: cr.projectID,
-			Labels:    m,
+			Labels:    labels,
 			Annotations: map[string]string{
 				"run.googleapis.com/launch-stage": "BETA",
 			},
@@ -90,31 +157,62 @@ func (cr *CloudRun) makeService(projectID, collectionID, planID int64, engineID
 			Template: &run.RevisionTemplate{
 				Metadata: &run.ObjectMeta{
 					Annotations: map[string]string{
-						"autoscaling.knative.dev/maxScale": "1",
-						"autoscaling.knative.dev/minScale": "1",
+						"autoscaling.knative.dev/maxScale":  "1",
+						"autoscaling.knative.dev/minScale":  "1",
+						"run.googleapis.com/cpu-throttling": "false",
 					},
 				},
 				Spec: &run.RevisionSpec{
-					Containers: []*run.Container{
-						{
-							Image: ec.Image,
-							Ports: []*run.ContainerPort{
-								{
-									ContainerPort: 8080,
-								},
-							},
-							Resources: &run.ResourceRequirements{
-								Requests: requests,
-								Limits:   requests,
-							},
-						},
-					},
+					ServiceAccountName: cr.serviceAccount(),
+					TimeoutSeconds:     requestTimeout,
+					Containers:         []*run.Container{container},
+					Volumes:            volumes,
 				},
 			},
 		},
 	}
 }
 
+func (cr *CloudRun) makeService(projectID, collectionID, planID int64, engineID, replicas int, serviceIP string, ec *config.ExecutorContainer) *run.Service {
+	m := cr.makeLabels(projectID, collectionID, planID, engineID, replicas)
+	name := cr.MakeName(projectID, collectionID, planID, engineID)
+	container := &run.Container{
+		Image: ec.Image,
+		Ports: []*run.ContainerPort{
+			{
+				ContainerPort: 8080,
+			},
+		},
+		Resources: makeResources(ec.CPU, ec.Mem),
+		Env: []*run.EnvVar{
+			{Name: "collection_id", Value: strconv.FormatInt(collectionID, 10)},
+			{Name: "plan_id", Value: strconv.FormatInt(planID, 10)},
+			{Name: "coordinator_ip", Value: serviceIP},
+			{Name: "engine_name", Value: name},
+			// cloud run only routes http to the services, so the engines cannot reach the pubsub port
+			// of the coordinator
+			{Name: "push_messages", Value: "true"},
+			makeSecretEnvVar("api_key", makeAPIKeySecretName(projectID)),
+		},
+	}
+	// cloud run does not allow the same env twice, and the env set by shibuya cannot be replaced
+	set := make(map[string]struct{})
+	for _, e := range container.Env {
+		set[e.Name] = struct{}{}
+	}
+	keys := make([]string, 0, len(ec.Env))
+	for k := range ec.Env {
+		if _, ok := set[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		container.Env = append(container.Env, &run.EnvVar{Name: k, Value: ec.Env[k]})
+	}
+	return cr.makeRunService(name, m, container, nil)
+}
+
 func (cr *CloudRun) startWriteRequestWorker() {
 	counter := 0
 	quota := 150
@@ -128,7 +226,7 @@ func (cr *CloudRun) startWriteRequestWorker() {
 			cr.deleteService(item.serviceID)
 			counter += 1
 		case "create":
-			if err := cr.sendCreateServiceReq(item.projectID, item.collectionID, item.planID, item.engineID, item.executorConfig); err != nil {
+			if err := cr.sendCreateServiceReq(item.service, true); err != nil {
 				log.Print(err)
 			}
 			// For each create request, we actually have two operations against the api.
@@ -138,21 +236,21 @@ func (cr *CloudRun) startWriteRequestWorker() {
 }
 
 type cloudRunRequest struct {
-	method         string
-	projectID      int64
-	collectionID   int64
-	planID         int64
-	engineID       int
-	serviceID      string
-	executorConfig *config.ExecutorContainer
+	method    string
+	serviceID string
+	service   *run.Service
 }
 
-func (cr *CloudRun) sendCreateServiceReq(projectID, collectionID, planID int64, engineID int, executorConfig *config.ExecutorContainer) error {
-	svc := cr.makeService(projectID, collectionID, planID, engineID, executorConfig)
+// sendCreateServiceReq creates the service. The public services can be invoked by all users, as they
+// are protected by the api key of the project.
+func (cr *CloudRun) sendCreateServiceReq(svc *run.Service, public bool) error {
 	_, err := cr.rs.Namespaces.Services.Create(cr.nsProjectID, svc).Do()
 	if err != nil {
 		return err
 	}
+	if !public {
+		return nil
+	}
 	// This is required by cloud run as we need to allow our engines to be triggered by all users
 	// https://cloud.google.com/run/docs/reference/rest/v1/projects.locations.services/setIamPolicy
 	policy := &run.Policy{
@@ -174,26 +272,35 @@ func (cr *CloudRun) sendCreateServiceReq(projectID, collectionID, planID int64,
 	return nil
 }
 
-func (cr *CloudRun) DeployEngine(projectID, collectionID, planID int64, engineID int, containerConfig *config.ExecutorContainer) error {
-	item := &cloudRunRequest{
-		method:         "create",
-		projectID:      projectID,
-		collectionID:   collectionID,
-		planID:         planID,
-		engineID:       engineID,
-		executorConfig: containerConfig,
+func (cr *CloudRun) DeployPlan(projectID, collectionID, planID int64, replicas int, serviceIP string, containerConfig *config.ExecutorContainer) error {
+	items, err := cr.getEnginesByCollectionPlan(collectionID, planID)
+	if err != nil {
+		return err
+	}
+	deployed := make(map[string]struct{})
+	for _, item := range items {
+		deployed[item.Metadata.Name] = struct{}{}
+	}
+	for i := 0; i < replicas; i++ {
+		svc := cr.makeService(projectID, collectionID, planID, i, replicas, serviceIP, containerConfig)
+		// the deployment is retried when any of the engines fails
+		if _, ok := deployed[svc.Metadata.Name]; ok {
+			continue
+		}
+		cr.throttlingQueue <- &cloudRunRequest{
+			method:  "create",
+			service: svc,
+		}
 	}
-	cr.throttlingQueue <- item
 	return nil
 }
 
-func (cr *CloudRun) DeployPlan(projectID, collectionID, planID int64, replicas int, serviceIP string, containerConfig *config.ExecutorContainer) error {
-	return nil
+func (cr *CloudRun) makeServiceName(serviceID string) string {
+	return fmt.Sprintf("%s/services/%s", cr.nsProjectID, serviceID)
 }
 
 func (cr *CloudRun) deleteService(serviceID string) error {
-	name := fmt.Sprintf("%s/services/%s", cr.nsProjectID, serviceID)
-	if _, err := cr.rs.Namespaces.Services.Delete(name).Do(); err != nil {
+	if _, err := cr.rs.Namespaces.Services.Delete(cr.makeServiceName(serviceID)).Do(); err != nil {
 		log.Print(err)
 		return err
 	}
@@ -201,7 +308,8 @@ func (cr *CloudRun) deleteService(serviceID string) error {
 }
 
 func (cr *CloudRun) PurgeCollection(collectionID int64) error {
-	items, err := cr.getEnginesByCollection(collectionID)
+	// the scraper is purged together with the engines
+	items, err := cr.listServices(fmt.Sprintf("collection=%d", collectionID))
 	if err != nil {
 		return err
 	}
@@ -211,45 +319,55 @@ func (cr *CloudRun) PurgeCollection(collectionID int64) error {
 			serviceID: item.Metadata.Name,
 		}
 	}
-	return nil
+	// The engines are removed from the inventory of the coordinator by the next status check of the project
+	return cr.deleteSecret(makeScraperName(collectionID))
 }
 
-func (cr *CloudRun) getEnginesByCollection(collectionID int64) ([]*run.Service, error) {
-	label := makeCollectionLabel(collectionID)
-	resp, err := cr.rs.Namespaces.Services.List(cr.nsProjectID).LabelSelector(label).Do()
+func (cr *CloudRun) listServices(labelSelector string) ([]*run.Service, error) {
+	resp, err := cr.rs.Namespaces.Services.List(cr.nsProjectID).LabelSelector(labelSelector).Do()
 	if err != nil {
 		return []*run.Service{}, err
 	}
+	sort.Slice(resp.Items, func(i, j int) bool {
+		return resp.Items[i].Metadata.Name < resp.Items[j].Metadata.Name
+	})
 	return resp.Items, nil
 }
 
+func (cr *CloudRun) getEnginesByCollection(collectionID int64) ([]*run.Service, error) {
+	return cr.listServices(makeCollectionLabel(collectionID))
+}
+
 func (cr *CloudRun) getEnginesByCollectionPlan(collectionID, planID int64) ([]*run.Service, error) {
-	label := fmt.Sprintf("collection=%d, plan=%d", collectionID, planID)
-	resp, err := cr.rs.Namespaces.Services.List(cr.nsProjectID).LabelSelector(label).Do()
-	if err != nil {
-		return []*run.Service{}, err
-	}
-	return resp.Items, nil
+	label := fmt.Sprintf("%s,plan=%d", makeCollectionLabel(collectionID), planID)
+	return cr.listServices(label)
 }
 
-func (cr *CloudRun) getReadyEnginesByCollection(collectionID int64) ([]*run.Service, error) {
-	items, err := cr.getEnginesByCollection(collectionID)
-	if err != nil {
-		return items, err
+func isReady(svc *run.Service) bool {
+	if svc.Status == nil || len(svc.Status.Conditions) == 0 {
+		return false
 	}
-	r := []*run.Service{}
-	for _, item := range items {
-		ready := true
-		for _, c := range item.Status.Conditions {
-			if c.Status != "True" {
-				ready = false
-			}
-		}
-		if ready {
-			r = append(r, item)
+	for _, c := range svc.Status.Conditions {
+		if c.Status != "True" {
+			return false
 		}
 	}
-	return r, nil
+	return true
+}
+
+func isNotFound(err error) bool {
+	apiErr, ok := err.(*googleapi.Error)
+	return ok && apiErr.Code == http.StatusNotFound
+}
+
+func isConflict(err error) bool {
+	apiErr, ok := err.(*googleapi.Error)
+	return ok && apiErr.Code == http.StatusConflict
+}
+
+func creationTime(svc *run.Service) time.Time {
+	t, _ := time.Parse(time.RFC3339, svc.Metadata.CreationTimestamp)
+	return t
 }
 
 func (cr *CloudRun) CollectionStatus(projectID, collectionID int64, eps []*model.ExecutionPlan) (*smodel.CollectionStatus, error) {
@@ -258,148 +376,241 @@ func (cr *CloudRun) CollectionStatus(projectID, collectionID int64, eps []*model
 		return nil, err
 	}
 	cs := &smodel.CollectionStatus{}
+	scraper, err := cr.rs.Namespaces.Services.Get(cr.makeServiceName(makeScraperName(collectionID))).Do()
+	if err == nil {
+		cs.ScraperDeployed = isReady(scraper)
+	}
 	planStatuses := make(map[int64]*smodel.PlanStatus)
-
-	// The reason we need this is we want to show users the progress of deployment
-	// Usually the engines deployment is quick but network access might be slow.
-	// So users should be able to see their engines deployed and later become reachable
-	planReachable := make(map[int64]int)
 	for _, ep := range eps {
 		ps := &smodel.PlanStatus{
 			PlanID:  ep.PlanID,
 			Engines: ep.Engines,
 		}
 		planStatuses[ep.PlanID] = ps
+		cs.Plans = append(cs.Plans, ps)
 	}
 	for _, item := range items {
 		planID, err := strconv.Atoi(item.Metadata.Labels["plan"])
 		if err != nil {
 			log.Error(err)
 		}
-		pid := int64(planID)
-		ps, ok := planStatuses[pid]
+		ps, ok := planStatuses[int64(planID)]
 		if !ok {
 			log.Error("Could not find running pod in ExecutionPlan")
 			continue
 		}
-		ps.EnginesDeployed += 1
-		ready := true
-		for _, c := range item.Status.Conditions {
-			if c.Status != "True" {
-				ready = false
-			}
+		// The engines are only counted when they are reachable by their urls
+		if isReady(item) {
+			ps.EnginesDeployed += 1
+		}
+	}
+	// cloud run does not tell the coordinator about the engines, so we keep its inventory up to date
+	if err := cr.updateInventory(projectID); err != nil {
+		log.Debug(err)
+	}
+	return cs, nil
+}
+
+// updateInventory sends the engines of the project to the coordinator. The engines of a collection are
+// only sent when all of them are ready.
+func (cr *CloudRun) updateInventory(projectID int64) error {
+	coordinator, err := cr.getCoordinator(projectID)
+	if err != nil {
+		return err
+	}
+	if !isReady(coordinator) {
+		return fmt.Errorf("coordinator of project %d is not ready", projectID)
+	}
+	items, err := cr.listServices(fmt.Sprintf("project=%d,kind=%s", projectID, smodel.Executor))
+	if err != nil {
+		return err
+	}
+	notReady := make(map[string]bool)
+	ready := make(map[string]int)
+	for _, item := range items {
+		labels := item.Metadata.Labels
+		if !isReady(item) {
+			notReady[labels["collection"]] = true
+			continue
 		}
-		if ready {
-			planReachable[pid] += 1
+		ready[labels["plan"]]++
+	}
+	for _, item := range items {
+		labels := item.Metadata.Labels
+		if expected, _ := strconv.Atoi(labels[enginesLabel]); ready[labels["plan"]] < expected {
+			notReady[labels["collection"]] = true
 		}
 	}
-	for planID, ps := range planStatuses {
-		reachableEngines, _ := planReachable[planID]
-		ps.EnginesReachable = reachableEngines == ps.Engines
-		// we only check if the plan is in progress if the engines are reachable
-		if ps.EnginesReachable {
-			rp, err := model.GetRunningPlan(collectionID, planID)
-			if err == nil {
-				ps.StartedTime = rp.StartedTime
-				ps.InProgress = true
-			}
+	endpoints := []upstream.FileEndpoint{}
+	for _, item := range items {
+		labels := item.Metadata.Labels
+		if notReady[labels["collection"]] {
+			continue
 		}
-		cs.Plans = append(cs.Plans, ps)
+		endpoints = append(endpoints, upstream.FileEndpoint{
+			CollectionID: labels["collection"],
+			PlanID:       labels["plan"],
+			Engine:       item.Metadata.Name,
+			Addr:         item.Status.Url,
+		})
 	}
-	return cs, nil
+	body, err := json.Marshal(endpoints)
+	if err != nil {
+		return err
+	}
+	apiKey, err := cr.GetProjectAPIKey(projectID)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("PUT", fmt.Sprintf("%s/api/inventory", coordinator.Status.Url), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(httptoken.AuthHeader, fmt.Sprintf("%s %s", httptoken.BEARER_PREFIX, apiKey))
+	resp, err := cr.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("coordinator of project %d returns %d for the inventory", projectID, resp.StatusCode)
+	}
+	return nil
 }
 
 // This func is used by generateEngines as we need to fetch the engine urls per plan
 func (cr *CloudRun) FetchEngineUrlsByPlan(collectionID, planID int64, opts *smodel.EngineOwnerRef) ([]string, error) {
-	// need to make it get url by plan
-	items, err := cr.getEnginesByCollectionPlan(collectionID, planID)
+	// the engines are reached through the coordinator, like in k8s
+	collectionUrl, err := cr.GetIngressUrl(opts.ProjectID)
 	if err != nil {
 		return nil, err
 	}
-	m := []string{}
-	for _, item := range items {
-		m = append(m, item.Status.Url)
+	urls := []string{}
+	for i := 0; i < opts.EnginesCount; i++ {
+		urls = append(urls, fmt.Sprintf("%s/%s", collectionUrl, cr.MakeName(opts.ProjectID, collectionID, planID, i)))
 	}
-	return m, nil
+	return urls, nil
 }
 
 func (cr *CloudRun) GetDeployedCollections() (map[int64]time.Time, error) {
 	deployCollections := make(map[int64]time.Time)
-	resp, err := cr.rs.Namespaces.Services.List(cr.nsProjectID).Do()
+	items, err := cr.listServices(fmt.Sprintf("kind=%s", smodel.Executor))
 	if err != nil {
 		return deployCollections, err
 	}
-	for _, pod := range resp.Items {
-		collectionID, err := strconv.ParseInt(pod.Metadata.Labels["collection"], 10, 64)
+	for _, item := range items {
+		collectionID, err := strconv.ParseInt(item.Metadata.Labels["collection"], 10, 64)
 		if err != nil {
 			return nil, err
 		}
-		t, _ := time.Parse(time.RFC3339, pod.Metadata.CreationTimestamp)
-		deployCollections[collectionID] = t
+		deployCollections[collectionID] = creationTime(item)
 	}
 	return deployCollections, nil
 }
 
-// TODO: what we need is actually get the deployed engines account, not only ready ones.
-// We also need to change this in k8s.go
 func (cr *CloudRun) PodReadyCount(collectionID int64) int {
 	items, err := cr.getEnginesByCollection(collectionID)
 	if err != nil {
 		return 0
 	}
-	return len(items)
+	ready := 0
+	for _, item := range items {
+		if isReady(item) {
+			ready++
+		}
+	}
+	return ready
 }
 
 func (cr *CloudRun) GetCollectionEnginesDetail(projectID, collectionID int64) (*smodel.CollectionDetails, error) {
-	return nil, nil
-}
-
-func (cr *CloudRun) ExposeProject(projectID int64) (*apiv1.Service, error) {
-	return nil, nil
-}
-
-func (cr *CloudRun) PurgeProjectIngress(projectID int64) error {
-	return nil
-}
-
-func (cr *CloudRun) GetDeployedServices() (map[int64]time.Time, error) {
-	return nil, nil
+	items, err := cr.getEnginesByCollection(collectionID)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, &serrors.NoResourcesFoundErr{Err: err, Message: "Cannot find the engines"}
+	}
+	collectionDetails := new(smodel.CollectionDetails)
+	ingressUrl, err := cr.GetIngressUrl(projectID)
+	if err != nil {
+		collectionDetails.IngressIP = err.Error()
+	} else {
+		collectionDetails.IngressIP = ingressUrl
+	}
+	engines := []*smodel.EngineStatus{}
+	for _, item := range items {
+		status := "Deploying"
+		if isReady(item) {
+			status = "Ready"
+		}
+		engines = append(engines, &smodel.EngineStatus{
+			Name:        item.Metadata.Name,
+			CreatedTime: creationTime(item),
+			Status:      status,
+		})
+	}
+	collectionDetails.Engines = engines
+	collectionDetails.ControllerReplicas = 1
+	return collectionDetails, nil
 }
 
 func (cr *CloudRun) GetEnginesByProject(projectID int64) ([]apiv1.Pod, error) {
-	return nil, nil
-}
-
-func (cr *CloudRun) CreateCollectionScraper(apiToken, token string, collectionID int64) error {
-	return nil
+	items, err := cr.listServices(fmt.Sprintf("project=%d,kind=%s", projectID, smodel.Executor))
+	if err != nil {
+		return nil, err
+	}
+	pods := make([]apiv1.Pod, len(items))
+	for i, item := range items {
+		pods[i] = apiv1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              item.Metadata.Name,
+				Labels:            item.Metadata.Labels,
+				CreationTimestamp: metav1.NewTime(creationTime(item)),
+			},
+		}
+	}
+	sort.Slice(pods, func(i, j int) bool {
+		return pods[i].CreationTimestamp.Time.After(pods[j].CreationTimestamp.Time)
+	})
+	return pods, nil
 }
 
 func (cr *CloudRun) DownloadPodLog(collectionID, planID int64) (string, error) {
-	// Cloud run API does not support fetching the logs now.
 	engines, err := cr.getEnginesByCollectionPlan(collectionID, planID)
 	if err != nil {
 		return "", err
 	}
-	e := engines[0]
-	engineUrl := e.Status.Url
-	logUrl := fmt.Sprintf("%s/output", engineUrl)
-	resp, err := cr.httpClient.Get(logUrl)
+	if len(engines) == 0 {
+		return "", fmt.Errorf("Cannot find engine for the plan %d", planID)
+	}
+	// The logs of the containers are only kept by Cloud Logging
+	filter := fmt.Sprintf(`resource.type="cloud_run_revision" AND resource.labels.service_name="%s"`,
+		engines[0].Metadata.Name)
+	req := &logging.ListLogEntriesRequest{
+		ResourceNames: []string{fmt.Sprintf("projects/%s", cr.projectID)},
+		Filter:        filter,
+		OrderBy:       "timestamp asc",
+		PageSize:      1000,
+	}
+	lines := []string{}
+	err = cr.ls.Entries.List(req).Pages(context.TODO(), func(resp *logging.ListLogEntriesResponse) error {
+		for _, entry := range resp.Entries {
+			lines = append(lines, entry.TextPayload)
+		}
+		return nil
+	})
 	if err != nil {
 		return "", err
 	}
-	defer resp.Body.Close()
-	r, _ := ioutil.ReadAll(resp.Body)
-	return string(r), nil
-}
-
-func (cr *CloudRun) GetIngressUrl(projectID int64) (string, error) {
-	return "", nil
+	return strings.Join(lines, "\n"), nil
 }
 
-func (cr *CloudRun) GetProjectAPIKey(projectID int64) (string, error) {
-	return "", nil
+// GetCapacity is not limited as Cloud Run is only limited by the quotas of the project
+func (cr *CloudRun) GetCapacity() (*smodel.ClusterCapacity, error) {
+	return nil, nil
 }
 
 func makeCollectionLabel(collectionID int64) string {
-	return fmt.Sprintf("collection=%d", collectionID)
+	return fmt.Sprintf("collection=%d,kind=%s", collectionID, smodel.Executor)
 }
@@ -0,0 +1,349 @@
+package cloudrun
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rakutentech/shibuya/shibuya/config"
+	"github.com/rakutentech/shibuya/shibuya/coordinator/upstream"
+	"github.com/rakutentech/shibuya/shibuya/model"
+	smodel "github.com/rakutentech/shibuya/shibuya/scheduler/model"
+	"github.com/stretchr/testify/assert"
+
+	logging "google.golang.org/api/logging/v2"
+	"google.golang.org/api/option"
+	"google.golang.org/api/run/v1"
+	secretmanager "google.golang.org/api/secretmanager/v1"
+)
+
+// fakeAPI serves the part of the Cloud Run, Secret Manager and Logging APIs used by the scheduler.
+// It also plays the coordinators receiving the inventories.
+type fakeAPI struct {
+	mu          sync.Mutex
+	url         string
+	services    map[string]*run.Service
+	public      map[string]bool
+	secrets     map[string][]string
+	inventories map[string][]upstream.FileEndpoint
+}
+
+func newFakeAPI(t *testing.T) (*fakeAPI, *httptest.Server) {
+	api := &fakeAPI{
+		services:    make(map[string]*run.Service),
+		public:      make(map[string]bool),
+		secrets:     make(map[string][]string),
+		inventories: make(map[string][]upstream.FileEndpoint),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /apis/serving.knative.dev/v1/namespaces/{ns}/services", api.createService)
+	mux.HandleFunc("GET /apis/serving.knative.dev/v1/namespaces/{ns}/services", api.listServices)
+	mux.HandleFunc("GET /apis/serving.knative.dev/v1/namespaces/{ns}/services/{name}", api.getService)
+	mux.HandleFunc("DELETE /apis/serving.knative.dev/v1/namespaces/{ns}/services/{name}", api.deleteService)
+	mux.HandleFunc("POST /v1/projects/{project}/locations/{region}/services/{resource}", api.setIamPolicy)
+	mux.HandleFunc("POST /v1/projects/{project}/secrets", api.createSecret)
+	mux.HandleFunc("POST /v1/projects/{project}/secrets/{resource}", api.addVersion)
+	mux.HandleFunc("GET /v1/projects/{project}/secrets/{name}/versions/{resource}", api.accessSecret)
+	mux.HandleFunc("DELETE /v1/projects/{project}/secrets/{name}", api.deleteSecret)
+	mux.HandleFunc("POST /v2/entries:list", api.listEntries)
+	mux.HandleFunc("PUT /{name}/api/inventory", api.putInventory)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	api.url = server.URL
+	return api, server
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]map[string]any{"error": {"code": status, "message": message}})
+}
+
+func (api *fakeAPI) createService(w http.ResponseWriter, r *http.Request) {
+	svc := new(run.Service)
+	if err := json.NewDecoder(r.Body).Decode(svc); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	name := svc.Metadata.Name
+	if _, ok := api.services[name]; ok {
+		writeError(w, http.StatusConflict, "Resource already exists")
+		return
+	}
+	// the services are ready as soon as they are created
+	svc.Metadata.CreationTimestamp = time.Now().Format(time.RFC3339)
+	svc.Status = &run.ServiceStatus{
+		Url:        api.url + "/" + name,
+		Conditions: []*run.GoogleCloudRunV1Condition{{Type: "Ready", Status: "True"}},
+	}
+	api.services[name] = svc
+	json.NewEncoder(w).Encode(svc)
+}
+
+func matchLabels(labels map[string]string, selector string) bool {
+	for _, term := range strings.Split(selector, ",") {
+		kv := strings.SplitN(term, "=", 2)
+		if labels[kv[0]] != kv[1] {
+			return false
+		}
+	}
+	return true
+}
+
+func (api *fakeAPI) listServices(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	items := []*run.Service{}
+	for _, svc := range api.services {
+		if matchLabels(svc.Metadata.Labels, r.URL.Query().Get("labelSelector")) {
+			items = append(items, svc)
+		}
+	}
+	json.NewEncoder(w).Encode(&run.ListServicesResponse{Items: items})
+}
+
+func (api *fakeAPI) getService(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	svc, ok := api.services[r.PathValue("name")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Resource not found")
+		return
+	}
+	json.NewEncoder(w).Encode(svc)
+}
+
+func (api *fakeAPI) deleteService(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	name := r.PathValue("name")
+	if _, ok := api.services[name]; !ok {
+		writeError(w, http.StatusNotFound, "Resource not found")
+		return
+	}
+	delete(api.services, name)
+	json.NewEncoder(w).Encode(&run.Status{})
+}
+
+func (api *fakeAPI) setIamPolicy(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.public[strings.TrimSuffix(r.PathValue("resource"), ":setIamPolicy")] = true
+	json.NewEncoder(w).Encode(&run.Policy{})
+}
+
+func (api *fakeAPI) createSecret(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	name := r.URL.Query().Get("secretId")
+	if _, ok := api.secrets[name]; ok {
+		writeError(w, http.StatusConflict, "Secret already exists")
+		return
+	}
+	api.secrets[name] = []string{}
+	json.NewEncoder(w).Encode(&secretmanager.Secret{Name: name})
+}
+
+func (api *fakeAPI) addVersion(w http.ResponseWriter, r *http.Request) {
+	req := new(secretmanager.AddSecretVersionRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	name := strings.TrimSuffix(r.PathValue("resource"), ":addVersion")
+	if _, ok := api.secrets[name]; !ok {
+		writeError(w, http.StatusNotFound, "Secret not found")
+		return
+	}
+	api.secrets[name] = append(api.secrets[name], req.Payload.Data)
+	json.NewEncoder(w).Encode(&secretmanager.SecretVersion{})
+}
+
+func (api *fakeAPI) accessSecret(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	versions := api.secrets[r.PathValue("name")]
+	if r.PathValue("resource") != "latest:access" || len(versions) == 0 {
+		writeError(w, http.StatusNotFound, "Secret version not found")
+		return
+	}
+	json.NewEncoder(w).Encode(&secretmanager.AccessSecretVersionResponse{
+		Payload: &secretmanager.SecretPayload{Data: versions[len(versions)-1]},
+	})
+}
+
+func (api *fakeAPI) deleteSecret(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	name := r.PathValue("name")
+	if _, ok := api.secrets[name]; !ok {
+		writeError(w, http.StatusNotFound, "Secret not found")
+		return
+	}
+	delete(api.secrets, name)
+	json.NewEncoder(w).Encode(&secretmanager.Empty{})
+}
+
+func (api *fakeAPI) listEntries(w http.ResponseWriter, r *http.Request) {
+	req := new(logging.ListLogEntriesRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil || !strings.Contains(req.Filter, "engine-1-2-3-0") {
+		writeError(w, http.StatusBadRequest, "unexpected filter")
+		return
+	}
+	json.NewEncoder(w).Encode(&logging.ListLogEntriesResponse{
+		Entries: []*logging.LogEntry{{TextPayload: "summary ="}, {TextPayload: "Tidying up"}},
+	})
+}
+
+func (api *fakeAPI) putInventory(w http.ResponseWriter, r *http.Request) {
+	var endpoints []upstream.FileEndpoint
+	if err := json.NewDecoder(r.Body).Decode(&endpoints); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	versions := api.secrets[makeAPIKeySecretName(1)]
+	if len(versions) == 0 {
+		writeError(w, http.StatusUnauthorized, "no key")
+		return
+	}
+	key, _ := base64.StdEncoding.DecodeString(versions[len(versions)-1])
+	if r.Header.Get("Authorization") != "Bearer "+string(key) {
+		writeError(w, http.StatusUnauthorized, "wrong key")
+		return
+	}
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].Engine < endpoints[j].Engine
+	})
+	api.inventories[r.PathValue("name")] = endpoints
+}
+
+func (api *fakeAPI) service(name string) *run.Service {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	return api.services[name]
+}
+
+func (api *fakeAPI) count() int {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	return len(api.services)
+}
+
+func newTestCloudRun(t *testing.T, endpoint string) *CloudRun {
+	ctx := context.Background()
+	opts := []option.ClientOption{option.WithEndpoint(endpoint + "/"), option.WithoutAuthentication()}
+	rs, err := run.NewService(ctx, opts...)
+	assert.Nil(t, err)
+	sm, err := secretmanager.NewService(ctx, opts...)
+	assert.Nil(t, err)
+	ls, err := logging.NewService(ctx, opts...)
+	assert.Nil(t, err)
+	sc := config.ShibuyaConfig{
+		IngressConfig: &config.IngressConfig{Image: "shibuya:coordinator", CPU: "1", Mem: "512Mi"},
+		ExecutorConfig: &config.ExecutorConfig{
+			Cluster:  &config.ClusterConfig{Project: "shibuya", Region: "asia-northeast1"},
+			CloudRun: &config.CloudRunConfig{ServiceAccount: "engines@shibuya.iam.gserviceaccount.com"},
+		},
+	}
+	cr := newCloudRun(sc, rs, sm, ls)
+	go cr.startWriteRequestWorker()
+	t.Cleanup(func() { close(cr.throttlingQueue) })
+	return cr
+}
+
+func TestCloudRun(t *testing.T) {
+	api, server := newFakeAPI(t)
+	cr := newTestCloudRun(t, server.URL)
+
+	service, err := cr.ExposeProject(1)
+	assert.Nil(t, err)
+	assert.Equal(t, "ig-1", service.Name)
+	assert.Equal(t, strings.TrimPrefix(server.URL, "http://")+"/ig-1", service.Spec.ClusterIP)
+	cdr := api.service("ig-1")
+	assert.True(t, api.public["ig-1"])
+	assert.Equal(t, "engines@shibuya.iam.gserviceaccount.com", cdr.Spec.Template.Spec.ServiceAccountName)
+	env := make(map[string]*run.EnvVar)
+	for _, e := range cdr.Spec.Template.Spec.Containers[0].Env {
+		env[e.Name] = e
+	}
+	assert.Equal(t, "false", env["enable_tls"].Value)
+	assert.Equal(t, "ig-1-key", env["api_key"].ValueFrom.SecretKeyRef.Name)
+	ingressUrl, err := cr.GetIngressUrl(1)
+	assert.Nil(t, err)
+	assert.Equal(t, service.Spec.ClusterIP, ingressUrl)
+	apiKey, err := cr.GetProjectAPIKey(1)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, apiKey)
+	// exposing the project again reuses the coordinator and its key
+	_, err = cr.ExposeProject(1)
+	assert.Nil(t, err)
+	sameKey, _ := cr.GetProjectAPIKey(1)
+	assert.Equal(t, apiKey, sameKey)
+	services, err := cr.GetDeployedServices()
+	assert.Nil(t, err)
+	assert.Contains(t, services, int64(1))
+
+	container := &config.ExecutorContainer{Image: "shibuya:jmeter", CPU: "1", Mem: "1Gi"}
+	assert.Nil(t, cr.DeployPlan(1, 2, 3, 2, service.Spec.ClusterIP, container))
+	assert.Eventually(t, func() bool { return api.count() == 3 }, time.Second, 10*time.Millisecond)
+	engine := api.service("engine-1-2-3-0")
+	assert.True(t, api.public["engine-1-2-3-0"])
+	assert.Equal(t, "2", engine.Metadata.Labels[enginesLabel])
+	assert.Equal(t, "1Gi", engine.Spec.Template.Spec.Containers[0].Resources.Limits["memory"])
+	assert.Equal(t, cr.PodReadyCount(2), 2)
+
+	eps := []*model.ExecutionPlan{{PlanID: 3, Engines: 2}}
+	cs, err := cr.CollectionStatus(1, 2, eps)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, cs.Plans[0].EnginesDeployed)
+	// the scraper is only deployed with the database to look up the project of the collection
+	assert.False(t, cs.ScraperDeployed)
+	inventory := api.inventories["ig-1"]
+	assert.Len(t, inventory, 2)
+	assert.Equal(t, upstream.FileEndpoint{CollectionID: "2", PlanID: "3", Engine: "engine-1-2-3-0",
+		Addr: server.URL + "/engine-1-2-3-0"}, inventory[0])
+
+	urls, err := cr.FetchEngineUrlsByPlan(2, 3, &smodel.EngineOwnerRef{ProjectID: 1, EnginesCount: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{ingressUrl + "/engine-1-2-3-0", ingressUrl + "/engine-1-2-3-1"}, urls)
+	collections, err := cr.GetDeployedCollections()
+	assert.Nil(t, err)
+	assert.Len(t, collections, 1)
+	detail, err := cr.GetCollectionEnginesDetail(1, 2)
+	assert.Nil(t, err)
+	assert.Len(t, detail.Engines, 2)
+	assert.Equal(t, "Ready", detail.Engines[0].Status)
+	pods, err := cr.GetEnginesByProject(1)
+	assert.Nil(t, err)
+	assert.Len(t, pods, 2)
+	logs, err := cr.DownloadPodLog(2, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, "summary =\nTidying up", logs)
+
+	assert.Nil(t, cr.PurgeCollection(2))
+	assert.Eventually(t, func() bool { return api.count() == 1 }, time.Second, 10*time.Millisecond)
+	_, err = cr.GetCollectionEnginesDetail(1, 2)
+	assert.NotNil(t, err)
+	// the purged engines are removed from the inventory by the next status check
+	_, err = cr.CollectionStatus(1, 2, eps)
+	assert.Nil(t, err)
+	assert.Empty(t, api.inventories["ig-1"])
+
+	assert.Nil(t, cr.PurgeProjectIngress(1))
+	assert.Equal(t, 0, api.count())
+	assert.Empty(t, api.secrets)
+	_, err = cr.GetIngressUrl(1)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,98 @@
+package cloudrun
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/rakutentech/shibuya/shibuya/engines/metrics"
+	model "github.com/rakutentech/shibuya/shibuya/model"
+	smodel "github.com/rakutentech/shibuya/shibuya/scheduler/model"
+	"gopkg.in/yaml.v2"
+
+	"google.golang.org/api/run/v1"
+)
+
+func makeScraperName(collectionID int64) string {
+	return fmt.Sprintf("prom-collection-%d", collectionID)
+}
+
+func makeScraperLabels(collectionID int64) map[string]string {
+	return map[string]string{
+		"kind":       smodel.Scraper,
+		"collection": strconv.FormatInt(collectionID, 10),
+	}
+}
+
+// makeScraperService mounts the prometheus config from the secret of the same name
+func (cr *CloudRun) makeScraperService(collectionID int64) *run.Service {
+	scraperContainer := cr.sc.ScraperContainer
+	name := makeScraperName(collectionID)
+	container := &run.Container{
+		Image: scraperContainer.Image,
+		Args: []string{
+			"--web.listen-address=:9090",
+			"--config.file=/etc/prometheus/prometheus.yml",
+		},
+		Ports: []*run.ContainerPort{
+			{
+				ContainerPort: 9090,
+			},
+		},
+		Resources: makeResources(scraperContainer.CPU, scraperContainer.Mem),
+		VolumeMounts: []*run.VolumeMount{
+			{
+				Name:      "prom-config",
+				MountPath: "/etc/prometheus",
+			},
+		},
+	}
+	volumes := []*run.Volume{
+		{
+			Name: "prom-config",
+			Secret: &run.SecretVolumeSource{
+				SecretName: name,
+				Items: []*run.KeyToPath{
+					{
+						Key:  "latest",
+						Path: "prometheus.yml",
+					},
+				},
+			},
+		},
+	}
+	return cr.makeRunService(name, makeScraperLabels(collectionID), container, volumes)
+}
+
+// CreateCollectionScraper deploys the scraper of the collection. As the engines cannot be discovered
+// by the scraper on cloud run, the scraper gets them from the inventory of the coordinator.
+func (cr *CloudRun) CreateCollectionScraper(apiToken, token string, collectionID int64) error {
+	collection, err := model.GetCollection(collectionID)
+	if err != nil {
+		return err
+	}
+	coordinator, err := cr.getCoordinator(collection.ProjectID)
+	if err != nil {
+		return err
+	}
+	targetsUrl := fmt.Sprintf("%s/api/collections/%d/targets", coordinator.Status.Url, collectionID)
+	pc, err := metrics.MakeHTTPSDScraperConfig(apiToken, token, collectionID, targetsUrl, "https",
+		cr.sc.Context, cr.sc.MetricStorage)
+	if err != nil {
+		return err
+	}
+	c, err := yaml.Marshal(pc)
+	if err != nil {
+		return err
+	}
+	if err := cr.createSecret(makeScraperName(collectionID), c); err != nil {
+		return err
+	}
+	if _, err := cr.rs.Namespaces.Services.Get(cr.makeServiceName(makeScraperName(collectionID))).Do(); err == nil {
+		return nil
+	}
+	// the scraper does not need to be reached by anyone
+	if err := cr.sendCreateServiceReq(cr.makeScraperService(collectionID), false); err != nil && !isConflict(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package cloudrun
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rakutentech/shibuya/shibuya/auth/keys"
+	serrors "github.com/rakutentech/shibuya/shibuya/scheduler/errors"
+	smodel "github.com/rakutentech/shibuya/shibuya/scheduler/model"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"google.golang.org/api/run/v1"
+	secretmanager "google.golang.org/api/secretmanager/v1"
+)
+
+const (
+	// cloud run terminates the tls in front of the coordinator
+	coordinatorPort = 8080
+	inventoryFile   = "/tmp/inventory.json"
+)
+
+// coordinatorURLTimeout is how long we wait for cloud run to assign the url to the coordinator
+var coordinatorURLTimeout = 30 * time.Second
+
+func makeCoordinatorName(projectID int64) string {
+	return fmt.Sprintf("ig-%d", projectID)
+}
+
+func makeAPIKeySecretName(projectID int64) string {
+	return fmt.Sprintf("%s-key", makeCoordinatorName(projectID))
+}
+
+func makeCoordinatorLabels(projectID int64) map[string]string {
+	return map[string]string{
+		"kind":    smodel.IngressController,
+		"project": strconv.FormatInt(projectID, 10),
+	}
+}
+
+func (cr *CloudRun) makeCoordinatorService(projectID int64) *run.Service {
+	igCfg := cr.sc.IngressConfig
+	container := &run.Container{
+		Image: igCfg.Image,
+		Ports: []*run.ContainerPort{
+			{
+				ContainerPort: coordinatorPort,
+			},
+		},
+		Resources: makeResources(igCfg.CPU, igCfg.Mem),
+		Env: []*run.EnvVar{
+			{Name: "project_id", Value: strconv.FormatInt(projectID, 10)},
+			{Name: "listen_addr", Value: fmt.Sprintf(":%d", coordinatorPort)},
+			{Name: "enable_tls", Value: "false"},
+			{Name: "push_messages", Value: "true"},
+			{Name: "inventory_file", Value: inventoryFile},
+			makeSecretEnvVar("api_key", makeAPIKeySecretName(projectID)),
+		},
+	}
+	return cr.makeRunService(makeCoordinatorName(projectID), makeCoordinatorLabels(projectID), container, nil)
+}
+
+func (cr *CloudRun) getCoordinator(projectID int64) (*run.Service, error) {
+	return cr.rs.Namespaces.Services.Get(cr.makeServiceName(makeCoordinatorName(projectID))).Do()
+}
+
+// makeCoordinatorService is what the controller expects from the scheduler. The engines reach the
+// coordinator by its url.
+func makeCoordinatorService(svc *run.Service) *apiv1.Service {
+	return &apiv1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   svc.Metadata.Name,
+			Labels: svc.Metadata.Labels,
+		},
+		Spec: apiv1.ServiceSpec{
+			ClusterIP: trimScheme(svc.Status.Url),
+		},
+	}
+}
+
+func trimScheme(url string) string {
+	if i := strings.Index(url, "://"); i >= 0 {
+		return url[i+3:]
+	}
+	return url
+}
+
+func (cr *CloudRun) ExposeProject(projectID int64) (*apiv1.Service, error) {
+	svc, err := cr.getCoordinator(projectID)
+	if err != nil {
+		if !isNotFound(err) {
+			return nil, err
+		}
+		svc, err = cr.createCoordinator(projectID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	deadline := time.Now().Add(coordinatorURLTimeout)
+	for svc.Status == nil || svc.Status.Url == "" {
+		if time.Now().After(deadline) {
+			return nil, serrors.MakeIPNotAssignedError()
+		}
+		time.Sleep(time.Second)
+		if svc, err = cr.getCoordinator(projectID); err != nil {
+			return nil, err
+		}
+	}
+	return makeCoordinatorService(svc), nil
+}
+
+// createCoordinator bypasses the throttling queue as the controller waits for the coordinator
+func (cr *CloudRun) createCoordinator(projectID int64) (*run.Service, error) {
+	apiKey, err := keys.GenerateAPIKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := cr.createSecret(makeAPIKeySecretName(projectID), []byte(apiKey)); err != nil {
+		return nil, err
+	}
+	if err := cr.sendCreateServiceReq(cr.makeCoordinatorService(projectID), true); err != nil {
+		// the coordinator is being deployed by another collection of the project
+		if !isConflict(err) {
+			return nil, err
+		}
+	}
+	return cr.getCoordinator(projectID)
+}
+
+func (cr *CloudRun) PurgeProjectIngress(projectID int64) error {
+	if err := cr.deleteService(makeCoordinatorName(projectID)); err != nil && !isNotFound(err) {
+		return err
+	}
+	return cr.deleteSecret(makeAPIKeySecretName(projectID))
+}
+
+func (cr *CloudRun) GetDeployedServices() (map[int64]time.Time, error) {
+	items, err := cr.listServices(fmt.Sprintf("kind=%s", smodel.IngressController))
+	if err != nil {
+		return nil, err
+	}
+	deployedServices := make(map[int64]time.Time)
+	for _, item := range items {
+		projectID, err := strconv.ParseInt(item.Metadata.Labels["project"], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		deployedServices[projectID] = creationTime(item)
+	}
+	return deployedServices, nil
+}
+
+func (cr *CloudRun) GetIngressUrl(projectID int64) (string, error) {
+	svc, err := cr.getCoordinator(projectID)
+	if err != nil {
+		return "", serrors.MakeSchedulerIngressError(err)
+	}
+	if svc.Status == nil || svc.Status.Url == "" {
+		return "", serrors.MakeSchedulerIngressError(fmt.Errorf("coordinator of project %d has no url yet", projectID))
+	}
+	return trimScheme(svc.Status.Url), nil
+}
+
+func (cr *CloudRun) GetProjectAPIKey(projectID int64) (string, error) {
+	return cr.accessSecret(makeAPIKeySecretName(projectID))
+}
+
+func (cr *CloudRun) makeSecretName(secretID string) string {
+	return fmt.Sprintf("projects/%s/secrets/%s", cr.projectID, secretID)
+}
+
+// createSecret keeps the data in Secret Manager, which can be mounted by the services. When the secret
+// already exists, the data is added as its latest version.
+func (cr *CloudRun) createSecret(secretID string, data []byte) error {
+	secret := &secretmanager.Secret{
+		Replication: &secretmanager.Replication{
+			Automatic: &secretmanager.Automatic{},
+		},
+	}
+	parent := fmt.Sprintf("projects/%s", cr.projectID)
+	if _, err := cr.sm.Projects.Secrets.Create(parent, secret).SecretId(secretID).Do(); err != nil && !isConflict(err) {
+		return err
+	}
+	req := &secretmanager.AddSecretVersionRequest{
+		Payload: &secretmanager.SecretPayload{
+			Data: base64.StdEncoding.EncodeToString(data),
+		},
+	}
+	_, err := cr.sm.Projects.Secrets.AddVersion(cr.makeSecretName(secretID), req).Do()
+	return err
+}
+
+func (cr *CloudRun) accessSecret(secretID string) (string, error) {
+	resp, err := cr.sm.Projects.Secrets.Versions.Access(cr.makeSecretName(secretID) + "/versions/latest").Do()
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(resp.Payload.Data)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func (cr *CloudRun) deleteSecret(secretID string) error {
+	if _, err := cr.sm.Projects.Secrets.Delete(cr.makeSecretName(secretID)).Do(); err != nil && !isNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,360 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rakutentech/shibuya/shibuya/config"
+)
+
+const (
+	requestTimeout = 30 * time.Second
+	// pullTimeout covers the download of a whole image, which takes minutes for the engine images
+	pullTimeout = 10 * time.Minute
+)
+
+// client talks to the Docker Engine API of one host. Only the parts of the API used by the
+// scheduler are covered.
+type client struct {
+	endpoint   string
+	address    string
+	version    string
+	httpClient *http.Client
+	pullClient *http.Client
+}
+
+type portBinding struct {
+	HostIP   string `json:"HostIp"`
+	HostPort string `json:"HostPort"`
+}
+
+type hostConfig struct {
+	PortBindings map[string][]portBinding `json:"PortBindings,omitempty"`
+	NanoCPUs     int64                    `json:"NanoCpus,omitempty"`
+	Memory       int64                    `json:"Memory,omitempty"`
+}
+
+type containerConfig struct {
+	Image        string              `json:"Image"`
+	Env          []string            `json:"Env,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	HostConfig   *hostConfig         `json:"HostConfig,omitempty"`
+}
+
+type containerPort struct {
+	IP          string `json:"IP"`
+	PrivatePort int    `json:"PrivatePort"`
+	PublicPort  int    `json:"PublicPort"`
+	Type        string `json:"Type"`
+}
+
+// container is an item of the container list
+type container struct {
+	ID      string            `json:"Id"`
+	Names   []string          `json:"Names"`
+	Labels  map[string]string `json:"Labels"`
+	State   string            `json:"State"`
+	Created int64             `json:"Created"`
+	Ports   []containerPort   `json:"Ports"`
+	// client is the host the container runs on
+	client *client
+}
+
+type containerDetail struct {
+	ID     string `json:"Id"`
+	Config struct {
+		Env []string `json:"Env"`
+	} `json:"Config"`
+}
+
+type apiError struct {
+	StatusCode int
+	Message    string `json:"message"`
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("docker api returns %d: %s", e.StatusCode, e.Message)
+}
+
+func isConflict(err error) bool {
+	apiErr, ok := err.(*apiError)
+	return ok && apiErr.StatusCode == http.StatusConflict
+}
+
+func isNotFound(err error) bool {
+	apiErr, ok := err.(*apiError)
+	return ok && apiErr.StatusCode == http.StatusNotFound
+}
+
+// newClient reaches the host over https with a client certificate, as the Docker Engine API gives
+// the control of the host to anyone who can call it. Plain http needs to be allowed explicitly.
+func newClient(host *config.DockerHost, version string) (*client, error) {
+	u, err := url.Parse(host.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	switch u.Scheme {
+	case "https":
+		tlsConfig, err := makeTLSConfig(host)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+	case "http":
+		if !host.Insecure {
+			return nil, fmt.Errorf("docker host %s is not using https, set insecure to allow it", host.Endpoint)
+		}
+	default:
+		return nil, fmt.Errorf("docker host %s should be an https url", host.Endpoint)
+	}
+	return &client{
+		endpoint:   strings.TrimSuffix(host.Endpoint, "/"),
+		address:    host.Address,
+		version:    version,
+		httpClient: &http.Client{Timeout: requestTimeout, Transport: transport},
+		pullClient: &http.Client{Timeout: pullTimeout, Transport: transport},
+	}, nil
+}
+
+func makeTLSConfig(host *config.DockerHost) (*tls.Config, error) {
+	if host.TLSCert == "" || host.TLSKey == "" {
+		return nil, fmt.Errorf("docker host %s needs tls_cert and tls_key", host.Endpoint)
+	}
+	cert, err := tls.LoadX509KeyPair(host.TLSCert, host.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if host.TLSCA == "" {
+		return tlsConfig, nil
+	}
+	ca, err := os.ReadFile(host.TLSCA)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.RootCAs = x509.NewCertPool()
+	if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("tls_ca of docker host %s has no certificate", host.Endpoint)
+	}
+	return tlsConfig, nil
+}
+
+func (c *client) do(method, path string, query url.Values, body io.Reader, contentType string, result any) error {
+	return c.doWith(c.httpClient, method, path, query, body, contentType, result)
+}
+
+func (c *client) doWith(httpClient *http.Client, method, path string, query url.Values, body io.Reader, contentType string, result any) error {
+	u := fmt.Sprintf("%s/%s%s", c.endpoint, c.version, path)
+	if len(query) > 0 {
+		u = fmt.Sprintf("%s?%s", u, query.Encode())
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		apiErr := &apiError{StatusCode: resp.StatusCode}
+		json.NewDecoder(resp.Body).Decode(apiErr)
+		return apiErr
+	}
+	if result == nil {
+		return nil
+	}
+	if w, ok := result.(io.Writer); ok {
+		_, err := io.Copy(w, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// createContainer creates the container, pulling its image first when the host does not have it
+func (c *client) createContainer(name string, cfg *containerConfig) (string, error) {
+	body, err := json.Marshal(cfg)
+	if err != nil {
+		return "", err
+	}
+	created := struct {
+		ID string `json:"Id"`
+	}{}
+	create := func() error {
+		return c.do(http.MethodPost, "/containers/create", url.Values{"name": {name}}, bytes.NewReader(body),
+			"application/json", &created)
+	}
+	err = create()
+	if !isNotFound(err) {
+		return created.ID, err
+	}
+	if err := c.pullImage(cfg.Image); err != nil {
+		return "", err
+	}
+	err = create()
+	return created.ID, err
+}
+
+// pullImage pulls the image from its registry. The progress of the pull is streamed in the response,
+// which ends with an error message when the pull fails.
+func (c *client) pullImage(image string) error {
+	var progress bytes.Buffer
+	if err := c.doWith(c.pullClient, http.MethodPost, "/images/create", url.Values{"fromImage": {image}}, nil, "", &progress); err != nil {
+		return err
+	}
+	dec := json.NewDecoder(&progress)
+	for {
+		msg := struct {
+			Error string `json:"error"`
+		}{}
+		if err := dec.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if msg.Error != "" {
+			return fmt.Errorf("failed to pull %s: %s", image, msg.Error)
+		}
+	}
+}
+
+func (c *client) startContainer(id string) error {
+	return c.do(http.MethodPost, fmt.Sprintf("/containers/%s/start", id), nil, nil, "", nil)
+}
+
+func (c *client) removeContainer(id string) error {
+	return c.do(http.MethodDelete, fmt.Sprintf("/containers/%s", id), url.Values{"force": {"true"}}, nil, "", nil)
+}
+
+// listContainers returns all the containers, including the stopped ones, having all the labels
+func (c *client) listContainers(labels map[string]string) ([]*container, error) {
+	selectors := []string{}
+	for k, v := range labels {
+		// an empty value only requires the label to exist
+		if v == "" {
+			selectors = append(selectors, k)
+			continue
+		}
+		selectors = append(selectors, fmt.Sprintf("%s=%s", k, v))
+	}
+	filters, err := json.Marshal(map[string][]string{"label": selectors})
+	if err != nil {
+		return nil, err
+	}
+	containers := []*container{}
+	query := url.Values{"all": {"true"}, "filters": {string(filters)}}
+	if err := c.do(http.MethodGet, "/containers/json", query, nil, "", &containers); err != nil {
+		return nil, err
+	}
+	for _, ct := range containers {
+		ct.client = c
+	}
+	return containers, nil
+}
+
+func (c *client) inspectContainer(id string) (*containerDetail, error) {
+	detail := new(containerDetail)
+	if err := c.do(http.MethodGet, fmt.Sprintf("/containers/%s/json", id), nil, nil, "", detail); err != nil {
+		return nil, err
+	}
+	return detail, nil
+}
+
+// putFiles copies the files into the dir of the container. It works for the created containers as
+// well as the running ones.
+func (c *client) putFiles(id, dir string, files map[string][]byte) error {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range files {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), ModTime: time.Now()}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(content); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return c.do(http.MethodPut, fmt.Sprintf("/containers/%s/archive", id), url.Values{"path": {dir}}, &buf,
+		"application/x-tar", nil)
+}
+
+// containerLogs returns the stdout and stderr of the container
+func (c *client) containerLogs(id string) (string, error) {
+	var buf bytes.Buffer
+	query := url.Values{"stdout": {"true"}, "stderr": {"true"}}
+	if err := c.do(http.MethodGet, fmt.Sprintf("/containers/%s/logs", id), query, nil, "", &buf); err != nil {
+		return "", err
+	}
+	return demuxLogs(buf.Bytes()), nil
+}
+
+// demuxLogs removes the headers of the multiplexed stream of the containers without tty. Every frame
+// starts with a header of 8 bytes, which has the size of the frame in the last 4 bytes.
+func demuxLogs(raw []byte) string {
+	var out strings.Builder
+	for len(raw) >= 8 {
+		size := int(binary.BigEndian.Uint32(raw[4:8]))
+		raw = raw[8:]
+		if size > len(raw) {
+			size = len(raw)
+		}
+		out.Write(raw[:size])
+		raw = raw[size:]
+	}
+	return out.String()
+}
+
+// name returns the name of the container without the leading slash
+func (ct *container) name() string {
+	if len(ct.Names) == 0 {
+		return ct.ID
+	}
+	return strings.TrimPrefix(ct.Names[0], "/")
+}
+
+// publicAddr returns the address the private port of the container is published at
+func (ct *container) publicAddr(privatePort int) (string, error) {
+	for _, p := range ct.Ports {
+		if p.PrivatePort == privatePort && p.PublicPort != 0 {
+			return fmt.Sprintf("%s:%d", ct.client.address, p.PublicPort), nil
+		}
+	}
+	return "", fmt.Errorf("port %d of %s is not published", privatePort, ct.name())
+}
+
+func (ct *container) running() bool {
+	return ct.State == "running"
+}
+
+func (d *containerDetail) env(name string) string {
+	prefix := name + "="
+	for _, e := range d.Config.Env {
+		if strings.HasPrefix(e, prefix) {
+			return strings.TrimPrefix(e, prefix)
+		}
+	}
+	return ""
+}
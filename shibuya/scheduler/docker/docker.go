@@ -0,0 +1,609 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/rakutentech/shibuya/shibuya/auth/keys"
+	"github.com/rakutentech/shibuya/shibuya/certmanager"
+	"github.com/rakutentech/shibuya/shibuya/config"
+	"github.com/rakutentech/shibuya/shibuya/coordinator/upstream"
+	model "github.com/rakutentech/shibuya/shibuya/model"
+	serrors "github.com/rakutentech/shibuya/shibuya/scheduler/errors"
+	smodel "github.com/rakutentech/shibuya/shibuya/scheduler/model"
+	log "github.com/sirupsen/logrus"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	enginePort      = 8080
+	coordinatorPort = 443
+	pubSubPort      = 2416
+	// contextLabel marks the containers of the controller, so several controllers can share the hosts
+	contextLabel = "shibuya.context"
+	// enginesLabel is the number of the engines of the plan, which tells whether all of them are deployed
+	enginesLabel = "engines"
+	// the files of the coordinator are copied into this dir
+	filesDir      = "shibuya"
+	tlsDir        = filesDir + "/tls"
+	inventoryFile = filesDir + "/inventory.json"
+)
+
+// Docker runs the engines and the coordinators as containers on a list of docker hosts through the
+// Docker Engine API. The containers are found by their labels, like the pods in k8s. The engines
+// and the coordinators reach each other by the published ports of the hosts. There is no scraper,
+// so the metrics of the engines are not pushed to the metric storage.
+type Docker struct {
+	sc      config.ShibuyaConfig
+	clients []*client
+}
+
+func NewDocker(sc config.ShibuyaConfig) *Docker {
+	cfg := sc.ExecutorConfig.Docker
+	d := &Docker{sc: sc}
+	for _, h := range cfg.Hosts {
+		c, err := newClient(h, cfg.APIVersion)
+		if err != nil {
+			log.Fatal(err)
+		}
+		d.clients = append(d.clients, c)
+	}
+	return d
+}
+
+func makeEngineName(projectID, collectionID, planID int64, engineID int) string {
+	return fmt.Sprintf("engine-%d-%d-%d-%d", projectID, collectionID, planID, engineID)
+}
+
+func makeCoordinatorName(projectID int64) string {
+	return fmt.Sprintf("ig-%d", projectID)
+}
+
+func makePlanLabels(projectID, collectionID, planID int64, engines int) map[string]string {
+	return map[string]string{
+		"collection": strconv.FormatInt(collectionID, 10),
+		"project":    strconv.FormatInt(projectID, 10),
+		"plan":       strconv.FormatInt(planID, 10),
+		"kind":       smodel.Executor,
+		enginesLabel: strconv.Itoa(engines),
+	}
+}
+
+func makeCoordinatorLabels(projectID int64) map[string]string {
+	return map[string]string{
+		"project": strconv.FormatInt(projectID, 10),
+		"kind":    smodel.IngressController,
+	}
+}
+
+func makeCollectionLabels(collectionID int64) map[string]string {
+	return map[string]string{
+		"collection": strconv.FormatInt(collectionID, 10),
+		"kind":       smodel.Executor,
+	}
+}
+
+func makeResources(cpu, mem string) (*hostConfig, error) {
+	hc := &hostConfig{}
+	if cpu != "" {
+		q, err := resource.ParseQuantity(cpu)
+		if err != nil {
+			return nil, err
+		}
+		hc.NanoCPUs = q.MilliValue() * 1e6
+	}
+	if mem != "" {
+		q, err := resource.ParseQuantity(mem)
+		if err != nil {
+			return nil, err
+		}
+		hc.Memory = q.Value()
+	}
+	return hc, nil
+}
+
+// makeEnv sorts the env by the names
+func makeEnv(env map[string]string) []string {
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	r := make([]string, len(names))
+	for i, name := range names {
+		r[i] = fmt.Sprintf("%s=%s", name, env[name])
+	}
+	return r
+}
+
+// publish publishes the ports of the container at random ports of the host
+func publish(cfg *containerConfig, ports ...int) {
+	cfg.ExposedPorts = make(map[string]struct{})
+	cfg.HostConfig.PortBindings = make(map[string][]portBinding)
+	for _, p := range ports {
+		key := fmt.Sprintf("%d/tcp", p)
+		cfg.ExposedPorts[key] = struct{}{}
+		cfg.HostConfig.PortBindings[key] = []portBinding{{}}
+	}
+}
+
+// list returns the containers having the labels on all the hosts, sorted by their names
+func (d *Docker) list(labels map[string]string) ([]*container, error) {
+	selector := map[string]string{contextLabel: d.sc.Context}
+	for k, v := range labels {
+		selector[k] = v
+	}
+	r := []*container{}
+	for _, c := range d.clients {
+		containers, err := c.listContainers(selector)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, containers...)
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i].name() < r[j].name() })
+	return r, nil
+}
+
+// leastLoaded returns the host running the fewest containers
+func (d *Docker) leastLoaded(load map[*client]int) *client {
+	host := d.clients[0]
+	for _, c := range d.clients[1:] {
+		if load[c] < load[host] {
+			host = c
+		}
+	}
+	return host
+}
+
+func (d *Docker) getLoad() (map[*client]int, error) {
+	containers, err := d.list(nil)
+	if err != nil {
+		return nil, err
+	}
+	load := make(map[*client]int)
+	for _, ct := range containers {
+		load[ct.client]++
+	}
+	return load, nil
+}
+
+func (d *Docker) getCoordinator(projectID int64) (*container, error) {
+	containers, err := d.list(makeCoordinatorLabels(projectID))
+	if err != nil {
+		return nil, err
+	}
+	if len(containers) == 0 {
+		return nil, fmt.Errorf("coordinator of project %d is not deployed", projectID)
+	}
+	return containers[0], nil
+}
+
+// updateInventory copies the engines of the project into the inventory file of its coordinator. The
+// engines of a collection are only listed when all of them are running.
+func (d *Docker) updateInventory(projectID int64) error {
+	cdr, err := d.getCoordinator(projectID)
+	if err != nil {
+		return err
+	}
+	engines, err := d.list(map[string]string{"project": strconv.FormatInt(projectID, 10), "kind": smodel.Executor})
+	if err != nil {
+		return err
+	}
+	notReady := make(map[string]bool)
+	running := make(map[string]int)
+	for _, e := range engines {
+		if !e.running() {
+			notReady[e.Labels["collection"]] = true
+			continue
+		}
+		running[e.Labels["plan"]]++
+	}
+	for _, e := range engines {
+		if expected, _ := strconv.Atoi(e.Labels[enginesLabel]); running[e.Labels["plan"]] < expected {
+			notReady[e.Labels["collection"]] = true
+		}
+	}
+	endpoints := []upstream.FileEndpoint{}
+	for _, e := range engines {
+		collectionID := e.Labels["collection"]
+		if notReady[collectionID] {
+			continue
+		}
+		addr, err := e.publicAddr(enginePort)
+		if err != nil {
+			return err
+		}
+		endpoints = append(endpoints, upstream.FileEndpoint{
+			CollectionID: collectionID,
+			PlanID:       e.Labels["plan"],
+			Engine:       e.name(),
+			Addr:         addr,
+		})
+	}
+	raw, err := json.Marshal(endpoints)
+	if err != nil {
+		return err
+	}
+	return cdr.client.putFiles(cdr.ID, "/", map[string][]byte{inventoryFile: raw})
+}
+
+func (d *Docker) DeployPlan(projectID, collectionID, planID int64, replicas int, serviceIP string, ec *config.ExecutorContainer) error {
+	cdr, err := d.getCoordinator(projectID)
+	if err != nil {
+		return err
+	}
+	pubSubAddr, err := cdr.publicAddr(pubSubPort)
+	if err != nil {
+		return err
+	}
+	apiKey, err := d.GetProjectAPIKey(projectID)
+	if err != nil {
+		return err
+	}
+	deployed, err := d.list(map[string]string{"plan": strconv.FormatInt(planID, 10), "collection": strconv.FormatInt(collectionID, 10)})
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]struct{})
+	for _, e := range deployed {
+		existing[e.name()] = struct{}{}
+	}
+	load, err := d.getLoad()
+	if err != nil {
+		return err
+	}
+	hc, err := makeResources(ec.CPU, ec.Mem)
+	if err != nil {
+		return err
+	}
+	labels := makePlanLabels(projectID, collectionID, planID, replicas)
+	labels[contextLabel] = d.sc.Context
+	for i := 0; i < replicas; i++ {
+		name := makeEngineName(projectID, collectionID, planID, i)
+		// the deployment is retried when any of the engines fails
+		if _, ok := existing[name]; ok {
+			continue
+		}
+		cfg := &containerConfig{
+			Image: ec.Image,
+			// the env set by shibuya comes last so it's not replaced by the env of the container
+			Env: append(makeEnv(ec.Env),
+				fmt.Sprintf("collection_id=%d", collectionID),
+				fmt.Sprintf("plan_id=%d", planID),
+				fmt.Sprintf("coordinator_ip=%s", serviceIP),
+				fmt.Sprintf("coordinator_pubsub_addr=%s", pubSubAddr),
+				fmt.Sprintf("engine_name=%s", name),
+				fmt.Sprintf("api_key=%s", apiKey),
+			),
+			Labels:     labels,
+			HostConfig: hc,
+		}
+		publish(cfg, enginePort)
+		host := d.leastLoaded(load)
+		id, err := host.createContainer(name, cfg)
+		if err != nil {
+			return err
+		}
+		if err := host.startContainer(id); err != nil {
+			return err
+		}
+		load[host]++
+	}
+	return d.updateInventory(projectID)
+}
+
+func (d *Docker) CollectionStatus(projectID, collectionID int64, eps []*model.ExecutionPlan) (*smodel.CollectionStatus, error) {
+	// there is no scraper to wait for
+	cs := &smodel.CollectionStatus{ScraperDeployed: true}
+	engines, err := d.list(makeCollectionLabels(collectionID))
+	if err != nil {
+		return cs, err
+	}
+	planStatuses := make(map[int64]*smodel.PlanStatus)
+	for _, ep := range eps {
+		ps := &smodel.PlanStatus{
+			PlanID:  ep.PlanID,
+			Engines: ep.Engines,
+		}
+		planStatuses[ep.PlanID] = ps
+		cs.Plans = append(cs.Plans, ps)
+	}
+	for _, e := range engines {
+		planID, err := strconv.ParseInt(e.Labels["plan"], 10, 64)
+		if err != nil {
+			log.Error(err)
+		}
+		ps, ok := planStatuses[planID]
+		if !ok {
+			log.Error("Could not find running engine in ExecutionPlan")
+			continue
+		}
+		if e.running() {
+			ps.EnginesDeployed += 1
+		}
+	}
+	// engines can exit by themselves, so the inventory is kept up to date by the status checks
+	if err := d.updateInventory(projectID); err != nil {
+		log.Debug(err)
+	}
+	return cs, nil
+}
+
+func (d *Docker) CreateCollectionScraper(apiToken, token string, collectionID int64) error {
+	return nil
+}
+
+func (d *Docker) FetchEngineUrlsByPlan(collectionID, planID int64, opts *smodel.EngineOwnerRef) ([]string, error) {
+	collectionUrl, err := d.GetIngressUrl(opts.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+	urls := []string{}
+	for i := 0; i < opts.EnginesCount; i++ {
+		urls = append(urls, fmt.Sprintf("%s/%s", collectionUrl, makeEngineName(opts.ProjectID, collectionID, planID, i)))
+	}
+	return urls, nil
+}
+
+func (d *Docker) PurgeCollection(collectionID int64) error {
+	engines, err := d.list(makeCollectionLabels(collectionID))
+	if err != nil {
+		return err
+	}
+	// If there are any errors in deletion, we only return the last one
+	var lastError error
+	projects := make(map[int64]struct{})
+	for _, e := range engines {
+		if err := e.client.removeContainer(e.ID); err != nil {
+			lastError = err
+		}
+		projectID, _ := strconv.ParseInt(e.Labels["project"], 10, 64)
+		projects[projectID] = struct{}{}
+	}
+	for projectID := range projects {
+		if err := d.updateInventory(projectID); err != nil {
+			log.Debug(err)
+		}
+	}
+	return lastError
+}
+
+func (d *Docker) GetDeployedCollections() (map[int64]time.Time, error) {
+	engines, err := d.list(map[string]string{"kind": smodel.Executor})
+	if err != nil {
+		return nil, err
+	}
+	deployedCollections := make(map[int64]time.Time)
+	for _, e := range engines {
+		collectionID, err := strconv.ParseInt(e.Labels["collection"], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		deployedCollections[collectionID] = time.Unix(e.Created, 0)
+	}
+	return deployedCollections, nil
+}
+
+func (d *Docker) PodReadyCount(collectionID int64) int {
+	engines, err := d.list(makeCollectionLabels(collectionID))
+	if err != nil {
+		log.Warn(err)
+	}
+	ready := 0
+	for _, e := range engines {
+		if e.running() {
+			ready++
+		}
+	}
+	return ready
+}
+
+func (d *Docker) DownloadPodLog(collectionID, planID int64) (string, error) {
+	labels := makeCollectionLabels(collectionID)
+	labels["plan"] = strconv.FormatInt(planID, 10)
+	engines, err := d.list(labels)
+	if err != nil {
+		return "", err
+	}
+	if len(engines) == 0 {
+		return "", fmt.Errorf("Cannot find engine for the plan %d", planID)
+	}
+	return engines[0].client.containerLogs(engines[0].ID)
+}
+
+func (d *Docker) GetCollectionEnginesDetail(projectID, collectionID int64) (*smodel.CollectionDetails, error) {
+	engines, err := d.list(makeCollectionLabels(collectionID))
+	if err != nil {
+		return nil, err
+	}
+	if len(engines) == 0 {
+		return nil, &serrors.NoResourcesFoundErr{Message: "Cannot find the engines"}
+	}
+	collectionDetails := &smodel.CollectionDetails{ControllerReplicas: 1}
+	ingressUrl, err := d.GetIngressUrl(projectID)
+	if err != nil {
+		collectionDetails.IngressIP = err.Error()
+	} else {
+		collectionDetails.IngressIP = ingressUrl
+	}
+	for _, e := range engines {
+		collectionDetails.Engines = append(collectionDetails.Engines, &smodel.EngineStatus{
+			Name:        e.name(),
+			Status:      e.State,
+			CreatedTime: time.Unix(e.Created, 0),
+		})
+	}
+	return collectionDetails, nil
+}
+
+func (d *Docker) GetDeployedServices() (map[int64]time.Time, error) {
+	coordinators, err := d.list(map[string]string{"kind": smodel.IngressController})
+	if err != nil {
+		return nil, err
+	}
+	deployedServices := make(map[int64]time.Time)
+	for _, cdr := range coordinators {
+		projectID, err := strconv.ParseInt(cdr.Labels["project"], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		deployedServices[projectID] = time.Unix(cdr.Created, 0)
+	}
+	return deployedServices, nil
+}
+
+func makeCoordinatorService(cdr *container) (*apiv1.Service, error) {
+	addr, err := cdr.publicAddr(coordinatorPort)
+	if err != nil {
+		return nil, err
+	}
+	return &apiv1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   cdr.name(),
+			Labels: cdr.Labels,
+		},
+		Spec: apiv1.ServiceSpec{
+			// this is the address the engines reach the coordinator at
+			ClusterIP: addr,
+		},
+	}, nil
+}
+
+func (d *Docker) ExposeProject(projectID int64) (*apiv1.Service, error) {
+	if cdr, err := d.getCoordinator(projectID); err == nil {
+		return makeCoordinatorService(cdr)
+	}
+	load, err := d.getLoad()
+	if err != nil {
+		return nil, err
+	}
+	host := d.leastLoaded(load)
+	apiKey, err := keys.GenerateAPIKey()
+	if err != nil {
+		return nil, err
+	}
+	// the controller can only verify the cert when the host is reached by its IP
+	ip := ""
+	if net.ParseIP(host.address) != nil {
+		ip = host.address
+	}
+	cert, key, err := certmanager.GenCertAndKey(d.sc.CAPair, projectID, ip)
+	if err != nil {
+		return nil, err
+	}
+	igCfg := d.sc.IngressConfig
+	hc, err := makeResources(igCfg.CPU, igCfg.Mem)
+	if err != nil {
+		return nil, err
+	}
+	labels := makeCoordinatorLabels(projectID)
+	labels[contextLabel] = d.sc.Context
+	cfg := &containerConfig{
+		Image: igCfg.Image,
+		Env: []string{
+			fmt.Sprintf("project_id=%d", projectID),
+			fmt.Sprintf("api_key=%s", apiKey),
+			fmt.Sprintf("listen_addr=:%d", coordinatorPort),
+			fmt.Sprintf("tls_dir=/%s", tlsDir),
+			fmt.Sprintf("inventory_file=/%s", inventoryFile),
+		},
+		Labels:     labels,
+		HostConfig: hc,
+	}
+	publish(cfg, coordinatorPort, pubSubPort)
+	id, err := host.createContainer(makeCoordinatorName(projectID), cfg)
+	if err != nil {
+		// the coordinator is being deployed by another collection of the project
+		if isConflict(err) {
+			return nil, serrors.MakeIPNotAssignedError()
+		}
+		return nil, err
+	}
+	files := map[string][]byte{
+		tlsDir + "/tls.crt": cert,
+		tlsDir + "/tls.key": key,
+		inventoryFile:       []byte("[]"),
+	}
+	if err := host.putFiles(id, "/", files); err != nil {
+		return nil, err
+	}
+	if err := host.startContainer(id); err != nil {
+		return nil, err
+	}
+	cdr, err := d.getCoordinator(projectID)
+	if err != nil {
+		return nil, err
+	}
+	return makeCoordinatorService(cdr)
+}
+
+func (d *Docker) PurgeProjectIngress(projectID int64) error {
+	coordinators, err := d.list(makeCoordinatorLabels(projectID))
+	if err != nil {
+		return err
+	}
+	var lastError error
+	for _, cdr := range coordinators {
+		if err := cdr.client.removeContainer(cdr.ID); err != nil {
+			lastError = err
+		}
+	}
+	return lastError
+}
+
+func (d *Docker) GetEnginesByProject(projectID int64) ([]apiv1.Pod, error) {
+	engines, err := d.list(map[string]string{"project": strconv.FormatInt(projectID, 10), "kind": smodel.Executor})
+	if err != nil {
+		return nil, err
+	}
+	pods := make([]apiv1.Pod, len(engines))
+	for i, e := range engines {
+		pods[i] = apiv1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              e.name(),
+				Labels:            e.Labels,
+				CreationTimestamp: metav1.NewTime(time.Unix(e.Created, 0)),
+			},
+		}
+	}
+	sort.Slice(pods, func(i, j int) bool {
+		return pods[i].CreationTimestamp.Time.After(pods[j].CreationTimestamp.Time)
+	})
+	return pods, nil
+}
+
+func (d *Docker) GetIngressUrl(projectID int64) (string, error) {
+	cdr, err := d.getCoordinator(projectID)
+	if err != nil {
+		return "", serrors.MakeSchedulerIngressError(err)
+	}
+	addr, err := cdr.publicAddr(coordinatorPort)
+	if err != nil {
+		return "", serrors.MakeSchedulerIngressError(err)
+	}
+	return addr, nil
+}
+
+func (d *Docker) GetProjectAPIKey(projectID int64) (string, error) {
+	cdr, err := d.getCoordinator(projectID)
+	if err != nil {
+		return "", err
+	}
+	detail, err := cdr.client.inspectContainer(cdr.ID)
+	if err != nil {
+		return "", err
+	}
+	return detail.env("api_key"), nil
+}
+
+// GetCapacity is not limited as the docker hosts do not reserve the resources of the containers
+func (d *Docker) GetCapacity() (*smodel.ClusterCapacity, error) {
+	return nil, nil
+}
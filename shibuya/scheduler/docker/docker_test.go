@@ -0,0 +1,468 @@
+package docker
+
+import (
+	"archive/tar"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rakutentech/shibuya/shibuya/config"
+	"github.com/rakutentech/shibuya/shibuya/coordinator/upstream"
+	"github.com/rakutentech/shibuya/shibuya/model"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeContainer struct {
+	container
+	cfg   *containerConfig
+	files map[string][]byte
+}
+
+// fakeAPI serves the part of the Docker Engine API used by the scheduler
+type fakeAPI struct {
+	mu         sync.Mutex
+	containers map[string]*fakeContainer
+	nextPort   int
+	// images are the images on the host, the others are pulled from the registry
+	images   map[string]bool
+	registry map[string]bool
+}
+
+func newFakeAPI(t *testing.T, firstPort int) (*fakeAPI, *httptest.Server) {
+	api := &fakeAPI{
+		containers: make(map[string]*fakeContainer),
+		nextPort:   firstPort,
+		images:     make(map[string]bool),
+		registry:   map[string]bool{"shibuya:coordinator": true, "shibuya:jmeter": true},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1.41/images/create", api.pull)
+	mux.HandleFunc("POST /v1.41/containers/create", api.create)
+	mux.HandleFunc("POST /v1.41/containers/{id}/start", api.start)
+	mux.HandleFunc("DELETE /v1.41/containers/{id}", api.remove)
+	mux.HandleFunc("GET /v1.41/containers/json", api.list)
+	mux.HandleFunc("GET /v1.41/containers/{id}/json", api.inspect)
+	mux.HandleFunc("PUT /v1.41/containers/{id}/archive", api.archive)
+	mux.HandleFunc("GET /v1.41/containers/{id}/logs", api.logs)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return api, server
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
+
+func (api *fakeAPI) get(w http.ResponseWriter, r *http.Request) *fakeContainer {
+	ct, ok := api.containers[r.PathValue("id")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "No such container")
+	}
+	return ct
+}
+
+func (api *fakeAPI) byName(name string) *fakeContainer {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	for _, ct := range api.containers {
+		if ct.name() == name {
+			return ct
+		}
+	}
+	return nil
+}
+
+func (api *fakeAPI) create(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	name := r.URL.Query().Get("name")
+	for _, ct := range api.containers {
+		if ct.name() == name {
+			writeError(w, http.StatusConflict, "Conflict. The container name is already in use")
+			return
+		}
+	}
+	cfg := new(containerConfig)
+	if err := json.NewDecoder(r.Body).Decode(cfg); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !api.images[cfg.Image] {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("No such image: %s", cfg.Image))
+		return
+	}
+	ct := &fakeContainer{
+		container: container{
+			ID:      fmt.Sprintf("id-%s", name),
+			Names:   []string{"/" + name},
+			Labels:  cfg.Labels,
+			State:   "created",
+			Created: time.Now().Unix(),
+		},
+		cfg:   cfg,
+		files: make(map[string][]byte),
+	}
+	api.containers[ct.ID] = ct
+	json.NewEncoder(w).Encode(map[string]string{"Id": ct.ID})
+}
+
+func (api *fakeAPI) pull(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	image := r.URL.Query().Get("fromImage")
+	enc := json.NewEncoder(w)
+	enc.Encode(map[string]string{"status": fmt.Sprintf("Pulling from %s", image)})
+	if !api.registry[image] {
+		enc.Encode(map[string]string{"error": fmt.Sprintf("pull access denied for %s", image)})
+		return
+	}
+	api.images[image] = true
+	enc.Encode(map[string]string{"status": fmt.Sprintf("Downloaded newer image for %s", image)})
+}
+
+func (api *fakeAPI) start(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	ct := api.get(w, r)
+	if ct == nil {
+		return
+	}
+	ct.State = "running"
+	for p := range ct.cfg.ExposedPorts {
+		var privatePort int
+		fmt.Sscanf(p, "%d/tcp", &privatePort)
+		ct.Ports = append(ct.Ports, containerPort{IP: "0.0.0.0", PrivatePort: privatePort, PublicPort: api.nextPort, Type: "tcp"})
+		api.nextPort++
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (api *fakeAPI) remove(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	ct := api.get(w, r)
+	if ct == nil {
+		return
+	}
+	delete(api.containers, ct.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (api *fakeAPI) list(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	filters := make(map[string][]string)
+	if err := json.Unmarshal([]byte(r.URL.Query().Get("filters")), &filters); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	containers := []container{}
+	for _, ct := range api.containers {
+		matched := true
+		for _, selector := range filters["label"] {
+			k, v, hasValue := strings.Cut(selector, "=")
+			actual, ok := ct.Labels[k]
+			if !ok || (hasValue && actual != v) {
+				matched = false
+			}
+		}
+		if matched {
+			containers = append(containers, ct.container)
+		}
+	}
+	json.NewEncoder(w).Encode(containers)
+}
+
+func (api *fakeAPI) inspect(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	ct := api.get(w, r)
+	if ct == nil {
+		return
+	}
+	detail := containerDetail{ID: ct.ID}
+	detail.Config.Env = ct.cfg.Env
+	json.NewEncoder(w).Encode(detail)
+}
+
+func (api *fakeAPI) archive(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	ct := api.get(w, r)
+	if ct == nil {
+		return
+	}
+	tr := tar.NewReader(r.Body)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		content, _ := io.ReadAll(tr)
+		ct.files[path.Join(r.URL.Query().Get("path"), hdr.Name)] = content
+	}
+}
+
+func (api *fakeAPI) logs(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	ct := api.get(w, r)
+	if ct == nil {
+		return
+	}
+	for stream, line := range []string{"stdout of ", "stderr of "} {
+		line += ct.name() + "\n"
+		header := make([]byte, 8)
+		header[0] = byte(stream + 1)
+		binary.BigEndian.PutUint32(header[4:], uint32(len(line)))
+		w.Write(append(header, line...))
+	}
+}
+
+func makeCAPair(t *testing.T) *config.CAPair {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "shibuya-ca"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	return &config.CAPair{Cert: cert, PrivateKey: key}
+}
+
+func readInventory(t *testing.T, cdr *fakeContainer) []upstream.FileEndpoint {
+	var endpoints []upstream.FileEndpoint
+	assert.Nil(t, json.Unmarshal(cdr.files["/"+inventoryFile], &endpoints))
+	return endpoints
+}
+
+func TestDocker(t *testing.T) {
+	api1, server1 := newFakeAPI(t, 30000)
+	api2, server2 := newFakeAPI(t, 40000)
+	sc := config.ShibuyaConfig{
+		CAPair:        makeCAPair(t),
+		IngressConfig: &config.IngressConfig{Image: "shibuya:coordinator", CPU: "500m"},
+		ExecutorConfig: &config.ExecutorConfig{
+			Docker: &config.DockerConfig{
+				APIVersion: "v1.41",
+				Hosts: []*config.DockerHost{
+					{Endpoint: server1.URL, Address: "10.0.0.1", Insecure: true},
+					{Endpoint: server2.URL, Address: "10.0.0.2", Insecure: true},
+				},
+			},
+		},
+	}
+	d := NewDocker(sc)
+
+	container := &config.ExecutorContainer{Image: "shibuya:jmeter", CPU: "1", Mem: "1Gi"}
+	assert.NotNil(t, d.DeployPlan(1, 2, 3, 2, "10.0.0.1:30000", container))
+
+	service, err := d.ExposeProject(1)
+	assert.Nil(t, err)
+	assert.Equal(t, "ig-1", service.Name)
+	cdr := api1.byName("ig-1")
+	assert.Equal(t, int64(5e8), cdr.cfg.HostConfig.NanoCPUs)
+	assert.Contains(t, cdr.files, "/"+tlsDir+"/tls.crt")
+	assert.Empty(t, readInventory(t, cdr))
+	ingressUrl, err := d.GetIngressUrl(1)
+	assert.Nil(t, err)
+	assert.Equal(t, service.Spec.ClusterIP, ingressUrl)
+	apiKey, err := d.GetProjectAPIKey(1)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, apiKey)
+	// the hosts pull the images they do not have
+	assert.True(t, api1.images["shibuya:coordinator"])
+	// exposing the project again reuses the coordinator
+	_, err = d.ExposeProject(1)
+	assert.Nil(t, err)
+	assert.Len(t, api1.containers, 1)
+	assert.Empty(t, api2.containers)
+
+	assert.Nil(t, d.DeployPlan(1, 2, 3, 2, service.Spec.ClusterIP, container))
+	assert.Nil(t, d.DeployPlan(1, 2, 4, 1, service.Spec.ClusterIP, container))
+	// the engines are spread over the hosts
+	assert.Len(t, api1.containers, 2)
+	assert.Len(t, api2.containers, 2)
+	assert.True(t, api2.images["shibuya:jmeter"])
+	engine := api2.byName("engine-1-2-3-0")
+	assert.Contains(t, engine.cfg.Env, "coordinator_ip="+service.Spec.ClusterIP)
+	pubSubAddr, err := d.list(makeCoordinatorLabels(1))
+	assert.Nil(t, err)
+	addr, _ := pubSubAddr[0].publicAddr(pubSubPort)
+	assert.Contains(t, engine.cfg.Env, "coordinator_pubsub_addr="+addr)
+	assert.Contains(t, engine.cfg.Env, "api_key="+apiKey)
+	assert.Equal(t, int64(1e9), engine.cfg.HostConfig.NanoCPUs)
+	assert.Equal(t, int64(1<<30), engine.cfg.HostConfig.Memory)
+	assert.Equal(t, []upstream.FileEndpoint{
+		{CollectionID: "2", PlanID: "3", Engine: "engine-1-2-3-0", Addr: "10.0.0.2:40000"},
+		{CollectionID: "2", PlanID: "3", Engine: "engine-1-2-3-1", Addr: "10.0.0.1:30002"},
+		{CollectionID: "2", PlanID: "4", Engine: "engine-1-2-4-0", Addr: "10.0.0.2:40001"},
+	}, readInventory(t, cdr))
+	// the engines which are already deployed are skipped
+	assert.Nil(t, d.DeployPlan(1, 2, 3, 2, service.Spec.ClusterIP, container))
+	assert.Len(t, api1.containers, 2)
+
+	eps := []*model.ExecutionPlan{{PlanID: 3, Engines: 2}, {PlanID: 4, Engines: 1}}
+	cs, err := d.CollectionStatus(1, 2, eps)
+	assert.Nil(t, err)
+	assert.True(t, cs.ScraperDeployed)
+	assert.Equal(t, 2, cs.Plans[0].EnginesDeployed)
+	assert.Equal(t, 1, cs.Plans[1].EnginesDeployed)
+	assert.Equal(t, 3, d.PodReadyCount(2))
+
+	// an engine exits by itself
+	api2.byName("engine-1-2-4-0").State = "exited"
+	cs, err = d.CollectionStatus(1, 2, eps)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, cs.Plans[1].EnginesDeployed)
+	assert.Empty(t, readInventory(t, cdr))
+
+	logs, err := d.DownloadPodLog(2, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, "stdout of engine-1-2-3-0\nstderr of engine-1-2-3-0\n", logs)
+	details, err := d.GetCollectionEnginesDetail(1, 2)
+	assert.Nil(t, err)
+	assert.Len(t, details.Engines, 3)
+	assert.Equal(t, "exited", details.Engines[2].Status)
+	assert.Equal(t, ingressUrl, details.IngressIP)
+	pods, err := d.GetEnginesByProject(1)
+	assert.Nil(t, err)
+	assert.Len(t, pods, 3)
+	assert.Equal(t, "2", pods[0].Labels["collection"])
+	deployed, err := d.GetDeployedCollections()
+	assert.Nil(t, err)
+	assert.Contains(t, deployed, int64(2))
+
+	assert.Nil(t, d.PurgeCollection(2))
+	assert.Len(t, api1.containers, 1)
+	assert.Empty(t, api2.containers)
+	_, err = d.GetCollectionEnginesDetail(1, 2)
+	assert.NotNil(t, err)
+
+	services, err := d.GetDeployedServices()
+	assert.Nil(t, err)
+	assert.Contains(t, services, int64(1))
+	assert.Nil(t, d.PurgeProjectIngress(1))
+	assert.Empty(t, api1.containers)
+	_, err = d.GetIngressUrl(1)
+	assert.NotNil(t, err)
+}
+
+func TestDockerContext(t *testing.T) {
+	api, server := newFakeAPI(t, 30000)
+	cfg := &config.ExecutorConfig{
+		Docker: &config.DockerConfig{
+			APIVersion: "v1.41",
+			Hosts:      []*config.DockerHost{{Endpoint: server.URL, Address: "10.0.0.1", Insecure: true}},
+		},
+	}
+	sc := config.ShibuyaConfig{
+		CAPair:         makeCAPair(t),
+		IngressConfig:  &config.IngressConfig{Image: "shibuya:coordinator"},
+		ExecutorConfig: cfg,
+		Context:        "tokyo",
+	}
+	d := NewDocker(sc)
+	_, err := d.ExposeProject(1)
+	assert.Nil(t, err)
+	assert.Equal(t, "tokyo", api.byName("ig-1").Labels[contextLabel])
+
+	// the controllers of the other contexts do not see the coordinator
+	sc.Context = "osaka"
+	other := NewDocker(sc)
+	services, err := other.GetDeployedServices()
+	assert.Nil(t, err)
+	assert.Empty(t, services)
+	// the name is taken, so the coordinator cannot be deployed yet
+	_, err = other.ExposeProject(1)
+	assert.NotNil(t, err)
+}
+
+func TestDockerPullFailure(t *testing.T) {
+	api, server := newFakeAPI(t, 30000)
+	c, err := newClient(&config.DockerHost{Endpoint: server.URL, Insecure: true}, "v1.41")
+	assert.Nil(t, err)
+	_, err = c.createContainer("engine-1-2-3-0", &containerConfig{Image: "shibuya:unknown"})
+	assert.ErrorContains(t, err, "pull access denied for shibuya:unknown")
+	assert.Empty(t, api.containers)
+}
+
+func writePEM(t *testing.T, name, kind string, der []byte) string {
+	p := path.Join(t.TempDir(), name)
+	assert.Nil(t, os.WriteFile(p, pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}), 0600))
+	return p
+}
+
+func TestDockerClientTLS(t *testing.T) {
+	ca := makeCAPair(t)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "shibuya"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.Cert, &key.PublicKey, ca.PrivateKey)
+	assert.Nil(t, err)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("[]"))
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.Cert)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	host := &config.DockerHost{
+		Endpoint: server.URL,
+		TLSCA:    writePEM(t, "ca.pem", "CERTIFICATE", server.Certificate().Raw),
+		TLSCert:  writePEM(t, "cert.pem", "CERTIFICATE", der),
+		TLSKey:   writePEM(t, "key.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key)),
+	}
+	c, err := newClient(host, "v1.41")
+	assert.Nil(t, err)
+	_, err = c.listContainers(nil)
+	assert.Nil(t, err)
+
+	// https needs a client certificate
+	_, err = newClient(&config.DockerHost{Endpoint: server.URL, TLSCA: host.TLSCA}, "v1.41")
+	assert.NotNil(t, err)
+
+	// plain http needs to be allowed explicitly
+	_, err = newClient(&config.DockerHost{Endpoint: "http://10.0.0.1:2375"}, "v1.41")
+	assert.NotNil(t, err)
+	_, err = newClient(&config.DockerHost{Endpoint: "http://10.0.0.1:2375", Insecure: true}, "v1.41")
+	assert.Nil(t, err)
+}
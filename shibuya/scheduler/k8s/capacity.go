@@ -0,0 +1,122 @@
+package k8s
+
+import (
+	"context"
+	"time"
+
+	"github.com/rakutentech/shibuya/shibuya/config"
+	smodel "github.com/rakutentech/shibuya/shibuya/scheduler/model"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// An engine pod pending for longer is considered stuck, and stops holding the queue
+const pendingEngineTimeout = 5 * time.Minute
+
+// GetCapacity returns the capacity of the nodes the engines can be scheduled on. The pods of all the
+// namespaces are counted as they share the nodes with the engines. There is no limit when the engines
+// run in their own node pools, as the pools are sized for them.
+func (kcm *K8sClientManager) GetCapacity() (*smodel.ClusterCapacity, error) {
+	if kcm.nodePools != nil {
+		return nil, nil
+	}
+	nodes, err := kcm.client.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	pods, err := kcm.client.CoreV1().Pods("").List(context.TODO(), metav1.ListOptions{
+		FieldSelector: "status.phase!=Succeeded,status.phase!=Failed",
+	})
+	if err != nil {
+		return nil, err
+	}
+	return makeClusterCapacity(nodes.Items, pods.Items, kcm.sc.ExecutorConfig, time.Now()), nil
+}
+
+func makeClusterCapacity(nodes []apiv1.Node, pods []apiv1.Pod, ec *config.ExecutorConfig, now time.Time) *smodel.ClusterCapacity {
+	cc := &smodel.ClusterCapacity{Nodes: []*smodel.NodeCapacity{}}
+	byName := make(map[string]*smodel.NodeCapacity)
+	for _, node := range nodes {
+		if !canRunEngines(node, ec) {
+			continue
+		}
+		cpu := node.Status.Allocatable.Cpu().MilliValue()
+		mem := node.Status.Allocatable.Memory().Value()
+		nc := &smodel.NodeCapacity{
+			Name:    node.Name,
+			Labels:  node.Labels,
+			CPU:     cpu,
+			Mem:     mem,
+			FreeCPU: cpu,
+			FreeMem: mem,
+		}
+		cc.Nodes = append(cc.Nodes, nc)
+		byName[node.Name] = nc
+	}
+	for _, pod := range pods {
+		if pod.Spec.NodeName == "" {
+			if isWaitingEngine(pod, now) {
+				cc.PendingPods++
+			}
+			continue
+		}
+		nc, ok := byName[pod.Spec.NodeName]
+		if !ok {
+			continue
+		}
+		for _, c := range pod.Spec.Containers {
+			nc.FreeCPU -= c.Resources.Requests.Cpu().MilliValue()
+			nc.FreeMem -= c.Resources.Requests.Memory().Value()
+		}
+	}
+	return cc
+}
+
+// isWaitingEngine tells whether the unscheduled pod is an engine which will take the free capacity
+// of the engine nodes. The pending pods of other workloads may never fit on these nodes.
+func isWaitingEngine(pod apiv1.Pod, now time.Time) bool {
+	if pod.Labels["kind"] != smodel.Executor {
+		return false
+	}
+	return now.Sub(pod.CreationTimestamp.Time) < pendingEngineTimeout
+}
+
+// canRunEngines tells whether the engines can be scheduled on the node with the affinity and the
+// tolerations of the executor config
+func canRunEngines(node apiv1.Node, ec *config.ExecutorConfig) bool {
+	if node.Spec.Unschedulable {
+		return false
+	}
+	ready := false
+	for _, c := range node.Status.Conditions {
+		if c.Type == apiv1.NodeReady {
+			ready = c.Status == apiv1.ConditionTrue
+		}
+	}
+	if !ready {
+		return false
+	}
+	if len(ec.NodeAffinity) > 0 {
+		t := ec.NodeAffinity[0]
+		if node.Labels[t["key"]] != t["value"] {
+			return false
+		}
+	}
+	for _, taint := range node.Spec.Taints {
+		if taint.Effect == apiv1.TaintEffectPreferNoSchedule {
+			continue
+		}
+		tolerated := false
+		for _, t := range ec.Tolerations {
+			if t.Key == taint.Key && t.Value == taint.Value && t.Effect == taint.Effect {
+				tolerated = true
+				break
+			}
+		}
+		if !tolerated {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,91 @@
+package k8s
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rakutentech/shibuya/shibuya/config"
+	smodel "github.com/rakutentech/shibuya/shibuya/scheduler/model"
+	"github.com/stretchr/testify/assert"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func makeNode(name string, labels map[string]string, taints ...apiv1.Taint) apiv1.Node {
+	return apiv1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		Spec:       apiv1.NodeSpec{Taints: taints},
+		Status: apiv1.NodeStatus{
+			Allocatable: apiv1.ResourceList{
+				apiv1.ResourceCPU:    resource.MustParse("4"),
+				apiv1.ResourceMemory: resource.MustParse("16Gi"),
+			},
+			Conditions: []apiv1.NodeCondition{{Type: apiv1.NodeReady, Status: apiv1.ConditionTrue}},
+		},
+	}
+}
+
+func makePod(nodeName, cpu, mem string) apiv1.Pod {
+	return apiv1.Pod{
+		Spec: apiv1.PodSpec{
+			NodeName: nodeName,
+			Containers: []apiv1.Container{{
+				Resources: apiv1.ResourceRequirements{
+					Requests: apiv1.ResourceList{
+						apiv1.ResourceCPU:    resource.MustParse(cpu),
+						apiv1.ResourceMemory: resource.MustParse(mem),
+					},
+				},
+			}},
+		},
+	}
+}
+
+func makePendingEngine(created time.Time) apiv1.Pod {
+	pod := makePod("", "1", "1Gi")
+	pod.Labels = map[string]string{"kind": smodel.Executor}
+	pod.CreationTimestamp = metav1.NewTime(created)
+	return pod
+}
+
+func TestMakeClusterCapacity(t *testing.T) {
+	ec := &config.ExecutorConfig{
+		NodeAffinity: []map[string]string{{"key": "pool", "value": "engines"}},
+		Tolerations:  []config.Toleration{{Key: "dedicated", Value: "engines", Effect: apiv1.TaintEffectNoSchedule}},
+	}
+	engines := map[string]string{"pool": "engines"}
+	now := time.Now()
+	cordoned := makeNode("cordoned", engines)
+	cordoned.Spec.Unschedulable = true
+	notReady := makeNode("not-ready", engines)
+	notReady.Status.Conditions[0].Status = apiv1.ConditionFalse
+	nodes := []apiv1.Node{
+		makeNode("node-1", engines),
+		makeNode("node-2", engines, apiv1.Taint{Key: "dedicated", Value: "engines", Effect: apiv1.TaintEffectNoSchedule}),
+		makeNode("other-pool", map[string]string{"pool": "default"}),
+		makeNode("tainted", engines, apiv1.Taint{Key: "gpu", Value: "true", Effect: apiv1.TaintEffectNoSchedule}),
+		cordoned,
+		notReady,
+	}
+	pods := []apiv1.Pod{
+		makePod("node-1", "1500m", "4Gi"),
+		makePod("node-1", "500m", "1Gi"),
+		makePod("other-pool", "1", "1Gi"),
+		makePendingEngine(now.Add(-time.Minute)),
+		// the pending pods of other workloads and the stuck engines do not hold the queue
+		makePod("", "1", "1Gi"),
+		makePendingEngine(now.Add(-pendingEngineTimeout)),
+	}
+	cc := makeClusterCapacity(nodes, pods, ec, now)
+	assert.Len(t, cc.Nodes, 2)
+	assert.Equal(t, 1, cc.PendingPods)
+	n1 := cc.Nodes[0]
+	assert.Equal(t, "node-1", n1.Name)
+	assert.Equal(t, int64(4000), n1.CPU)
+	assert.Equal(t, int64(2000), n1.FreeCPU)
+	assert.Equal(t, int64(11*1024*1024*1024), n1.FreeMem)
+	assert.Equal(t, "node-2", cc.Nodes[1].Name)
+	assert.Equal(t, int64(4000), cc.Nodes[1].FreeCPU)
+}
@@ -15,9 +15,9 @@ import (
 
 type collectionResource int64
 
-func (cr collectionResource) makeScraperConfig(apiToken, token, namespace string,
+func (cr collectionResource) makeScraperConfig(apiToken, token, namespace, context string,
 	metricStorage []config.MetricStorage) (*apiv1.ConfigMap, error) {
-	pc, err := metrics.MakeScraperConfig(apiToken, token, int64(cr), namespace, metricStorage)
+	pc, err := metrics.MakeScraperConfig(apiToken, token, int64(cr), namespace, context, metricStorage)
 	if err != nil {
 		return nil, err
 	}
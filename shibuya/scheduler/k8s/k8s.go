@@ -17,6 +17,7 @@ import (
 	model "github.com/rakutentech/shibuya/shibuya/model"
 	serrors "github.com/rakutentech/shibuya/shibuya/scheduler/errors"
 	smodel "github.com/rakutentech/shibuya/shibuya/scheduler/model"
+	"github.com/rakutentech/shibuya/shibuya/scheduler/nodepool"
 	log "github.com/sirupsen/logrus"
 
 	apiv1 "k8s.io/api/core/v1"
@@ -33,6 +34,8 @@ type K8sClientManager struct {
 	Namespace             string
 	httpClient            *http.Client
 	CAPair                *config.CAPair
+	// nodePools is nil when the engines are not run in dedicated node pools
+	nodePools *nodepool.Manager
 }
 
 func NewK8sClientManager(cfg config.ShibuyaConfig) *K8sClientManager {
@@ -50,9 +53,15 @@ func NewK8sClientManager(cfg config.ShibuyaConfig) *K8sClientManager {
 			},
 		},
 	}
-	return &K8sClientManager{
-		cfg, c, "shibuya-coordinator", "shibuya-scraper", cfg.ExecutorConfig.Namespace, httpClient, cfg.CAPair,
+	kcm := &K8sClientManager{
+		cfg, c, "shibuya-coordinator", "shibuya-scraper", cfg.ExecutorConfig.Namespace, httpClient, cfg.CAPair, nil,
 	}
+	if cfg.ExecutorConfig.NodePool != nil {
+		if kcm.nodePools, err = nodepool.NewManager(cfg.ExecutorConfig); err != nil {
+			log.Fatal(err)
+		}
+	}
+	return kcm
 }
 
 func (kcm *K8sClientManager) getRandomHostIP() (string, error) {
@@ -75,6 +84,13 @@ func (kcm *K8sClientManager) getRandomHostIP() (string, error) {
 }
 
 func (kcm *K8sClientManager) DeployPlan(projectID, collectionID, planID int64, enginesNo int, serviceIP string, containerconfig *config.ExecutorContainer) error {
+	// the nodes are added before the engines, otherwise the engines would be pending until the cluster
+	// is scaled up
+	if kcm.nodePools != nil {
+		if err := kcm.nodePools.Reserve(collectionID, planID, enginesNo, containerconfig); err != nil {
+			return err
+		}
+	}
 	pr := planResource{projectID, collectionID, planID}
 	planSts := pr.makePlanDeployment(enginesNo, serviceIP, kcm.sc, containerconfig)
 	if _, err := kcm.client.AppsV1().StatefulSets(kcm.Namespace).Create(context.TODO(), planSts, metav1.CreateOptions{}); err != nil {
@@ -201,6 +217,12 @@ func (kcm *K8sClientManager) CollectionStatus(projectID, collectionID int64, eps
 		}
 		planStatuses[ep.PlanID] = ps
 	}
+	if kcm.nodePools != nil {
+		if np, err := kcm.nodePools.Status(collectionID); err == nil {
+			cs.PoolSize = np.Size
+			cs.PoolStatus = np.Status
+		}
+	}
 	for _, pod := range pods {
 		if pod.Labels["kind"] == smodel.Scraper {
 			if pod.Status.Phase == apiv1.PodRunning {
@@ -327,6 +349,11 @@ func (kcm *K8sClientManager) PurgeCollection(collectionID int64) error {
 		metav1.DeleteOptions{GracePeriodSeconds: new(int64)}, metav1.ListOptions{LabelSelector: label}); err != nil {
 		return err
 	}
+	if kcm.nodePools != nil {
+		if err := kcm.nodePools.Release(collectionID); err != nil {
+			return err
+		}
+	}
 	err := kcm.deleteService(collectionID)
 	if err != nil {
 		return err
@@ -359,7 +386,7 @@ func (kcm *K8sClientManager) CreateCollectionScraper(apiToken, token string, col
 	cr := collectionResource(collectionID)
 	promDeployment := cr.makeScraperDeployment(kcm.scraperServiceAccount, kcm.Namespace,
 		kcm.sc.ExecutorConfig.NodeAffinity, kcm.sc.ExecutorConfig.Tolerations, kcm.sc.ScraperContainer)
-	promConfig, err := cr.makeScraperConfig(apiToken, token, kcm.Namespace, kcm.sc.MetricStorage)
+	promConfig, err := cr.makeScraperConfig(apiToken, token, kcm.Namespace, kcm.sc.Context, kcm.sc.MetricStorage)
 	if err != nil {
 		return err
 	}
@@ -460,6 +487,18 @@ func (kcm *K8sClientManager) GetDeployedCollections() (map[int64]time.Time, erro
 		}
 		deployedCollections[collectionID] = pod.CreationTimestamp.Time
 	}
+	// the pools without engines are purged as well, like when the deployment failed
+	if kcm.nodePools != nil {
+		pools, err := kcm.nodePools.DeployedCollections()
+		if err != nil {
+			return nil, err
+		}
+		for collectionID, launchTime := range pools {
+			if _, ok := deployedCollections[collectionID]; !ok {
+				deployedCollections[collectionID] = launchTime
+			}
+		}
+	}
 	return deployedCollections, nil
 }
 
@@ -2,6 +2,7 @@ package k8s
 
 import (
 	"fmt"
+	"sort"
 
 	"github.com/rakutentech/shibuya/shibuya/config"
 	apiv1 "k8s.io/api/core/v1"
@@ -100,6 +101,19 @@ func makeHostAliases(preConfighostAliases []*config.HostAlias) []apiv1.HostAlias
 	return []apiv1.HostAlias{}
 }
 
+func makeEnvvars(env map[string]string) []apiv1.EnvVar {
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	envvars := make([]apiv1.EnvVar, len(names))
+	for i, name := range names {
+		envvars[i] = apiv1.EnvVar{Name: name, Value: env[name]}
+	}
+	return envvars
+}
+
 func makeCollectionLabel(collectionID int64) string {
 	return fmt.Sprintf("collection=%d", collectionID)
 }
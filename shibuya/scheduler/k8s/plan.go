@@ -7,6 +7,7 @@ import (
 
 	"github.com/rakutentech/shibuya/shibuya/config"
 	smodel "github.com/rakutentech/shibuya/shibuya/scheduler/model"
+	"github.com/rakutentech/shibuya/shibuya/scheduler/nodepool"
 	appsv1 "k8s.io/api/apps/v1"
 	apiv1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
@@ -21,10 +22,16 @@ type planResource struct {
 func (plan planResource) makePlanDeployment(replicas int, serviceIP string,
 	sc config.ShibuyaConfig, containerConfig *config.ExecutorContainer) *appsv1.StatefulSet {
 	planName := plan.makeName()
-	envvars := plan.makeEngineMetaEnvvars(serviceIP)
+	// the meta env comes last so it's not replaced by the env of the container
+	envvars := append(makeEnvvars(containerConfig.Env), plan.makeEngineMetaEnvvars(serviceIP)...)
 	labels := plan.makePlanLabel()
 	affinity := prepareAffinity(plan.collectionID, sc.ExecutorConfig.NodeAffinity)
 	tolerations := prepareTolerations(sc.ExecutorConfig.Tolerations)
+	if sc.ExecutorConfig.NodePool != nil {
+		collectionID := strconv.FormatInt(plan.collectionID, 10)
+		affinity.NodeAffinity = makeNodeAffinity(nodepool.CollectionLabel, collectionID)
+		tolerations = append(tolerations, makeTolerations(nodepool.CollectionLabel, collectionID, apiv1.TaintEffectNoSchedule))
+	}
 	t := true
 	sts := &appsv1.StatefulSet{
 		ObjectMeta: metav1.ObjectMeta{
@@ -47,6 +54,7 @@ func (plan planResource) makePlanDeployment(replicas int, serviceIP string,
 					Tolerations:                  tolerations,
 					AutomountServiceAccountToken: &t,
 					HostAliases:                  makeHostAliases(sc.ExecutorConfig.HostAliases),
+					NodeSelector:                 containerConfig.NodeSelector,
 					Containers: []apiv1.Container{
 						{
 							Name:            planName,
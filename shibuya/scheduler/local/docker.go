@@ -0,0 +1,149 @@
+package local
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// containerLabel marks the containers run by the local scheduler
+const containerLabel = "shibuya.local"
+
+// dockerRuntime runs the units as containers attached to the same network, so the engines and the
+// coordinator reach each other by their names. Only the coordinator is published to the controller.
+type dockerRuntime struct {
+	network string
+	mu      sync.Mutex
+	// networkReady is set once the network is found or created
+	networkReady bool
+}
+
+func docker(args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("docker", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("docker %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+func (dr *dockerRuntime) ensureNetwork() error {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	if dr.networkReady {
+		return nil
+	}
+	if _, err := docker("network", "inspect", dr.network); err != nil {
+		if _, err := docker("network", "create", dr.network); err != nil {
+			return err
+		}
+	}
+	dr.networkReady = true
+	return nil
+}
+
+func makeRunArgs(network string, s *spec) ([]string, error) {
+	args := []string{"run", "-d", "--name", s.name, "--network", network,
+		"--label", containerLabel, "-v", fmt.Sprintf("%s:%s", s.dir, s.dir),
+		"-e", fmt.Sprintf("listen_addr=:%d", s.port)}
+	names := make([]string, 0, len(s.env))
+	for k := range s.env {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		args = append(args, "-e", fmt.Sprintf("%s=%s", k, s.env[k]))
+	}
+	if s.cpu != "" {
+		cpu, err := resource.ParseQuantity(s.cpu)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "--cpus", fmt.Sprintf("%g", cpu.AsApproximateFloat64()))
+	}
+	if s.mem != "" {
+		mem, err := resource.ParseQuantity(s.mem)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "--memory", fmt.Sprintf("%d", mem.Value()))
+	}
+	if s.publish {
+		args = append(args, "-p", fmt.Sprintf("127.0.0.1::%d", s.port))
+	}
+	return append(args, s.image), nil
+}
+
+func (dr *dockerRuntime) run(s *spec) (handle, error) {
+	if err := dr.ensureNetwork(); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return nil, err
+	}
+	args, err := makeRunArgs(dr.network, s)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := docker(args...); err != nil {
+		return nil, err
+	}
+	return &dockerHandle{name: s.name, p: s.port}, nil
+}
+
+func (dr *dockerRuntime) cleanup() error {
+	ids, err := docker("ps", "-aq", "--filter", "label="+containerLabel)
+	if err != nil || ids == "" {
+		return err
+	}
+	_, err = docker(append([]string{"rm", "-f"}, strings.Fields(ids)...)...)
+	return err
+}
+
+type dockerHandle struct {
+	name string
+	p    int
+}
+
+func (h *dockerHandle) host() string {
+	return h.name
+}
+
+func (h *dockerHandle) port() int {
+	return h.p
+}
+
+func (h *dockerHandle) hostAddr() (string, error) {
+	out, err := docker("port", h.name, fmt.Sprintf("%d/tcp", h.p))
+	if err != nil {
+		return "", err
+	}
+	// the port could be published on both ipv4 and ipv6
+	return strings.Split(out, "\n")[0], nil
+}
+
+func (h *dockerHandle) running() bool {
+	out, err := docker("inspect", "-f", "{{.State.Running}}", h.name)
+	return err == nil && out == "true"
+}
+
+func (h *dockerHandle) logs() (string, error) {
+	out, err := exec.Command("docker", "logs", h.name).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("docker logs: %w", err)
+	}
+	return string(out), nil
+}
+
+func (h *dockerHandle) stop() error {
+	_, err := docker("rm", "-f", h.name)
+	return err
+}
@@ -0,0 +1,490 @@
+package local
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rakutentech/shibuya/shibuya/auth/keys"
+	"github.com/rakutentech/shibuya/shibuya/certmanager"
+	"github.com/rakutentech/shibuya/shibuya/config"
+	"github.com/rakutentech/shibuya/shibuya/coordinator/upstream"
+	model "github.com/rakutentech/shibuya/shibuya/model"
+	serrors "github.com/rakutentech/shibuya/shibuya/scheduler/errors"
+	smodel "github.com/rakutentech/shibuya/shibuya/scheduler/model"
+	log "github.com/sirupsen/logrus"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	enginePort      = 8080
+	coordinatorPort = 443
+	pubSubPort      = 2416
+	inventoryFile   = "inventory.json"
+)
+
+// unit is an engine or a coordinator run by the runtime
+type unit struct {
+	name         string
+	projectID    int64
+	collectionID int64
+	planID       int64
+	createdTime  time.Time
+	dir          string
+	handle       handle
+}
+
+type coordinator struct {
+	unit
+	apiKey string
+}
+
+// Local runs the engines and the coordinators on the controller host, either as processes or as
+// containers. It keeps the units in memory, so the units left by a previous controller are removed
+// when it starts. There is no scraper, so the metrics of the engines are not pushed to the metric
+// storage.
+type Local struct {
+	sc           config.ShibuyaConfig
+	workDir      string
+	runtime      runtime
+	mu           sync.RWMutex
+	engines      map[string]*unit
+	coordinators map[int64]*coordinator
+}
+
+func NewLocal(sc config.ShibuyaConfig) *Local {
+	cfg := sc.ExecutorConfig.Local
+	rt, err := newRuntime(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := rt.cleanup(); err != nil {
+		log.Warn(err)
+	}
+	return newLocal(sc, cfg.WorkDir, rt)
+}
+
+func newLocal(sc config.ShibuyaConfig, workDir string, rt runtime) *Local {
+	return &Local{
+		sc:           sc,
+		workDir:      workDir,
+		runtime:      rt,
+		engines:      make(map[string]*unit),
+		coordinators: make(map[int64]*coordinator),
+	}
+}
+
+func makeEngineName(projectID, collectionID, planID int64, engineID int) string {
+	return fmt.Sprintf("engine-%d-%d-%d-%d", projectID, collectionID, planID, engineID)
+}
+
+func makeCoordinatorName(projectID int64) string {
+	return fmt.Sprintf("ig-%d", projectID)
+}
+
+func (u *unit) addr() string {
+	return fmt.Sprintf("%s:%d", u.handle.host(), u.handle.port())
+}
+
+func (u *unit) labels(kind string) map[string]string {
+	return map[string]string{
+		"collection": strconv.FormatInt(u.collectionID, 10),
+		"project":    strconv.FormatInt(u.projectID, 10),
+		"plan":       strconv.FormatInt(u.planID, 10),
+		"kind":       kind,
+	}
+}
+
+func (u *unit) status() string {
+	if u.handle.running() {
+		return string(apiv1.PodRunning)
+	}
+	return string(apiv1.PodFailed)
+}
+
+func (l *Local) getCoordinator(projectID int64) (*coordinator, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	cdr, ok := l.coordinators[projectID]
+	if !ok {
+		return nil, fmt.Errorf("coordinator of project %d is not running", projectID)
+	}
+	return cdr, nil
+}
+
+// enginesBy returns the engines matching the filter sorted by their names
+func (l *Local) enginesBy(filter func(u *unit) bool) []*unit {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	r := []*unit{}
+	for _, u := range l.engines {
+		if filter(u) {
+			r = append(r, u)
+		}
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i].name < r[j].name })
+	return r
+}
+
+func (l *Local) getEnginesByCollection(collectionID int64) []*unit {
+	return l.enginesBy(func(u *unit) bool { return u.collectionID == collectionID })
+}
+
+// writeInventory lists the running engines of the project for its coordinator. The engines of a
+// collection are only listed when all of them are running.
+func (l *Local) writeInventory(projectID int64) error {
+	cdr, err := l.getCoordinator(projectID)
+	if err != nil {
+		return err
+	}
+	engines := l.enginesBy(func(u *unit) bool { return u.projectID == projectID })
+	notReady := make(map[int64]bool)
+	for _, e := range engines {
+		if !e.handle.running() {
+			notReady[e.collectionID] = true
+		}
+	}
+	endpoints := []upstream.FileEndpoint{}
+	for _, e := range engines {
+		if notReady[e.collectionID] {
+			continue
+		}
+		endpoints = append(endpoints, upstream.FileEndpoint{
+			CollectionID: strconv.FormatInt(e.collectionID, 10),
+			PlanID:       strconv.FormatInt(e.planID, 10),
+			Engine:       e.name,
+			Addr:         e.addr(),
+		})
+	}
+	return upstream.WriteInventoryFile(filepath.Join(cdr.dir, inventoryFile), endpoints)
+}
+
+func (l *Local) DeployPlan(projectID, collectionID, planID int64, replicas int, serviceIP string, containerConfig *config.ExecutorContainer) error {
+	cdr, err := l.getCoordinator(projectID)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < replicas; i++ {
+		name := makeEngineName(projectID, collectionID, planID, i)
+		l.mu.RLock()
+		_, ok := l.engines[name]
+		l.mu.RUnlock()
+		// the deployment is retried when any of the engines fails
+		if ok {
+			continue
+		}
+		s := &spec{
+			name:    name,
+			image:   containerConfig.Image,
+			command: containerConfig.Command,
+			cpu:     containerConfig.CPU,
+			mem:     containerConfig.Mem,
+			dir:     filepath.Join(l.workDir, name),
+			port:    enginePort,
+			env: map[string]string{
+				"collection_id":           strconv.FormatInt(collectionID, 10),
+				"plan_id":                 strconv.FormatInt(planID, 10),
+				"coordinator_ip":          serviceIP,
+				"coordinator_pubsub_addr": fmt.Sprintf("%s:%d", cdr.handle.host(), pubSubPort),
+				"engine_name":             name,
+				"api_key":                 cdr.apiKey,
+			},
+		}
+		// the env of the container cannot replace the ones set by shibuya
+		for k, v := range containerConfig.Env {
+			if _, ok := s.env[k]; !ok {
+				s.env[k] = v
+			}
+		}
+		h, err := l.runtime.run(s)
+		if err != nil {
+			return err
+		}
+		l.mu.Lock()
+		l.engines[name] = &unit{
+			name:         name,
+			projectID:    projectID,
+			collectionID: collectionID,
+			planID:       planID,
+			createdTime:  time.Now(),
+			dir:          s.dir,
+			handle:       h,
+		}
+		l.mu.Unlock()
+	}
+	return l.writeInventory(projectID)
+}
+
+func (l *Local) CollectionStatus(projectID, collectionID int64, eps []*model.ExecutionPlan) (*smodel.CollectionStatus, error) {
+	// there is no scraper to wait for
+	cs := &smodel.CollectionStatus{ScraperDeployed: true}
+	planStatuses := make(map[int64]*smodel.PlanStatus)
+	for _, ep := range eps {
+		ps := &smodel.PlanStatus{
+			PlanID:  ep.PlanID,
+			Engines: ep.Engines,
+		}
+		planStatuses[ep.PlanID] = ps
+		cs.Plans = append(cs.Plans, ps)
+	}
+	for _, e := range l.getEnginesByCollection(collectionID) {
+		ps, ok := planStatuses[e.planID]
+		if !ok {
+			log.Error("Could not find running engine in ExecutionPlan")
+			continue
+		}
+		if e.handle.running() {
+			ps.EnginesDeployed += 1
+		}
+	}
+	// engines can exit by themselves, so the inventory is kept up to date by the status checks
+	if err := l.writeInventory(projectID); err != nil {
+		log.Debug(err)
+	}
+	return cs, nil
+}
+
+func (l *Local) CreateCollectionScraper(apiToken, token string, collectionID int64) error {
+	return nil
+}
+
+func (l *Local) FetchEngineUrlsByPlan(collectionID, planID int64, opts *smodel.EngineOwnerRef) ([]string, error) {
+	collectionUrl, err := l.GetIngressUrl(opts.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+	urls := []string{}
+	for i := 0; i < opts.EnginesCount; i++ {
+		urls = append(urls, fmt.Sprintf("%s/%s", collectionUrl, makeEngineName(opts.ProjectID, collectionID, planID, i)))
+	}
+	return urls, nil
+}
+
+func removeUnit(u *unit) error {
+	err := u.handle.stop()
+	if err := os.RemoveAll(u.dir); err != nil {
+		log.Error(err)
+	}
+	return err
+}
+
+func (l *Local) PurgeCollection(collectionID int64) error {
+	engines := l.getEnginesByCollection(collectionID)
+	// If there are any errors in deletion, we only return the last one
+	var lastError error
+	projects := make(map[int64]struct{})
+	for _, e := range engines {
+		if err := removeUnit(e); err != nil {
+			lastError = err
+		}
+		l.mu.Lock()
+		delete(l.engines, e.name)
+		l.mu.Unlock()
+		projects[e.projectID] = struct{}{}
+	}
+	for projectID := range projects {
+		if err := l.writeInventory(projectID); err != nil {
+			log.Debug(err)
+		}
+	}
+	return lastError
+}
+
+func (l *Local) GetDeployedCollections() (map[int64]time.Time, error) {
+	deployedCollections := make(map[int64]time.Time)
+	for _, e := range l.enginesBy(func(*unit) bool { return true }) {
+		deployedCollections[e.collectionID] = e.createdTime
+	}
+	return deployedCollections, nil
+}
+
+func (l *Local) PodReadyCount(collectionID int64) int {
+	ready := 0
+	for _, e := range l.getEnginesByCollection(collectionID) {
+		if e.handle.running() {
+			ready++
+		}
+	}
+	return ready
+}
+
+func (l *Local) DownloadPodLog(collectionID, planID int64) (string, error) {
+	engines := l.enginesBy(func(u *unit) bool {
+		return u.collectionID == collectionID && u.planID == planID
+	})
+	if len(engines) == 0 {
+		return "", fmt.Errorf("Cannot find engine for the plan %d", planID)
+	}
+	return engines[0].handle.logs()
+}
+
+func (l *Local) GetCollectionEnginesDetail(projectID, collectionID int64) (*smodel.CollectionDetails, error) {
+	engines := l.getEnginesByCollection(collectionID)
+	if len(engines) == 0 {
+		return nil, &serrors.NoResourcesFoundErr{Message: "Cannot find the engines"}
+	}
+	collectionDetails := &smodel.CollectionDetails{ControllerReplicas: 1}
+	ingressUrl, err := l.GetIngressUrl(projectID)
+	if err != nil {
+		collectionDetails.IngressIP = err.Error()
+	} else {
+		collectionDetails.IngressIP = ingressUrl
+	}
+	for _, e := range engines {
+		collectionDetails.Engines = append(collectionDetails.Engines, &smodel.EngineStatus{
+			Name:        e.name,
+			Status:      e.status(),
+			CreatedTime: e.createdTime,
+		})
+	}
+	return collectionDetails, nil
+}
+
+func (l *Local) GetDeployedServices() (map[int64]time.Time, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	deployedServices := make(map[int64]time.Time)
+	for projectID, cdr := range l.coordinators {
+		deployedServices[projectID] = cdr.createdTime
+	}
+	return deployedServices, nil
+}
+
+// writeCertKey writes the cert of the coordinator, which is reached by the controller at localhost
+func (l *Local) writeCertKey(projectID int64, dir string) error {
+	cert, key, err := certmanager.GenCertAndKey(l.sc.CAPair, projectID, "127.0.0.1")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	// the coordinator could be run by another user in the container
+	if err := os.WriteFile(filepath.Join(dir, "tls.crt"), cert, 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "tls.key"), key, 0644)
+}
+
+func (l *Local) ExposeProject(projectID int64) (*apiv1.Service, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	name := makeCoordinatorName(projectID)
+	cdr, ok := l.coordinators[projectID]
+	if !ok {
+		apiKey, err := keys.GenerateAPIKey()
+		if err != nil {
+			return nil, err
+		}
+		dir := filepath.Join(l.workDir, name)
+		tlsDir := filepath.Join(dir, "tls")
+		if err := l.writeCertKey(projectID, tlsDir); err != nil {
+			return nil, err
+		}
+		inventory := filepath.Join(dir, inventoryFile)
+		if err := upstream.WriteInventoryFile(inventory, nil); err != nil {
+			return nil, err
+		}
+		igCfg := l.sc.IngressConfig
+		h, err := l.runtime.run(&spec{
+			name:    name,
+			image:   igCfg.Image,
+			command: igCfg.Command,
+			cpu:     igCfg.CPU,
+			mem:     igCfg.Mem,
+			dir:     dir,
+			port:    coordinatorPort,
+			publish: true,
+			env: map[string]string{
+				"project_id":     strconv.FormatInt(projectID, 10),
+				"api_key":        apiKey,
+				"tls_dir":        tlsDir,
+				"inventory_file": inventory,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		cdr = &coordinator{
+			unit: unit{
+				name:        name,
+				projectID:   projectID,
+				createdTime: time.Now(),
+				dir:         dir,
+				handle:      h,
+			},
+			apiKey: apiKey,
+		}
+		l.coordinators[projectID] = cdr
+	}
+	return &apiv1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: cdr.labels(smodel.IngressController),
+		},
+		Spec: apiv1.ServiceSpec{
+			// this is the address the engines reach the coordinator at
+			ClusterIP: cdr.addr(),
+		},
+	}, nil
+}
+
+func (l *Local) PurgeProjectIngress(projectID int64) error {
+	l.mu.Lock()
+	cdr, ok := l.coordinators[projectID]
+	delete(l.coordinators, projectID)
+	l.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return removeUnit(&cdr.unit)
+}
+
+func (l *Local) GetEnginesByProject(projectID int64) ([]apiv1.Pod, error) {
+	engines := l.enginesBy(func(u *unit) bool { return u.projectID == projectID })
+	pods := make([]apiv1.Pod, len(engines))
+	for i, e := range engines {
+		pods[i] = apiv1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              e.name,
+				Labels:            e.labels(smodel.Executor),
+				CreationTimestamp: metav1.NewTime(e.createdTime),
+			},
+			Status: apiv1.PodStatus{Phase: apiv1.PodPhase(e.status())},
+		}
+	}
+	sort.Slice(pods, func(i, j int) bool {
+		return pods[i].CreationTimestamp.Time.After(pods[j].CreationTimestamp.Time)
+	})
+	return pods, nil
+}
+
+func (l *Local) GetIngressUrl(projectID int64) (string, error) {
+	cdr, err := l.getCoordinator(projectID)
+	if err != nil {
+		return "", serrors.MakeSchedulerIngressError(err)
+	}
+	addr, err := cdr.handle.hostAddr()
+	if err != nil {
+		return "", serrors.MakeSchedulerIngressError(err)
+	}
+	return addr, nil
+}
+
+func (l *Local) GetProjectAPIKey(projectID int64) (string, error) {
+	cdr, err := l.getCoordinator(projectID)
+	if err != nil {
+		return "", err
+	}
+	return cdr.apiKey, nil
+}
+
+// GetCapacity is not limited as the engines run on the controller host
+func (l *Local) GetCapacity() (*smodel.ClusterCapacity, error) {
+	return nil, nil
+}
@@ -0,0 +1,214 @@
+package local
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rakutentech/shibuya/shibuya/config"
+	"github.com/rakutentech/shibuya/shibuya/coordinator/upstream"
+	"github.com/rakutentech/shibuya/shibuya/model"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeHandle struct {
+	name    string
+	p       int
+	stopped bool
+}
+
+func (h *fakeHandle) host() string              { return h.name }
+func (h *fakeHandle) port() int                 { return h.p }
+func (h *fakeHandle) hostAddr() (string, error) { return fmt.Sprintf("127.0.0.1:%d", h.p), nil }
+func (h *fakeHandle) running() bool             { return !h.stopped }
+func (h *fakeHandle) logs() (string, error)     { return "log of " + h.name, nil }
+func (h *fakeHandle) stop() error {
+	h.stopped = true
+	return nil
+}
+
+type fakeRuntime struct {
+	specs   map[string]*spec
+	handles map[string]*fakeHandle
+}
+
+func (fr *fakeRuntime) run(s *spec) (handle, error) {
+	fr.specs[s.name] = s
+	h := &fakeHandle{name: s.name, p: s.port}
+	fr.handles[s.name] = h
+	return h, nil
+}
+
+func (fr *fakeRuntime) cleanup() error {
+	return nil
+}
+
+func makeCAPair(t *testing.T) *config.CAPair {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "shibuya-ca"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	return &config.CAPair{Cert: cert, PrivateKey: key}
+}
+
+func readInventory(t *testing.T, file string) []upstream.FileEndpoint {
+	raw, err := os.ReadFile(file)
+	assert.Nil(t, err)
+	var endpoints []upstream.FileEndpoint
+	assert.Nil(t, json.Unmarshal(raw, &endpoints))
+	return endpoints
+}
+
+func TestLocal(t *testing.T) {
+	workDir := t.TempDir()
+	rt := &fakeRuntime{specs: make(map[string]*spec), handles: make(map[string]*fakeHandle)}
+	sc := config.ShibuyaConfig{
+		CAPair:        makeCAPair(t),
+		IngressConfig: &config.IngressConfig{Image: "shibuya:coordinator"},
+	}
+	l := newLocal(sc, workDir, rt)
+
+	container := &config.ExecutorContainer{Image: "shibuya:jmeter", CPU: "1", Mem: "1Gi"}
+	assert.NotNil(t, l.DeployPlan(1, 2, 3, 2, "ig-1:443", container))
+
+	service, err := l.ExposeProject(1)
+	assert.Nil(t, err)
+	assert.Equal(t, "ig-1:443", service.Spec.ClusterIP)
+	cdrSpec := rt.specs["ig-1"]
+	assert.True(t, cdrSpec.publish)
+	assert.FileExists(t, filepath.Join(cdrSpec.env["tls_dir"], "tls.crt"))
+	apiKey, err := l.GetProjectAPIKey(1)
+	assert.Nil(t, err)
+	assert.Equal(t, apiKey, cdrSpec.env["api_key"])
+	ingressUrl, err := l.GetIngressUrl(1)
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.1:443", ingressUrl)
+	// exposing the project again reuses the coordinator
+	_, err = l.ExposeProject(1)
+	assert.Nil(t, err)
+	assert.Len(t, rt.specs, 1)
+
+	assert.Nil(t, l.DeployPlan(1, 2, 3, 2, service.Spec.ClusterIP, container))
+	assert.Nil(t, l.DeployPlan(1, 2, 4, 1, service.Spec.ClusterIP, container))
+	engineSpec := rt.specs["engine-1-2-3-1"]
+	assert.Equal(t, "ig-1:443", engineSpec.env["coordinator_ip"])
+	assert.Equal(t, "ig-1:2416", engineSpec.env["coordinator_pubsub_addr"])
+	assert.Equal(t, "engine-1-2-3-1", engineSpec.env["engine_name"])
+	assert.Equal(t, apiKey, engineSpec.env["api_key"])
+	assert.Equal(t, []upstream.FileEndpoint{
+		{CollectionID: "2", PlanID: "3", Engine: "engine-1-2-3-0", Addr: "engine-1-2-3-0:8080"},
+		{CollectionID: "2", PlanID: "3", Engine: "engine-1-2-3-1", Addr: "engine-1-2-3-1:8080"},
+		{CollectionID: "2", PlanID: "4", Engine: "engine-1-2-4-0", Addr: "engine-1-2-4-0:8080"},
+	}, readInventory(t, cdrSpec.env["inventory_file"]))
+
+	eps := []*model.ExecutionPlan{{PlanID: 3, Engines: 2}, {PlanID: 4, Engines: 1}}
+	cs, err := l.CollectionStatus(1, 2, eps)
+	assert.Nil(t, err)
+	assert.True(t, cs.ScraperDeployed)
+	assert.Equal(t, 2, cs.Plans[0].EnginesDeployed)
+	assert.Equal(t, 1, cs.Plans[1].EnginesDeployed)
+	assert.Equal(t, 3, l.PodReadyCount(2))
+
+	// an engine exits by itself
+	rt.handles["engine-1-2-4-0"].stopped = true
+	cs, err = l.CollectionStatus(1, 2, eps)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, cs.Plans[1].EnginesDeployed)
+	assert.Empty(t, readInventory(t, cdrSpec.env["inventory_file"]))
+
+	logs, err := l.DownloadPodLog(2, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, "log of engine-1-2-3-0", logs)
+	details, err := l.GetCollectionEnginesDetail(1, 2)
+	assert.Nil(t, err)
+	assert.Len(t, details.Engines, 3)
+	assert.Equal(t, "Failed", details.Engines[2].Status)
+	pods, err := l.GetEnginesByProject(1)
+	assert.Nil(t, err)
+	assert.Len(t, pods, 3)
+	assert.Equal(t, "2", pods[0].Labels["collection"])
+
+	assert.Nil(t, l.PurgeCollection(2))
+	assert.True(t, rt.handles["engine-1-2-3-0"].stopped)
+	deployed, err := l.GetDeployedCollections()
+	assert.Nil(t, err)
+	assert.Empty(t, deployed)
+	_, err = l.GetCollectionEnginesDetail(1, 2)
+	assert.NotNil(t, err)
+
+	assert.Nil(t, l.PurgeProjectIngress(1))
+	assert.True(t, rt.handles["ig-1"].stopped)
+	services, err := l.GetDeployedServices()
+	assert.Nil(t, err)
+	assert.Empty(t, services)
+	assert.NoDirExists(t, filepath.Join(workDir, "ig-1"))
+}
+
+func TestMakeRunArgs(t *testing.T) {
+	s := &spec{
+		name:    "ig-1",
+		image:   "shibuya:coordinator",
+		env:     map[string]string{"project_id": "1", "api_key": "key"},
+		cpu:     "500m",
+		mem:     "1Gi",
+		dir:     "/tmp/ig-1",
+		port:    443,
+		publish: true,
+	}
+	args, err := makeRunArgs("shibuya", s)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"run", "-d", "--name", "ig-1", "--network", "shibuya",
+		"--label", containerLabel, "-v", "/tmp/ig-1:/tmp/ig-1", "-e", "listen_addr=:443",
+		"-e", "api_key=key", "-e", "project_id=1", "--cpus", "0.5", "--memory", "1073741824",
+		"-p", "127.0.0.1::443", "shibuya:coordinator"}, args)
+
+	s.cpu = "one"
+	_, err = makeRunArgs("shibuya", s)
+	assert.NotNil(t, err)
+}
+
+func TestProcessRuntime(t *testing.T) {
+	dir := t.TempDir()
+	_, err := processRuntime{}.run(&spec{name: "engine-1-2-3-0", dir: dir})
+	assert.NotNil(t, err)
+
+	h, err := processRuntime{}.run(&spec{
+		name:    "engine-1-2-3-0",
+		command: []string{"sh", "-c", "echo $engine_name $listen_addr $AGENT_ROOT; exec sleep 60"},
+		env:     map[string]string{"engine_name": "engine-1-2-3-0"},
+		dir:     dir,
+	})
+	assert.Nil(t, err)
+	assert.True(t, h.running())
+	assert.DirExists(t, filepath.Join(dir, "test-data"))
+	addr, err := h.hostAddr()
+	assert.Nil(t, err)
+	assert.Equal(t, fmt.Sprintf("127.0.0.1:%d", h.port()), addr)
+	expected := fmt.Sprintf("engine-1-2-3-0 %s %s\n", addr, dir)
+	assert.Eventually(t, func() bool {
+		logs, err := h.logs()
+		return err == nil && logs == expected
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Nil(t, h.stop())
+	assert.False(t, h.running())
+	assert.Nil(t, h.stop())
+}
@@ -0,0 +1,170 @@
+package local
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+
+	"github.com/rakutentech/shibuya/shibuya/config"
+)
+
+const (
+	processRuntimeKind = "process"
+	dockerRuntimeKind  = "docker"
+	logFileName        = "output.log"
+)
+
+// spec is a unit run by the runtime, either an engine or a coordinator
+type spec struct {
+	name    string
+	image   string
+	command []string
+	env     map[string]string
+	cpu     string
+	mem     string
+	// dir is the work dir of the unit. Containers have it mounted at the same path.
+	dir string
+	// port is the port the unit serves on in its container. Processes are given a free port instead.
+	port int
+	// publish exposes the port to the controller
+	publish bool
+}
+
+type runtime interface {
+	run(s *spec) (handle, error)
+	// cleanup removes the units left by a previous controller
+	cleanup() error
+}
+
+type handle interface {
+	// host is the host the other units reach the unit at
+	host() string
+	// port is the port the unit serves on
+	port() int
+	// hostAddr is the address the controller reaches the unit at
+	hostAddr() (string, error)
+	running() bool
+	logs() (string, error)
+	stop() error
+}
+
+func newRuntime(cfg *config.LocalConfig) (runtime, error) {
+	switch cfg.Runtime {
+	case processRuntimeKind:
+		return processRuntime{}, nil
+	case dockerRuntimeKind:
+		return &dockerRuntime{network: cfg.Network}, nil
+	}
+	return nil, fmt.Errorf("local scheduler does not support %s as runtime", cfg.Runtime)
+}
+
+// processRuntime runs the units as child processes of the controller. Every unit gets its own agent
+// root, so several engines can run on the same host.
+type processRuntime struct{}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+func (processRuntime) run(s *spec) (handle, error) {
+	if len(s.command) == 0 {
+		return nil, fmt.Errorf("%s needs a command to run as a process", s.name)
+	}
+	port, err := freePort()
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range []string{"test-conf", "test-result", "test-data"} {
+		if err := os.MkdirAll(filepath.Join(s.dir, d), 0755); err != nil {
+			return nil, err
+		}
+	}
+	logFile, err := os.Create(filepath.Join(s.dir, logFileName))
+	if err != nil {
+		return nil, err
+	}
+	defer logFile.Close()
+	cmd := exec.Command(s.command[0], s.command[1:]...)
+	cmd.Dir = s.dir
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.Env = os.Environ()
+	for k, v := range s.env {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, v))
+	}
+	cmd.Env = append(cmd.Env,
+		fmt.Sprintf("listen_addr=127.0.0.1:%d", port),
+		fmt.Sprintf("AGENT_ROOT=%s", s.dir),
+		fmt.Sprintf("TEST_DATA_DIR=%s", filepath.Join(s.dir, "test-data")),
+	)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	h := &processHandle{cmd: cmd, p: port, logFile: logFile.Name(), done: make(chan struct{})}
+	go func() {
+		cmd.Wait()
+		close(h.done)
+	}()
+	return h, nil
+}
+
+func (processRuntime) cleanup() error {
+	// the processes are killed together with the controller
+	return nil
+}
+
+type processHandle struct {
+	cmd     *exec.Cmd
+	p       int
+	logFile string
+	done    chan struct{}
+	once    sync.Once
+}
+
+func (h *processHandle) host() string {
+	return "127.0.0.1"
+}
+
+func (h *processHandle) port() int {
+	return h.p
+}
+
+func (h *processHandle) hostAddr() (string, error) {
+	return fmt.Sprintf("%s:%d", h.host(), h.p), nil
+}
+
+func (h *processHandle) running() bool {
+	select {
+	case <-h.done:
+		return false
+	default:
+		return true
+	}
+}
+
+func (h *processHandle) logs() (string, error) {
+	content, err := os.ReadFile(h.logFile)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+func (h *processHandle) stop() error {
+	var err error
+	h.once.Do(func() {
+		if h.running() {
+			err = h.cmd.Process.Kill()
+		}
+		<-h.done
+	})
+	return err
+}
@@ -53,10 +53,28 @@ type NodesInfo struct {
 
 type AllNodesInfo map[string]*NodesInfo
 
+// NodeCapacity is a node the engines can run on, with its allocatable cpu in millicores and memory
+// in bytes, and the part of them not requested by the pods on the node
+type NodeCapacity struct {
+	Name    string            `json:"name"`
+	Labels  map[string]string `json:"labels"`
+	CPU     int64             `json:"cpu"`
+	Mem     int64             `json:"mem"`
+	FreeCPU int64             `json:"free_cpu"`
+	FreeMem int64             `json:"free_mem"`
+}
+
+type ClusterCapacity struct {
+	Nodes []*NodeCapacity `json:"nodes"`
+	// PendingPods are the engines waiting for a node, so they take the free capacity first
+	PendingPods int `json:"pending_pods"`
+}
+
 type EngineStatus struct {
 	Name        string    `json:"name"`
 	Status      string    `json:"status"`
 	CreatedTime time.Time `json:"created_time"`
+	Context     string    `json:"context"`
 }
 
 type CollectionDetails struct {
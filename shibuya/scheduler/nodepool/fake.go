@@ -0,0 +1,71 @@
+package nodepool
+
+import (
+	"sort"
+	"sync"
+)
+
+// Fake keeps the node pools in memory. It's used by the tests.
+type Fake struct {
+	mu    sync.Mutex
+	Pools map[string]*NodePool
+}
+
+func NewFake() *Fake {
+	return &Fake{Pools: make(map[string]*NodePool)}
+}
+
+func (f *Fake) GetNodePool(name string) (*NodePool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	np, ok := f.Pools[name]
+	if !ok {
+		return nil, ErrNodePoolNotFound
+	}
+	copied := *np
+	return &copied, nil
+}
+
+func (f *Fake) ListNodePools() ([]*NodePool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	pools := []*NodePool{}
+	for _, np := range f.Pools {
+		copied := *np
+		pools = append(pools, &copied)
+	}
+	sort.Slice(pools, func(i, j int) bool {
+		return pools[i].Name < pools[j].Name
+	})
+	return pools, nil
+}
+
+func (f *Fake) CreateNodePool(np *NodePool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	created := *np
+	created.Status = "RUNNING"
+	f.Pools[np.Name] = &created
+	return nil
+}
+
+func (f *Fake) ResizeNodePool(name string, size int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	np, ok := f.Pools[name]
+	if !ok {
+		return ErrNodePoolNotFound
+	}
+	np.Size = size
+	return nil
+}
+
+func (f *Fake) DeleteNodePool(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.Pools[name]; !ok {
+		return ErrNodePoolNotFound
+	}
+	delete(f.Pools, name)
+	return nil
+}
@@ -0,0 +1,196 @@
+package nodepool
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rakutentech/shibuya/shibuya/config"
+	apiv1 "k8s.io/api/core/v1"
+
+	compute "google.golang.org/api/compute/v1"
+	container "google.golang.org/api/container/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// gkeOperationTimeout is how long we wait for the creation or the resizing of a pool. GKE only runs
+// one operation of a cluster at a time, so we wait for them to be done.
+var gkeOperationTimeout = 15 * time.Minute
+var gkeOperationInterval = 5 * time.Second
+
+var gkeTaintEffects = map[apiv1.TaintEffect]string{
+	apiv1.TaintEffectNoSchedule:       "NO_SCHEDULE",
+	apiv1.TaintEffectPreferNoSchedule: "PREFER_NO_SCHEDULE",
+	apiv1.TaintEffectNoExecute:        "NO_EXECUTE",
+}
+
+type GKE struct {
+	cs *container.Service
+	ce *compute.Service
+	// cluster is projects/<project>/locations/<zone>/clusters/<cluster_id>
+	cluster     string
+	location    string
+	machineType string
+	diskSizeGb  int64
+}
+
+func NewGKE(cfg *config.ExecutorConfig) (*GKE, error) {
+	ctx := context.Background()
+	cs, err := container.NewService(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ce, err := compute.NewService(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newGKE(cfg, cs, ce), nil
+}
+
+func newGKE(cfg *config.ExecutorConfig, cs *container.Service, ce *compute.Service) *GKE {
+	cluster := cfg.Cluster
+	return &GKE{
+		cs:          cs,
+		ce:          ce,
+		cluster:     fmt.Sprintf("projects/%s/locations/%s/clusters/%s", cluster.Project, cluster.Zone, cluster.ClusterID),
+		location:    fmt.Sprintf("projects/%s/locations/%s", cluster.Project, cluster.Zone),
+		machineType: cfg.NodePool.MachineType,
+		diskSizeGb:  cfg.NodePool.DiskSizeGb,
+	}
+}
+
+func (g *GKE) makePoolName(name string) string {
+	return fmt.Sprintf("%s/nodePools/%s", g.cluster, name)
+}
+
+func isNotFound(err error) bool {
+	apiErr, ok := err.(*googleapi.Error)
+	return ok && apiErr.Code == http.StatusNotFound
+}
+
+// targetSize sums the sizes of the instance groups of the pool, as GKE only tells the initial size
+func (g *GKE) targetSize(np *container.NodePool) (int, error) {
+	size := 0
+	for _, u := range np.InstanceGroupUrls {
+		// https://www.googleapis.com/compute/v1/projects/<project>/zones/<zone>/instanceGroupManagers/<name>
+		parts := strings.Split(u, "/")
+		if len(parts) < 6 {
+			return 0, fmt.Errorf("Invalid instance group url %s", u)
+		}
+		n := len(parts)
+		igm, err := g.ce.InstanceGroupManagers.Get(parts[n-5], parts[n-3], parts[n-1]).Do()
+		if err != nil {
+			return 0, err
+		}
+		size += int(igm.TargetSize)
+	}
+	return size, nil
+}
+
+func (g *GKE) makeNodePool(np *container.NodePool) (*NodePool, error) {
+	size, err := g.targetSize(np)
+	if err != nil {
+		return nil, err
+	}
+	return &NodePool{
+		Name:   np.Name,
+		Size:   size,
+		Status: np.Status,
+	}, nil
+}
+
+func (g *GKE) GetNodePool(name string) (*NodePool, error) {
+	np, err := g.cs.Projects.Locations.Clusters.NodePools.Get(g.makePoolName(name)).Do()
+	if err != nil {
+		if isNotFound(err) {
+			return nil, ErrNodePoolNotFound
+		}
+		return nil, err
+	}
+	return g.makeNodePool(np)
+}
+
+// ListNodePools does not return the sizes of the pools
+func (g *GKE) ListNodePools() ([]*NodePool, error) {
+	resp, err := g.cs.Projects.Locations.Clusters.NodePools.List(g.cluster).Do()
+	if err != nil {
+		return nil, err
+	}
+	pools := make([]*NodePool, len(resp.NodePools))
+	for i, np := range resp.NodePools {
+		pools[i] = &NodePool{Name: np.Name, Status: np.Status}
+	}
+	return pools, nil
+}
+
+func (g *GKE) CreateNodePool(np *NodePool) error {
+	taints := []*container.NodeTaint{}
+	for _, t := range np.Taints {
+		taints = append(taints, &container.NodeTaint{
+			Key:    t.Key,
+			Value:  t.Value,
+			Effect: gkeTaintEffects[t.Effect],
+		})
+	}
+	req := &container.CreateNodePoolRequest{
+		NodePool: &container.NodePool{
+			Name:             np.Name,
+			InitialNodeCount: int64(np.Size),
+			Config: &container.NodeConfig{
+				MachineType: g.machineType,
+				DiskSizeGb:  g.diskSizeGb,
+				Labels:      np.Labels,
+				Taints:      taints,
+			},
+		},
+	}
+	op, err := g.cs.Projects.Locations.Clusters.NodePools.Create(g.cluster, req).Do()
+	if err != nil {
+		return err
+	}
+	return g.waitForOperation(op)
+}
+
+func (g *GKE) ResizeNodePool(name string, size int) error {
+	req := &container.SetNodePoolSizeRequest{NodeCount: int64(size)}
+	op, err := g.cs.Projects.Locations.Clusters.NodePools.SetSize(g.makePoolName(name), req).Do()
+	if err != nil {
+		if isNotFound(err) {
+			return ErrNodePoolNotFound
+		}
+		return err
+	}
+	return g.waitForOperation(op)
+}
+
+// DeleteNodePool does not wait for the deletion, as nothing waits for the pool to be gone
+func (g *GKE) DeleteNodePool(name string) error {
+	if _, err := g.cs.Projects.Locations.Clusters.NodePools.Delete(g.makePoolName(name)).Do(); err != nil {
+		if isNotFound(err) {
+			return ErrNodePoolNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+func (g *GKE) waitForOperation(op *container.Operation) error {
+	name := fmt.Sprintf("%s/operations/%s", g.location, op.Name)
+	deadline := time.Now().Add(gkeOperationTimeout)
+	for op.Status != "DONE" {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Operation %s of the node pool is not done in %s", op.Name, gkeOperationTimeout)
+		}
+		time.Sleep(gkeOperationInterval)
+		var err error
+		if op, err = g.cs.Projects.Locations.Operations.Get(name).Do(); err != nil {
+			return err
+		}
+	}
+	if op.Error != nil {
+		return fmt.Errorf("Operation %s of the node pool failed: %s", op.Name, op.Error.Message)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package nodepool
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rakutentech/shibuya/shibuya/config"
+	"github.com/stretchr/testify/assert"
+
+	compute "google.golang.org/api/compute/v1"
+	container "google.golang.org/api/container/v1"
+	"google.golang.org/api/option"
+)
+
+// fakeGKE serves the part of the GKE and the Compute Engine APIs used by the provider. The
+// operations are done when they are polled once.
+type fakeGKE struct {
+	mu    sync.Mutex
+	pools map[string]*container.NodePool
+	sizes map[string]int64
+}
+
+func newFakeGKE(t *testing.T) (*fakeGKE, *httptest.Server) {
+	api := &fakeGKE{pools: make(map[string]*container.NodePool), sizes: make(map[string]int64)}
+	pools := "/v1/projects/shibuya/locations/asia-northeast1-a/clusters/engines/nodePools"
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+pools, api.list)
+	mux.HandleFunc("POST "+pools, api.create)
+	mux.HandleFunc("GET "+pools+"/{name}", api.get)
+	mux.HandleFunc("POST "+pools+"/{name}", api.setSize)
+	mux.HandleFunc("DELETE "+pools+"/{name}", api.remove)
+	mux.HandleFunc("GET /v1/projects/shibuya/locations/asia-northeast1-a/operations/{name}", api.operation)
+	mux.HandleFunc("GET /projects/shibuya/zones/asia-northeast1-a/instanceGroupManagers/{name}", api.instanceGroup)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return api, server
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]map[string]any{"error": {"code": status, "message": message}})
+}
+
+func (api *fakeGKE) list(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	resp := &container.ListNodePoolsResponse{}
+	for _, np := range api.pools {
+		resp.NodePools = append(resp.NodePools, np)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (api *fakeGKE) create(w http.ResponseWriter, r *http.Request) {
+	req := new(container.CreateNodePoolRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	np := req.NodePool
+	np.Status = "RUNNING"
+	np.InstanceGroupUrls = []string{
+		"https://www.googleapis.com/compute/v1/projects/shibuya/zones/asia-northeast1-a/instanceGroupManagers/gke-" + np.Name,
+	}
+	api.pools[np.Name] = np
+	api.sizes["gke-"+np.Name] = np.InitialNodeCount
+	json.NewEncoder(w).Encode(&container.Operation{Name: "create-" + np.Name, Status: "RUNNING"})
+}
+
+func (api *fakeGKE) get(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	np, ok := api.pools[r.PathValue("name")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Node pool not found")
+		return
+	}
+	json.NewEncoder(w).Encode(np)
+}
+
+func (api *fakeGKE) setSize(w http.ResponseWriter, r *http.Request) {
+	req := new(container.SetNodePoolSizeRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	name := strings.TrimSuffix(r.PathValue("name"), ":setSize")
+	if _, ok := api.pools[name]; !ok {
+		writeError(w, http.StatusNotFound, "Node pool not found")
+		return
+	}
+	api.sizes["gke-"+name] = req.NodeCount
+	json.NewEncoder(w).Encode(&container.Operation{Name: "resize-" + name, Status: "RUNNING"})
+}
+
+func (api *fakeGKE) remove(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	name := r.PathValue("name")
+	if _, ok := api.pools[name]; !ok {
+		writeError(w, http.StatusNotFound, "Node pool not found")
+		return
+	}
+	delete(api.pools, name)
+	json.NewEncoder(w).Encode(&container.Operation{Name: "delete-" + name, Status: "RUNNING"})
+}
+
+func (api *fakeGKE) operation(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(&container.Operation{Name: r.PathValue("name"), Status: "DONE"})
+}
+
+func (api *fakeGKE) instanceGroup(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	json.NewEncoder(w).Encode(&compute.InstanceGroupManager{TargetSize: api.sizes[r.PathValue("name")]})
+}
+
+func TestGKE(t *testing.T) {
+	api, server := newFakeGKE(t)
+	gkeOperationInterval = time.Millisecond
+	ctx := context.Background()
+	opts := []option.ClientOption{option.WithEndpoint(server.URL + "/"), option.WithoutAuthentication()}
+	cs, err := container.NewService(ctx, opts...)
+	assert.Nil(t, err)
+	ce, err := compute.NewService(ctx, opts...)
+	assert.Nil(t, err)
+	cfg := &config.ExecutorConfig{
+		Cluster:  &config.ClusterConfig{Project: "shibuya", Zone: "asia-northeast1-a", ClusterID: "engines"},
+		NodePool: &config.NodePoolConfig{MachineType: "n2-standard-8", NodeCPU: "7910m", NodeMem: "29Gi"},
+	}
+	m := newManager(newGKE(cfg, cs, ce), cfg.NodePool)
+	ec := &config.ExecutorContainer{CPU: "1", Mem: "4Gi"}
+
+	assert.Nil(t, m.Reserve(1, 1, 10, ec))
+	np := api.pools["shibuya-collection-1"]
+	assert.Equal(t, "n2-standard-8", np.Config.MachineType)
+	assert.Equal(t, "1", np.Config.Labels[CollectionLabel])
+	assert.Equal(t, "NO_SCHEDULE", np.Config.Taints[0].Effect)
+	status, err := m.Status(1)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, status.Size)
+
+	assert.Nil(t, m.Reserve(1, 2, 7, ec))
+	status, _ = m.Status(1)
+	assert.Equal(t, 3, status.Size)
+	collections, err := m.DeployedCollections()
+	assert.Nil(t, err)
+	assert.Contains(t, collections, int64(1))
+
+	assert.Nil(t, m.Release(1))
+	assert.Empty(t, api.pools)
+	assert.Nil(t, m.Release(1))
+}
@@ -0,0 +1,227 @@
+package nodepool
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rakutentech/shibuya/shibuya/config"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// CollectionLabel is the label and the taint of the nodes of a pool, so only the engines of the
+	// collection are scheduled to the pool
+	CollectionLabel = "shibuya-collection"
+	namePrefix      = "shibuya-collection-"
+)
+
+var ErrNodePoolNotFound = errors.New("Node pool not found")
+
+type NodePool struct {
+	Name   string
+	Size   int
+	Status string
+	// Labels and Taints are applied to the nodes of the pool when it's created
+	Labels map[string]string
+	Taints []config.Toleration
+}
+
+// Provider manages the node pools of a cluster. The size of a pool is the number of nodes it's
+// scaled to, which could be more than the nodes ready.
+type Provider interface {
+	GetNodePool(name string) (*NodePool, error)
+	ListNodePools() ([]*NodePool, error)
+	CreateNodePool(np *NodePool) error
+	ResizeNodePool(name string, size int) error
+	DeleteNodePool(name string) error
+}
+
+func NewProvider(cfg *config.ExecutorConfig) (Provider, error) {
+	switch cfg.NodePool.Provider {
+	case "gke":
+		return NewGKE(cfg)
+	}
+	return nil, fmt.Errorf("Shibuya does not support %s as node pool provider", cfg.NodePool.Provider)
+}
+
+func MakeName(collectionID int64) string {
+	return fmt.Sprintf("%s%d", namePrefix, collectionID)
+}
+
+func collectionOfPool(name string) (int64, bool) {
+	if !strings.HasPrefix(name, namePrefix) {
+		return 0, false
+	}
+	collectionID, err := strconv.ParseInt(strings.TrimPrefix(name, namePrefix), 10, 64)
+	return collectionID, err == nil
+}
+
+// EstimateNodes returns the number of the nodes needed by the engines
+func EstimateNodes(cfg *config.NodePoolConfig, ec *config.ExecutorContainer, engines int) (int, error) {
+	fits := -1
+	for _, r := range [][2]string{{cfg.NodeCPU, ec.CPU}, {cfg.NodeMem, ec.Mem}} {
+		node, err := resource.ParseQuantity(r[0])
+		if err != nil {
+			return 0, err
+		}
+		engine, err := resource.ParseQuantity(r[1])
+		if err != nil {
+			return 0, err
+		}
+		if engine.IsZero() {
+			continue
+		}
+		n := int(node.MilliValue() / engine.MilliValue())
+		if fits < 0 || n < fits {
+			fits = n
+		}
+	}
+	if fits == 0 {
+		return 0, fmt.Errorf("An engine requiring %s cpu and %s memory does not fit in a node", ec.CPU, ec.Mem)
+	}
+	if fits < 0 {
+		return 1, nil
+	}
+	return (engines + fits - 1) / fits, nil
+}
+
+// Manager sizes the pools of the collections for the engines of their plans
+type Manager struct {
+	provider Provider
+	cfg      *config.NodePoolConfig
+
+	// the operations of a cluster are done one by one. They can take minutes so opMu is never
+	// taken to read the state of the manager.
+	opMu sync.Mutex
+	// mu guards demand and launchTime, and is never held during the operations of the provider
+	mu sync.Mutex
+	// nodes needed by the plans of the collections. It's only changed with opMu.
+	demand map[int64]map[int64]int
+	// launchTime is when the pools were created, or first seen by this controller as the providers
+	// do not tell it
+	launchTime map[string]time.Time
+}
+
+func NewManager(cfg *config.ExecutorConfig) (*Manager, error) {
+	provider, err := NewProvider(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return newManager(provider, cfg.NodePool), nil
+}
+
+func newManager(provider Provider, cfg *config.NodePoolConfig) *Manager {
+	return &Manager{
+		provider:   provider,
+		cfg:        cfg,
+		demand:     make(map[int64]map[int64]int),
+		launchTime: make(map[string]time.Time),
+	}
+}
+
+// Reserve makes the pool of the collection big enough for the engines of the plan, together with
+// the plans reserved before. The pool is never scaled down here as the engines could be running.
+func (m *Manager) Reserve(collectionID, planID int64, engines int, ec *config.ExecutorContainer) error {
+	nodes, err := EstimateNodes(m.cfg, ec, engines)
+	if err != nil {
+		return err
+	}
+	m.opMu.Lock()
+	defer m.opMu.Unlock()
+	m.mu.Lock()
+	total := nodes
+	for p, n := range m.demand[collectionID] {
+		if p != planID {
+			total += n
+		}
+	}
+	m.mu.Unlock()
+	if m.cfg.MaxNodes > 0 && total > m.cfg.MaxNodes {
+		return fmt.Errorf("Collection %d needs %d nodes, more than the max %d of a node pool", collectionID,
+			total, m.cfg.MaxNodes)
+	}
+	if err := m.scale(collectionID, total); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.demand[collectionID]; !ok {
+		m.demand[collectionID] = make(map[int64]int)
+	}
+	m.demand[collectionID][planID] = nodes
+	return nil
+}
+
+func (m *Manager) scale(collectionID int64, size int) error {
+	name := MakeName(collectionID)
+	np, err := m.provider.GetNodePool(name)
+	if errors.Is(err, ErrNodePoolNotFound) {
+		id := strconv.FormatInt(collectionID, 10)
+		err = m.provider.CreateNodePool(&NodePool{
+			Name:   name,
+			Size:   size,
+			Labels: map[string]string{CollectionLabel: id},
+			Taints: []config.Toleration{{Key: CollectionLabel, Value: id, Effect: apiv1.TaintEffectNoSchedule}},
+		})
+		if err != nil {
+			return err
+		}
+		m.mu.Lock()
+		m.launchTime[name] = time.Now()
+		m.mu.Unlock()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if np.Size >= size {
+		return nil
+	}
+	return m.provider.ResizeNodePool(name, size)
+}
+
+// Release deletes the pool of the collection
+func (m *Manager) Release(collectionID int64) error {
+	m.opMu.Lock()
+	defer m.opMu.Unlock()
+	name := MakeName(collectionID)
+	m.mu.Lock()
+	delete(m.demand, collectionID)
+	delete(m.launchTime, name)
+	m.mu.Unlock()
+	if err := m.provider.DeleteNodePool(name); err != nil && !errors.Is(err, ErrNodePoolNotFound) {
+		return err
+	}
+	return nil
+}
+
+func (m *Manager) Status(collectionID int64) (*NodePool, error) {
+	return m.provider.GetNodePool(MakeName(collectionID))
+}
+
+// DeployedCollections returns the collections having pools by the launch time of the pools
+func (m *Manager) DeployedCollections() (map[int64]time.Time, error) {
+	pools, err := m.provider.ListNodePools()
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	deployedCollections := make(map[int64]time.Time)
+	for _, np := range pools {
+		collectionID, ok := collectionOfPool(np.Name)
+		if !ok {
+			continue
+		}
+		if _, ok := m.launchTime[np.Name]; !ok {
+			m.launchTime[np.Name] = time.Now()
+		}
+		deployedCollections[collectionID] = m.launchTime[np.Name]
+	}
+	return deployedCollections, nil
+}
@@ -0,0 +1,125 @@
+package nodepool
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rakutentech/shibuya/shibuya/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimateNodes(t *testing.T) {
+	cfg := &config.NodePoolConfig{NodeCPU: "7910m", NodeMem: "29Gi"}
+	tests := []struct {
+		cpu, mem string
+		engines  int
+		expected int
+	}{
+		{"1", "1Gi", 7, 1},
+		{"1", "1Gi", 8, 2},
+		{"1", "1Gi", 15, 3},
+		// memory decides
+		{"500m", "4Gi", 8, 2},
+		{"2", "1Gi", 0, 0},
+	}
+	for _, test := range tests {
+		nodes, err := EstimateNodes(cfg, &config.ExecutorContainer{CPU: test.cpu, Mem: test.mem}, test.engines)
+		assert.Nil(t, err)
+		assert.Equal(t, test.expected, nodes)
+	}
+	_, err := EstimateNodes(cfg, &config.ExecutorContainer{CPU: "8", Mem: "1Gi"}, 1)
+	assert.NotNil(t, err)
+	_, err = EstimateNodes(cfg, &config.ExecutorContainer{CPU: "one", Mem: "1Gi"}, 1)
+	assert.NotNil(t, err)
+}
+
+func TestManager(t *testing.T) {
+	fake := NewFake()
+	cfg := &config.NodePoolConfig{NodeCPU: "4", NodeMem: "16Gi", MaxNodes: 5}
+	m := newManager(fake, cfg)
+	ec := &config.ExecutorContainer{CPU: "1", Mem: "1Gi"}
+
+	assert.Nil(t, m.Reserve(1, 1, 8, ec))
+	np, err := m.Status(1)
+	assert.Nil(t, err)
+	assert.Equal(t, "shibuya-collection-1", np.Name)
+	assert.Equal(t, 2, np.Size)
+	assert.Equal(t, "RUNNING", np.Status)
+	assert.Equal(t, "1", fake.Pools[np.Name].Labels[CollectionLabel])
+	assert.Equal(t, CollectionLabel, fake.Pools[np.Name].Taints[0].Key)
+
+	// the pool is scaled up for the other plans of the collection
+	assert.Nil(t, m.Reserve(1, 2, 4, ec))
+	np, _ = m.Status(1)
+	assert.Equal(t, 3, np.Size)
+	// a retried deployment does not count the plan twice
+	assert.Nil(t, m.Reserve(1, 2, 4, ec))
+	np, _ = m.Status(1)
+	assert.Equal(t, 3, np.Size)
+	assert.NotNil(t, m.Reserve(1, 3, 12, ec))
+
+	assert.Nil(t, m.Reserve(2, 4, 1, ec))
+	collections, err := m.DeployedCollections()
+	assert.Nil(t, err)
+	assert.Len(t, collections, 2)
+	assert.Contains(t, collections, int64(2))
+
+	assert.Nil(t, m.Release(1))
+	_, err = m.Status(1)
+	assert.ErrorIs(t, err, ErrNodePoolNotFound)
+	assert.Nil(t, m.Release(1))
+	// the pools not managed by shibuya are ignored
+	fake.Pools["default-pool"] = &NodePool{Name: "default-pool", Size: 3}
+	collections, err = m.DeployedCollections()
+	assert.Nil(t, err)
+	assert.Len(t, collections, 1)
+
+	// the demand is not kept after the release
+	assert.Nil(t, m.Reserve(1, 2, 4, ec))
+	np, _ = m.Status(1)
+	assert.Equal(t, 1, np.Size)
+}
+
+// blockingProvider blocks the resizes until unblocked
+type blockingProvider struct {
+	*Fake
+	resizing chan struct{}
+	unblock  chan struct{}
+}
+
+func (b *blockingProvider) ResizeNodePool(name string, size int) error {
+	b.resizing <- struct{}{}
+	<-b.unblock
+	return b.Fake.ResizeNodePool(name, size)
+}
+
+func TestManagerDoesNotBlockOnOperations(t *testing.T) {
+	provider := &blockingProvider{Fake: NewFake(), resizing: make(chan struct{}), unblock: make(chan struct{})}
+	cfg := &config.NodePoolConfig{NodeCPU: "4", NodeMem: "16Gi"}
+	m := newManager(provider, cfg)
+	ec := &config.ExecutorContainer{CPU: "1", Mem: "1Gi"}
+	assert.Nil(t, m.Reserve(1, 1, 4, ec))
+
+	reserved := make(chan error)
+	go func() {
+		reserved <- m.Reserve(1, 2, 4, ec)
+	}()
+	<-provider.resizing
+	listed := make(chan struct{})
+	go func() {
+		collections, err := m.DeployedCollections()
+		assert.Nil(t, err)
+		assert.Contains(t, collections, int64(1))
+		close(listed)
+	}()
+	select {
+	case <-listed:
+	case <-time.After(time.Second):
+		t.Fatal("DeployedCollections is blocked by the resize")
+	}
+	close(provider.unblock)
+	assert.Nil(t, <-reserved)
+	np, err := m.Status(1)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, np.Size)
+}
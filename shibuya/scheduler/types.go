@@ -8,7 +8,9 @@ import (
 	"github.com/rakutentech/shibuya/shibuya/config"
 	"github.com/rakutentech/shibuya/shibuya/model"
 	cloudrun "github.com/rakutentech/shibuya/shibuya/scheduler/cloudrun"
+	"github.com/rakutentech/shibuya/shibuya/scheduler/docker"
 	k8s "github.com/rakutentech/shibuya/shibuya/scheduler/k8s"
+	"github.com/rakutentech/shibuya/shibuya/scheduler/local"
 	smodel "github.com/rakutentech/shibuya/shibuya/scheduler/model"
 	apiv1 "k8s.io/api/core/v1"
 )
@@ -34,6 +36,8 @@ type EngineScheduler interface {
 	GetEnginesByProject(projectID int64) ([]apiv1.Pod, error)
 	GetIngressUrl(projectID int64) (string, error)
 	GetProjectAPIKey(projectID int64) (string, error)
+	// GetCapacity returns nil when the engines are not limited by the capacity of the nodes
+	GetCapacity() (*smodel.ClusterCapacity, error)
 }
 
 var FeatureUnavailable = errors.New("Feature unavailable")
@@ -44,6 +48,10 @@ func NewEngineScheduler(cfg config.ShibuyaConfig) EngineScheduler {
 		return k8s.NewK8sClientManager(cfg)
 	case "cloudrun":
 		return cloudrun.NewCloudRun(cfg)
+	case "local":
+		return local.NewLocal(cfg)
+	case "docker":
+		return docker.NewDocker(cfg)
 	}
 	log.Fatalf("Shibuya does not support %s as scheduler", cfg.ExecutorConfig.Cluster.Kind)
 	return nil
@@ -10,6 +10,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rakutentech/shibuya/shibuya/api"
 	"github.com/rakutentech/shibuya/shibuya/config"
+	_ "github.com/rakutentech/shibuya/shibuya/engines/builtin"
 	httpauth "github.com/rakutentech/shibuya/shibuya/http/auth"
 	httproute "github.com/rakutentech/shibuya/shibuya/http/route"
 	httpserver "github.com/rakutentech/shibuya/shibuya/http/server"
@@ -176,6 +176,9 @@ var Collection = Vue.component("collection", {
                 function (resp) {
                     this.launched = true;
                     this.purged = false;
+                    if (resp.status == 202) {
+                        alert("The deployment is queued at position " + resp.body.position + ": " + resp.body.message);
+                    }
                 },
                 function (resp) {
                     alert(resp.body.message);